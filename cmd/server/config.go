@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	config "provider-report-api/configs"
+)
+
+const configUsage = "usage: provider-report-api config print [--redacted]"
+
+// runConfig runs the config subcommand: print writes every setting with the source it came
+// from, then fails if the configuration would not pass the startup validation
+func runConfig(cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New(configUsage)
+	}
+
+	redacted := false
+	for _, arg := range args[1:] {
+		switch arg {
+		case "--redacted":
+			redacted = true
+		default:
+			return fmt.Errorf("unknown flag %q, %s", arg, configUsage)
+		}
+	}
+
+	if err := cfg.Print(os.Stdout, redacted); err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
@@ -1,19 +1,39 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	docs "provider-report-api/cmd/docs"
 	config "provider-report-api/configs"
+	"provider-report-api/constant"
+	"provider-report-api/internal/middleware"
+	providerDtos "provider-report-api/internal/modules/provider-detail/dtos"
 	router "provider-report-api/internal/routers"
 	providerRepositories "provider-report-api/internal/modules/provider-detail/repositories"
 	providerServices "provider-report-api/internal/modules/provider-detail/services"
+	"provider-report-api/pkg/audit"
+	"provider-report-api/pkg/auth"
+	"provider-report-api/pkg/health"
+	"provider-report-api/pkg/logging"
+	"provider-report-api/pkg/metrics"
+	"provider-report-api/pkg/sqldialect"
+	"provider-report-api/pkg/tracing"
+	"provider-report-api/pkg/utility"
+	"provider-report-api/pkg/vault"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
 	"github.com/joho/godotenv"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -40,7 +60,9 @@ func corsMiddleware() gin.HandlerFunc {
 		// Allow specific methods
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
 		// Allow specific headers
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With, X-Request-ID")
+		// Let browsers read the request ID to report it with errors
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		// Allow credentials
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 
@@ -54,36 +76,181 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
+// auditSinks builds the audit sinks listed in AUDIT_SINKS
+func auditSinks(cfg *config.Config, db *sqlx.DB, dialect sqldialect.Dialect) []audit.AuditSink {
+	var sinks []audit.AuditSink
+	for _, name := range cfg.AuditSinks {
+		switch name {
+		case "database":
+			sinks = append(sinks, audit.NewDatabaseSink(db, dialect))
+		case "logstash":
+			if cfg.LogstashURL == "" {
+				slog.Warn("LOGSTASH_URL is not set, audit events are not sent to Logstash")
+				continue
+			}
+			sinks = append(sinks, audit.NewLogstashSink(cfg.LogstashURL, cfg.LogstashVerifyCert))
+		case "stdout":
+			sinks = append(sinks, audit.NewStdoutSink(os.Stdout))
+		default:
+			slog.Warn("Unknown audit sink in AUDIT_SINKS, ignoring it", "sink", name)
+		}
+	}
+	return sinks
+}
+
+// openDatabase connects to the database. With VAULT_DB_CREDS_PATH the credentials are leased
+// from Vault and the pool picks up rotated credentials; the lease is returned to be kept alive.
+func openDatabase(cfg *config.Config, dialect sqldialect.Dialect) (*sqlx.DB, *vault.DynamicCredentials, error) {
+	if cfg.VaultDBCredsPath == "" {
+		db, err := config.Initialize(dialect, cfg.GetDatabaseURL())
+		return db, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	creds, err := vault.NewDynamicCredentials(ctx, cfg.VaultClient(), cfg.VaultDBCredsPath, cfg.VaultDBRotateBefore)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Connections are recycled well before the old lease ends after a rotation
+	db, err := config.InitializeWithCredentials(dialect, cfg, func() (string, string) {
+		current := creds.Current()
+		return current.Username, current.Password
+	}, creds.RotateBefore()/2)
+	if err != nil {
+		return nil, nil, err
+	}
+	creds.OnRotate(func(vault.Credentials) {
+		config.RecycleIdleConnections(db)
+	})
+	return db, creds, nil
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
 func main() {
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found")
+		slog.Info("No .env file found")
 	}
 
-	// Load configuration
-	cfg := config.Load()
+	// Load configuration: defaults, CONFIG_FILE, environment and Vault, see config.Load
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+	logging.Setup(cfg.LogLevel, cfg.LogFormat)
+
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfig(cfg, os.Args[2:]); err != nil {
+			fatal("Invalid configuration", err)
+		}
+		return
+	}
+
+	// Fail fast instead of serving with a broken or insecure configuration
+	if err := cfg.Validate(); err != nil {
+		fatal("Invalid configuration", err)
+	}
+
+	// Tracing is set up first so the database and Redis clients pick up the tracer provider
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.GetTracingConfig())
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", "error", err)
+		}
+	}()
 
 	// Initialize database
-	db, err := config.Initialize(cfg.GetDatabaseURL())
+	sqlDialect, err := cfg.GetDialect()
+	if err != nil {
+		fatal("Invalid DB_DRIVER", err)
+	}
+	db, dbCreds, err := openDatabase(cfg, sqlDialect)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		fatal("Failed to connect to database", err)
 	}
 	defer db.Close()
 
+	// Keep the Vault token and the database lease alive
+	vaultCtx, stopVault := context.WithCancel(context.Background())
+	defer stopVault()
+	if vaultClient := cfg.VaultClient(); vaultClient != nil {
+		go vaultClient.KeepAlive(vaultCtx)
+	}
+	if dbCreds != nil {
+		go dbCreds.Run(vaultCtx)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(context.Background(), db, sqlDialect, os.Args[2:]); err != nil {
+			fatal("Migration failed", err)
+		}
+		return
+	}
+
+	// Metrics are served on /metrics; the pool statistics are labelled with the database name
+	appMetrics := metrics.New()
+	appMetrics.RegisterDB(db.DB, cfg.DatabaseName)
+
 	// Initialize repositories
-	providerRepo := providerRepositories.NewProviderRepository(db)
-	templateRepo := providerRepositories.NewTemplateRepository(db)
-	scheduleRepo := providerRepositories.NewScheduleRepository(db)
-	logRepo := providerRepositories.NewLogRepository(db)
-	fieldRepo := providerRepositories.NewFieldRepository(db)
+	timeouts := cfg.Timeouts
+	providerRepo := providerRepositories.NewProviderRepository(db, sqlDialect, timeouts.Query)
+	templateRepo := providerRepositories.NewTemplateRepository(db, sqlDialect, timeouts.Query)
+	scheduleRepo := providerRepositories.NewScheduleRepository(db, sqlDialect, timeouts.Query)
+	logRepo := providerRepositories.NewLogRepository(db, sqlDialect, timeouts.Query)
+	fieldRepo := providerRepositories.NewFieldRepository(db, sqlDialect, timeouts.Query)
+	savedSearchRepo := providerRepositories.NewSavedSearchRepository(db, sqlDialect, timeouts.Query)
+	historyRepo := providerRepositories.NewHistoryRepository(db, sqlDialect, timeouts.Query)
+	scopeRepo := providerRepositories.NewDataScopeRepository(db, sqlDialect, timeouts.Query)
+	apiKeyRepo := providerRepositories.NewAPIKeyRepository(db, sqlDialect, timeouts.Query)
+	exportQuotaRepo := providerRepositories.NewExportQuotaRepository(db, sqlDialect, timeouts.Query)
+
+	// Redis is optional: saved searches and permissions fall back to the database when it is unavailable
+	var searchStore providerServices.SearchPreferenceStore
+	redisService, err := utility.ConnectRedisService(cfg.RedisURL, appMetrics)
+	if err != nil {
+		slog.Warn("Redis unavailable, saved searches and permissions use the database only", "error", err)
+	} else {
+		searchStore = redisService
+		defer redisService.Close()
+	}
+
+	// Audit events are shipped in the background so a slow sink never fails a request
+	auditor := audit.NewAuditor(audit.Options{Service: constant.PROVIDER_REPORT_INDEX}, auditSinks(cfg, db, sqlDialect)...)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := auditor.Close(ctx); err != nil {
+			slog.Error("Failed to flush audit events", "error", err)
+		}
+	}()
 
 	// Initialize services
-	emailService := providerServices.NewEmailService(cfg)
+	emailService := providerServices.NewEmailService(cfg, appMetrics)
 	exportService := providerServices.NewExportService()
-	providerService := providerServices.NewProviderService(providerRepo, exportService, fieldRepo)
-	templateService := providerServices.NewTemplateService(templateRepo, fieldRepo)
-	scheduleService := providerServices.NewScheduleService(scheduleRepo, templateRepo, emailService)
+	historyService := providerServices.NewHistoryService(historyRepo, fieldRepo)
+	exportQuotaService := providerServices.NewExportQuotaService(exportQuotaRepo, cfg.ExportDailyRowLimit, cfg.ExportDailyByteLimit)
+	providerService := providerServices.NewProviderService(providerRepo, exportService, fieldRepo, templateRepo, historyService, scopeRepo, auditor, exportQuotaService, timeouts.Export, appMetrics)
+	templateService := providerServices.NewTemplateService(templateRepo, fieldRepo, historyService, auditor)
+	scheduleService := providerServices.NewScheduleService(scheduleRepo, templateRepo, emailService, historyService, providerService, logRepo, auditor, timeouts.ScheduleRun, appMetrics, cfg.ScheduleRetry)
+	appMetrics.RegisterGauge("schedule_runs_in_progress", "Schedule runs in progress.", func() float64 {
+		running, _ := scheduleService.RunState()
+		return float64(running)
+	})
 	logService := providerServices.NewLogService(logRepo)
+	savedSearchService := providerServices.NewSavedSearchService(savedSearchRepo, templateRepo, searchStore)
+	apiKeyService := providerServices.NewAPIKeyService(apiKeyRepo)
 
 	// Create dependencies struct
 	deps := &router.Dependencies{
@@ -92,40 +259,108 @@ func main() {
 		ScheduleService: scheduleService,
 		LogService:      logService,
 		FieldRepo:       fieldRepo,
+
+		SavedSearchService: savedSearchService,
+		HistoryService:     historyService,
+		APIKeyService:      apiKeyService,
+
+		AuthEnabled: cfg.AuthEnabled,
+	}
+
+	if cfg.RateLimitPerMinute > 0 {
+		deps.RateLimiter = middleware.NewRateLimiter(redisService, cfg.RateLimitPerMinute, cfg.RateLimitBurst)
+	}
+
+	if cfg.AuthEnabled {
+		verifier, err := auth.NewVerifier(cfg.GetAuthConfig())
+		if err != nil {
+			fatal("Failed to configure JWT verification", err)
+		}
+		deps.TokenVerifier = verifier
+		deps.PermissionCache = middleware.NewPermissionCache(redisService, cfg.PermissionModule, cfg.PermissionMenuIDs, cfg.PermissionCacheTTL)
+	} else {
+		slog.Warn("AUTH_ENABLED=false, report routes are not authenticated")
 	}
 
 	// Setup router
-	r := gin.Default()
+	r := gin.New()
+
+	// Tag every request with an ID, trace it, log it once handled and turn panics into 500
+	// responses. Metrics wrap Recovery so panics are counted as the 500s they are answered with.
+	r.Use(middleware.RequestIDMiddleware(), middleware.Tracing(cfg.TracingServiceName), middleware.RequestLogger(), middleware.RequestMetrics(appMetrics), middleware.Recovery())
 
 	// Use CORS middleware
 	r.Use(corsMiddleware())
 
-	// Custom middleware to log errors
-	r.Use(func(c *gin.Context) {
-		c.Next()
-		if len(c.Errors) > 0 {
-			for _, e := range c.Errors {
-				fmt.Printf("Error: %v", e)
+	// Readiness needs the database and a scheduler that accepts runs. Redis and SMTP are only
+	// reported: saved searches and permissions fall back to the database, and only schedule
+	// runs send email.
+	checker := health.NewChecker(timeouts.Readiness)
+	checker.Add("database", true, func(ctx context.Context) error {
+		return config.HealthCheck(ctx, db)
+	})
+	checker.Add("redis", false, func(ctx context.Context) error {
+		if redisService == nil {
+			return errors.New("not connected")
+		}
+		return redisService.Ping(ctx)
+	})
+	checker.Add("smtp", false, emailService.CheckConnection)
+	if vaultClient := cfg.VaultClient(); vaultClient != nil {
+		// Critical only when the database depends on leases that Vault must keep renewing
+		checker.AddWithDetails("vault", dbCreds != nil, func(ctx context.Context) (map[string]interface{}, error) {
+			details, err := vaultClient.Health()
+			if err != nil || dbCreds == nil {
+				return details, err
 			}
+			lease, err := dbCreds.Health()
+			details["database_credentials"] = lease
+			return details, err
+		})
+	}
+	checker.AddWithDetails("scheduler", true, func(ctx context.Context) (map[string]interface{}, error) {
+		running, draining := scheduleService.RunState()
+		details := map[string]interface{}{"running": running, "accepting_runs": !draining}
+		if draining {
+			return details, errors.New("draining")
 		}
+		return details, nil
 	})
 
-	// Health check endpoint
+	// Liveness only tells the process serves requests, a dependency outage must not restart it
+	r.GET("/livez", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "alive"})
+	})
+	r.GET("/readyz", func(c *gin.Context) {
+		ready, report := checker.Ready(c.Request.Context())
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, report)
+	})
+
+	// Health check endpoint, kept for existing monitors
 	r.GET("/health", func(c *gin.Context) {
-		if err := config.HealthCheck(db); err != nil {
+		if err := config.HealthCheck(c.Request.Context(), db); err != nil {
 			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
 	})
 
+	// Prometheus scrape endpoint, unauthenticated like the probes; restrict it at the ingress
+	r.GET("/metrics", gin.WrapH(appMetrics.Handler()))
+
 	// Swagger endpoint
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
 
 	// Validator setup
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
 		// Register custom validation functions or tags here
-		_ = v // Use the validator as needed
+		if err := providerDtos.RegisterProviderValidations(v); err != nil {
+			fatal("Failed to register provider validations", err)
+		}
 	}
 
 	// Define base settings for Swagger API documentation. This setup includes specifying the title,
@@ -143,13 +378,76 @@ func main() {
 
 	// Get port from config
 	port := cfg.ServerPort
-	if port == "" {
-		port = "8777"
+
+	// Requests run with a context that is only cancelled when in-flight requests outlive the
+	// shutdown timeout, so running exports and schedule runs stop and release their queries
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	// Failed schedule runs are retried alongside the requests and drained with them
+	go scheduleService.RetryFailedRuns(baseCtx)
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
 
-	log.Printf("Server starting on port %s", port)
-	log.Printf("Swagger UI available at: http://localhost:%s/swagger/index.html", port)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Server starting", "port", port)
+	slog.Info(fmt.Sprintf("Swagger UI available at: http://localhost:%s/swagger/index.html", port))
 
 	// Start the server
-	r.Run(":" + port)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Server failed", "error", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	stop()
+	shutdown(srv, checker, scheduleService, cancelRequests, timeouts)
+}
+
+// shutdown drains the server: readiness fails first so load balancers stop sending requests,
+// then in-flight requests, exports and schedule runs get the shutdown timeout to finish
+// before their contexts are cancelled
+func shutdown(srv *http.Server, checker *health.Checker, scheduleService *providerServices.ScheduleService, cancelRequests context.CancelFunc, timeouts config.Timeouts) {
+	slog.Info("Shutting down server", "drain_delay", timeouts.ShutdownDrainDelay, "timeout", timeouts.Shutdown)
+	checker.SetDraining()
+	scheduleService.Drain()
+	time.Sleep(timeouts.ShutdownDrainDelay)
+
+	ctx := context.Background()
+	if timeouts.Shutdown > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeouts.Shutdown)
+		defer cancel()
+	}
+
+	if err := srv.Shutdown(ctx); err != nil {
+		running, _ := scheduleService.RunState()
+		slog.Warn("Shutdown timeout expired, cancelling in-flight requests", "error", err, "running_schedules", running)
+		cancelRequests()
+
+		// Cancelled requests return quickly; give them a moment to log and respond
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := scheduleService.Wait(closeCtx); err != nil {
+			slog.Error("Schedule runs did not stop", "error", err)
+		}
+		srv.Close()
+	}
+	slog.Info("Server stopped")
 }
\ No newline at end of file
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"provider-report-api/database"
+	"provider-report-api/pkg/migrate"
+	"provider-report-api/pkg/sqldialect"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const migrateUsage = "usage: provider-report-api migrate [up | down [steps] | status]"
+
+// runMigrate runs the migrate subcommand: up applies pending migrations (the default), down
+// rolls back the last steps migrations (1 by default), status lists every migration
+func runMigrate(ctx context.Context, db *sqlx.DB, dialect sqldialect.Dialect, args []string) error {
+	files, err := database.Migrations(dialect.Name())
+	if err != nil {
+		return err
+	}
+	migrations, err := migrate.Load(files)
+	if err != nil {
+		return err
+	}
+	migrator := migrate.New(db, dialect, migrations)
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied   %03d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q, %s", args[1], migrateUsage)
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("rolled back %03d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied != nil {
+				state = "applied " + status.Applied.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if status.Modified {
+				state += " (modified since applied)"
+			}
+			fmt.Printf("%03d_%-32s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q, %s", command, migrateUsage)
+}
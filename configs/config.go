@@ -1,65 +1,648 @@
 package configs
 
 import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
     "os"
-    
-    _ "github.com/denisenkom/go-mssqldb" // SQL Server driver
+    "strconv"
+    "strings"
+    "time"
+
+    "provider-report-api/constant"
+    "provider-report-api/pkg/auth"
+    "provider-report-api/pkg/sqldialect"
+    "provider-report-api/pkg/tracing"
+    "provider-report-api/pkg/vault"
+
+    "github.com/joho/godotenv"
+)
+
+// Sources of a setting, from the lowest to the highest precedence. Vault overrides the
+// environment for the secrets it holds so it stays the single source of record for them.
+const (
+    sourceDefault = "default"
+    sourceFile    = "file"
+    sourceEnv     = "env"
+    sourceVault   = "vault"
 )
 
+// envProduction is the ENVIRONMENT in which placeholder secrets are refused at startup
+const envProduction = "production"
+
 type Config struct {
-    DatabaseURL    string
-    DatabaseDriver string
-    DatabaseHost   string
-    DatabasePort   string
-    DatabaseName   string
-    DatabaseUser   string
-    DatabasePass   string
-    ServerPort     string
-    JWTSecret      string
-    SMTPHost       string
-    SMTPPort       string
-    SMTPUser       string
-    SMTPPass       string
-    SMTPFrom       string
-    Environment    string
-}
-
-func Load() *Config {
-    return &Config{
-        DatabaseDriver: getEnv("DB_DRIVER", "mssql"),           
-        DatabaseHost:   getEnv("DB_HOST", "localhost"),
-        DatabasePort:   getEnv("DB_PORT", "1433"),             
-        DatabaseName:   getEnv("DB_NAME", "tpacaredb"),
-        DatabaseUser:   getEnv("DB_USER", "dcsnewcoretpa"),
-        DatabasePass:   getEnv("DB_PASSWORD", "TPA@mindcs!2"), 
-        ServerPort:     getEnv("PORT", "8777"),                
-        JWTSecret:      getEnv("JWT_SECRET", "your-secret-key"),
-        SMTPHost:       getEnv("SMTP_HOST", "smtp.gmail.com"),
-        SMTPPort:       getEnv("SMTP_PORT", "587"),
-        SMTPUser:       getEnv("SMTP_USERNAME", ""),           
-        SMTPPass:       getEnv("SMTP_PASSWORD", ""),          
-        SMTPFrom:       getEnv("SMTP_FROM", "noreply@company.com"),
-        Environment:    getEnv("ENVIRONMENT", "development"),
+    Environment          string
+    DatabaseURL          string
+    DatabaseDriver       string
+    DatabaseHost         string
+    DatabasePort         string
+    DatabaseName         string
+    DatabaseUser         string
+    DatabasePass         string
+    // RedisURL is the address of Redis, empty runs without Redis
+    RedisURL             string
+    VaultAddr            string
+    // VaultAuthMethod is token, approle or kubernetes; each reads its own VAULT_* settings
+    VaultAuthMethod      string
+    VaultToken           string
+    VaultAppRoleMount    string
+    VaultAppRoleID       string
+    VaultAppSecretID     string
+    VaultK8sMount        string
+    VaultK8sRole         string
+    VaultK8sTokenPath    string
+    VaultDBSecretPath    string
+    VaultRedisSecretPath string
+    // VaultDBCredsPath leases dynamic database credentials, e.g. database/creds/report-api.
+    // They are rotated VaultDBRotateBefore ahead of the end of their lease.
+    VaultDBCredsPath     string
+    VaultDBRotateBefore  time.Duration
+    ServerPort           string
+    JWTSecret            string
+    JWTJWKSURL           string
+    JWTAlgorithms        []string
+    JWTIssuer            string
+    JWTAudience          string
+    JWTClockSkew         time.Duration
+    AuthEnabled          bool
+    // PermissionModule names the permission cache entries of this service in Redis
+    PermissionModule     string
+    PermissionMenuIDs    []int
+    PermissionCacheTTL   time.Duration
+    // AuditSinks lists where audit events are shipped: database, logstash and stdout
+    AuditSinks           []string
+    LogstashURL          string
+    LogstashVerifyCert   bool
+    // RateLimitPerMinute is the sustained request rate of each client, 0 disables rate limiting
+    RateLimitPerMinute   int
+    RateLimitBurst       int
+    // ExportDailyRowLimit and ExportDailyByteLimit are the export quota of roles without one, 0 is unlimited
+    ExportDailyRowLimit  int64
+    ExportDailyByteLimit int64
+    Timeouts             Timeouts
+    ScheduleRetry        ScheduleRetry
+    SMTPHost             string
+    SMTPPort             string
+    SMTPUser             string
+    SMTPPass             string
+    SMTPFrom             string
+    // LogLevel is debug, info, warn or error; LogFormat is json or text
+    LogLevel             string
+    LogFormat            string
+    // TracingExporter is none or otlp; spans go to TracingEndpoint, sampled at TracingSampleRatio
+    TracingExporter      string
+    TracingEndpoint      string
+    TracingServiceName   string
+    TracingSampleRatio   float64
+
+    // values keeps the raw value and the source of every setting for Print
+    values map[string]settingValue
+    // vaultClient is the client Load logged in with, nil without VAULT_ADDR
+    vaultClient *vault.Client
+}
+
+// Timeouts are the time limits of the operations that can run long. 0 disables a timeout.
+type Timeouts struct {
+    // Query bounds each repository call, Export a whole export and ScheduleRun a schedule
+    // run including its email
+    Query       time.Duration
+    Export      time.Duration
+    ScheduleRun time.Duration
+    // On SIGTERM readiness fails for ShutdownDrainDelay before the server stops accepting
+    // connections, then in-flight requests get Shutdown to finish. Readiness bounds each
+    // dependency check of /readyz.
+    ShutdownDrainDelay time.Duration
+    Shutdown           time.Duration
+    Readiness          time.Duration
+}
+
+// ScheduleRetry is how failed schedule runs are retried. Attempt n waits Backoff*2^(n-1),
+// capped at MaxBackoff; once Limit retries failed the run is marked failed. A Limit of 0
+// disables retries.
+type ScheduleRetry struct {
+    Limit      int
+    Backoff    time.Duration
+    MaxBackoff time.Duration
+    // PollInterval is how often due retries are looked up
+    PollInterval time.Duration
+}
+
+type settingValue struct {
+    value  string
+    source string
+}
+
+// setting is one configuration key. apply parses the raw value into the Config.
+type setting struct {
+    key     string
+    aliases []string
+    def     string
+    secret  bool
+    apply   func(c *Config, value string) error
+}
+
+// settings lists every key the service reads, in the order Print shows them
+var settings = []setting{
+    {key: "ENVIRONMENT", aliases: []string{"ENV"}, def: "development", apply: asString(func(c *Config) *string { return &c.Environment })},
+
+    {key: "DATABASE_URL", secret: true, apply: asString(func(c *Config) *string { return &c.DatabaseURL })},
+    {key: "DB_DRIVER", def: "mssql", apply: asString(func(c *Config) *string { return &c.DatabaseDriver })},
+    {key: "DB_HOST", def: "localhost", apply: asString(func(c *Config) *string { return &c.DatabaseHost })},
+    {key: "DB_PORT", def: "1433", apply: asString(func(c *Config) *string { return &c.DatabasePort })},
+    {key: "DB_NAME", def: "tpacaredb", apply: asString(func(c *Config) *string { return &c.DatabaseName })},
+    {key: "DB_USER", apply: asString(func(c *Config) *string { return &c.DatabaseUser })},
+    {key: "DB_PASSWORD", secret: true, apply: asString(func(c *Config) *string { return &c.DatabasePass })},
+    {key: "REDIS_URL", apply: asString(func(c *Config) *string { return &c.RedisURL })},
+
+    {key: "VAULT_ADDR", apply: asString(func(c *Config) *string { return &c.VaultAddr })},
+    {key: "VAULT_AUTH_METHOD", def: vault.AuthToken, apply: asString(func(c *Config) *string { return &c.VaultAuthMethod })},
+    {key: "VAULT_TOKEN", secret: true, apply: asString(func(c *Config) *string { return &c.VaultToken })},
+    {key: "VAULT_APPROLE_MOUNT", def: "approle", apply: asString(func(c *Config) *string { return &c.VaultAppRoleMount })},
+    {key: "VAULT_APPROLE_ROLE_ID", apply: asString(func(c *Config) *string { return &c.VaultAppRoleID })},
+    {key: "VAULT_APPROLE_SECRET_ID", secret: true, apply: asString(func(c *Config) *string { return &c.VaultAppSecretID })},
+    {key: "VAULT_K8S_MOUNT", def: "kubernetes", apply: asString(func(c *Config) *string { return &c.VaultK8sMount })},
+    {key: "VAULT_K8S_ROLE", apply: asString(func(c *Config) *string { return &c.VaultK8sRole })},
+    {key: "VAULT_K8S_TOKEN_PATH", def: "/var/run/secrets/kubernetes.io/serviceaccount/token", apply: asString(func(c *Config) *string { return &c.VaultK8sTokenPath })},
+    {key: "VAULT_DB_SECRET_PATH", def: "kv/data/tpa-newcore", apply: asString(func(c *Config) *string { return &c.VaultDBSecretPath })},
+    {key: "VAULT_REDIS_SECRET_PATH", def: "kv/data/redis", apply: asString(func(c *Config) *string { return &c.VaultRedisSecretPath })},
+    {key: "VAULT_DB_CREDS_PATH", apply: asString(func(c *Config) *string { return &c.VaultDBCredsPath })},
+    {key: "VAULT_DB_ROTATE_BEFORE", def: "5m", apply: asDuration(func(c *Config) *time.Duration { return &c.VaultDBRotateBefore })},
+
+    {key: "PORT", def: "8777", apply: asString(func(c *Config) *string { return &c.ServerPort })},
+
+    {key: "JWT_SECRET", secret: true, apply: asString(func(c *Config) *string { return &c.JWTSecret })},
+    {key: "JWT_JWKS_URL", apply: asString(func(c *Config) *string { return &c.JWTJWKSURL })},
+    {key: "JWT_ALGORITHMS", apply: asList(func(c *Config) *[]string { return &c.JWTAlgorithms })},
+    {key: "JWT_ISSUER", apply: asString(func(c *Config) *string { return &c.JWTIssuer })},
+    {key: "JWT_AUDIENCE", apply: asString(func(c *Config) *string { return &c.JWTAudience })},
+    {key: "JWT_CLOCK_SKEW", def: "30s", apply: asDuration(func(c *Config) *time.Duration { return &c.JWTClockSkew })},
+    {key: "AUTH_ENABLED", def: "true", apply: asBool(func(c *Config) *bool { return &c.AuthEnabled })},
+
+    {key: "PERMISSION_MODULE", def: "providerreport", apply: asString(func(c *Config) *string { return &c.PermissionModule })},
+    {key: "PERMISSION_MENU_IDS", def: strconv.Itoa(constant.PROVIDER_DETAIL_REPORT_MENU_ID), apply: asIntList(func(c *Config) *[]int { return &c.PermissionMenuIDs })},
+    {key: "PERMISSION_CACHE_TTL", def: "10m", apply: asDuration(func(c *Config) *time.Duration { return &c.PermissionCacheTTL })},
+
+    {key: "AUDIT_SINKS", def: "database,logstash", apply: func(c *Config, value string) error {
+        return asList(func(c *Config) *[]string { return &c.AuditSinks })(c, strings.ToLower(value))
+    }},
+    {key: "LOGSTASH_URL", apply: asString(func(c *Config) *string { return &c.LogstashURL })},
+    {key: "LOGSTASH_VERIFY_CERT", def: "true", apply: asBool(func(c *Config) *bool { return &c.LogstashVerifyCert })},
+
+    {key: "RATE_LIMIT_PER_MINUTE", def: "120", apply: asInt(func(c *Config) *int { return &c.RateLimitPerMinute })},
+    {key: "RATE_LIMIT_BURST", def: "30", apply: asInt(func(c *Config) *int { return &c.RateLimitBurst })},
+    {key: "EXPORT_DAILY_ROW_LIMIT", def: "200000", apply: asInt64(func(c *Config) *int64 { return &c.ExportDailyRowLimit })},
+    {key: "EXPORT_DAILY_BYTE_LIMIT", def: "524288000", apply: asInt64(func(c *Config) *int64 { return &c.ExportDailyByteLimit })},
+
+    {key: "DB_QUERY_TIMEOUT", def: "30s", apply: asDuration(func(c *Config) *time.Duration { return &c.Timeouts.Query })},
+    {key: "EXPORT_TIMEOUT", def: "5m", apply: asDuration(func(c *Config) *time.Duration { return &c.Timeouts.Export })},
+    {key: "SCHEDULE_RUN_TIMEOUT", def: "10m", apply: asDuration(func(c *Config) *time.Duration { return &c.Timeouts.ScheduleRun })},
+    {key: "SHUTDOWN_DRAIN_DELAY", def: "5s", apply: asDuration(func(c *Config) *time.Duration { return &c.Timeouts.ShutdownDrainDelay })},
+    {key: "SHUTDOWN_TIMEOUT", def: "60s", apply: asDuration(func(c *Config) *time.Duration { return &c.Timeouts.Shutdown })},
+    {key: "READINESS_TIMEOUT", def: "2s", apply: asDuration(func(c *Config) *time.Duration { return &c.Timeouts.Readiness })},
+
+    {key: "SCHEDULE_RETRY_LIMIT", def: "3", apply: asInt(func(c *Config) *int { return &c.ScheduleRetry.Limit })},
+    {key: "SCHEDULE_RETRY_BACKOFF", def: "1m", apply: asDuration(func(c *Config) *time.Duration { return &c.ScheduleRetry.Backoff })},
+    {key: "SCHEDULE_RETRY_MAX_BACKOFF", def: "30m", apply: asDuration(func(c *Config) *time.Duration { return &c.ScheduleRetry.MaxBackoff })},
+    {key: "SCHEDULE_RETRY_POLL_INTERVAL", def: "30s", apply: asDuration(func(c *Config) *time.Duration { return &c.ScheduleRetry.PollInterval })},
+
+    {key: "SMTP_HOST", def: "smtp.gmail.com", apply: asString(func(c *Config) *string { return &c.SMTPHost })},
+    {key: "SMTP_PORT", def: "587", apply: asString(func(c *Config) *string { return &c.SMTPPort })},
+    {key: "SMTP_USERNAME", apply: asString(func(c *Config) *string { return &c.SMTPUser })},
+    {key: "SMTP_PASSWORD", secret: true, apply: asString(func(c *Config) *string { return &c.SMTPPass })},
+    {key: "SMTP_FROM", def: "noreply@company.com", apply: asString(func(c *Config) *string { return &c.SMTPFrom })},
+
+    {key: "LOG_LEVEL", def: "info", apply: asString(func(c *Config) *string { return &c.LogLevel })},
+    {key: "LOG_FORMAT", def: "json", apply: asString(func(c *Config) *string { return &c.LogFormat })},
+
+    {key: "TRACING_EXPORTER", def: "none", apply: asString(func(c *Config) *string { return &c.TracingExporter })},
+    {key: "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", apply: asString(func(c *Config) *string { return &c.TracingEndpoint })},
+    {key: "OTEL_SERVICE_NAME", def: "provider-report-api", apply: asString(func(c *Config) *string { return &c.TracingServiceName })},
+    {key: "TRACING_SAMPLE_RATIO", def: "1", apply: asRatio(func(c *Config) *float64 { return &c.TracingSampleRatio })},
+}
+
+// placeholderSecrets are sample values from .env files and old defaults that must never reach
+// production
+var placeholderSecrets = map[string]bool{
+    "password":                 true,
+    "changeme":                 true,
+    "secret":                   true,
+    "your-secret-key":          true,
+    "your_jwt_secret_key_here": true,
+    "your_app_password":        true,
+    "TPA@mindcs!2":             true,
+}
+
+// minJWTSecretLength is the shortest HS256 secret accepted in production
+const minJWTSecretLength = 32
+
+// vaultLoadTimeout bounds the Vault login and secret reads of Load
+const vaultLoadTimeout = 30 * time.Second
+
+// Load reads the configuration. Every key starts from its default and is overridden, in this
+// order, by the file named by CONFIG_FILE (KEY=VALUE lines), the environment and, when
+// VAULT_ADDR is set, the database and Redis secrets in Vault. Load fails on unknown keys in
+// the file and on values that don't parse; call Validate for the checks across settings.
+func Load() (*Config, error) {
+    values := make(map[string]settingValue, len(settings))
+    for _, s := range settings {
+        values[s.key] = settingValue{value: s.def, source: sourceDefault}
+    }
+
+    if path := os.Getenv("CONFIG_FILE"); path != "" {
+        file, err := godotenv.Read(path)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+        }
+        for key, value := range file {
+            s, ok := lookupSetting(key)
+            if !ok {
+                return nil, fmt.Errorf("unknown setting %s in config file %s", key, path)
+            }
+            values[s.key] = settingValue{value: value, source: sourceFile}
+        }
+    }
+
+    for _, s := range settings {
+        for _, name := range append([]string{s.key}, s.aliases...) {
+            if value := os.Getenv(name); value != "" {
+                values[s.key] = settingValue{value: value, source: sourceEnv}
+                break
+            }
+        }
     }
+
+    var vaultClient *vault.Client
+    if values["VAULT_ADDR"].value != "" {
+        client, secrets, err := loadVaultSecrets(values)
+        if err != nil {
+            return nil, err
+        }
+        vaultClient = client
+        for key, value := range secrets {
+            if value != "" {
+                values[key] = settingValue{value: value, source: sourceVault}
+            }
+        }
+    }
+
+    c := &Config{values: values, vaultClient: vaultClient}
+    var errs []error
+    for _, s := range settings {
+        if err := s.apply(c, values[s.key].value); err != nil {
+            errs = append(errs, fmt.Errorf("invalid %s %q: %w", s.key, redact(s, values[s.key].value), err))
+        }
+    }
+    if err := errors.Join(errs...); err != nil {
+        return nil, err
+    }
+    current = c
+    return c, nil
+}
+
+// current is the configuration returned by the last successful Load
+var current *Config
+
+// Current returns the configuration loaded at startup, nil before Load. It is meant for the
+// helpers that predate dependency injection, everything else gets the Config passed in.
+func Current() *Config {
+    return current
+}
+
+// VaultClient returns the Vault client Load logged in with, nil when VAULT_ADDR is not set
+func (c *Config) VaultClient() *vault.Client {
+    return c.vaultClient
+}
+
+// loadVaultSecrets logs in to Vault and returns the client with the database and Redis
+// secrets by setting key. An empty secret path skips that secret.
+func loadVaultSecrets(values map[string]settingValue) (*vault.Client, map[string]string, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), vaultLoadTimeout)
+    defer cancel()
+
+    client, err := vault.NewClient(ctx, vault.Config{
+        Address:             values["VAULT_ADDR"].value,
+        AuthMethod:          values["VAULT_AUTH_METHOD"].value,
+        Token:               values["VAULT_TOKEN"].value,
+        AppRoleMount:        values["VAULT_APPROLE_MOUNT"].value,
+        RoleID:              values["VAULT_APPROLE_ROLE_ID"].value,
+        SecretID:            values["VAULT_APPROLE_SECRET_ID"].value,
+        KubernetesMount:     values["VAULT_K8S_MOUNT"].value,
+        KubernetesRole:      values["VAULT_K8S_ROLE"].value,
+        KubernetesTokenPath: values["VAULT_K8S_TOKEN_PATH"].value,
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+
+    secrets := make(map[string]string)
+    if path := values["VAULT_DB_SECRET_PATH"].value; path != "" {
+        db, err := client.DatabaseCredentials(ctx, path)
+        if err != nil {
+            return nil, nil, fmt.Errorf("failed to load database secret from Vault: %w", err)
+        }
+        secrets["DB_HOST"] = db.DatabaseUrl
+        secrets["DB_NAME"] = db.DatabaseName
+        secrets["DB_USER"] = db.DatabaseUsername
+        secrets["DB_PASSWORD"] = db.DatabasePassword
+        if db.DatabasePort != 0 {
+            secrets["DB_PORT"] = strconv.Itoa(db.DatabasePort)
+        }
+    }
+    if path := values["VAULT_REDIS_SECRET_PATH"].value; path != "" {
+        redis, err := client.RedisCredentials(ctx, path)
+        if err != nil {
+            return nil, nil, fmt.Errorf("failed to load Redis secret from Vault: %w", err)
+        }
+        secrets["REDIS_URL"] = redis.RedisUrl
+    }
+    return client, secrets, nil
+}
+
+// IsProduction reports whether the service runs in production
+func (c *Config) IsProduction() bool {
+    return strings.EqualFold(c.Environment, envProduction)
+}
+
+// Validate checks the settings against each other. In production it also refuses placeholder
+// secrets and disabled authentication, so a missing secret fails the deploy instead of
+// running with a sample value.
+func (c *Config) Validate() error {
+    var errs []error
+
+    if _, err := c.GetDialect(); err != nil {
+        errs = append(errs, fmt.Errorf("invalid DB_DRIVER: %w", err))
+    }
+    if port, err := strconv.Atoi(c.ServerPort); err != nil || port < 1 || port > 65535 {
+        errs = append(errs, fmt.Errorf("invalid PORT %q", c.ServerPort))
+    }
+    switch strings.ToLower(c.LogLevel) {
+    case "debug", "info", "warn", "error":
+    default:
+        errs = append(errs, fmt.Errorf("invalid LOG_LEVEL %q, use debug, info, warn or error", c.LogLevel))
+    }
+    switch strings.ToLower(c.LogFormat) {
+    case "json", "text":
+    default:
+        errs = append(errs, fmt.Errorf("invalid LOG_FORMAT %q, use json or text", c.LogFormat))
+    }
+    if c.TracingExporter != tracing.ExporterNone && c.TracingExporter != tracing.ExporterOTLP {
+        errs = append(errs, fmt.Errorf("invalid TRACING_EXPORTER %q, use none or otlp", c.TracingExporter))
+    }
+    for _, sink := range c.AuditSinks {
+        if sink != "database" && sink != "logstash" && sink != "stdout" {
+            errs = append(errs, fmt.Errorf("unknown audit sink %q in AUDIT_SINKS", sink))
+        }
+    }
+    if c.AuthEnabled && c.JWTSecret == "" && c.JWTJWKSURL == "" {
+        errs = append(errs, errors.New("AUTH_ENABLED requires JWT_SECRET or JWT_JWKS_URL"))
+    }
+    switch c.VaultAuthMethod {
+    case vault.AuthToken:
+    case vault.AuthAppRole:
+        if c.VaultAddr != "" && (c.VaultAppRoleID == "" || c.VaultAppSecretID == "") {
+            errs = append(errs, errors.New("VAULT_AUTH_METHOD=approle requires VAULT_APPROLE_ROLE_ID and VAULT_APPROLE_SECRET_ID"))
+        }
+    case vault.AuthKubernetes:
+        if c.VaultAddr != "" && c.VaultK8sRole == "" {
+            errs = append(errs, errors.New("VAULT_AUTH_METHOD=kubernetes requires VAULT_K8S_ROLE"))
+        }
+    default:
+        errs = append(errs, fmt.Errorf("invalid VAULT_AUTH_METHOD %q, use token, approle or kubernetes", c.VaultAuthMethod))
+    }
+    if c.ScheduleRetry.Limit < 0 {
+        errs = append(errs, errors.New("SCHEDULE_RETRY_LIMIT cannot be negative"))
+    }
+    if c.ScheduleRetry.Limit > 0 {
+        if c.ScheduleRetry.Backoff <= 0 || c.ScheduleRetry.PollInterval <= 0 {
+            errs = append(errs, errors.New("SCHEDULE_RETRY_BACKOFF and SCHEDULE_RETRY_POLL_INTERVAL must be positive"))
+        }
+        if c.ScheduleRetry.MaxBackoff < c.ScheduleRetry.Backoff {
+            errs = append(errs, errors.New("SCHEDULE_RETRY_MAX_BACKOFF cannot be less than SCHEDULE_RETRY_BACKOFF"))
+        }
+    }
+    if c.VaultDBCredsPath != "" {
+        if c.VaultAddr == "" {
+            errs = append(errs, errors.New("VAULT_DB_CREDS_PATH requires VAULT_ADDR"))
+        }
+        if c.DatabaseURL != "" {
+            errs = append(errs, errors.New("VAULT_DB_CREDS_PATH cannot be used with DATABASE_URL, set DB_HOST, DB_PORT and DB_NAME instead"))
+        }
+        if c.VaultDBRotateBefore <= 0 {
+            errs = append(errs, errors.New("VAULT_DB_ROTATE_BEFORE must be positive"))
+        }
+    }
+
+    if c.IsProduction() {
+        if !c.AuthEnabled {
+            errs = append(errs, errors.New("AUTH_ENABLED=false is not allowed in production"))
+        }
+        if c.DatabaseURL == "" && c.DatabasePass == "" && c.VaultDBCredsPath == "" {
+            errs = append(errs, errors.New("DB_PASSWORD is required in production"))
+        }
+        if c.JWTSecret != "" && len(c.JWTSecret) < minJWTSecretLength {
+            errs = append(errs, fmt.Errorf("JWT_SECRET must be at least %d characters in production", minJWTSecretLength))
+        }
+        for _, s := range settings {
+            if s.secret && placeholderSecrets[c.values[s.key].value] {
+                errs = append(errs, fmt.Errorf("%s is set to a placeholder value", s.key))
+            }
+        }
+    }
+
+    return errors.Join(errs...)
+}
+
+// Print writes every setting as KEY=value followed by its source. With redacted, the values
+// of secrets are replaced by asterisks.
+func (c *Config) Print(w io.Writer, redacted bool) error {
+    for _, s := range settings {
+        value := c.values[s.key]
+        shown := value.value
+        if redacted {
+            shown = redact(s, shown)
+        }
+        if _, err := fmt.Fprintf(w, "%s=%s # %s\n", s.key, shown, value.source); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// redact hides the value of a secret, an unset secret stays empty
+func redact(s setting, value string) string {
+    if s.secret && value != "" {
+        return "******"
+    }
+    return value
+}
+
+func lookupSetting(key string) (setting, bool) {
+    for _, s := range settings {
+        if s.key == key {
+            return s, true
+        }
+        for _, alias := range s.aliases {
+            if alias == key {
+                return s, true
+            }
+        }
+    }
+    return setting{}, false
+}
+
+// GetDialect returns the SQL dialect of DB_DRIVER, mssql (default) or postgres
+func (c *Config) GetDialect() (sqldialect.Dialect, error) {
+    return sqldialect.New(c.DatabaseDriver)
 }
 
 func (c *Config) GetDatabaseURL() string {
     if c.DatabaseURL != "" {
         return c.DatabaseURL
     }
-    
+    return c.DatabaseURLWithCredentials(c.DatabaseUser, c.DatabasePass)
+}
+
+// DatabaseURLWithCredentials returns the connection string of DB_HOST, DB_PORT and DB_NAME
+// for user and password, which may differ from DB_USER when they are leased from Vault
+func (c *Config) DatabaseURLWithCredentials(user, password string) string {
+    if dialect, err := c.GetDialect(); err == nil && dialect.Name() == sqldialect.Postgres {
+        return "host=" + c.DatabaseHost +
+               " port=" + c.DatabasePort +
+               " user=" + user +
+               " password=" + password +
+               " dbname=" + c.DatabaseName +
+               " sslmode=disable connect_timeout=30"
+    }
+
     // SQL Server connection string format
-    return "server=" + c.DatabaseHost + 
+    return "server=" + c.DatabaseHost +
            ";port=" + c.DatabasePort +
-           ";user id=" + c.DatabaseUser +
-           ";password=" + c.DatabasePass +
+           ";user id=" + user +
+           ";password=" + password +
            ";database=" + c.DatabaseName +
            ";encrypt=disable;connection timeout=30"
 }
 
-func getEnv(key, defaultValue string) string {
-    if value := os.Getenv(key); value != "" {
-        return value
+// GetTracingConfig returns where spans are exported
+func (c *Config) GetTracingConfig() tracing.Config {
+    return tracing.Config{
+        Exporter:    c.TracingExporter,
+        Endpoint:    c.TracingEndpoint,
+        ServiceName: c.TracingServiceName,
+        Environment: c.Environment,
+        SampleRatio: c.TracingSampleRatio,
+    }
+}
+
+// GetAuthConfig returns the JWT verification settings. With JWT_JWKS_URL set, RS256/ES256
+// tokens are verified against the SSO keys; JWT_SECRET keeps HS256 tokens working.
+func (c *Config) GetAuthConfig() auth.Config {
+    return auth.Config{
+        Secret:     c.JWTSecret,
+        JWKSURL:    c.JWTJWKSURL,
+        Algorithms: c.JWTAlgorithms,
+        Issuer:     c.JWTIssuer,
+        Audience:   c.JWTAudience,
+        Leeway:     c.JWTClockSkew,
     }
-    return defaultValue
-}
\ No newline at end of file
+}
+
+func asString(field func(c *Config) *string) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        *field(c) = value
+        return nil
+    }
+}
+
+func asBool(field func(c *Config) *bool) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+        if err != nil {
+            return errors.New("use true or false")
+        }
+        *field(c) = parsed
+        return nil
+    }
+}
+
+// asInt parses a number that must not be negative
+func asInt(field func(c *Config) *int) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        number, err := strconv.Atoi(strings.TrimSpace(value))
+        if err != nil || number < 0 {
+            return errors.New("use a number of 0 or more")
+        }
+        *field(c) = number
+        return nil
+    }
+}
+
+// asInt64 parses a number that must not be negative
+func asInt64(field func(c *Config) *int64) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        number, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+        if err != nil || number < 0 {
+            return errors.New("use a number of 0 or more")
+        }
+        *field(c) = number
+        return nil
+    }
+}
+
+// asRatio parses a number from 0 to 1
+func asRatio(field func(c *Config) *float64) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        ratio, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+        if err != nil || ratio < 0 || ratio > 1 {
+            return errors.New("use a number from 0 to 1")
+        }
+        *field(c) = ratio
+        return nil
+    }
+}
+
+// asDuration parses a duration like 30s or 5m that must not be negative
+func asDuration(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        duration, err := time.ParseDuration(strings.TrimSpace(value))
+        if err != nil || duration < 0 {
+            return errors.New("use a duration like 30s or 5m")
+        }
+        *field(c) = duration
+        return nil
+    }
+}
+
+// asList parses a comma separated list and drops empty entries
+func asList(field func(c *Config) *[]string) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        var list []string
+        for _, item := range strings.Split(value, ",") {
+            if item = strings.TrimSpace(item); item != "" {
+                list = append(list, item)
+            }
+        }
+        *field(c) = list
+        return nil
+    }
+}
+
+// asIntList parses a comma separated list of numbers
+func asIntList(field func(c *Config) *[]int) func(c *Config, value string) error {
+    return func(c *Config, value string) error {
+        var list []int
+        for _, item := range strings.Split(value, ",") {
+            if item = strings.TrimSpace(item); item == "" {
+                continue
+            }
+            number, err := strconv.Atoi(item)
+            if err != nil {
+                return fmt.Errorf("%q is not a number", item)
+            }
+            list = append(list, number)
+        }
+        *field(c) = list
+        return nil
+    }
+}
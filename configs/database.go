@@ -1,104 +1,157 @@
 package configs
 
 import (
+    "context"
     "database/sql"
+    "database/sql/driver"
     "fmt"
     "log"
+    "log/slog"
     "time"
-    "sync"
 
-    "provider-report-api/pkg/vault"
+    "provider-report-api/pkg/sqldialect"
+    "provider-report-api/pkg/tracing"
 
+    "github.com/XSAM/otelsql"
     "github.com/jmoiron/sqlx"
     _ "github.com/denisenkom/go-mssqldb" // SQL Server driver
+    _ "github.com/lib/pq"                 // PostgreSQL driver
 )
 
-var db *sql.DB
-var once sync.Once
+// sharedDB is the pool opened by Initialize
+var sharedDB *sqlx.DB
 
-func Initialize(databaseURL string) (*sqlx.DB, error) {
-    log.Printf("Connecting to SQL Server database...")
-    log.Printf("Connection string: %s", maskPassword(databaseURL))
-    
-    // เปลี่ยนจาก postgres เป็น mssql
-    db, err := sqlx.Connect("mssql", databaseURL)
+// Connection pool settings
+const (
+    maxOpenConns    = 25
+    maxIdleConns    = 5
+    connMaxLifetime = time.Hour
+)
+
+// Initialize connects to the database of dialect, SQL Server or PostgreSQL. Every query is
+// traced, see tracing.SQLOptions.
+func Initialize(dialect sqldialect.Dialect, databaseURL string) (*sqlx.DB, error) {
+    slog.Info("connecting to database", "dialect", dialect.Name(), "dsn", maskPassword(databaseURL))
+
+    sqlDB, err := otelsql.Open(dialect.DriverName(), databaseURL, tracing.SQLOptions(dialect)...)
     if err != nil {
         return nil, fmt.Errorf("failed to connect to database: %w", err)
     }
+    db := sqlx.NewDb(sqlDB, dialect.DriverName())
 
     // Test connection
     if err := db.Ping(); err != nil {
+        db.Close()
         return nil, fmt.Errorf("failed to ping database: %w", err)
     }
 
     // Set connection pool settings
-    db.SetMaxOpenConns(25)
-    db.SetMaxIdleConns(5)
-    db.SetConnMaxLifetime(time.Hour)
+    db.SetMaxOpenConns(maxOpenConns)
+    db.SetMaxIdleConns(maxIdleConns)
+    db.SetConnMaxLifetime(connMaxLifetime)
+
+    slog.Info("connected to database", "dialect", dialect.Name())
+    sharedDB = db
+    return db, nil
+}
+
+// CredentialsFunc returns the username and password to open a connection with
+type CredentialsFunc func() (username, password string)
+
+// InitializeWithCredentials connects like Initialize, but every new connection of the pool
+// asks credentials for its username and password, so credentials leased from Vault can rotate
+// without restarting. Connections are recycled after maxLifetime, which should be shorter
+// than the time left on the old lease when credentials rotate.
+func InitializeWithCredentials(dialect sqldialect.Dialect, cfg *Config, credentials CredentialsFunc, maxLifetime time.Duration) (*sqlx.DB, error) {
+    slog.Info("connecting to database with leased credentials", "dialect", dialect.Name())
+
+    // sql.Open only looks up the driver, it does not connect
+    probe, err := sql.Open(dialect.DriverName(), "")
+    if err != nil {
+        return nil, fmt.Errorf("failed to load %s driver: %w", dialect.DriverName(), err)
+    }
+    drv := probe.Driver()
+    probe.Close()
+
+    connector := &credentialsConnector{
+        driver: drv,
+        dsn: func() string {
+            user, password := credentials()
+            return cfg.DatabaseURLWithCredentials(user, password)
+        },
+    }
+    db := sqlx.NewDb(otelsql.OpenDB(connector, tracing.SQLOptions(dialect)...), dialect.DriverName())
+
+    if err := db.Ping(); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("failed to ping database: %w", err)
+    }
 
-    log.Println("Successfully connected to SQL Server database")
+    db.SetMaxOpenConns(maxOpenConns)
+    db.SetMaxIdleConns(maxIdleConns)
+    db.SetConnMaxLifetime(min(connMaxLifetime, maxLifetime))
+
+    slog.Info("connected to database", "dialect", dialect.Name())
+    sharedDB = db
     return db, nil
 }
 
-// Health check function
-func HealthCheck(db *sqlx.DB) error {
+// RecycleIdleConnections closes the idle connections of db, so the next queries open
+// connections with the current credentials. Connections in use are closed when released
+// after their max lifetime.
+func RecycleIdleConnections(db *sqlx.DB) {
+    db.SetMaxIdleConns(0)
+    db.SetMaxIdleConns(maxIdleConns)
+}
+
+// credentialsConnector opens connections with the connection string of the moment
+type credentialsConnector struct {
+    driver driver.Driver
+    dsn    func() string
+}
+
+func (c *credentialsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+    dsn := c.dsn()
+    if dc, ok := c.driver.(driver.DriverContext); ok {
+        connector, err := dc.OpenConnector(dsn)
+        if err != nil {
+            return nil, err
+        }
+        return connector.Connect(ctx)
+    }
+    return c.driver.Open(dsn)
+}
+
+func (c *credentialsConnector) Driver() driver.Driver {
+    return c.driver
+}
+
+// HealthCheck runs a trivial query on db
+func HealthCheck(ctx context.Context, db *sqlx.DB) error {
     var result int
-    err := db.Get(&result, "SELECT 1")
+    err := db.GetContext(ctx, &result, "SELECT 1")
     if err != nil {
         return fmt.Errorf("database health check failed: %w", err)
     }
     return nil
 }
 
-// GetDB returns a singleton DB instance
+// GetDB returns the pool opened by Initialize, for the helpers that predate dependency
+// injection. Sharing it keeps the service at one connection pool.
 func GetDB() *sql.DB {
-    once.Do(func() {
-        log.Println("Initializing database connection...")
-        
-        // ลองใช้ vault secret ก่อน
-        creds := vault.GetDatabaseSecret()
-        if creds != nil {
-            log.Println("Using Vault credentials")
-            connString := fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d;database=%s;encrypt=disable;connection timeout=30",
-                creds.DatabaseUrl, creds.DatabaseUsername, creds.DatabasePassword, creds.DatabasePort, creds.DatabaseName)
-            
-            var err error
-            db, err = sql.Open("mssql", connString)
-            if err != nil {
-                log.Printf("Error creating database connection with Vault: %v", err)
-                db = nil
-            } else {
-                err = db.Ping()
-                if err != nil {
-                    log.Printf("Error pinging database with Vault: %v", err)
-                    db.Close()
-                    db = nil
-                }
-            }
-        }
-        
-        // ถ้า vault ไม่ได้หรือเชื่อมต่อไม่ได้ ให้ใช้ environment variables
-        if db == nil {
-            log.Println("Vault failed, using environment variables")
-            config := Load()
-            connString := config.GetDatabaseURL()
-            log.Printf("Connection string: %s", maskPassword(connString))
-            
-            var err error
-            db, err = sql.Open("mssql", connString)
-            if err != nil {
-                log.Fatalf("Error creating database connection: %v", err)
-            }
-
-            err = db.Ping()
-            if err != nil {
-                log.Fatalf("Error pinging database: %v", err)
-            }
-            
-            log.Println("Successfully connected to database using environment variables")
-        }
-    })
-    return db
+    if sharedDB == nil {
+        log.Fatal("database is not initialized, call Initialize first")
+    }
+    return sharedDB.DB
+}
+
+// Rebind converts the ? placeholders of query to the ones of the database opened by
+// Initialize, for the helpers using GetDB
+func Rebind(query string) string {
+    if sharedDB == nil {
+        log.Fatal("database is not initialized, call Initialize first")
+    }
+    return sharedDB.Rebind(query)
 }
 
 // Helper function to mask password in connection string for logging
@@ -3,6 +3,9 @@ package constant
 const DEFAULT_PAGE_SIZE = 20
 const DEFAULT_LIMIT_RECORDS = 10000
 
+// PROVIDER_DETAIL_REPORT_MENU_ID is the MAINTAIN.MENU entry that grants access to the provider detail report
+const PROVIDER_DETAIL_REPORT_MENU_ID = 62
+
 const (
 	TRANSACTION_TYPE_NEW         = "New"
 	TRANSACTION_TYPE_ENDORSEMENT = "Endorsement"
@@ -34,6 +37,9 @@ const (
 const (
 	CLIENT_MGMT_INDEX = "user-management-history"
 	// client-management-history-paymentdetail
+
+	// PROVIDER_REPORT_INDEX tags the audit events of this service
+	PROVIDER_REPORT_INDEX = "provider-report-history"
 )
 
 // EXPORT PROVIDER
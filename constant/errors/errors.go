@@ -3,10 +3,16 @@ package clienterrors
 import "errors"
 
 var ErrAlreadyDeleted = errors.New("object is already deleted")
+var ErrNotDeleted = errors.New("object is not deleted")
 var ErrInsurerDeleted = errors.New("insurer is deleted or does not exist")
 var ErrProviderDeleted = errors.New("provier is deleted or does not exist")
 var ErrNotFound = errors.New("object is deleted or does not exist")
 var ErrDataNotFound = errors.New("data not found")
+var ErrDuplicateName = errors.New("name is already in use")
+var ErrInvalidInput = errors.New("invalid input")
+var ErrPreconditionFailed = errors.New("object was modified by another request")
+var ErrQuotaExceeded = errors.New("daily export quota exceeded")
+var ErrShuttingDown = errors.New("server is shutting down")
 
 var Errn = errors.New("company is deleted or does not exist")
 
@@ -0,0 +1,15 @@
+// Package database embeds the SQL migrations, one directory per dialect with the same versions
+package database
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed migrations/postgres/*.sql migrations/mssql/*.sql
+var migrations embed.FS
+
+// Migrations returns the migration files of dialect, sqldialect.Postgres or sqldialect.SQLServer
+func Migrations(dialect string) (fs.FS, error) {
+	return fs.Sub(migrations, "migrations/"+dialect)
+}
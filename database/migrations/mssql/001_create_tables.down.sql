@@ -0,0 +1,10 @@
+IF OBJECT_ID(N'sent_report_logs', N'U') IS NOT NULL
+    DROP TABLE sent_report_logs;
+IF OBJECT_ID(N'schedules', N'U') IS NOT NULL
+    DROP TABLE schedules;
+IF OBJECT_ID(N'templates', N'U') IS NOT NULL
+    DROP TABLE templates;
+IF OBJECT_ID(N'available_fields', N'U') IS NOT NULL
+    DROP TABLE available_fields;
+IF OBJECT_ID(N'providers', N'U') IS NOT NULL
+    DROP TABLE providers;
@@ -0,0 +1,171 @@
+-- Providers table with all fields
+IF OBJECT_ID(N'providers', N'U') IS NULL
+CREATE TABLE providers (
+id INT IDENTITY(1,1) PRIMARY KEY,
+
+-- Basic Info
+provider_code NVARCHAR(50) NOT NULL UNIQUE,
+title_thai NVARCHAR(100),
+name_thai NVARCHAR(255) NOT NULL,
+title_eng NVARCHAR(100),
+name_eng NVARCHAR(255),
+provider_type NVARCHAR(100) NOT NULL DEFAULT 'Hospital',
+register_status NVARCHAR(100),
+business_type NVARCHAR(100),
+bed_size NVARCHAR(50),
+eligibility_method NVARCHAR(100),
+opening_time NVARCHAR(100),
+provider_status NVARCHAR(50) NOT NULL DEFAULT 'Active',
+is_tpa_network BIT NOT NULL DEFAULT 0,
+has_incident BIT NOT NULL DEFAULT 0,
+
+-- Address
+building_no NVARCHAR(100),
+village_no NVARCHAR(100),
+lane_alley NVARCHAR(100),
+road NVARCHAR(100),
+sub_district NVARCHAR(100),
+district NVARCHAR(100),
+province NVARCHAR(100) NOT NULL,
+region NVARCHAR(100),
+country NVARCHAR(100) DEFAULT N'ประเทศไทย',
+post_code NVARCHAR(20),
+
+-- Contact Info
+title_name NVARCHAR(255),
+department NVARCHAR(100),
+general_phone_no NVARCHAR(50),
+direct_phone_no NVARCHAR(50),
+email NVARCHAR(255),
+email_to_list NVARCHAR(MAX),
+email_cc_list NVARCHAR(MAX),
+
+-- Tax Info
+provider_tax_id NVARCHAR(20),
+wh_tax_percent DECIMAL(5,2),
+exempt_percent DECIMAL(5,2),
+wh_tax_exempt_from DATE,
+wh_tax_exempt_to DATE,
+
+-- Payment Info
+payment_method NVARCHAR(50),
+payment_branch_id NVARCHAR(20),
+payee_name NVARCHAR(255),
+bank_account_number NVARCHAR(50),
+bank_account_type NVARCHAR(50),
+bank_branch_name NVARCHAR(100),
+bank_name NVARCHAR(100),
+
+-- Discount and pricing categories (JSON arrays)
+discount_categories NVARCHAR(MAX) NOT NULL DEFAULT '[]',
+pricing_categories NVARCHAR(MAX) NOT NULL DEFAULT '[]',
+
+-- System fields
+created_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP,
+updated_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP,
+created_by NVARCHAR(100),
+updated_by NVARCHAR(100)
+);
+
+-- Fields that can be placed on a report template
+IF OBJECT_ID(N'available_fields', N'U') IS NULL
+CREATE TABLE available_fields (
+id INT IDENTITY(1,1) PRIMARY KEY,
+field_code NVARCHAR(100) NOT NULL UNIQUE,
+field_name_thai NVARCHAR(255) NOT NULL,
+field_name_eng NVARCHAR(255) NOT NULL,
+field_type NVARCHAR(20) NOT NULL DEFAULT 'text'
+    CHECK (field_type IN ('text', 'numeric', 'date', 'boolean')),
+field_category NVARCHAR(20) NOT NULL
+    CHECK (field_category IN ('header', 'summary', 'detail')),
+data_source NVARCHAR(255),
+format_example NVARCHAR(255),
+is_required BIT NOT NULL DEFAULT 0,
+is_active BIT NOT NULL DEFAULT 1,
+sort_order INT,
+description NVARCHAR(MAX)
+);
+
+-- Report templates: field codes of the header, data and summary sections
+IF OBJECT_ID(N'templates', N'U') IS NULL
+CREATE TABLE templates (
+id INT IDENTITY(1,1) PRIMARY KEY,
+template_name NVARCHAR(255) NOT NULL,
+is_standard BIT NOT NULL DEFAULT 0,
+description NVARCHAR(MAX),
+header_fields NVARCHAR(MAX) NOT NULL DEFAULT '[]',
+data_fields NVARCHAR(MAX) NOT NULL DEFAULT '[]',
+summary_fields NVARCHAR(MAX) NOT NULL DEFAULT '[]',
+field_positions NVARCHAR(MAX),
+created_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP,
+updated_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP,
+created_by NVARCHAR(100) NOT NULL,
+updated_by NVARCHAR(100),
+is_deleted BIT NOT NULL DEFAULT 0
+);
+
+-- Scheduled reports emailed to recipients
+IF OBJECT_ID(N'schedules', N'U') IS NULL
+CREATE TABLE schedules (
+id INT IDENTITY(1,1) PRIMARY KEY,
+schedule_name NVARCHAR(255) NOT NULL,
+template_id INT NOT NULL REFERENCES templates(id),
+email_to NVARCHAR(MAX) NOT NULL,
+email_cc NVARCHAR(MAX),
+email_bcc NVARCHAR(MAX),
+frequency NVARCHAR(20) NOT NULL CHECK (frequency IN ('daily', 'weekly', 'monthly')),
+schedule_days NVARCHAR(MAX) NOT NULL DEFAULT '[]',
+start_date DATE NOT NULL,
+end_date DATE,
+start_time NVARCHAR(8) NOT NULL,
+timezone NVARCHAR(50) NOT NULL DEFAULT 'Asia/Bangkok',
+is_active BIT NOT NULL DEFAULT 1,
+last_run_at DATETIME2,
+next_run_at DATETIME2,
+search_criteria NVARCHAR(MAX) NOT NULL DEFAULT '{}',
+export_format NVARCHAR(10) NOT NULL DEFAULT 'excel',
+created_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP,
+updated_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP,
+created_by NVARCHAR(100) NOT NULL,
+updated_by NVARCHAR(100),
+is_deleted BIT NOT NULL DEFAULT 0
+);
+
+-- Reports sent by email, manually or by a schedule
+IF OBJECT_ID(N'sent_report_logs', N'U') IS NULL
+CREATE TABLE sent_report_logs (
+id INT IDENTITY(1,1) PRIMARY KEY,
+template_id INT NOT NULL REFERENCES templates(id),
+schedule_id INT REFERENCES schedules(id),
+recipients NVARCHAR(MAX) NOT NULL,
+subject NVARCHAR(500),
+file_name NVARCHAR(255),
+file_size_kb INT,
+export_format NVARCHAR(10),
+total_records INT,
+sent_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP,
+status NVARCHAR(20) NOT NULL CHECK (status IN ('success', 'failed', 'pending')),
+error_message NVARCHAR(MAX),
+retry_count INT NOT NULL DEFAULT 0,
+execution_time_ms INT
+);
+
+-- Indexes
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_providers_name_thai' AND object_id = OBJECT_ID(N'providers'))
+    CREATE INDEX idx_providers_name_thai ON providers(name_thai);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_providers_province' AND object_id = OBJECT_ID(N'providers'))
+    CREATE INDEX idx_providers_province ON providers(province);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_providers_type' AND object_id = OBJECT_ID(N'providers'))
+    CREATE INDEX idx_providers_type ON providers(provider_type);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_providers_status' AND object_id = OBJECT_ID(N'providers'))
+    CREATE INDEX idx_providers_status ON providers(provider_status);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_providers_created_at' AND object_id = OBJECT_ID(N'providers'))
+    CREATE INDEX idx_providers_created_at ON providers(created_at);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_available_fields_category' AND object_id = OBJECT_ID(N'available_fields'))
+    CREATE INDEX idx_available_fields_category ON available_fields(field_category, sort_order);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_schedules_next_run_at' AND object_id = OBJECT_ID(N'schedules'))
+    CREATE INDEX idx_schedules_next_run_at ON schedules(next_run_at);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_sent_report_logs_sent_at' AND object_id = OBJECT_ID(N'sent_report_logs'))
+    CREATE INDEX idx_sent_report_logs_sent_at ON sent_report_logs(sent_at);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_sent_report_logs_schedule' AND object_id = OBJECT_ID(N'sent_report_logs'))
+    CREATE INDEX idx_sent_report_logs_schedule ON sent_report_logs(schedule_id);
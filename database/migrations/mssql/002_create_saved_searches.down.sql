@@ -0,0 +1,2 @@
+IF OBJECT_ID(N'saved_searches', N'U') IS NOT NULL
+    DROP TABLE saved_searches;
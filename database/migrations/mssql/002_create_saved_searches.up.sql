@@ -0,0 +1,17 @@
+-- Saved provider searches per user (database fallback for the Redis search preferences)
+IF OBJECT_ID(N'saved_searches', N'U') IS NULL
+CREATE TABLE saved_searches (
+id INT IDENTITY(1,1) PRIMARY KEY,
+username NVARCHAR(100) NOT NULL,
+search_name NVARCHAR(100) NOT NULL,
+search_criteria NVARCHAR(MAX) NOT NULL DEFAULT '{}',
+template_id INT,
+format_type NVARCHAR(20),
+is_default BIT NOT NULL DEFAULT 0,
+created_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP,
+updated_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP,
+UNIQUE (username, search_name)
+);
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_saved_searches_username' AND object_id = OBJECT_ID(N'saved_searches'))
+    CREATE INDEX idx_saved_searches_username ON saved_searches(username);
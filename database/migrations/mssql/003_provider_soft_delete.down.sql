@@ -0,0 +1,6 @@
+IF EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_providers_deleted_at' AND object_id = OBJECT_ID(N'providers'))
+    DROP INDEX idx_providers_deleted_at ON providers;
+IF COL_LENGTH(N'providers', N'deleted_by') IS NOT NULL
+    ALTER TABLE providers DROP COLUMN deleted_by;
+IF COL_LENGTH(N'providers', N'deleted_at') IS NOT NULL
+    ALTER TABLE providers DROP COLUMN deleted_at;
@@ -0,0 +1,9 @@
+-- Soft delete for providers: rows are referenced by claims history and must not be removed
+IF COL_LENGTH(N'providers', N'deleted_at') IS NULL
+    ALTER TABLE providers ADD deleted_at DATETIME2;
+IF COL_LENGTH(N'providers', N'deleted_by') IS NULL
+    ALTER TABLE providers ADD deleted_by NVARCHAR(100);
+GO
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_providers_deleted_at' AND object_id = OBJECT_ID(N'providers'))
+    CREATE INDEX idx_providers_deleted_at ON providers(deleted_at);
@@ -0,0 +1,12 @@
+IF OBJECT_ID(N'df_schedules_version') IS NOT NULL
+    ALTER TABLE schedules DROP CONSTRAINT df_schedules_version;
+IF COL_LENGTH(N'schedules', N'version') IS NOT NULL
+    ALTER TABLE schedules DROP COLUMN version;
+IF OBJECT_ID(N'df_templates_version') IS NOT NULL
+    ALTER TABLE templates DROP CONSTRAINT df_templates_version;
+IF COL_LENGTH(N'templates', N'version') IS NOT NULL
+    ALTER TABLE templates DROP COLUMN version;
+IF OBJECT_ID(N'df_providers_version') IS NOT NULL
+    ALTER TABLE providers DROP CONSTRAINT df_providers_version;
+IF COL_LENGTH(N'providers', N'version') IS NOT NULL
+    ALTER TABLE providers DROP COLUMN version;
@@ -0,0 +1,7 @@
+-- Row versions for optimistic concurrency control (exposed as ETag / If-Match)
+IF COL_LENGTH(N'providers', N'version') IS NULL
+    ALTER TABLE providers ADD version INT NOT NULL CONSTRAINT df_providers_version DEFAULT 1;
+IF COL_LENGTH(N'templates', N'version') IS NULL
+    ALTER TABLE templates ADD version INT NOT NULL CONSTRAINT df_templates_version DEFAULT 1;
+IF COL_LENGTH(N'schedules', N'version') IS NULL
+    ALTER TABLE schedules ADD version INT NOT NULL CONSTRAINT df_schedules_version DEFAULT 1;
@@ -0,0 +1,2 @@
+IF OBJECT_ID(N'change_history', N'U') IS NOT NULL
+    DROP TABLE change_history;
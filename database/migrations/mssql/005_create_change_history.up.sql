@@ -0,0 +1,21 @@
+-- Field-level change history of providers, templates, schedules and fields
+IF OBJECT_ID(N'change_history', N'U') IS NULL
+CREATE TABLE change_history (
+id INT IDENTITY(1,1) PRIMARY KEY,
+change_id NVARCHAR(36) NOT NULL,
+entity_type NVARCHAR(20) NOT NULL,
+entity_id INT NOT NULL,
+action NVARCHAR(20) NOT NULL,
+field_name NVARCHAR(100),
+old_value NVARCHAR(MAX),
+new_value NVARCHAR(MAX),
+changed_by NVARCHAR(100) NOT NULL,
+changed_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_change_history_entity' AND object_id = OBJECT_ID(N'change_history'))
+    CREATE INDEX idx_change_history_entity ON change_history(entity_type, entity_id);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_change_history_changed_at' AND object_id = OBJECT_ID(N'change_history'))
+    CREATE INDEX idx_change_history_changed_at ON change_history(changed_at);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_change_history_field_name' AND object_id = OBJECT_ID(N'change_history'))
+    CREATE INDEX idx_change_history_field_name ON change_history(field_name);
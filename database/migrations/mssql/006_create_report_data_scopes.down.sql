@@ -0,0 +1,4 @@
+IF COL_LENGTH(N'schedules', N'owner_role_id') IS NOT NULL
+    ALTER TABLE schedules DROP COLUMN owner_role_id;
+IF OBJECT_ID(N'report_data_scopes', N'U') IS NOT NULL
+    DROP TABLE report_data_scopes;
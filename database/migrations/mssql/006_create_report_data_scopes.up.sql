@@ -0,0 +1,15 @@
+-- Row-level data scope per user role (USER_ROLE_ID of the JWT userRoleId claim).
+-- Roles without a row see every provider; empty lists do not restrict.
+IF OBJECT_ID(N'report_data_scopes', N'U') IS NULL
+CREATE TABLE report_data_scopes (
+user_role_id INT PRIMARY KEY,
+regions NVARCHAR(MAX) NOT NULL DEFAULT '[]',
+provinces NVARCHAR(MAX) NOT NULL DEFAULT '[]',
+provider_types NVARCHAR(MAX) NOT NULL DEFAULT '[]',
+tpa_network_only BIT NOT NULL DEFAULT 0,
+updated_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Scheduled reports run with the data scope of the role that created them
+IF COL_LENGTH(N'schedules', N'owner_role_id') IS NULL
+    ALTER TABLE schedules ADD owner_role_id INT;
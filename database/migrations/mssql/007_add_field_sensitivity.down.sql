@@ -0,0 +1,10 @@
+IF OBJECT_ID(N'ck_available_fields_mask_type') IS NOT NULL
+    ALTER TABLE available_fields DROP CONSTRAINT ck_available_fields_mask_type;
+IF COL_LENGTH(N'available_fields', N'mask_type') IS NOT NULL
+    ALTER TABLE available_fields DROP COLUMN mask_type;
+IF OBJECT_ID(N'ck_available_fields_sensitivity') IS NOT NULL
+    ALTER TABLE available_fields DROP CONSTRAINT ck_available_fields_sensitivity;
+IF OBJECT_ID(N'df_available_fields_sensitivity') IS NOT NULL
+    ALTER TABLE available_fields DROP CONSTRAINT df_available_fields_sensitivity;
+IF COL_LENGTH(N'available_fields', N'sensitivity') IS NOT NULL
+    ALTER TABLE available_fields DROP COLUMN sensitivity;
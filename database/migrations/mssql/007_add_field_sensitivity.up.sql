@@ -0,0 +1,15 @@
+-- Sensitivity classification of report fields. Sensitive fields are masked in responses,
+-- exports and emailed reports unless the user has the VIEW_SENSITIVE action.
+IF COL_LENGTH(N'available_fields', N'sensitivity') IS NULL
+    ALTER TABLE available_fields ADD sensitivity NVARCHAR(20) NOT NULL CONSTRAINT df_available_fields_sensitivity DEFAULT 'public'
+        CONSTRAINT ck_available_fields_sensitivity CHECK (sensitivity IN ('public', 'sensitive'));
+IF COL_LENGTH(N'available_fields', N'mask_type') IS NULL
+    ALTER TABLE available_fields ADD mask_type NVARCHAR(20)
+        CONSTRAINT ck_available_fields_mask_type CHECK (mask_type IN ('account', 'last4', 'email', 'name', 'full'));
+GO
+
+UPDATE available_fields SET sensitivity = 'sensitive', mask_type = 'account' WHERE field_code = 'bank_account_number';
+UPDATE available_fields SET sensitivity = 'sensitive', mask_type = 'last4' WHERE field_code = 'provider_tax_id';
+UPDATE available_fields SET sensitivity = 'sensitive', mask_type = 'name' WHERE field_code = 'payee_name';
+UPDATE available_fields SET sensitivity = 'sensitive', mask_type = 'last4' WHERE field_code IN ('general_phone_no', 'direct_phone_no');
+UPDATE available_fields SET sensitivity = 'sensitive', mask_type = 'email' WHERE field_code IN ('email', 'email_to_list', 'email_cc_list');
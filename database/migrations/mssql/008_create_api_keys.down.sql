@@ -0,0 +1,4 @@
+IF OBJECT_ID(N'api_key_usage', N'U') IS NOT NULL
+    DROP TABLE api_key_usage;
+IF OBJECT_ID(N'api_keys', N'U') IS NOT NULL
+    DROP TABLE api_keys;
@@ -0,0 +1,32 @@
+-- API keys of machine clients (BI tool, claims system). Only a SHA-256 hash of the key is stored.
+IF OBJECT_ID(N'api_keys', N'U') IS NULL
+CREATE TABLE api_keys (
+id INT IDENTITY(1,1) PRIMARY KEY,
+name NVARCHAR(100) NOT NULL,
+key_prefix NVARCHAR(8) NOT NULL UNIQUE,
+key_hash NVARCHAR(64) NOT NULL,
+scopes NVARCHAR(MAX) NOT NULL DEFAULT '[]',
+user_role_id INT,
+expires_at DATETIME2,
+revoked_at DATETIME2,
+revoked_by NVARCHAR(100),
+last_used_at DATETIME2,
+usage_count BIGINT NOT NULL DEFAULT 0,
+created_by NVARCHAR(100) NOT NULL,
+created_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP,
+updated_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+IF OBJECT_ID(N'api_key_usage', N'U') IS NULL
+CREATE TABLE api_key_usage (
+id BIGINT IDENTITY(1,1) PRIMARY KEY,
+api_key_id INT NOT NULL REFERENCES api_keys(id),
+method NVARCHAR(10) NOT NULL,
+path NVARCHAR(255) NOT NULL,
+status INT NOT NULL,
+client_ip NVARCHAR(45),
+used_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_api_key_usage_key' AND object_id = OBJECT_ID(N'api_key_usage'))
+    CREATE INDEX idx_api_key_usage_key ON api_key_usage(api_key_id, used_at DESC);
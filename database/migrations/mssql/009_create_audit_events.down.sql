@@ -0,0 +1,2 @@
+IF OBJECT_ID(N'audit_events', N'U') IS NOT NULL
+    DROP TABLE audit_events;
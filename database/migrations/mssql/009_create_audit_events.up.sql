@@ -0,0 +1,18 @@
+-- Audit trail of report actions (exports, schedule runs, template/provider changes),
+-- written by the database audit sink. Events are retried, so inserts ignore known ids.
+IF OBJECT_ID(N'audit_events', N'U') IS NULL
+CREATE TABLE audit_events (
+id NVARCHAR(36) PRIMARY KEY,
+action NVARCHAR(100) NOT NULL,
+username NVARCHAR(100) NOT NULL,
+data NVARCHAR(MAX),
+level NVARCHAR(10) NOT NULL,
+service NVARCHAR(100) NOT NULL,
+sub_module NVARCHAR(100),
+created_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_audit_events_action' AND object_id = OBJECT_ID(N'audit_events'))
+    CREATE INDEX idx_audit_events_action ON audit_events(action, created_at DESC);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_audit_events_username' AND object_id = OBJECT_ID(N'audit_events'))
+    CREATE INDEX idx_audit_events_username ON audit_events(username, created_at DESC);
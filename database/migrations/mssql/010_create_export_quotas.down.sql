@@ -0,0 +1,4 @@
+IF OBJECT_ID(N'export_usage', N'U') IS NOT NULL
+    DROP TABLE export_usage;
+IF OBJECT_ID(N'export_quotas', N'U') IS NOT NULL
+    DROP TABLE export_quotas;
@@ -0,0 +1,19 @@
+-- Daily export quotas of user roles. NULL limits fall back to the EXPORT_DAILY_* defaults,
+-- 0 disables the limit.
+IF OBJECT_ID(N'export_quotas', N'U') IS NULL
+CREATE TABLE export_quotas (
+user_role_id INT PRIMARY KEY,
+daily_row_limit BIGINT,
+daily_byte_limit BIGINT,
+updated_at DATETIME2 NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Rows and bytes exported by each user per day, checked against the quota of the user's role
+IF OBJECT_ID(N'export_usage', N'U') IS NULL
+CREATE TABLE export_usage (
+username NVARCHAR(100) NOT NULL,
+usage_date DATE NOT NULL,
+row_count BIGINT NOT NULL DEFAULT 0,
+byte_count BIGINT NOT NULL DEFAULT 0,
+PRIMARY KEY (username, usage_date)
+);
@@ -0,0 +1,6 @@
+IF EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_audit_events_request_id' AND object_id = OBJECT_ID(N'audit_events'))
+    DROP INDEX idx_audit_events_request_id ON audit_events;
+IF COL_LENGTH(N'audit_events', N'request_id') IS NOT NULL
+    ALTER TABLE audit_events DROP COLUMN request_id;
+IF COL_LENGTH(N'sent_report_logs', N'request_id') IS NOT NULL
+    ALTER TABLE sent_report_logs DROP COLUMN request_id;
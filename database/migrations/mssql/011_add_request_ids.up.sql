@@ -0,0 +1,9 @@
+-- X-Request-ID of the request that ran a scheduled report or caused an audit event
+IF COL_LENGTH(N'sent_report_logs', N'request_id') IS NULL
+    ALTER TABLE sent_report_logs ADD request_id NVARCHAR(128);
+IF COL_LENGTH(N'audit_events', N'request_id') IS NULL
+    ALTER TABLE audit_events ADD request_id NVARCHAR(128);
+GO
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_audit_events_request_id' AND object_id = OBJECT_ID(N'audit_events'))
+    CREATE INDEX idx_audit_events_request_id ON audit_events(request_id);
@@ -0,0 +1,16 @@
+DELETE FROM templates
+WHERE template_name = 'Standard Provider Report' AND created_by = 'system'
+    AND id NOT IN (SELECT template_id FROM schedules)
+    AND id NOT IN (SELECT template_id FROM sent_report_logs);
+
+DELETE FROM available_fields WHERE field_code IN (
+    'provider_code', 'title_thai', 'name_thai', 'title_eng', 'name_eng', 'provider_type',
+    'provider_status', 'register_status', 'business_type', 'bed_size', 'eligibility_method', 'opening_time',
+    'is_tpa_network', 'has_incident', 'building_no', 'village_no', 'lane_alley', 'road',
+    'sub_district', 'district', 'province', 'region', 'country', 'post_code',
+    'title_name', 'department', 'general_phone_no', 'direct_phone_no', 'email', 'email_to_list',
+    'email_cc_list', 'provider_tax_id', 'wh_tax_percent', 'exempt_percent', 'wh_tax_exempt_from', 'wh_tax_exempt_to',
+    'payment_method', 'payment_branch_id', 'payee_name', 'bank_account_number', 'bank_account_type', 'bank_branch_name',
+    'bank_name', 'discount_categories', 'pricing_categories', 'created_at', 'hospital', 'clinic',
+    'grand_total'
+);
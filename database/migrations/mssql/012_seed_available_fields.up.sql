@@ -0,0 +1,65 @@
+-- Fields offered by the template editor. field_code is the JSON name of the provider
+-- field (detail and header sections) or of the summary count; sensitivity matches 007.
+INSERT INTO available_fields (field_code, field_name_thai, field_name_eng, field_type, field_category, data_source, format_example, is_required, sort_order, sensitivity, mask_type)
+SELECT v.*
+FROM (VALUES
+    ('provider_code', N'รหัสสถานพยาบาล', N'Provider Code', 'text', 'header', 'providers.provider_code', N'PVR001', 1, 10, 'public', NULL),
+    ('title_thai', N'คำนำหน้า (ไทย)', N'Title (Thai)', 'text', 'header', 'providers.title_thai', N'บริษัท', 0, 20, 'public', NULL),
+    ('name_thai', N'ชื่อสถานพยาบาล (ไทย)', N'Provider Name (Thai)', 'text', 'header', 'providers.name_thai', N'โรงพยาบาลบำรุงราษฎร์', 1, 30, 'public', NULL),
+    ('title_eng', N'คำนำหน้า (อังกฤษ)', N'Title (English)', 'text', 'header', 'providers.title_eng', N'Company', 0, 40, 'public', NULL),
+    ('name_eng', N'ชื่อสถานพยาบาล (อังกฤษ)', N'Provider Name (English)', 'text', 'header', 'providers.name_eng', N'Bumrungrad Hospital', 0, 50, 'public', NULL),
+    ('provider_type', N'ประเภทสถานพยาบาล', N'Provider Type', 'text', 'header', 'providers.provider_type', N'Hospital', 0, 60, 'public', NULL),
+    ('provider_status', N'สถานะ', N'Provider Status', 'text', 'header', 'providers.provider_status', N'Active', 0, 70, 'public', NULL),
+    ('register_status', N'สถานะการจดทะเบียน', N'Register Status', 'text', 'detail', 'providers.register_status', N'Registered', 0, 80, 'public', NULL),
+    ('business_type', N'ประเภทธุรกิจ', N'Business Type', 'text', 'detail', 'providers.business_type', N'Private', 0, 90, 'public', NULL),
+    ('bed_size', N'จำนวนเตียง', N'Bed Size', 'text', 'detail', 'providers.bed_size', N'100-200', 0, 100, 'public', NULL),
+    ('eligibility_method', N'วิธีตรวจสอบสิทธิ์', N'Eligibility Method', 'text', 'detail', 'providers.eligibility_method', N'Online', 0, 110, 'public', NULL),
+    ('opening_time', N'เวลาทำการ', N'Opening Time', 'text', 'detail', 'providers.opening_time', N'08:00-20:00', 0, 120, 'public', NULL),
+    ('is_tpa_network', N'อยู่ในเครือข่าย TPA', N'TPA Network', 'boolean', 'detail', 'providers.is_tpa_network', N'true', 0, 130, 'public', NULL),
+    ('has_incident', N'มีเหตุการณ์ผิดปกติ', N'Has Incident', 'boolean', 'detail', 'providers.has_incident', N'false', 0, 140, 'public', NULL),
+    ('building_no', N'เลขที่', N'Building No.', 'text', 'detail', 'providers.building_no', N'33', 0, 150, 'public', NULL),
+    ('village_no', N'หมู่ที่', N'Village No.', 'text', 'detail', 'providers.village_no', N'4', 0, 160, 'public', NULL),
+    ('lane_alley', N'ตรอก/ซอย', N'Lane/Alley', 'text', 'detail', 'providers.lane_alley', N'สุขุมวิท 3', 0, 170, 'public', NULL),
+    ('road', N'ถนน', N'Road', 'text', 'detail', 'providers.road', N'สุขุมวิท', 0, 180, 'public', NULL),
+    ('sub_district', N'แขวง/ตำบล', N'Sub-district', 'text', 'detail', 'providers.sub_district', N'คลองเตยเหนือ', 0, 190, 'public', NULL),
+    ('district', N'เขต/อำเภอ', N'District', 'text', 'detail', 'providers.district', N'วัฒนา', 0, 200, 'public', NULL),
+    ('province', N'จังหวัด', N'Province', 'text', 'detail', 'providers.province', N'กรุงเทพมหานคร', 1, 210, 'public', NULL),
+    ('region', N'ภูมิภาค', N'Region', 'text', 'detail', 'providers.region', N'ภาคกลาง', 0, 220, 'public', NULL),
+    ('country', N'ประเทศ', N'Country', 'text', 'detail', 'providers.country', N'ประเทศไทย', 0, 230, 'public', NULL),
+    ('post_code', N'รหัสไปรษณีย์', N'Post Code', 'text', 'detail', 'providers.post_code', N'10110', 0, 240, 'public', NULL),
+    ('title_name', N'ชื่อผู้ติดต่อ', N'Contact Name', 'text', 'detail', 'providers.title_name', N'คุณสมชาย ใจดี', 0, 250, 'public', NULL),
+    ('department', N'แผนก', N'Department', 'text', 'detail', 'providers.department', N'การเงิน', 0, 260, 'public', NULL),
+    ('general_phone_no', N'เบอร์โทรศัพท์', N'General Phone No.', 'text', 'detail', 'providers.general_phone_no', N'02-667-1000', 0, 270, 'sensitive', 'last4'),
+    ('direct_phone_no', N'เบอร์โทรศัพท์สายตรง', N'Direct Phone No.', 'text', 'detail', 'providers.direct_phone_no', N'02-667-1001', 0, 280, 'sensitive', 'last4'),
+    ('email', N'อีเมล', N'Email', 'text', 'detail', 'providers.email', N'info@bumrungrad.com', 0, 290, 'sensitive', 'email'),
+    ('email_to_list', N'อีเมลผู้รับ', N'Email To', 'text', 'detail', 'providers.email_to_list', N'a@example.com;b@example.com', 0, 300, 'sensitive', 'email'),
+    ('email_cc_list', N'อีเมลสำเนา', N'Email CC', 'text', 'detail', 'providers.email_cc_list', N'c@example.com', 0, 310, 'sensitive', 'email'),
+    ('provider_tax_id', N'เลขประจำตัวผู้เสียภาษี', N'Tax ID', 'text', 'detail', 'providers.provider_tax_id', N'0105536000000', 0, 320, 'sensitive', 'last4'),
+    ('wh_tax_percent', N'ภาษีหัก ณ ที่จ่าย (%)', N'Withholding Tax (%)', 'numeric', 'detail', 'providers.wh_tax_percent', N'3.00', 0, 330, 'public', NULL),
+    ('exempt_percent', N'ยกเว้นภาษี (%)', N'Tax Exemption (%)', 'numeric', 'detail', 'providers.exempt_percent', N'0.00', 0, 340, 'public', NULL),
+    ('wh_tax_exempt_from', N'ยกเว้นภาษีตั้งแต่', N'Tax Exempt From', 'date', 'detail', 'providers.wh_tax_exempt_from', N'2024-01-01', 0, 350, 'public', NULL),
+    ('wh_tax_exempt_to', N'ยกเว้นภาษีถึง', N'Tax Exempt To', 'date', 'detail', 'providers.wh_tax_exempt_to', N'2024-12-31', 0, 360, 'public', NULL),
+    ('payment_method', N'วิธีการชำระเงิน', N'Payment Method', 'text', 'detail', 'providers.payment_method', N'Transfer', 0, 370, 'public', NULL),
+    ('payment_branch_id', N'รหัสสาขาที่รับชำระ', N'Payment Branch ID', 'text', 'detail', 'providers.payment_branch_id', N'00000', 0, 380, 'public', NULL),
+    ('payee_name', N'ชื่อผู้รับเงิน', N'Payee Name', 'text', 'detail', 'providers.payee_name', N'บริษัท บำรุงราษฎร์ จำกัด', 0, 390, 'sensitive', 'name'),
+    ('bank_account_number', N'เลขที่บัญชี', N'Bank Account No.', 'text', 'detail', 'providers.bank_account_number', N'123-4-56789-0', 0, 400, 'sensitive', 'account'),
+    ('bank_account_type', N'ประเภทบัญชี', N'Bank Account Type', 'text', 'detail', 'providers.bank_account_type', N'Savings', 0, 410, 'public', NULL),
+    ('bank_branch_name', N'สาขาธนาคาร', N'Bank Branch', 'text', 'detail', 'providers.bank_branch_name', N'สุขุมวิท', 0, 420, 'public', NULL),
+    ('bank_name', N'ธนาคาร', N'Bank', 'text', 'detail', 'providers.bank_name', N'ธนาคารกรุงเทพ', 0, 430, 'public', NULL),
+    ('discount_categories', N'กลุ่มส่วนลด', N'Discount Categories', 'text', 'detail', 'providers.discount_categories', N'["OPD","IPD"]', 0, 440, 'public', NULL),
+    ('pricing_categories', N'กลุ่มราคา', N'Pricing Categories', 'text', 'detail', 'providers.pricing_categories', N'["Standard"]', 0, 450, 'public', NULL),
+    ('created_at', N'วันที่สร้าง', N'Created At', 'date', 'detail', 'providers.created_at', N'2024-01-01 09:00:00', 0, 460, 'public', NULL),
+    ('hospital', N'จำนวนโรงพยาบาล', N'Hospitals', 'numeric', 'summary', 'summary.hospital', N'10', 0, 470, 'public', NULL),
+    ('clinic', N'จำนวนคลินิก', N'Clinics', 'numeric', 'summary', 'summary.clinic', N'25', 0, 480, 'public', NULL),
+    ('grand_total', N'รวมทั้งหมด', N'Grand Total', 'numeric', 'summary', 'summary.grand_total', N'35', 0, 490, 'public', NULL)
+) AS v (field_code, field_name_thai, field_name_eng, field_type, field_category, data_source, format_example, is_required, sort_order, sensitivity, mask_type)
+WHERE NOT EXISTS (SELECT 1 FROM available_fields f WHERE f.field_code = v.field_code);
+
+-- Standard template selected by default in the report screen
+IF NOT EXISTS (SELECT 1 FROM templates WHERE is_standard = 1)
+INSERT INTO templates (template_name, is_standard, description, header_fields, data_fields, summary_fields, created_by)
+VALUES (N'Standard Provider Report', 1, N'Default template for provider detail report',
+    N'["provider_code", "name_thai"]',
+    N'["provider_code", "name_thai", "name_eng", "provider_type", "business_type", "province", "general_phone_no", "is_tpa_network", "provider_status"]',
+    N'["hospital", "clinic", "grand_total"]',
+    N'system');
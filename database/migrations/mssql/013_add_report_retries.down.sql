@@ -0,0 +1,4 @@
+IF EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_sent_report_logs_retry' AND object_id = OBJECT_ID(N'sent_report_logs'))
+    DROP INDEX idx_sent_report_logs_retry ON sent_report_logs;
+IF COL_LENGTH(N'sent_report_logs', N'next_retry_at') IS NOT NULL
+    ALTER TABLE sent_report_logs DROP COLUMN next_retry_at;
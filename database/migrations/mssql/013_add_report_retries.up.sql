@@ -0,0 +1,7 @@
+-- When a failed scheduled report is retried next; NULL once it was sent or failed for good
+IF COL_LENGTH(N'sent_report_logs', N'next_retry_at') IS NULL
+    ALTER TABLE sent_report_logs ADD next_retry_at DATETIME2;
+GO
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_sent_report_logs_retry' AND object_id = OBJECT_ID(N'sent_report_logs'))
+    CREATE INDEX idx_sent_report_logs_retry ON sent_report_logs(status, next_retry_at);
@@ -0,0 +1,6 @@
+IF EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_sent_report_logs_resent_from' AND object_id = OBJECT_ID(N'sent_report_logs'))
+    DROP INDEX idx_sent_report_logs_resent_from ON sent_report_logs;
+IF OBJECT_ID(N'fk_sent_report_logs_resent_from') IS NOT NULL
+    ALTER TABLE sent_report_logs DROP CONSTRAINT fk_sent_report_logs_resent_from;
+IF COL_LENGTH(N'sent_report_logs', N'resent_from_id') IS NOT NULL
+    ALTER TABLE sent_report_logs DROP COLUMN resent_from_id;
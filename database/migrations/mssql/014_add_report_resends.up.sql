@@ -0,0 +1,7 @@
+-- The sent report log a manual resend was made from; NULL for scheduled runs
+IF COL_LENGTH(N'sent_report_logs', N'resent_from_id') IS NULL
+    ALTER TABLE sent_report_logs ADD resent_from_id INT CONSTRAINT fk_sent_report_logs_resent_from REFERENCES sent_report_logs(id);
+GO
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'idx_sent_report_logs_resent_from' AND object_id = OBJECT_ID(N'sent_report_logs'))
+    CREATE INDEX idx_sent_report_logs_resent_from ON sent_report_logs(resent_from_id);
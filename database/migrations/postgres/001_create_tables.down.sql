@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS sent_report_logs;
+DROP TABLE IF EXISTS schedules;
+DROP TABLE IF EXISTS templates;
+DROP TABLE IF EXISTS available_fields;
+DROP TABLE IF EXISTS providers;
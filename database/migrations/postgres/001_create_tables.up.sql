@@ -0,0 +1,157 @@
+-- Providers table with all fields
+CREATE TABLE IF NOT EXISTS providers (
+    id SERIAL PRIMARY KEY,
+
+    -- Basic Info
+    provider_code VARCHAR(50) NOT NULL UNIQUE,
+    title_thai VARCHAR(100),
+    name_thai VARCHAR(255) NOT NULL,
+    title_eng VARCHAR(100),
+    name_eng VARCHAR(255),
+    provider_type VARCHAR(100) NOT NULL DEFAULT 'Hospital',
+    register_status VARCHAR(100),
+    business_type VARCHAR(100),
+    bed_size VARCHAR(50),
+    eligibility_method VARCHAR(100),
+    opening_time VARCHAR(100),
+    provider_status VARCHAR(50) NOT NULL DEFAULT 'Active',
+    is_tpa_network BOOLEAN NOT NULL DEFAULT FALSE,
+    has_incident BOOLEAN NOT NULL DEFAULT FALSE,
+
+    -- Address
+    building_no VARCHAR(100),
+    village_no VARCHAR(100),
+    lane_alley VARCHAR(100),
+    road VARCHAR(100),
+    sub_district VARCHAR(100),
+    district VARCHAR(100),
+    province VARCHAR(100) NOT NULL,
+    region VARCHAR(100),
+    country VARCHAR(100) DEFAULT 'ประเทศไทย',
+    post_code VARCHAR(20),
+
+    -- Contact Info
+    title_name VARCHAR(255),
+    department VARCHAR(100),
+    general_phone_no VARCHAR(50),
+    direct_phone_no VARCHAR(50),
+    email VARCHAR(255),
+    email_to_list TEXT,
+    email_cc_list TEXT,
+
+    -- Tax Info
+    provider_tax_id VARCHAR(20),
+    wh_tax_percent DECIMAL(5,2),
+    exempt_percent DECIMAL(5,2),
+    wh_tax_exempt_from DATE,
+    wh_tax_exempt_to DATE,
+
+    -- Payment Info
+    payment_method VARCHAR(50),
+    payment_branch_id VARCHAR(20),
+    payee_name VARCHAR(255),
+    bank_account_number VARCHAR(50),
+    bank_account_type VARCHAR(50),
+    bank_branch_name VARCHAR(100),
+    bank_name VARCHAR(100),
+
+    -- Discount and pricing categories (JSON arrays)
+    discount_categories JSONB NOT NULL DEFAULT '[]'::jsonb,
+    pricing_categories JSONB NOT NULL DEFAULT '[]'::jsonb,
+
+    -- System fields
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_by VARCHAR(100),
+    updated_by VARCHAR(100)
+);
+
+-- Fields that can be placed on a report template
+CREATE TABLE IF NOT EXISTS available_fields (
+    id SERIAL PRIMARY KEY,
+    field_code VARCHAR(100) NOT NULL UNIQUE,
+    field_name_thai VARCHAR(255) NOT NULL,
+    field_name_eng VARCHAR(255) NOT NULL,
+    field_type VARCHAR(20) NOT NULL DEFAULT 'text'
+        CHECK (field_type IN ('text', 'numeric', 'date', 'boolean')),
+    field_category VARCHAR(20) NOT NULL
+        CHECK (field_category IN ('header', 'summary', 'detail')),
+    data_source VARCHAR(255),
+    format_example VARCHAR(255),
+    is_required BOOLEAN NOT NULL DEFAULT FALSE,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    sort_order INTEGER,
+    description TEXT
+);
+
+-- Report templates: field codes of the header, data and summary sections
+CREATE TABLE IF NOT EXISTS templates (
+    id SERIAL PRIMARY KEY,
+    template_name VARCHAR(255) NOT NULL,
+    is_standard BOOLEAN NOT NULL DEFAULT FALSE,
+    description TEXT,
+    header_fields JSONB NOT NULL DEFAULT '[]'::jsonb,
+    data_fields JSONB NOT NULL DEFAULT '[]'::jsonb,
+    summary_fields JSONB NOT NULL DEFAULT '[]'::jsonb,
+    field_positions TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_by VARCHAR(100) NOT NULL,
+    updated_by VARCHAR(100),
+    is_deleted BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Scheduled reports emailed to recipients
+CREATE TABLE IF NOT EXISTS schedules (
+    id SERIAL PRIMARY KEY,
+    schedule_name VARCHAR(255) NOT NULL,
+    template_id INTEGER NOT NULL REFERENCES templates(id),
+    email_to TEXT NOT NULL,
+    email_cc TEXT,
+    email_bcc TEXT,
+    frequency VARCHAR(20) NOT NULL CHECK (frequency IN ('daily', 'weekly', 'monthly')),
+    schedule_days JSONB NOT NULL DEFAULT '[]'::jsonb,
+    start_date DATE NOT NULL,
+    end_date DATE,
+    start_time VARCHAR(8) NOT NULL,
+    timezone VARCHAR(50) NOT NULL DEFAULT 'Asia/Bangkok',
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    last_run_at TIMESTAMP,
+    next_run_at TIMESTAMP,
+    search_criteria JSONB NOT NULL DEFAULT '{}'::jsonb,
+    export_format VARCHAR(10) NOT NULL DEFAULT 'excel',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_by VARCHAR(100) NOT NULL,
+    updated_by VARCHAR(100),
+    is_deleted BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Reports sent by email, manually or by a schedule
+CREATE TABLE IF NOT EXISTS sent_report_logs (
+    id SERIAL PRIMARY KEY,
+    template_id INTEGER NOT NULL REFERENCES templates(id),
+    schedule_id INTEGER REFERENCES schedules(id),
+    recipients TEXT NOT NULL,
+    subject VARCHAR(500),
+    file_name VARCHAR(255),
+    file_size_kb INTEGER,
+    export_format VARCHAR(10),
+    total_records INTEGER,
+    sent_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('success', 'failed', 'pending')),
+    error_message TEXT,
+    retry_count INTEGER NOT NULL DEFAULT 0,
+    execution_time_ms INTEGER
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_providers_name_thai ON providers(name_thai);
+CREATE INDEX IF NOT EXISTS idx_providers_province ON providers(province);
+CREATE INDEX IF NOT EXISTS idx_providers_type ON providers(provider_type);
+CREATE INDEX IF NOT EXISTS idx_providers_status ON providers(provider_status);
+CREATE INDEX IF NOT EXISTS idx_providers_created_at ON providers(created_at);
+CREATE INDEX IF NOT EXISTS idx_available_fields_category ON available_fields(field_category, sort_order);
+CREATE INDEX IF NOT EXISTS idx_schedules_next_run_at ON schedules(next_run_at);
+CREATE INDEX IF NOT EXISTS idx_sent_report_logs_sent_at ON sent_report_logs(sent_at);
+CREATE INDEX IF NOT EXISTS idx_sent_report_logs_schedule ON sent_report_logs(schedule_id);
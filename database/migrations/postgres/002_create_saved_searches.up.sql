@@ -0,0 +1,15 @@
+-- Saved provider searches per user (database fallback for the Redis search preferences)
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id SERIAL PRIMARY KEY,
+    username VARCHAR(100) NOT NULL,
+    search_name VARCHAR(100) NOT NULL,
+    search_criteria JSONB NOT NULL DEFAULT '{}'::jsonb,
+    template_id INTEGER,
+    format_type VARCHAR(20),
+    is_default BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (username, search_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_searches_username ON saved_searches(username);
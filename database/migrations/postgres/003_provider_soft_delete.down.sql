@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_providers_deleted_at;
+ALTER TABLE providers DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE providers DROP COLUMN IF EXISTS deleted_at;
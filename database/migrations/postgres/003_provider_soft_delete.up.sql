@@ -0,0 +1,5 @@
+-- Soft delete for providers: rows are referenced by claims history and must not be removed
+ALTER TABLE providers ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE providers ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(100);
+
+CREATE INDEX IF NOT EXISTS idx_providers_deleted_at ON providers(deleted_at);
@@ -0,0 +1,3 @@
+ALTER TABLE schedules DROP COLUMN IF EXISTS version;
+ALTER TABLE templates DROP COLUMN IF EXISTS version;
+ALTER TABLE providers DROP COLUMN IF EXISTS version;
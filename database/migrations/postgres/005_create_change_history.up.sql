@@ -0,0 +1,17 @@
+-- Field-level change history of providers, templates, schedules and fields
+CREATE TABLE IF NOT EXISTS change_history (
+    id SERIAL PRIMARY KEY,
+    change_id VARCHAR(36) NOT NULL,
+    entity_type VARCHAR(20) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    field_name VARCHAR(100),
+    old_value TEXT,
+    new_value TEXT,
+    changed_by VARCHAR(100) NOT NULL,
+    changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_change_history_entity ON change_history(entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS idx_change_history_changed_at ON change_history(changed_at);
+CREATE INDEX IF NOT EXISTS idx_change_history_field_name ON change_history(field_name);
@@ -0,0 +1,2 @@
+ALTER TABLE schedules DROP COLUMN IF EXISTS owner_role_id;
+DROP TABLE IF EXISTS report_data_scopes;
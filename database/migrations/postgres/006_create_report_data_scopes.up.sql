@@ -0,0 +1,13 @@
+-- Row-level data scope per user role (USER_ROLE_ID of the JWT userRoleId claim).
+-- Roles without a row see every provider; empty lists do not restrict.
+CREATE TABLE IF NOT EXISTS report_data_scopes (
+    user_role_id INTEGER PRIMARY KEY,
+    regions JSONB NOT NULL DEFAULT '[]'::jsonb,
+    provinces JSONB NOT NULL DEFAULT '[]'::jsonb,
+    provider_types JSONB NOT NULL DEFAULT '[]'::jsonb,
+    tpa_network_only BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Scheduled reports run with the data scope of the role that created them
+ALTER TABLE schedules ADD COLUMN IF NOT EXISTS owner_role_id INTEGER;
@@ -0,0 +1,2 @@
+ALTER TABLE available_fields DROP COLUMN IF EXISTS mask_type;
+ALTER TABLE available_fields DROP COLUMN IF EXISTS sensitivity;
@@ -0,0 +1,12 @@
+-- Sensitivity classification of report fields. Sensitive fields are masked in responses,
+-- exports and emailed reports unless the user has the VIEW_SENSITIVE action.
+ALTER TABLE available_fields ADD COLUMN IF NOT EXISTS sensitivity VARCHAR(20) NOT NULL DEFAULT 'public'
+    CHECK (sensitivity IN ('public', 'sensitive'));
+ALTER TABLE available_fields ADD COLUMN IF NOT EXISTS mask_type VARCHAR(20)
+    CHECK (mask_type IN ('account', 'last4', 'email', 'name', 'full'));
+
+UPDATE available_fields SET sensitivity = 'sensitive', mask_type = 'account' WHERE field_code = 'bank_account_number';
+UPDATE available_fields SET sensitivity = 'sensitive', mask_type = 'last4' WHERE field_code = 'provider_tax_id';
+UPDATE available_fields SET sensitivity = 'sensitive', mask_type = 'name' WHERE field_code = 'payee_name';
+UPDATE available_fields SET sensitivity = 'sensitive', mask_type = 'last4' WHERE field_code IN ('general_phone_no', 'direct_phone_no');
+UPDATE available_fields SET sensitivity = 'sensitive', mask_type = 'email' WHERE field_code IN ('email', 'email_to_list', 'email_cc_list');
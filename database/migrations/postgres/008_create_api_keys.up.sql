@@ -0,0 +1,29 @@
+-- API keys of machine clients (BI tool, claims system). Only a SHA-256 hash of the key is stored.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    key_prefix VARCHAR(8) NOT NULL UNIQUE,
+    key_hash VARCHAR(64) NOT NULL,
+    scopes JSONB NOT NULL DEFAULT '[]'::jsonb,
+    user_role_id INTEGER,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    revoked_by VARCHAR(100),
+    last_used_at TIMESTAMP,
+    usage_count BIGINT NOT NULL DEFAULT 0,
+    created_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS api_key_usage (
+    id BIGSERIAL PRIMARY KEY,
+    api_key_id INTEGER NOT NULL REFERENCES api_keys(id),
+    method VARCHAR(10) NOT NULL,
+    path VARCHAR(255) NOT NULL,
+    status INTEGER NOT NULL,
+    client_ip VARCHAR(45),
+    used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_key_usage_key ON api_key_usage(api_key_id, used_at DESC);
@@ -0,0 +1,15 @@
+-- Audit trail of report actions (exports, schedule runs, template/provider changes),
+-- written by the database audit sink. Events are retried, so inserts ignore known ids.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id VARCHAR(36) PRIMARY KEY,
+    action VARCHAR(100) NOT NULL,
+    username VARCHAR(100) NOT NULL,
+    data JSONB,
+    level VARCHAR(10) NOT NULL,
+    service VARCHAR(100) NOT NULL,
+    sub_module VARCHAR(100),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_username ON audit_events(username, created_at DESC);
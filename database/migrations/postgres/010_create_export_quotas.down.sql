@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS export_usage;
+DROP TABLE IF EXISTS export_quotas;
@@ -0,0 +1,17 @@
+-- Daily export quotas of user roles. NULL limits fall back to the EXPORT_DAILY_* defaults,
+-- 0 disables the limit.
+CREATE TABLE IF NOT EXISTS export_quotas (
+    user_role_id INTEGER PRIMARY KEY,
+    daily_row_limit BIGINT,
+    daily_byte_limit BIGINT,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Rows and bytes exported by each user per day, checked against the quota of the user's role
+CREATE TABLE IF NOT EXISTS export_usage (
+    username VARCHAR(100) NOT NULL,
+    usage_date DATE NOT NULL,
+    row_count BIGINT NOT NULL DEFAULT 0,
+    byte_count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (username, usage_date)
+);
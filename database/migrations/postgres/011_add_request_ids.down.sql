@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_audit_events_request_id;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS request_id;
+ALTER TABLE sent_report_logs DROP COLUMN IF EXISTS request_id;
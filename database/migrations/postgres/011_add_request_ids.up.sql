@@ -0,0 +1,5 @@
+-- X-Request-ID of the request that ran a scheduled report or caused an audit event
+ALTER TABLE sent_report_logs ADD COLUMN IF NOT EXISTS request_id VARCHAR(128);
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS request_id VARCHAR(128);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_request_id ON audit_events(request_id);
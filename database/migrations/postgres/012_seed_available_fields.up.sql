@@ -0,0 +1,63 @@
+-- Fields offered by the template editor. field_code is the JSON name of the provider
+-- field (detail and header sections) or of the summary count; sensitivity matches 007.
+INSERT INTO available_fields (field_code, field_name_thai, field_name_eng, field_type, field_category, data_source, format_example, is_required, sort_order, sensitivity, mask_type)
+VALUES
+    ('provider_code', 'รหัสสถานพยาบาล', 'Provider Code', 'text', 'header', 'providers.provider_code', 'PVR001', TRUE, 10, 'public', NULL),
+    ('title_thai', 'คำนำหน้า (ไทย)', 'Title (Thai)', 'text', 'header', 'providers.title_thai', 'บริษัท', FALSE, 20, 'public', NULL),
+    ('name_thai', 'ชื่อสถานพยาบาล (ไทย)', 'Provider Name (Thai)', 'text', 'header', 'providers.name_thai', 'โรงพยาบาลบำรุงราษฎร์', TRUE, 30, 'public', NULL),
+    ('title_eng', 'คำนำหน้า (อังกฤษ)', 'Title (English)', 'text', 'header', 'providers.title_eng', 'Company', FALSE, 40, 'public', NULL),
+    ('name_eng', 'ชื่อสถานพยาบาล (อังกฤษ)', 'Provider Name (English)', 'text', 'header', 'providers.name_eng', 'Bumrungrad Hospital', FALSE, 50, 'public', NULL),
+    ('provider_type', 'ประเภทสถานพยาบาล', 'Provider Type', 'text', 'header', 'providers.provider_type', 'Hospital', FALSE, 60, 'public', NULL),
+    ('provider_status', 'สถานะ', 'Provider Status', 'text', 'header', 'providers.provider_status', 'Active', FALSE, 70, 'public', NULL),
+    ('register_status', 'สถานะการจดทะเบียน', 'Register Status', 'text', 'detail', 'providers.register_status', 'Registered', FALSE, 80, 'public', NULL),
+    ('business_type', 'ประเภทธุรกิจ', 'Business Type', 'text', 'detail', 'providers.business_type', 'Private', FALSE, 90, 'public', NULL),
+    ('bed_size', 'จำนวนเตียง', 'Bed Size', 'text', 'detail', 'providers.bed_size', '100-200', FALSE, 100, 'public', NULL),
+    ('eligibility_method', 'วิธีตรวจสอบสิทธิ์', 'Eligibility Method', 'text', 'detail', 'providers.eligibility_method', 'Online', FALSE, 110, 'public', NULL),
+    ('opening_time', 'เวลาทำการ', 'Opening Time', 'text', 'detail', 'providers.opening_time', '08:00-20:00', FALSE, 120, 'public', NULL),
+    ('is_tpa_network', 'อยู่ในเครือข่าย TPA', 'TPA Network', 'boolean', 'detail', 'providers.is_tpa_network', 'true', FALSE, 130, 'public', NULL),
+    ('has_incident', 'มีเหตุการณ์ผิดปกติ', 'Has Incident', 'boolean', 'detail', 'providers.has_incident', 'false', FALSE, 140, 'public', NULL),
+    ('building_no', 'เลขที่', 'Building No.', 'text', 'detail', 'providers.building_no', '33', FALSE, 150, 'public', NULL),
+    ('village_no', 'หมู่ที่', 'Village No.', 'text', 'detail', 'providers.village_no', '4', FALSE, 160, 'public', NULL),
+    ('lane_alley', 'ตรอก/ซอย', 'Lane/Alley', 'text', 'detail', 'providers.lane_alley', 'สุขุมวิท 3', FALSE, 170, 'public', NULL),
+    ('road', 'ถนน', 'Road', 'text', 'detail', 'providers.road', 'สุขุมวิท', FALSE, 180, 'public', NULL),
+    ('sub_district', 'แขวง/ตำบล', 'Sub-district', 'text', 'detail', 'providers.sub_district', 'คลองเตยเหนือ', FALSE, 190, 'public', NULL),
+    ('district', 'เขต/อำเภอ', 'District', 'text', 'detail', 'providers.district', 'วัฒนา', FALSE, 200, 'public', NULL),
+    ('province', 'จังหวัด', 'Province', 'text', 'detail', 'providers.province', 'กรุงเทพมหานคร', TRUE, 210, 'public', NULL),
+    ('region', 'ภูมิภาค', 'Region', 'text', 'detail', 'providers.region', 'ภาคกลาง', FALSE, 220, 'public', NULL),
+    ('country', 'ประเทศ', 'Country', 'text', 'detail', 'providers.country', 'ประเทศไทย', FALSE, 230, 'public', NULL),
+    ('post_code', 'รหัสไปรษณีย์', 'Post Code', 'text', 'detail', 'providers.post_code', '10110', FALSE, 240, 'public', NULL),
+    ('title_name', 'ชื่อผู้ติดต่อ', 'Contact Name', 'text', 'detail', 'providers.title_name', 'คุณสมชาย ใจดี', FALSE, 250, 'public', NULL),
+    ('department', 'แผนก', 'Department', 'text', 'detail', 'providers.department', 'การเงิน', FALSE, 260, 'public', NULL),
+    ('general_phone_no', 'เบอร์โทรศัพท์', 'General Phone No.', 'text', 'detail', 'providers.general_phone_no', '02-667-1000', FALSE, 270, 'sensitive', 'last4'),
+    ('direct_phone_no', 'เบอร์โทรศัพท์สายตรง', 'Direct Phone No.', 'text', 'detail', 'providers.direct_phone_no', '02-667-1001', FALSE, 280, 'sensitive', 'last4'),
+    ('email', 'อีเมล', 'Email', 'text', 'detail', 'providers.email', 'info@bumrungrad.com', FALSE, 290, 'sensitive', 'email'),
+    ('email_to_list', 'อีเมลผู้รับ', 'Email To', 'text', 'detail', 'providers.email_to_list', 'a@example.com;b@example.com', FALSE, 300, 'sensitive', 'email'),
+    ('email_cc_list', 'อีเมลสำเนา', 'Email CC', 'text', 'detail', 'providers.email_cc_list', 'c@example.com', FALSE, 310, 'sensitive', 'email'),
+    ('provider_tax_id', 'เลขประจำตัวผู้เสียภาษี', 'Tax ID', 'text', 'detail', 'providers.provider_tax_id', '0105536000000', FALSE, 320, 'sensitive', 'last4'),
+    ('wh_tax_percent', 'ภาษีหัก ณ ที่จ่าย (%)', 'Withholding Tax (%)', 'numeric', 'detail', 'providers.wh_tax_percent', '3.00', FALSE, 330, 'public', NULL),
+    ('exempt_percent', 'ยกเว้นภาษี (%)', 'Tax Exemption (%)', 'numeric', 'detail', 'providers.exempt_percent', '0.00', FALSE, 340, 'public', NULL),
+    ('wh_tax_exempt_from', 'ยกเว้นภาษีตั้งแต่', 'Tax Exempt From', 'date', 'detail', 'providers.wh_tax_exempt_from', '2024-01-01', FALSE, 350, 'public', NULL),
+    ('wh_tax_exempt_to', 'ยกเว้นภาษีถึง', 'Tax Exempt To', 'date', 'detail', 'providers.wh_tax_exempt_to', '2024-12-31', FALSE, 360, 'public', NULL),
+    ('payment_method', 'วิธีการชำระเงิน', 'Payment Method', 'text', 'detail', 'providers.payment_method', 'Transfer', FALSE, 370, 'public', NULL),
+    ('payment_branch_id', 'รหัสสาขาที่รับชำระ', 'Payment Branch ID', 'text', 'detail', 'providers.payment_branch_id', '00000', FALSE, 380, 'public', NULL),
+    ('payee_name', 'ชื่อผู้รับเงิน', 'Payee Name', 'text', 'detail', 'providers.payee_name', 'บริษัท บำรุงราษฎร์ จำกัด', FALSE, 390, 'sensitive', 'name'),
+    ('bank_account_number', 'เลขที่บัญชี', 'Bank Account No.', 'text', 'detail', 'providers.bank_account_number', '123-4-56789-0', FALSE, 400, 'sensitive', 'account'),
+    ('bank_account_type', 'ประเภทบัญชี', 'Bank Account Type', 'text', 'detail', 'providers.bank_account_type', 'Savings', FALSE, 410, 'public', NULL),
+    ('bank_branch_name', 'สาขาธนาคาร', 'Bank Branch', 'text', 'detail', 'providers.bank_branch_name', 'สุขุมวิท', FALSE, 420, 'public', NULL),
+    ('bank_name', 'ธนาคาร', 'Bank', 'text', 'detail', 'providers.bank_name', 'ธนาคารกรุงเทพ', FALSE, 430, 'public', NULL),
+    ('discount_categories', 'กลุ่มส่วนลด', 'Discount Categories', 'text', 'detail', 'providers.discount_categories', '["OPD","IPD"]', FALSE, 440, 'public', NULL),
+    ('pricing_categories', 'กลุ่มราคา', 'Pricing Categories', 'text', 'detail', 'providers.pricing_categories', '["Standard"]', FALSE, 450, 'public', NULL),
+    ('created_at', 'วันที่สร้าง', 'Created At', 'date', 'detail', 'providers.created_at', '2024-01-01 09:00:00', FALSE, 460, 'public', NULL),
+    ('hospital', 'จำนวนโรงพยาบาล', 'Hospitals', 'numeric', 'summary', 'summary.hospital', '10', FALSE, 470, 'public', NULL),
+    ('clinic', 'จำนวนคลินิก', 'Clinics', 'numeric', 'summary', 'summary.clinic', '25', FALSE, 480, 'public', NULL),
+    ('grand_total', 'รวมทั้งหมด', 'Grand Total', 'numeric', 'summary', 'summary.grand_total', '35', FALSE, 490, 'public', NULL)
+ON CONFLICT (field_code) DO NOTHING;
+
+-- Standard template selected by default in the report screen
+INSERT INTO templates (template_name, is_standard, description, header_fields, data_fields, summary_fields, created_by)
+SELECT 'Standard Provider Report', TRUE, 'Default template for provider detail report',
+    '["provider_code", "name_thai"]'::jsonb,
+    '["provider_code", "name_thai", "name_eng", "provider_type", "business_type", "province", "general_phone_no", "is_tpa_network", "provider_status"]'::jsonb,
+    '["hospital", "clinic", "grand_total"]'::jsonb,
+    'system'
+WHERE NOT EXISTS (SELECT 1 FROM templates WHERE is_standard = TRUE);
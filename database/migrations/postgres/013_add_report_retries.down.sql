@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_sent_report_logs_retry;
+ALTER TABLE sent_report_logs DROP COLUMN IF EXISTS next_retry_at;
@@ -0,0 +1,4 @@
+-- When a failed scheduled report is retried next; NULL once it was sent or failed for good
+ALTER TABLE sent_report_logs ADD COLUMN IF NOT EXISTS next_retry_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_sent_report_logs_retry ON sent_report_logs(status, next_retry_at);
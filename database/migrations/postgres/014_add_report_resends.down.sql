@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_sent_report_logs_resent_from;
+ALTER TABLE sent_report_logs DROP COLUMN IF EXISTS resent_from_id;
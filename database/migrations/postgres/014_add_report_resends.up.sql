@@ -0,0 +1,4 @@
+-- The sent report log a manual resend was made from; NULL for scheduled runs
+ALTER TABLE sent_report_logs ADD COLUMN IF NOT EXISTS resent_from_id INTEGER REFERENCES sent_report_logs(id);
+
+CREATE INDEX IF NOT EXISTS idx_sent_report_logs_resent_from ON sent_report_logs(resent_from_id);
@@ -1,46 +1,46 @@
 package middleware
 
 import (
+	"provider-report-api/pkg/auth"
+	"provider-report-api/pkg/logging"
 	utils "provider-report-api/pkg/utility"
 	"errors"
-	"fmt"
 	"net/http"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware is jwt middleware
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware is jwt middleware. Tokens are checked by verifier, see auth.NewVerifier.
+// Machine clients may send an API key in the X-API-Key header instead, checked by apiKeys.
+func AuthMiddleware(verifier auth.Verifier, apiKeys auth.APIKeyAuthenticator) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		if key := ctx.GetHeader(auth.APIKeyHeader); key != "" && apiKeys != nil {
+			authenticateAPIKey(ctx, apiKeys, key)
+			return
+		}
+
 		var code int
 		var data interface{}
 		var err error
 		code = 200
 		authHeader := ctx.GetHeader("Authorization")
 		if authHeader == "" {
-			code = 400
 			err = errors.New("missing token")
-			fmt.Println("missing token")
+			logging.FromContext(ctx.Request.Context()).Warn("authentication failed", "error", err)
 			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			// ctx.Abort()
+			ctx.Abort()
 			return
 		}
 
 		// Extract the token from the Authorization header
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 
-		// Decode the token
-		claims, err := utils.DecodeToken(tokenString)
+		// Verify the token
+		claims, err := verifier.Verify(tokenString)
 		if err != nil {
-			fmt.Println(err.Error())
-			switch err.(*jwt.ValidationError).Errors {
-			case jwt.ValidationErrorExpired:
-				code = 20002
-			default:
-				code = 20001
-			}
+			logging.FromContext(ctx.Request.Context()).Warn("authentication failed", "error", err)
+			code = auth.ErrorCode(err)
 		}
 
 		if code != 200 {
@@ -57,6 +57,60 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Set token claims in the context for use in other handlers
 		ctx.Set("claims", claims)
 
+		// Carry the user in the request context so services record the real actor of each write
+		username, err := utils.GetUsername(ctx)
+		if err != nil {
+			if isWriteMethod(ctx.Request.Method) {
+				ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Token has no username"})
+				ctx.Abort()
+				return
+			}
+		} else {
+			ctx.Request = ctx.Request.WithContext(utils.ContextWithUsername(ctx.Request.Context(), username))
+		}
+
+		// The user role selects the data scope applied to provider queries
+		if userRoleID, ok := claims["userRoleId"].(float64); ok {
+			ctx.Request = ctx.Request.WithContext(utils.ContextWithUserRoleID(ctx.Request.Context(), int(userRoleID)))
+		}
+
 		ctx.Next()
 	}
 }
+
+// authenticateAPIKey authenticates a machine client and records every request it makes
+func authenticateAPIKey(ctx *gin.Context, apiKeys auth.APIKeyAuthenticator, key string) {
+	apiKey, err := apiKeys.AuthenticateAPIKey(ctx.Request.Context(), key)
+	if err != nil {
+		logging.FromContext(ctx.Request.Context()).Warn("API key authentication failed", "error", err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"code": auth.ErrorCode(err),
+			"msg":  "API key is invalid",
+			"data": nil,
+		})
+		ctx.Abort()
+		return
+	}
+
+	ctx.Set("apiKey", apiKey)
+
+	// The key name is recorded as the actor, and the key's role selects its data scope
+	requestCtx := utils.ContextWithUsername(ctx.Request.Context(), "apikey:"+apiKey.Name)
+	if apiKey.UserRoleID != nil {
+		requestCtx = utils.ContextWithUserRoleID(requestCtx, *apiKey.UserRoleID)
+	}
+	ctx.Request = ctx.Request.WithContext(requestCtx)
+
+	ctx.Next()
+
+	apiKeys.RecordAPIKeyUsage(ctx.Request.Context(), apiKey.ID, ctx.Request.Method, ctx.FullPath(), ctx.Writer.Status(), ctx.ClientIP())
+}
+
+// isWriteMethod reports whether the request changes data and therefore needs an actor
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
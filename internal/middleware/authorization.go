@@ -2,21 +2,29 @@ package middleware
 
 import (
 	config "provider-report-api/configs"
+	"provider-report-api/constant"
 	shared "provider-report-api/internal/modules/shared/dtos"
+	"provider-report-api/pkg/auth"
+	"provider-report-api/pkg/logging"
 	"provider-report-api/pkg/utility"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gin-gonic/gin"
 )
 
-// RoutePermission holds the required permissions for a route
+// RoutePermission holds the required permissions for a route. A permission with a Query is
+// required on top of the route's own when that boolean query parameter is true.
 type RoutePermission struct {
 	Path       string
 	Method     string
+	Query      string
 	Permission string
 }
 
@@ -26,16 +34,123 @@ type Permission struct {
 	Actions  []string `json:"actions"`
 }
 
-// Example list of route permissions
+// providerDetailPath is the route prefix of the provider-detail report module
+const providerDetailPath = "/tpa-api/report/provider-detail"
+
+// Actions of the provider detail report menu
+const (
+	actionSelect = "SELECT"
+	actionInsert = "INSERT"
+	actionUpdate = "UPDATE"
+	actionDelete = "DELETE"
+	// actionViewSensitive shows sensitive provider fields (bank account, tax ID, contacts) unmasked
+	actionViewSensitive = "VIEW_SENSITIVE"
+	// actionAdmin manages the report service itself, e.g. the permission cache
+	actionAdmin = "ADMIN"
+	// actionAudit shows soft-deleted providers next to the live ones (include_deleted)
+	actionAudit = "AUDIT"
+)
+
+// routePermissions maps every provider-detail route to the menu action it requires. Routes
+// missing from this table are rejected, so new routes must be added here.
 var routePermissions = []RoutePermission{
-	{Path: "/tpa-api/client-management/company", Method: "GET", Permission: "60:SELECT"},
-	{Path: "/example", Method: "POST", Permission: "write:example"},
-	// Add more route permissions as needed
+	// Providers
+	providerDetailRoute("GET", "/providers/search", actionSelect),
+	providerDetailQueryRoute("GET", "/providers/search", "include_deleted", actionAudit),
+	providerDetailRoute("POST", "/providers/report", actionSelect),
+	providerDetailRoute("POST", "/providers/export", actionSelect),
+	providerDetailRoute("GET", "/providers/summary", actionSelect),
+	providerDetailRoute("GET", "/providers/stats", actionSelect),
+	providerDetailRoute("GET", "/providers/provinces", actionSelect),
+	providerDetailRoute("GET", "/providers/types", actionSelect),
+	providerDetailRoute("GET", "/providers/trash", actionSelect),
+	providerDetailRoute("POST", "/providers", actionInsert),
+	providerDetailRoute("GET", "/providers/:id", actionSelect),
+	providerDetailQueryRoute("GET", "/providers/:id", "include_deleted", actionAudit),
+	providerDetailRoute("PUT", "/providers/:id", actionUpdate),
+	providerDetailRoute("PATCH", "/providers/:id", actionUpdate),
+	providerDetailRoute("DELETE", "/providers/:id", actionDelete),
+	providerDetailRoute("POST", "/providers/:id/restore", actionUpdate),
+	providerDetailRoute("GET", "/providers/:id/history", actionSelect),
+
+	// Saved searches belong to the current user, viewing reports is enough to manage them
+	providerDetailRoute("GET", "/providers/saved-searches", actionSelect),
+	providerDetailRoute("GET", "/providers/saved-searches/:id", actionSelect),
+	providerDetailRoute("POST", "/providers/saved-searches", actionSelect),
+	providerDetailRoute("PUT", "/providers/saved-searches/:id", actionSelect),
+	providerDetailRoute("DELETE", "/providers/saved-searches/:id", actionSelect),
+
+	// Templates
+	providerDetailRoute("GET", "/templates", actionSelect),
+	providerDetailRoute("GET", "/templates/:id", actionSelect),
+	providerDetailRoute("POST", "/templates", actionInsert),
+	providerDetailRoute("PUT", "/templates/:id", actionUpdate),
+	providerDetailRoute("DELETE", "/templates/:id", actionDelete),
+
+	// Schedules
+	providerDetailRoute("GET", "/schedules", actionSelect),
+	providerDetailRoute("GET", "/schedules/:id", actionSelect),
+	providerDetailRoute("POST", "/schedules", actionInsert),
+	providerDetailRoute("PUT", "/schedules/:id", actionUpdate),
+	providerDetailRoute("DELETE", "/schedules/:id", actionDelete),
+	providerDetailRoute("POST", "/schedules/:id/run", actionUpdate),
+
+	// Logs, fields and change history
+	providerDetailRoute("GET", "/logs/sent-reports", actionSelect),
+	providerDetailRoute("GET", "/logs/sent-reports/:id", actionSelect),
+	providerDetailRoute("POST", "/logs/sent-reports/:id/resend", actionUpdate),
+	providerDetailRoute("GET", "/fields", actionSelect),
+	providerDetailRoute("GET", "/fields/by-category/:category", actionSelect),
+	providerDetailRoute("GET", "/history", actionSelect),
+
+	// Administration
+	providerDetailRoute("DELETE", "/admin/permissions/:userId", actionAdmin),
+	providerDetailRoute("GET", "/admin/api-keys", actionAdmin),
+	providerDetailRoute("POST", "/admin/api-keys", actionAdmin),
+	providerDetailRoute("DELETE", "/admin/api-keys/:id", actionAdmin),
+	providerDetailRoute("POST", "/admin/api-keys/:id/rotate", actionAdmin),
+	providerDetailRoute("GET", "/admin/api-keys/:id/usage", actionAdmin),
+}
+
+// apiKeyRoutes maps the routes open to API keys to the scope they require. API keys are
+// read-only and cannot call any other route.
+var apiKeyRoutes = map[string]string{
+	"GET " + providerDetailPath + "/providers/search":    auth.APIKeyScopeSearch,
+	"GET " + providerDetailPath + "/providers/summary":   auth.APIKeyScopeSearch,
+	"GET " + providerDetailPath + "/providers/stats":     auth.APIKeyScopeSearch,
+	"GET " + providerDetailPath + "/providers/provinces": auth.APIKeyScopeSearch,
+	"GET " + providerDetailPath + "/providers/types":     auth.APIKeyScopeSearch,
+	"POST " + providerDetailPath + "/providers/report":   auth.APIKeyScopeExport,
+	"POST " + providerDetailPath + "/providers/export":   auth.APIKeyScopeExport,
+}
+
+func providerDetailRoute(method, path, action string) RoutePermission {
+	return RoutePermission{
+		Path:       providerDetailPath + path,
+		Method:     method,
+		Permission: providerDetailPermission(action),
+	}
+}
+
+func providerDetailQueryRoute(method, path, query, action string) RoutePermission {
+	rp := providerDetailRoute(method, path, action)
+	rp.Query = query
+	return rp
+}
+
+func providerDetailPermission(action string) string {
+	return fmt.Sprintf("%d:%s", constant.PROVIDER_DETAIL_REPORT_MENU_ID, action)
 }
 
-// PermissionMiddleware checks if the user has the required permissions to access the endpoint
-func PermissionMiddleware() gin.HandlerFunc {
+// PermissionMiddleware checks if the user has the required permissions to access the endpoint.
+// Permissions are read through cache, see PermissionCache.
+func PermissionMiddleware(cache *PermissionCache) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if apiKey, ok := c.Get("apiKey"); ok {
+			checkAPIKeyScope(c, apiKey.(*auth.APIKey))
+			return
+		}
+
 		claims, ok := c.Get("claims")
 		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
@@ -50,39 +165,40 @@ func PermissionMiddleware() gin.HandlerFunc {
 		}
 
 		if claimsMap["userRoleId"] == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"claimsMap": claimsMap, "claims": claims})
+			// The claims stay in the log, the token holder doesn't need them echoed back
+			claimNames := make([]string, 0, len(claimsMap))
+			for name := range claimsMap {
+				claimNames = append(claimNames, name)
+			}
+			sort.Strings(claimNames)
+			logging.FromContext(c.Request.Context()).Warn("token has no userRoleId claim", "sub", claimsMap["sub"], "claims", claimNames)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			c.Abort()
 			return
 		}
 
-		userMasterId := claimsMap["sub"].(string)
+		userMasterId, ok := claimsMap["sub"].(string)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
 		f, ok := claimsMap["userRoleId"].(float64)
 		if !ok {
-			fmt.Println("interface{} is not a float64")
+			logging.FromContext(c.Request.Context()).Warn("token has no userRoleId claim")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
 			return
 		}
 
 		// Convert the float64 to a string
 		userRoleId := strconv.FormatFloat(f, 'f', -1, 64)
-		fmt.Println(userRoleId)
-		var permissions *[]shared.UserActionAccessRights
-
-		// Get the permission from Redis
-		findRedisPermission, err := utility.NewRedisService().GetPermissions(userMasterId, userRoleId, "clientmanagement")
+		permissions, err := cache.Get(c.Request.Context(), userMasterId, userRoleId)
 		if err != nil {
-			permissions, err = FindUserAccessRights(userMasterId, userRoleId)
-			if err != nil {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
-				c.Abort()
-				return
-			}
-			// save permission to redis
-			err := utility.NewRedisService().SetPermissions(userMasterId, userRoleId, "clientmanagement", permissions)
-			if err != nil {
-				fmt.Println("error save permission to database")
-			}
-		} else {
-			permissions = findRedisPermission
+			logging.FromContext(c.Request.Context()).Error("failed to load permissions", "user_role_id", userRoleId, "error", err)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
+			c.Abort()
+			return
 		}
 
 		requiredPermission, exists := routeExists(c.FullPath(), c.Request.Method)
@@ -97,12 +213,40 @@ func PermissionMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		for _, queryPermission := range queryPermissions(c) {
+			if !hasPermission(queryPermission, permissions) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to access this resource"})
+				c.Abort()
+				return
+			}
+		}
+
+		// Sensitive provider fields are masked unless the role may see them
+		canViewSensitive := hasPermission(providerDetailPermission(actionViewSensitive), permissions)
+		c.Request = c.Request.WithContext(utility.ContextWithSensitiveDataAccess(c.Request.Context(), canViewSensitive))
+		// Report and export bodies can ask for deleted providers too, the service checks this
+		canViewDeleted := hasPermission(providerDetailPermission(actionAudit), permissions)
+		c.Request = c.Request.WithContext(utility.ContextWithDeletedDataAccess(c.Request.Context(), canViewDeleted))
 
 		// If the user has permission, proceed to the next handler
 		c.Next()
 	}
 }
 
+// checkAPIKeyScope allows API key requests to the routes the key is scoped to. Sensitive
+// fields are always masked for API keys.
+func checkAPIKeyScope(c *gin.Context, apiKey *auth.APIKey) {
+	scope, exists := apiKeyRoutes[c.Request.Method+" "+c.FullPath()]
+	if !exists || !apiKey.HasScope(scope) || len(queryPermissions(c)) > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is not allowed to access this resource"})
+		c.Abort()
+		return
+	}
+
+	c.Request = c.Request.WithContext(utility.ContextWithSensitiveDataAccess(c.Request.Context(), false))
+	c.Next()
+}
+
 // Function to check if permission exists
 func hasPermission(requiredPermission string, permissions *[]shared.UserActionAccessRights) bool {
 	parts := strings.Split(requiredPermission, ":")
@@ -127,16 +271,40 @@ func hasPermission(requiredPermission string, permissions *[]shared.UserActionAc
 // Function to check if route exists and retrieve its required permission
 func routeExists(path, method string) (string, bool) {
 	for _, rp := range routePermissions {
-		if rp.Path == path && rp.Method == method {
+		if rp.Path == path && rp.Method == method && rp.Query == "" {
 			return rp.Permission, true
 		}
 	}
 	return "", false
 }
 
-func FindUserAccessRights(userMasterId, userRoleId string) (*[]shared.UserActionAccessRights, error) {
+// queryPermissions returns the extra permissions required by the query parameters of the request
+func queryPermissions(c *gin.Context) []string {
+	var required []string
+	for _, rp := range routePermissions {
+		if rp.Query == "" || rp.Path != c.FullPath() || rp.Method != c.Request.Method {
+			continue
+		}
+		if enabled, err := strconv.ParseBool(c.Query(rp.Query)); err == nil && enabled {
+			required = append(required, rp.Permission)
+		}
+	}
+	return required
+}
+
+// FindUserAccessRights loads the actions the user role has on menuIDs
+func FindUserAccessRights(ctx context.Context, userMasterId, userRoleId string, menuIDs []int) (*[]shared.UserActionAccessRights, error) {
+	if len(menuIDs) == 0 {
+		return nil, errors.New("no permission menus configured")
+	}
 	db := config.GetDB() // Get the shared instance of the database
 
+	menuPlaceholders := strings.TrimSuffix(strings.Repeat("?,", len(menuIDs)), ",")
+	args := []interface{}{userMasterId, userRoleId}
+	for _, menuID := range menuIDs {
+		args = append(args, menuID)
+	}
+
 	query := `
 		SELECT 
 			m.MENU_ID,
@@ -167,9 +335,10 @@ func FindUserAccessRights(userMasterId, userRoleId string) (*[]shared.UserAction
 		ON 
 			msta.ACTION_ID = a.ACTION_ID
 		WHERE 
-			uuarr.USER_MASTER_ID = ? AND uar.USER_ROLE_ID = ? AND msta.STATUS_ID= 'Y' AND m.MENU_ID IN (?,?,?,?,?,?)`
+			uuarr.USER_MASTER_ID = ? AND uar.USER_ROLE_ID = ? AND msta.STATUS_ID= 'Y' AND m.MENU_ID IN (` + menuPlaceholders + `)`
 
-	rows, err := db.Query(query, userMasterId, userRoleId, 60, 61, 5, 6, 7, 9)
+	// lib/pq only accepts $n placeholders
+	rows, err := db.QueryContext(ctx, config.Rebind(query), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -194,7 +363,6 @@ func FindUserAccessRights(userMasterId, userRoleId string) (*[]shared.UserAction
 			actionExists := false
 			if contains(menuAccess.Actions, *action.ActionCode) {
 				actionExists = true
-				break
 			}
 			if !actionExists {
 				menuAccess.Actions = append(menuAccess.Actions, *action.ActionCode)
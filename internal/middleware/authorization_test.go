@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"provider-report-api/constant"
+	shared "provider-report-api/internal/modules/shared/dtos"
+	"provider-report-api/pkg/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gin-gonic/gin"
+)
+
+const testSecret = "middleware-test-secret"
+
+// Roles known to the test permission loader
+const (
+	roleReader  = 10
+	roleWriter  = 11
+	roleAuditor = 12
+	roleUnknown = 99
+)
+
+var roleActions = map[string][]string{
+	"10": {"SELECT"},
+	"11": {"INSERT", "UPDATE"},
+	"12": {"SELECT", "AUDIT"},
+}
+
+func loadTestPermissions(ctx context.Context, userMasterId, userRoleId string, menuIDs []int) (*[]shared.UserActionAccessRights, error) {
+	actions, ok := roleActions[userRoleId]
+	if !ok {
+		return nil, errors.New("role not found")
+	}
+	return &[]shared.UserActionAccessRights{{
+		MenuID:   constant.PROVIDER_DETAIL_REPORT_MENU_ID,
+		MenuName: "Provider Detail Report",
+		Actions:  actions,
+	}}, nil
+}
+
+// newTestRouter serves a few provider-detail routes behind the token and permission checks,
+// answering 200 once both pass
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	verifier, err := auth.NewVerifier(auth.Config{Secret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"success": true}) }
+	r := gin.New()
+	report := r.Group("/tpa-api/report", AuthMiddleware(verifier, nil), PermissionMiddleware(NewPermissionCacheWithLoader(nil, "report", []int{constant.PROVIDER_DETAIL_REPORT_MENU_ID}, time.Minute, loadTestPermissions)))
+	report.GET("/provider-detail/providers/search", ok)
+	report.GET("/provider-detail/providers/:id", ok)
+	report.DELETE("/provider-detail/providers/:id", ok)
+	report.GET("/provider-detail/admin/api-keys", ok)
+	report.GET("/provider-detail/unlisted", ok)
+	return r
+}
+
+func testClaims(roleID int) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub":        "user-1",
+		"username":   "somchai",
+		"userRoleId": float64(roleID),
+		"iat":        now.Unix(),
+		"exp":        now.Add(time.Hour).Unix(),
+	}
+}
+
+func signTestToken(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func serve(r *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestReportRoutesRequireValidToken(t *testing.T) {
+	r := newTestRouter(t)
+	path := "/tpa-api/report/provider-detail/providers/1"
+
+	expired := testClaims(roleReader)
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noRole := testClaims(roleReader)
+	delete(noRole, "userRoleId")
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{"missing token", "", 0},
+		{"expired token", signTestToken(t, testSecret, expired), auth.CodeTokenExpired},
+		{"bad signature", signTestToken(t, "another-secret", testClaims(roleReader)), auth.CodeInvalidToken},
+		{"malformed token", "not-a-jwt", auth.CodeInvalidToken},
+		{"no user role", signTestToken(t, testSecret, noRole), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodGet, path, tt.token)
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantCode != 0 && body["code"] != float64(tt.wantCode) {
+				t.Errorf("code = %v, want %d", body["code"], tt.wantCode)
+			}
+			for _, key := range []string{"claims", "claimsMap"} {
+				if claims, ok := body[key]; ok {
+					t.Errorf("response echoes the token claims as %s: %v", key, claims)
+				}
+			}
+		})
+	}
+}
+
+func TestReportRoutesCheckMenuActions(t *testing.T) {
+	r := newTestRouter(t)
+	providerPath := "/tpa-api/report/provider-detail/providers/1"
+
+	tests := []struct {
+		name       string
+		role       int
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"select allowed", roleReader, http.MethodGet, providerPath, http.StatusOK},
+		{"select missing", roleWriter, http.MethodGet, providerPath, http.StatusForbidden},
+		{"delete missing", roleReader, http.MethodDelete, providerPath, http.StatusForbidden},
+		{"search allowed", roleReader, http.MethodGet, "/tpa-api/report/provider-detail/providers/search", http.StatusOK},
+		{"include deleted without audit", roleReader, http.MethodGet, providerPath + "?include_deleted=true", http.StatusForbidden},
+		{"include deleted search without audit", roleReader, http.MethodGet, "/tpa-api/report/provider-detail/providers/search?include_deleted=1", http.StatusForbidden},
+		{"include deleted with audit", roleAuditor, http.MethodGet, providerPath + "?include_deleted=true", http.StatusOK},
+		{"include deleted false", roleReader, http.MethodGet, providerPath + "?include_deleted=false", http.StatusOK},
+		{"admin route", roleReader, http.MethodGet, "/tpa-api/report/provider-detail/admin/api-keys", http.StatusForbidden},
+		{"route without permission", roleReader, http.MethodGet, "/tpa-api/report/provider-detail/unlisted", http.StatusNotFound},
+		{"unknown role", roleUnknown, http.MethodGet, providerPath, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, tt.method, tt.path, signTestToken(t, testSecret, testClaims(tt.role)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
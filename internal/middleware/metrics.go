@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"provider-report-api/pkg/metrics"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unmatchedRoute labels requests that matched no route, so scanners don't create a series
+// per path
+const unmatchedRoute = "unmatched"
+
+// RequestMetrics records the latency and status of every request by route template
+func RequestMetrics(m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		m.ObserveRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
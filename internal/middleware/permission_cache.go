@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	shared "provider-report-api/internal/modules/shared/dtos"
+	"provider-report-api/pkg/logging"
+	"provider-report-api/pkg/utility"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultPermissionCacheTTL = 10 * time.Minute
+
+// maxPermissionCacheEntries bounds the in-memory cache, one entry per user role
+const maxPermissionCacheEntries = 10000
+
+// PermissionLoader loads the actions a user role has on menuIDs, see FindUserAccessRights
+type PermissionLoader func(ctx context.Context, userMasterId, userRoleId string, menuIDs []int) (*[]shared.UserActionAccessRights, error)
+
+// PermissionCache loads user permissions for the report menus and caches them for ttl. Redis
+// is shared by every instance; while it is unavailable an in-memory cache keeps the database
+// from being queried on every request.
+type PermissionCache struct {
+	redis   *utility.RedisService
+	module  string
+	menuIDs []int
+	ttl     time.Duration
+	load    PermissionLoader
+
+	mu      sync.Mutex
+	entries map[string]permissionCacheEntry
+	now     func() time.Time
+}
+
+type permissionCacheEntry struct {
+	permissions *[]shared.UserActionAccessRights
+	expiresAt   time.Time
+}
+
+// NewPermissionCache creates a cache for the permissions of module on menuIDs. redisService
+// may be nil to cache in memory only. ttl defaults to 10 minutes.
+func NewPermissionCache(redisService *utility.RedisService, module string, menuIDs []int, ttl time.Duration) *PermissionCache {
+	return NewPermissionCacheWithLoader(redisService, module, menuIDs, ttl, FindUserAccessRights)
+}
+
+// NewPermissionCacheWithLoader creates a cache that loads permissions with load instead of
+// querying the database
+func NewPermissionCacheWithLoader(redisService *utility.RedisService, module string, menuIDs []int, ttl time.Duration, load PermissionLoader) *PermissionCache {
+	if ttl <= 0 {
+		ttl = defaultPermissionCacheTTL
+	}
+	return &PermissionCache{
+		redis:   redisService,
+		module:  module,
+		menuIDs: menuIDs,
+		ttl:     ttl,
+		load:    load,
+		entries: make(map[string]permissionCacheEntry),
+		now:     time.Now,
+	}
+}
+
+// Get returns the permissions of the user role from Redis, the in-memory cache or the database
+func (p *PermissionCache) Get(ctx context.Context, userMasterId, userRoleId string) (*[]shared.UserActionAccessRights, error) {
+	redisAvailable := p.redis != nil
+	if redisAvailable {
+		permissions, err := p.redis.GetPermissions(ctx, userMasterId, userRoleId, p.module)
+		if err == nil && permissions != nil {
+			return permissions, nil
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			slog.Warn("permission cache: Redis unavailable, using in-memory cache", "error", err)
+			redisAvailable = false
+		}
+	}
+
+	key := p.entryKey(userMasterId, userRoleId)
+	if !redisAvailable {
+		if permissions, ok := p.getEntry(key); ok {
+			return permissions, nil
+		}
+	}
+
+	permissions, err := p.load(ctx, userMasterId, userRoleId, p.menuIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if redisAvailable {
+		if err := p.redis.SetPermissions(ctx, userMasterId, userRoleId, p.module, permissions, p.ttl); err == nil {
+			return permissions, nil
+		}
+		slog.Warn("permission cache: failed to save permissions to Redis")
+	}
+	p.setEntry(key, permissions)
+	return permissions, nil
+}
+
+// Invalidate drops the cached permissions of a user so changed access rights apply on the next
+// request. In-memory entries of other instances expire after the TTL.
+func (p *PermissionCache) Invalidate(ctx context.Context, userMasterId string) error {
+	p.mu.Lock()
+	prefix := userMasterId + ":"
+	for key := range p.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(p.entries, key)
+		}
+	}
+	p.mu.Unlock()
+
+	if p.redis == nil {
+		return nil
+	}
+	return p.redis.InvalidateUserPermissions(ctx, userMasterId, p.module)
+}
+
+func (p *PermissionCache) entryKey(userMasterId, userRoleId string) string {
+	return userMasterId + ":" + userRoleId
+}
+
+func (p *PermissionCache) getEntry(key string) (*[]shared.UserActionAccessRights, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if p.now().After(entry.expiresAt) {
+		delete(p.entries, key)
+		return nil, false
+	}
+	return entry.permissions, true
+}
+
+// setEntry caches permissions under key. A full cache first drops its expired entries, then the
+// entry closest to expiry, which is the oldest since every entry has the same TTL.
+func (p *PermissionCache) setEntry(key string, permissions *[]shared.UserActionAccessRights) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if _, exists := p.entries[key]; !exists && len(p.entries) >= maxPermissionCacheEntries {
+		p.evictLocked(now)
+	}
+	p.entries[key] = permissionCacheEntry{permissions: permissions, expiresAt: now.Add(p.ttl)}
+}
+
+// evictLocked makes room for one entry. p.mu must be held.
+func (p *PermissionCache) evictLocked(now time.Time) {
+	oldestKey := ""
+	var oldest time.Time
+	for key, entry := range p.entries {
+		if now.After(entry.expiresAt) {
+			delete(p.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.expiresAt.Before(oldest) {
+			oldestKey, oldest = key, entry.expiresAt
+		}
+	}
+	if len(p.entries) >= maxPermissionCacheEntries {
+		delete(p.entries, oldestKey)
+	}
+}
+
+// InvalidatePermissionsHandler drops the cached permissions of the :userId user
+func InvalidatePermissionsHandler(cache *PermissionCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.Param("userId")
+		// The user ID becomes part of a Redis key pattern
+		if strings.ContainsAny(userId, "*?[]:") {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "Invalid user ID"})
+			return
+		}
+
+		if err := cache.Invalidate(c.Request.Context(), userId); err != nil {
+			logging.FromContext(c.Request.Context()).Error("failed to invalidate permissions", "user_id", userId, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to invalidate permissions"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Permissions invalidated"})
+	}
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"provider-report-api/pkg/auth"
+	"provider-report-api/pkg/logging"
+	"provider-report-api/pkg/utility"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRateLimitBuckets bounds the in-memory buckets, idle ones are dropped to stay within it
+const maxRateLimitBuckets = 10000
+
+// RateLimiter is a token bucket per API key, user or client IP. Buckets live in Redis so the
+// limit is shared by every instance; while Redis is unavailable each instance limits on its own.
+type RateLimiter struct {
+	redis *utility.RedisService
+	rate  float64
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter allows requestsPerMinute requests per client with bursts of up to burst
+// requests. redisService may be nil to limit in memory only.
+func NewRateLimiter(redisService *utility.RedisService, requestsPerMinute, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = requestsPerMinute
+	}
+	return &RateLimiter{
+		redis:   redisService,
+		rate:    float64(requestsPerMinute) / 60,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Take takes a token for key. It returns whether the request is allowed, the tokens left and
+// how long until the next token.
+func (l *RateLimiter) Take(c *gin.Context, key string) (bool, int, time.Duration) {
+	if l.redis != nil {
+		allowed, remaining, wait, err := l.redis.TakeToken(c.Request.Context(), key, l.rate, l.burst)
+		if err == nil {
+			return allowed, remaining, wait
+		}
+		logging.FromContext(c.Request.Context()).Warn("rate limit: Redis unavailable, limiting in memory", "error", err)
+	}
+	return l.takeLocal(key)
+}
+
+func (l *RateLimiter) takeLocal(key string) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitBuckets {
+			l.evictBuckets(now)
+		}
+		bucket = &tokenBucket{tokens: float64(l.burst), updated: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(l.burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+
+	allowed := false
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		allowed = true
+	}
+
+	var wait time.Duration
+	if bucket.tokens < 1 {
+		wait = time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	return allowed, int(bucket.tokens), wait
+}
+
+// evictBuckets makes room for a new bucket. Buckets that have refilled behave like new ones and
+// are dropped; when none has, the least recently used bucket goes, it has refilled the most.
+func (l *RateLimiter) evictBuckets(now time.Time) {
+	refill := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	oldestKey := ""
+	var oldest time.Time
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= refill {
+			delete(l.buckets, key)
+			continue
+		}
+		if oldestKey == "" || bucket.updated.Before(oldest) {
+			oldestKey, oldest = key, bucket.updated
+		}
+	}
+	if len(l.buckets) >= maxRateLimitBuckets {
+		delete(l.buckets, oldestKey)
+	}
+}
+
+// RateLimitMiddleware rejects clients that exceed the rate limit with 429 and Retry-After.
+// It runs after AuthMiddleware so API keys and users are limited separately from their IP.
+func RateLimitMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, remaining, wait := limiter.Take(c, rateLimitKey(c))
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limiter.burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// rateLimitKey identifies the client: the API key, the user, or the client IP
+func rateLimitKey(c *gin.Context) string {
+	if apiKey, ok := c.Get("apiKey"); ok {
+		return fmt.Sprintf("apikey:%d", apiKey.(*auth.APIKey).ID)
+	}
+	if username, ok := utility.UsernameFromContext(c.Request.Context()); ok {
+		return "user:" + username
+	}
+	return "ip:" + c.ClientIP()
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"provider-report-api/internal/modules/provider-detail/dtos"
+	"provider-report-api/pkg/logging"
+	"provider-report-api/pkg/utility"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxRequestIDLength bounds client supplied request IDs, longer ones are replaced
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware carries the X-Request-ID of the request, or a new one, in the request
+// context and echoes it in the response
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(logging.RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		c.Header(logging.RequestIDHeader, requestID)
+		c.Request = c.Request.WithContext(logging.ContextWithRequestID(c.Request.Context(), requestID))
+		c.Next()
+	}
+}
+
+// validRequestID accepts printable ASCII IDs so they can be logged and stored as is
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range requestID {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// probePaths are polled by the orchestrator and Prometheus every few seconds, they are logged
+// at debug level
+var probePaths = map[string]bool{
+	"/health":  true,
+	"/livez":   true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// RequestLogger logs every request with its status, latency and user once it is handled
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		ctx := c.Request.Context()
+		status := c.Writer.Status()
+		attrs := []any{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Int64("latency_ms", time.Since(start).Milliseconds()),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("size", c.Writer.Size()),
+		}
+		if username, ok := utility.UsernameFromContext(ctx); ok {
+			attrs = append(attrs, slog.String("user", username))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case probePaths[c.Request.URL.Path]:
+			level = slog.LevelDebug
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		logging.FromContext(ctx).Log(ctx, level, "request", attrs...)
+	}
+}
+
+// Recovery turns a panic in a handler into a 500 ErrorResponse and logs it with its stack
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			// The client went away, there is no one to answer
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			requestID := logging.RequestIDFromContext(c.Request.Context())
+			logging.FromContext(c.Request.Context()).Error("panic while handling request",
+				slog.String("method", c.Request.Method),
+				slog.String("path", c.Request.URL.Path),
+				slog.String("panic", fmt.Sprint(recovered)),
+				slog.String("stack", string(debug.Stack())),
+			)
+
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, dtos.ErrorResponse{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error, cannot process the request.",
+				Details: "request ID " + requestID,
+			})
+		}()
+
+		c.Next()
+	}
+}
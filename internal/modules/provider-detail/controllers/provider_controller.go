@@ -1,13 +1,21 @@
 package controllers
 
 import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
     "net/http"
     "strconv"
+    "strings"
+    "time"
 
     "github.com/gin-gonic/gin"
+    clienterrors "provider-report-api/constant/errors"
     "provider-report-api/internal/modules/provider-detail/dtos"
     "provider-report-api/internal/modules/provider-detail/repositories"
     "provider-report-api/internal/modules/provider-detail/services"
+    "provider-report-api/pkg/utility"
 )
 
 // ================= PROVIDER CONTROLLER =================
@@ -28,14 +36,20 @@ func InitializeProviderDetailController(
 	templateService *services.TemplateService,
 	scheduleService *services.ScheduleService,
 	logService *services.LogService,
-	fieldRepo *repositories.FieldRepository,
+	fieldRepo repositories.FieldStore,
+	savedSearchService *services.SavedSearchService,
+	historyService *services.HistoryService,
+	apiKeyService *services.APIKeyService,
 ) {
 	// Initialize controllers with their dependencies
 	providerController := NewProviderController(providerService)
 	templateController := NewTemplateController(templateService)
 	scheduleController := NewScheduleController(scheduleService)
-	logController := NewLogController(logService)
+	logController := NewLogController(logService, scheduleService)
 	fieldController := NewFieldController(fieldRepo)
+	savedSearchController := NewSavedSearchController(savedSearchService)
+	historyController := NewHistoryController(historyService, providerService)
+	apiKeyController := NewAPIKeyController(apiKeyService)
 
 	// Provider Routes
 	providers := providerRoute.Group("/providers")
@@ -44,7 +58,11 @@ func InitializeProviderDetailController(
 		providers.POST("", providerController.CreateProvider)           // Create new provider
 		providers.GET("/:id", providerController.GetProvider)           // Get provider by ID
 		providers.PUT("/:id", providerController.UpdateProvider)        // Update provider
-		providers.DELETE("/:id", providerController.DeleteProvider)     // Delete provider
+		providers.PATCH("/:id", providerController.PatchProvider)       // Merge patch provider
+		providers.DELETE("/:id", providerController.DeleteProvider)     // Soft delete provider
+		providers.POST("/:id/restore", providerController.RestoreProvider) // Restore deleted provider
+		providers.GET("/trash", providerController.GetDeletedProviders)    // List deleted providers
+		providers.GET("/:id/history", historyController.GetProviderHistory) // Field-level change history
 		
 		// Provider Search and Filter Operations
 		providers.GET("/search", providerController.SearchProviders)
@@ -60,6 +78,13 @@ func InitializeProviderDetailController(
 		// Provider Reference Data
 		providers.GET("/provinces", providerController.GetProvinces)
 		providers.GET("/types", providerController.GetProviderTypes)
+
+		// Saved Searches of the current user
+		providers.GET("/saved-searches", savedSearchController.GetSavedSearches)
+		providers.GET("/saved-searches/:id", savedSearchController.GetSavedSearch)
+		providers.POST("/saved-searches", savedSearchController.CreateSavedSearch)
+		providers.PUT("/saved-searches/:id", savedSearchController.UpdateSavedSearch)
+		providers.DELETE("/saved-searches/:id", savedSearchController.DeleteSavedSearch)
 	}
 
 	// Template Routes
@@ -88,6 +113,7 @@ func InitializeProviderDetailController(
 	{
 		logs.GET("/sent-reports", logController.GetSentReportLogs)
 		logs.GET("/sent-reports/:id", logController.GetSentReportLog)
+		logs.POST("/sent-reports/:id/resend", logController.ResendSentReport)
 	}
 
 	// Field Routes
@@ -96,15 +122,31 @@ func InitializeProviderDetailController(
 		fields.GET("", fieldController.GetAvailableFields)
 		fields.GET("/by-category/:category", fieldController.GetFieldsByCategory)
 	}
+
+	// Change History Routes
+	history := providerRoute.Group("/history")
+	{
+		history.GET("", historyController.SearchHistory)
+	}
+
+	// API Keys of machine clients
+	apiKeys := providerRoute.Group("/admin/api-keys")
+	{
+		apiKeys.GET("", apiKeyController.GetAPIKeys)
+		apiKeys.POST("", apiKeyController.CreateAPIKey)
+		apiKeys.DELETE("/:id", apiKeyController.RevokeAPIKey)
+		apiKeys.POST("/:id/rotate", apiKeyController.RotateAPIKey)
+		apiKeys.GET("/:id/usage", apiKeyController.GetAPIKeyUsage)
+	}
 }
 
-// CreateProvider godoc
-// @Summary Create a new provider
-// @Description Create a new provider with the provided information
+// CreateSchedule godoc
+// @Summary Create a new schedule
+// @Description Create a new report schedule
 // @Tags providerDetail
 // @Accept json
 // @Produce json
-// @Param provider body dtos.CreateProviderRequestDTO true "Provider data"
+// @Param schedule body dtos.CreateScheduleRequestDTO true "Schedule data"
 // @Success 201 {object} dtos.APIResponse
 // @Failure 400 {object} dtos.ErrorResponse
 // @Failure 500 {object} dtos.ErrorResponse
@@ -121,7 +163,7 @@ func (c *ScheduleController) CreateSchedule(ctx *gin.Context) {
         return
     }
 
-    schedule, err := c.scheduleService.CreateSchedule(req)
+    schedule, err := c.scheduleService.CreateSchedule(ctx.Request.Context(), req)
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -146,8 +188,11 @@ func (c *ScheduleController) CreateSchedule(ctx *gin.Context) {
 // @Produce json
 // @Param id path int true "Schedule ID"
 // @Param schedule body dtos.UpdateScheduleRequestDTO true "Schedule update data"
+// @Param If-Match header string false "ETag of the schedule the change is based on"
 // @Success 200 {object} dtos.APIResponse
 // @Failure 400 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 412 {object} dtos.APIResponse
 // @Failure 500 {object} dtos.ErrorResponse
 // @Router /provider-detail/schedules/{id} [put]
 // @Security BearerAuth
@@ -173,16 +218,40 @@ func (c *ScheduleController) UpdateSchedule(ctx *gin.Context) {
         return
     }
 
-    schedule, err := c.scheduleService.UpdateSchedule(id, req)
+    expectedVersion, err := ifMatchVersion(ctx)
     if err != nil {
-        ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
-            Code:    http.StatusInternalServerError,
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid If-Match header",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    schedule, err := c.scheduleService.UpdateSchedule(ctx.Request.Context(), id, req, expectedVersion)
+    if err != nil {
+        if errors.Is(err, clienterrors.ErrPreconditionFailed) {
+            current, getErr := c.scheduleService.GetSchedule(ctx.Request.Context(), id)
+            if getErr == nil {
+                writePreconditionFailed(ctx, current, current.Version)
+                return
+            }
+            err = getErr
+        }
+
+        code := http.StatusInternalServerError
+        if errors.Is(err, clienterrors.ErrNotFound) {
+            code = http.StatusNotFound
+        }
+        ctx.JSON(code, dtos.ErrorResponse{
+            Code:    code,
             Message: "Failed to update schedule",
             Details: err.Error(),
         })
         return
     }
 
+    setETag(ctx, schedule.Version)
     ctx.JSON(http.StatusOK, dtos.APIResponse{
         Success: true,
         Message: "Schedule updated successfully",
@@ -213,7 +282,7 @@ func (c *ScheduleController) DeleteSchedule(ctx *gin.Context) {
         return
     }
 
-    err = c.scheduleService.DeleteSchedule(id)
+    err = c.scheduleService.DeleteSchedule(ctx.Request.Context(), id)
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -238,6 +307,8 @@ func (c *ScheduleController) DeleteSchedule(ctx *gin.Context) {
 // @Success 200 {object} dtos.APIResponse
 // @Failure 400 {object} dtos.ErrorResponse
 // @Failure 500 {object} dtos.ErrorResponse
+// @Failure 503 {object} dtos.ErrorResponse
+// @Failure 504 {object} dtos.ErrorResponse
 // @Router /provider-detail/schedules/{id}/run [post]
 // @Security BearerAuth
 func (c *ScheduleController) RunSchedule(ctx *gin.Context) {
@@ -252,8 +323,33 @@ func (c *ScheduleController) RunSchedule(ctx *gin.Context) {
         return
     }
 
-    result, err := c.scheduleService.RunSchedule(id)
+    result, err := c.scheduleService.RunSchedule(ctx.Request.Context(), id)
     if err != nil {
+        if errors.Is(err, clienterrors.ErrShuttingDown) {
+            ctx.Header("Retry-After", "30")
+            ctx.JSON(http.StatusServiceUnavailable, dtos.ErrorResponse{
+                Code:    http.StatusServiceUnavailable,
+                Message: "Server is shutting down, retry the schedule run later",
+                Details: err.Error(),
+            })
+            return
+        }
+        if errors.Is(err, clienterrors.ErrInvalidInput) {
+            ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+                Code:    http.StatusBadRequest,
+                Message: "Schedule cannot run",
+                Details: err.Error(),
+            })
+            return
+        }
+        if errors.Is(err, context.DeadlineExceeded) {
+            ctx.JSON(http.StatusGatewayTimeout, dtos.ErrorResponse{
+                Code:    http.StatusGatewayTimeout,
+                Message: "Schedule run timed out",
+                Details: err.Error(),
+            })
+            return
+        }
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
             Message: "Failed to run schedule",
@@ -272,12 +368,14 @@ func (c *ScheduleController) RunSchedule(ctx *gin.Context) {
 // ================= LOG CONTROLLER =================
 
 type LogController struct {
-    logService *services.LogService
+    logService      *services.LogService
+    scheduleService *services.ScheduleService
 }
 
-func NewLogController(logService *services.LogService) *LogController {
+func NewLogController(logService *services.LogService, scheduleService *services.ScheduleService) *LogController {
     return &LogController{
-        logService: logService,
+        logService:      logService,
+        scheduleService: scheduleService,
     }
 }
 
@@ -315,7 +413,7 @@ func (c *LogController) GetSentReportLogs(ctx *gin.Context) {
         req.Limit = 10
     }
 
-    result, err := c.logService.GetSentReportLogs(req)
+    result, err := c.logService.GetSentReportLogs(ctx.Request.Context(), req)
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -355,7 +453,7 @@ func (c *LogController) GetSentReportLog(ctx *gin.Context) {
         return
     }
 
-    log, err := c.logService.GetSentReportLog(id)
+    log, err := c.logService.GetSentReportLog(ctx.Request.Context(), id)
     if err != nil {
         ctx.JSON(http.StatusNotFound, dtos.ErrorResponse{
             Code:    http.StatusNotFound,
@@ -372,13 +470,84 @@ func (c *LogController) GetSentReportLog(ctx *gin.Context) {
     })
 }
 
+// ResendSentReport godoc
+// @Summary Resend a sent report
+// @Description Regenerate the report of a sent report log from its schedule and email it again to the recipients of the log. The resend is logged as a new sent report log linked to the original, and is not retried when it fails.
+// @Tags providerDetail
+// @Produce json
+// @Param id path int true "Log ID"
+// @Success 200 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Failure 503 {object} dtos.ErrorResponse
+// @Failure 504 {object} dtos.ErrorResponse
+// @Router /provider-detail/logs/sent-reports/{id}/resend [post]
+// @Security BearerAuth
+func (c *LogController) ResendSentReport(ctx *gin.Context) {
+    idStr := ctx.Param("id")
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid log ID",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    result, err := c.scheduleService.ResendReport(ctx.Request.Context(), id)
+    if err != nil {
+        switch {
+        case errors.Is(err, clienterrors.ErrInvalidInput):
+            ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+                Code:    http.StatusBadRequest,
+                Message: "Report cannot be resent",
+                Details: err.Error(),
+            })
+        case errors.Is(err, clienterrors.ErrNotFound):
+            ctx.JSON(http.StatusNotFound, dtos.ErrorResponse{
+                Code:    http.StatusNotFound,
+                Message: "Log or schedule not found",
+                Details: err.Error(),
+            })
+        case errors.Is(err, clienterrors.ErrShuttingDown):
+            ctx.Header("Retry-After", "30")
+            ctx.JSON(http.StatusServiceUnavailable, dtos.ErrorResponse{
+                Code:    http.StatusServiceUnavailable,
+                Message: "Server is shutting down, retry the resend later",
+                Details: err.Error(),
+            })
+        case errors.Is(err, context.DeadlineExceeded):
+            ctx.JSON(http.StatusGatewayTimeout, dtos.ErrorResponse{
+                Code:    http.StatusGatewayTimeout,
+                Message: "Report resend timed out",
+                Details: err.Error(),
+            })
+        default:
+            ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
+                Code:    http.StatusInternalServerError,
+                Message: "Failed to resend report",
+                Details: err.Error(),
+            })
+        }
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "Report resent successfully",
+        Data:    result,
+    })
+}
+
 // ================= FIELD CONTROLLER =================
 
 type FieldController struct {
-    fieldRepo *repositories.FieldRepository
+    fieldRepo repositories.FieldStore
 }
 
-func NewFieldController(fieldRepo *repositories.FieldRepository) *FieldController {
+func NewFieldController(fieldRepo repositories.FieldStore) *FieldController {
     return &FieldController{
         fieldRepo: fieldRepo,
     }
@@ -394,7 +563,7 @@ func NewFieldController(fieldRepo *repositories.FieldRepository) *FieldControlle
 // @Router /provider-detail/fields [get]
 // @Security BearerAuth
 func (c *FieldController) GetAvailableFields(ctx *gin.Context) {
-    fields, err := c.fieldRepo.GetAllFields()
+    fields, err := c.fieldRepo.GetAllFields(ctx.Request.Context())
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -441,7 +610,7 @@ func (c *FieldController) GetFieldsByCategory(ctx *gin.Context) {
         return
     }
 
-    fields, err := c.fieldRepo.GetFieldsByCategory(category)
+    fields, err := c.fieldRepo.GetFieldsByCategory(ctx.Request.Context(), category)
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -458,6 +627,16 @@ func (c *FieldController) GetFieldsByCategory(ctx *gin.Context) {
     })
 }
 
+// CreateProvider godoc
+// @Summary Create a new provider
+// @Description Create a provider with its basic, address, contact, tax, payment and pricing sections
+// @Tags providerDetail
+// @Accept json
+// @Produce json
+// @Param provider body dtos.CreateProviderRequestDTO true "Provider data"
+// @Success 201 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
 // @Router /provider-detail/providers [post]
 // @Security BearerAuth
 func (c *ProviderController) CreateProvider(ctx *gin.Context) {
@@ -467,20 +646,18 @@ func (c *ProviderController) CreateProvider(ctx *gin.Context) {
             Code:    http.StatusBadRequest,
             Message: "Invalid request body",
             Details: err.Error(),
+            Errors:  dtos.NewFieldErrors(err),
         })
         return
     }
 
-    provider, err := c.providerService.CreateProvider(req)
+    provider, err := c.providerService.CreateProvider(ctx.Request.Context(), req)
     if err != nil {
-        ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
-            Code:    http.StatusInternalServerError,
-            Message: "Failed to create provider",
-            Details: err.Error(),
-        })
+        writeProviderError(ctx, "Failed to create provider", err)
         return
     }
 
+    setETag(ctx, provider.Version)
     ctx.JSON(http.StatusCreated, dtos.APIResponse{
         Success: true,
         Message: "Provider created successfully",
@@ -494,9 +671,11 @@ func (c *ProviderController) CreateProvider(ctx *gin.Context) {
 // @Tags providerDetail
 // @Produce json
 // @Param id path int true "Provider ID"
+// @Param include_deleted query bool false "Also return a soft-deleted provider (requires the AUDIT action)"
 // @Success 200 {object} dtos.APIResponse
 // @Failure 400 {object} dtos.ErrorResponse
 // @Failure 404 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
 // @Router /provider-detail/providers/{id} [get]
 // @Security BearerAuth
 func (c *ProviderController) GetProvider(ctx *gin.Context) {
@@ -511,16 +690,18 @@ func (c *ProviderController) GetProvider(ctx *gin.Context) {
         return
     }
 
-    provider, err := c.providerService.GetProviderByID(id)
+    var provider *dtos.ProviderDTO
+    if ctx.Query("include_deleted") == "true" {
+        provider, err = c.providerService.GetProviderIncludingDeleted(ctx.Request.Context(), id)
+    } else {
+        provider, err = c.providerService.GetProviderByID(ctx.Request.Context(), id)
+    }
     if err != nil {
-        ctx.JSON(http.StatusNotFound, dtos.ErrorResponse{
-            Code:    http.StatusNotFound,
-            Message: "Provider not found",
-            Details: err.Error(),
-        })
+        writeProviderError(ctx, "Failed to get provider", err)
         return
     }
 
+    setETag(ctx, provider.Version)
     ctx.JSON(http.StatusOK, dtos.APIResponse{
         Success: true,
         Message: "Provider retrieved successfully",
@@ -530,14 +711,17 @@ func (c *ProviderController) GetProvider(ctx *gin.Context) {
 
 // UpdateProvider godoc
 // @Summary Update provider
-// @Description Update provider information by ID
+// @Description Replace every maintainable section of a provider by ID
 // @Tags providerDetail
 // @Accept json
 // @Produce json
 // @Param id path int true "Provider ID"
 // @Param provider body dtos.UpdateProviderRequestDTO true "Provider update data"
+// @Param If-Match header string false "ETag of the provider the change is based on"
 // @Success 200 {object} dtos.APIResponse
 // @Failure 400 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 412 {object} dtos.APIResponse
 // @Failure 500 {object} dtos.ErrorResponse
 // @Router /provider-detail/providers/{id} [put]
 // @Security BearerAuth
@@ -553,8 +737,9 @@ func (c *ProviderController) UpdateProvider(ctx *gin.Context) {
         return
     }
 
+    // The service validates the request once the masked values sent back are restored
     var req dtos.UpdateProviderRequestDTO
-    if err := ctx.ShouldBindJSON(&req); err != nil {
+    if err := json.NewDecoder(ctx.Request.Body).Decode(&req); err != nil {
         ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
             Code:    http.StatusBadRequest,
             Message: "Invalid request body",
@@ -563,16 +748,93 @@ func (c *ProviderController) UpdateProvider(ctx *gin.Context) {
         return
     }
 
-    provider, err := c.providerService.UpdateProvider(id, req)
+    expectedVersion, err := ifMatchVersion(ctx)
     if err != nil {
-        ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
-            Code:    http.StatusInternalServerError,
-            Message: "Failed to update provider",
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid If-Match header",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    provider, err := c.providerService.UpdateProvider(ctx.Request.Context(), id, req, expectedVersion)
+    if err != nil {
+        if errors.Is(err, clienterrors.ErrPreconditionFailed) {
+            c.writeProviderConflict(ctx, id)
+            return
+        }
+        writeProviderError(ctx, "Failed to update provider", err)
+        return
+    }
+
+    setETag(ctx, provider.Version)
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "Provider updated successfully",
+        Data:    provider,
+    })
+}
+
+// PatchProvider godoc
+// @Summary Patch provider
+// @Description Partially update a provider with a JSON merge patch (RFC 7396). Members set to null are cleared.
+// @Tags providerDetail
+// @Accept json
+// @Produce json
+// @Param id path int true "Provider ID"
+// @Param provider body dtos.UpdateProviderRequestDTO true "Provider merge patch"
+// @Param If-Match header string false "ETag of the provider the change is based on"
+// @Success 200 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 412 {object} dtos.APIResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/providers/{id} [patch]
+// @Security BearerAuth
+func (c *ProviderController) PatchProvider(ctx *gin.Context) {
+    idStr := ctx.Param("id")
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid provider ID",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    expectedVersion, err := ifMatchVersion(ctx)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid If-Match header",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    patch, err := ctx.GetRawData()
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid request body",
             Details: err.Error(),
         })
         return
     }
 
+    provider, err := c.providerService.PatchProvider(ctx.Request.Context(), id, patch, expectedVersion)
+    if err != nil {
+        if errors.Is(err, clienterrors.ErrPreconditionFailed) {
+            c.writeProviderConflict(ctx, id)
+            return
+        }
+        writeProviderError(ctx, "Failed to patch provider", err)
+        return
+    }
+
+    setETag(ctx, provider.Version)
     ctx.JSON(http.StatusOK, dtos.APIResponse{
         Success: true,
         Message: "Provider updated successfully",
@@ -582,12 +844,14 @@ func (c *ProviderController) UpdateProvider(ctx *gin.Context) {
 
 // DeleteProvider godoc
 // @Summary Delete provider
-// @Description Delete provider by ID
+// @Description Soft delete provider by ID. The provider is kept for claims history and can be restored.
 // @Tags providerDetail 
 // @Produce json
 // @Param id path int true "Provider ID"
 // @Success 200 {object} dtos.APIResponse
 // @Failure 400 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 409 {object} dtos.ErrorResponse
 // @Failure 500 {object} dtos.ErrorResponse
 // @Router /provider-detail/providers/{id} [delete]
 // @Security BearerAuth
@@ -603,19 +867,144 @@ func (c *ProviderController) DeleteProvider(ctx *gin.Context) {
         return
     }
 
-    err = c.providerService.DeleteProvider(id)
+    err = c.providerService.DeleteProvider(ctx.Request.Context(), id)
+    if err != nil {
+        writeProviderError(ctx, "Failed to delete provider", err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "Provider deleted successfully",
+    })
+}
+
+// RestoreProvider godoc
+// @Summary Restore provider
+// @Description Restore a soft-deleted provider by ID
+// @Tags providerDetail
+// @Produce json
+// @Param id path int true "Provider ID"
+// @Success 200 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 409 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/providers/{id}/restore [post]
+// @Security BearerAuth
+func (c *ProviderController) RestoreProvider(ctx *gin.Context) {
+    idStr := ctx.Param("id")
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid provider ID",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    provider, err := c.providerService.RestoreProvider(ctx.Request.Context(), id)
+    if err != nil {
+        writeProviderError(ctx, "Failed to restore provider", err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "Provider restored successfully",
+        Data:    provider,
+    })
+}
+
+// GetDeletedProviders godoc
+// @Summary List deleted providers
+// @Description Search soft-deleted providers (trash) with filters
+// @Tags providerDetail
+// @Produce json
+// @Param provider_name query string false "Provider name"
+// @Param province_name query string false "Province name"
+// @Param provider_type query string false "Provider type"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(10)
+// @Success 200 {object} dtos.PaginatedResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/providers/trash [get]
+// @Security BearerAuth
+func (c *ProviderController) GetDeletedProviders(ctx *gin.Context) {
+    var req dtos.ProviderSearchRequestDTO
+    if err := ctx.ShouldBindQuery(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid query parameters",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    providers, total, err := c.providerService.GetDeletedProviders(ctx.Request.Context(), req)
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
-            Message: "Failed to delete provider",
+            Message: "Failed to get deleted providers",
             Details: err.Error(),
         })
         return
     }
 
+    if req.Page == 0 {
+        req.Page = 1
+    }
+    if req.Limit == 0 {
+        req.Limit = 10
+    }
+
+    totalPages := int(total) / req.Limit
+    if int(total)%req.Limit > 0 {
+        totalPages++
+    }
+
     ctx.JSON(http.StatusOK, dtos.APIResponse{
         Success: true,
-        Message: "Provider deleted successfully",
+        Message: "Deleted providers retrieved successfully",
+        Data: dtos.PaginatedResponse{
+            Data:       providers,
+            Total:      total,
+            Page:       req.Page,
+            Limit:      req.Limit,
+            TotalPages: totalPages,
+        },
+    })
+}
+
+// writeProviderError maps provider errors to 400 (invalid input), 404 (deleted or missing),
+// 409 (wrong state) or 500
+func writeProviderError(ctx *gin.Context, message string, err error) {
+    if fieldErrors := dtos.NewFieldErrors(err); fieldErrors != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: message,
+            Details: err.Error(),
+            Errors:  fieldErrors,
+        })
+        return
+    }
+
+    code := http.StatusInternalServerError
+    switch {
+    case errors.Is(err, clienterrors.ErrInvalidInput):
+        code = http.StatusBadRequest
+    case errors.Is(err, clienterrors.ErrProviderDeleted):
+        code = http.StatusNotFound
+    case errors.Is(err, clienterrors.ErrAlreadyDeleted), errors.Is(err, clienterrors.ErrNotDeleted):
+        code = http.StatusConflict
+    }
+
+    ctx.JSON(code, dtos.ErrorResponse{
+        Code:    code,
+        Message: message,
+        Details: err.Error(),
     })
 }
 
@@ -630,6 +1019,7 @@ func (c *ProviderController) DeleteProvider(ctx *gin.Context) {
 // @Param province_name query string false "Province name"
 // @Param provider_type query string false "Provider type"
 // @Param business_type query string false "Business type"
+// @Param include_deleted query bool false "Include soft-deleted providers (requires the AUDIT action)"
 // @Param page query int false "Page number" default(1)
 // @Param limit query int false "Items per page" default(10)
 // @Success 200 {object} dtos.PaginatedResponse
@@ -648,7 +1038,7 @@ func (c *ProviderController) SearchProviders(ctx *gin.Context) {
         return
     }
 
-    providers, total, err := c.providerService.SearchProviders(req)
+    providers, total, err := c.providerService.SearchProviders(ctx.Request.Context(), req)
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -711,7 +1101,7 @@ func (c *ProviderController) GetProviderSummary(ctx *gin.Context) {
         return
     }
 
-    summary, err := c.providerService.GetProviderSummary(req)
+    summary, err := c.providerService.GetProviderSummary(ctx.Request.Context(), req)
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -738,7 +1128,7 @@ func (c *ProviderController) GetProviderSummary(ctx *gin.Context) {
 // @Router /provider-detail/providers/stats [get]
 // @Security BearerAuth
 func (c *ProviderController) GetProviderStats(ctx *gin.Context) {
-    stats, err := c.providerService.GetProviderStats()
+    stats, err := c.providerService.GetProviderStats(ctx.Request.Context())
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -764,6 +1154,7 @@ func (c *ProviderController) GetProviderStats(ctx *gin.Context) {
 // @Param report body dtos.ProviderReportRequestDTO true "Report generation parameters"
 // @Success 200 {object} dtos.APIResponse
 // @Failure 400 {object} dtos.ErrorResponse
+// @Failure 429 {object} dtos.ErrorResponse
 // @Failure 500 {object} dtos.ErrorResponse
 // @Router /provider-detail/providers/report [post]
 // @Security BearerAuth
@@ -778,8 +1169,21 @@ func (c *ProviderController) GenerateReport(ctx *gin.Context) {
         return
     }
 
-    reportData, err := c.providerService.GenerateReport(req)
+    reportData, quota, err := c.providerService.GenerateReport(ctx.Request.Context(), req)
+    if quota != nil {
+        setExportQuotaHeaders(ctx, quota)
+    }
     if err != nil {
+        if errors.Is(err, clienterrors.ErrQuotaExceeded) {
+            retryAfter := int(time.Until(quota.ResetAt).Seconds()) + 1
+            ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+            ctx.JSON(http.StatusTooManyRequests, dtos.ErrorResponse{
+                Code:    http.StatusTooManyRequests,
+                Message: "Daily export quota exceeded",
+                Details: err.Error(),
+            })
+            return
+        }
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
             Message: "Failed to generate report",
@@ -797,14 +1201,16 @@ func (c *ProviderController) GenerateReport(ctx *gin.Context) {
 
 // ExportReport godoc
 // @Summary Export provider report
-// @Description Export provider report in specified format (Excel, PDF, etc.)
+// @Description Export provider report in specified format (Excel, CSV, PDF, etc.)
 // @Tags providerDetail
 // @Accept json
 // @Produce application/octet-stream
 // @Param export body dtos.ProviderReportRequestDTO true "Export parameters"
 // @Success 200 {file} file "Exported report file"
 // @Failure 400 {object} dtos.ErrorResponse
+// @Failure 429 {object} dtos.ErrorResponse
 // @Failure 500 {object} dtos.ErrorResponse
+// @Failure 504 {object} dtos.ErrorResponse
 // @Router /provider-detail/providers/export [post]
 // @Security BearerAuth
 func (c *ProviderController) ExportReport(ctx *gin.Context) {
@@ -822,11 +1228,32 @@ func (c *ProviderController) ExportReport(ctx *gin.Context) {
         req.FormatType = "excel"
     }
 
-    fileData, filename, contentType, err := c.providerService.ExportReport(req)
+    fileData, filename, contentType, quota, err := c.providerService.ExportReport(ctx.Request.Context(), req)
+    if quota != nil {
+        setExportQuotaHeaders(ctx, quota)
+    }
     if err != nil {
-        ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
-            Code:    http.StatusInternalServerError,
-            Message: "Failed to export report",
+        if errors.Is(err, clienterrors.ErrQuotaExceeded) {
+            retryAfter := int(time.Until(quota.ResetAt).Seconds()) + 1
+            ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+            ctx.JSON(http.StatusTooManyRequests, dtos.ErrorResponse{
+                Code:    http.StatusTooManyRequests,
+                Message: "Daily export quota exceeded",
+                Details: err.Error(),
+            })
+            return
+        }
+        if errors.Is(err, context.DeadlineExceeded) {
+            ctx.JSON(http.StatusGatewayTimeout, dtos.ErrorResponse{
+                Code:    http.StatusGatewayTimeout,
+                Message: "Export timed out",
+                Details: err.Error(),
+            })
+            return
+        }
+        ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
+            Code:    http.StatusInternalServerError,
+            Message: "Failed to export report",
             Details: err.Error(),
         })
         return
@@ -846,7 +1273,7 @@ func (c *ProviderController) ExportReport(ctx *gin.Context) {
 // @Router /provider-detail/providers/provinces [get]
 // @Security BearerAuth
 func (c *ProviderController) GetProvinces(ctx *gin.Context) {
-    provinces, err := c.providerService.GetProvinces()
+    provinces, err := c.providerService.GetProvinces(ctx.Request.Context())
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -873,7 +1300,7 @@ func (c *ProviderController) GetProvinces(ctx *gin.Context) {
 // @Router /provider-detail/providers/types [get]
 // @Security BearerAuth
 func (c *ProviderController) GetProviderTypes(ctx *gin.Context) {
-    types, err := c.providerService.GetProviderTypes()
+    types, err := c.providerService.GetProviderTypes(ctx.Request.Context())
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -912,7 +1339,7 @@ func NewTemplateController(templateService *services.TemplateService) *TemplateC
 // @Router /provider-detail/templates [get]
 // @Security BearerAuth
 func (c *TemplateController) GetTemplates(ctx *gin.Context) {
-    templates, err := c.templateService.GetAllTemplates()
+    templates, err := c.templateService.GetAllTemplates(ctx.Request.Context())
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -953,9 +1380,9 @@ func (c *TemplateController) GetTemplate(ctx *gin.Context) {
         return
     }
 
-    template, err := c.templateService.GetTemplate(id)
+    template, err := c.templateService.GetTemplate(ctx.Request.Context(), id)
     if err != nil {
-        if err.Error() == "template not found" {
+        if errors.Is(err, clienterrors.ErrNotFound) {
             ctx.JSON(http.StatusNotFound, dtos.ErrorResponse{
                 Code:    http.StatusNotFound,
                 Message: "Template not found",
@@ -972,6 +1399,7 @@ func (c *TemplateController) GetTemplate(ctx *gin.Context) {
         return
     }
 
+    setETag(ctx, template.Version)
     ctx.JSON(http.StatusOK, dtos.APIResponse{
         Success: true,
         Message: "Template retrieved successfully",
@@ -1003,7 +1431,7 @@ func (c *TemplateController) CreateTemplate(ctx *gin.Context) {
         return
     }
 
-    template, err := c.templateService.CreateTemplate(req)
+    template, err := c.templateService.CreateTemplate(ctx.Request.Context(), req)
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -1028,9 +1456,11 @@ func (c *TemplateController) CreateTemplate(ctx *gin.Context) {
 // @Produce json
 // @Param id path int true "Template ID"
 // @Param template body dtos.UpdateTemplateRequestDTO true "Template update data"
+// @Param If-Match header string false "ETag of the template the change is based on"
 // @Success 200 {object} dtos.APIResponse
 // @Failure 400 {object} dtos.ErrorResponse
 // @Failure 404 {object} dtos.ErrorResponse
+// @Failure 412 {object} dtos.APIResponse
 // @Failure 500 {object} dtos.ErrorResponse
 // @Router /provider-detail/templates/{id} [put]
 // @Security BearerAuth
@@ -1056,9 +1486,28 @@ func (c *TemplateController) UpdateTemplate(ctx *gin.Context) {
         return
     }
 
-    template, err := c.templateService.UpdateTemplate(id, req)
+    expectedVersion, err := ifMatchVersion(ctx)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid If-Match header",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    template, err := c.templateService.UpdateTemplate(ctx.Request.Context(), id, req, expectedVersion)
     if err != nil {
-        if err.Error() == "template not found" {
+        if errors.Is(err, clienterrors.ErrPreconditionFailed) {
+            current, getErr := c.templateService.GetTemplate(ctx.Request.Context(), id)
+            if getErr == nil {
+                writePreconditionFailed(ctx, current, current.Version)
+                return
+            }
+            err = getErr
+        }
+
+        if errors.Is(err, clienterrors.ErrNotFound) {
             ctx.JSON(http.StatusNotFound, dtos.ErrorResponse{
                 Code:    http.StatusNotFound,
                 Message: "Template not found",
@@ -1075,6 +1524,7 @@ func (c *TemplateController) UpdateTemplate(ctx *gin.Context) {
         return
     }
 
+    setETag(ctx, template.Version)
     ctx.JSON(http.StatusOK, dtos.APIResponse{
         Success: true,
         Message: "Template updated successfully",
@@ -1105,7 +1555,7 @@ func (c *TemplateController) DeleteTemplate(ctx *gin.Context) {
         return
     }
 
-    err = c.templateService.DeleteTemplate(id)
+    err = c.templateService.DeleteTemplate(ctx.Request.Context(), id)
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -1143,7 +1593,7 @@ func NewScheduleController(scheduleService *services.ScheduleService) *ScheduleC
 // @Router /provider-detail/schedules [get]
 // @Security BearerAuth
 func (c *ScheduleController) GetSchedules(ctx *gin.Context) {
-    schedules, err := c.scheduleService.GetAllSchedules()
+    schedules, err := c.scheduleService.GetAllSchedules(ctx.Request.Context())
     if err != nil {
         ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
             Code:    http.StatusInternalServerError,
@@ -1183,7 +1633,7 @@ func (c *ScheduleController) GetSchedule(ctx *gin.Context) {
         return
     }
 
-    schedule, err := c.scheduleService.GetSchedule(id)
+    schedule, err := c.scheduleService.GetSchedule(ctx.Request.Context(), id)
     if err != nil {
         ctx.JSON(http.StatusNotFound, dtos.ErrorResponse{
             Code:    http.StatusNotFound,
@@ -1193,6 +1643,7 @@ func (c *ScheduleController) GetSchedule(ctx *gin.Context) {
         return
     }
 
+    setETag(ctx, schedule.Version)
     ctx.JSON(http.StatusOK, dtos.APIResponse{
         Success: true,
         Message: "Schedule retrieved successfully",
@@ -1208,6 +1659,7 @@ func (c *LogController) SetupRoutes(api *gin.RouterGroup) {
     {
         logs.GET("/sent-reports", c.GetSentReportLogs)
         logs.GET("/sent-reports/:id", c.GetSentReportLog)
+        logs.POST("/sent-reports/:id/resend", c.ResendSentReport)
     }
 }
 // ================= FIELD CONTROLLER =================
@@ -1219,4 +1671,648 @@ func (c *FieldController) SetupRoutes(api *gin.RouterGroup) {
         fields.GET("", c.GetAvailableFields)
         fields.GET("/by-category/:category", c.GetFieldsByCategory)
     }
-}
\ No newline at end of file
+}
+
+// writeProviderConflict answers a failed If-Match precondition with the current provider
+func (c *ProviderController) writeProviderConflict(ctx *gin.Context, id int) {
+    current, err := c.providerService.GetProviderByID(ctx.Request.Context(), id)
+    if err != nil {
+        writeProviderError(ctx, "Failed to update provider", err)
+        return
+    }
+    writePreconditionFailed(ctx, current, current.Version)
+}
+
+// ================= CONCURRENCY HELPERS =================
+
+// setETag exposes the row version so clients can send it back in If-Match
+func setETag(ctx *gin.Context, version int) {
+    ctx.Header("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// ifMatchVersion parses the If-Match header. It returns nil when the header is absent or "*".
+func ifMatchVersion(ctx *gin.Context) (*int, error) {
+    value := strings.TrimSpace(ctx.GetHeader("If-Match"))
+    if value == "" || value == "*" {
+        return nil, nil
+    }
+
+    value = strings.TrimPrefix(value, "W/")
+    version, err := strconv.Atoi(strings.Trim(value, `"`))
+    if err != nil {
+        return nil, fmt.Errorf("If-Match must be an ETag returned by this API, got %q", ctx.GetHeader("If-Match"))
+    }
+    return &version, nil
+}
+
+// writePreconditionFailed returns 412 with the current server state so the client can
+// re-apply its change and retry
+func writePreconditionFailed(ctx *gin.Context, current interface{}, version int) {
+    setETag(ctx, version)
+    ctx.JSON(http.StatusPreconditionFailed, dtos.APIResponse{
+        Success: false,
+        Message: "The resource was modified by another request",
+        Error:   clienterrors.ErrPreconditionFailed.Error(),
+        Data:    current,
+    })
+}
+
+// ================= QUOTA HELPERS =================
+
+// setExportQuotaHeaders exposes the daily export quota of the user. A limit of 0 is unlimited
+// and has -1 remaining.
+func setExportQuotaHeaders(ctx *gin.Context, quota *dtos.ExportQuotaStatusDTO) {
+    ctx.Header("X-Export-Quota-Rows-Limit", strconv.FormatInt(quota.RowLimit, 10))
+    ctx.Header("X-Export-Quota-Rows-Remaining", strconv.FormatInt(quota.RowsRemaining(), 10))
+    ctx.Header("X-Export-Quota-Bytes-Limit", strconv.FormatInt(quota.ByteLimit, 10))
+    ctx.Header("X-Export-Quota-Bytes-Remaining", strconv.FormatInt(quota.BytesRemaining(), 10))
+    ctx.Header("X-Export-Quota-Reset", strconv.FormatInt(quota.ResetAt.Unix(), 10))
+}
+
+// ================= SAVED SEARCH CONTROLLER =================
+
+type SavedSearchController struct {
+    savedSearchService *services.SavedSearchService
+}
+
+func NewSavedSearchController(savedSearchService *services.SavedSearchService) *SavedSearchController {
+    return &SavedSearchController{
+        savedSearchService: savedSearchService,
+    }
+}
+
+// GetSavedSearches godoc
+// @Summary Get saved searches
+// @Description Get the provider searches saved by the current user
+// @Tags providerDetail
+// @Produce json
+// @Success 200 {object} dtos.APIResponse
+// @Failure 401 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/providers/saved-searches [get]
+// @Security BearerAuth
+func (c *SavedSearchController) GetSavedSearches(ctx *gin.Context) {
+    username, ok := requireUsername(ctx)
+    if !ok {
+        return
+    }
+
+    searches, err := c.savedSearchService.GetSavedSearches(ctx.Request.Context(), username)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
+            Code:    http.StatusInternalServerError,
+            Message: "Failed to get saved searches",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "Saved searches retrieved successfully",
+        Data: dtos.SavedSearchListResponseDTO{
+            SavedSearches: searches,
+            Total:         len(searches),
+        },
+    })
+}
+
+// GetSavedSearch godoc
+// @Summary Get saved search by ID
+// @Description Load a single saved search of the current user
+// @Tags providerDetail
+// @Produce json
+// @Param id path int true "Saved search ID"
+// @Success 200 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 401 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Router /provider-detail/providers/saved-searches/{id} [get]
+// @Security BearerAuth
+func (c *SavedSearchController) GetSavedSearch(ctx *gin.Context) {
+    username, ok := requireUsername(ctx)
+    if !ok {
+        return
+    }
+
+    id, err := strconv.Atoi(ctx.Param("id"))
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid saved search ID",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    search, err := c.savedSearchService.GetSavedSearch(ctx.Request.Context(), id, username)
+    if err != nil {
+        writeSavedSearchError(ctx, "Failed to get saved search", err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "Saved search retrieved successfully",
+        Data:    search,
+    })
+}
+
+// CreateSavedSearch godoc
+// @Summary Save a provider search
+// @Description Save named provider search criteria and a default export template for the current user
+// @Tags providerDetail
+// @Accept json
+// @Produce json
+// @Param search body dtos.CreateSavedSearchRequestDTO true "Saved search data"
+// @Success 201 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 401 {object} dtos.ErrorResponse
+// @Failure 409 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/providers/saved-searches [post]
+// @Security BearerAuth
+func (c *SavedSearchController) CreateSavedSearch(ctx *gin.Context) {
+    username, ok := requireUsername(ctx)
+    if !ok {
+        return
+    }
+
+    var req dtos.CreateSavedSearchRequestDTO
+    if err := ctx.ShouldBindJSON(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid request body",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    search, err := c.savedSearchService.CreateSavedSearch(ctx.Request.Context(), username, req)
+    if err != nil {
+        writeSavedSearchError(ctx, "Failed to create saved search", err)
+        return
+    }
+
+    ctx.JSON(http.StatusCreated, dtos.APIResponse{
+        Success: true,
+        Message: "Saved search created successfully",
+        Data:    search,
+    })
+}
+
+// UpdateSavedSearch godoc
+// @Summary Update saved search
+// @Description Rename or change the criteria of a saved search of the current user
+// @Tags providerDetail
+// @Accept json
+// @Produce json
+// @Param id path int true "Saved search ID"
+// @Param search body dtos.UpdateSavedSearchRequestDTO true "Saved search update data"
+// @Success 200 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 401 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 409 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/providers/saved-searches/{id} [put]
+// @Security BearerAuth
+func (c *SavedSearchController) UpdateSavedSearch(ctx *gin.Context) {
+    username, ok := requireUsername(ctx)
+    if !ok {
+        return
+    }
+
+    id, err := strconv.Atoi(ctx.Param("id"))
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid saved search ID",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    var req dtos.UpdateSavedSearchRequestDTO
+    if err := ctx.ShouldBindJSON(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid request body",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    search, err := c.savedSearchService.UpdateSavedSearch(ctx.Request.Context(), id, username, req)
+    if err != nil {
+        writeSavedSearchError(ctx, "Failed to update saved search", err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "Saved search updated successfully",
+        Data:    search,
+    })
+}
+
+// DeleteSavedSearch godoc
+// @Summary Delete saved search
+// @Description Delete a saved search of the current user
+// @Tags providerDetail
+// @Produce json
+// @Param id path int true "Saved search ID"
+// @Success 200 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 401 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/providers/saved-searches/{id} [delete]
+// @Security BearerAuth
+func (c *SavedSearchController) DeleteSavedSearch(ctx *gin.Context) {
+    username, ok := requireUsername(ctx)
+    if !ok {
+        return
+    }
+
+    id, err := strconv.Atoi(ctx.Param("id"))
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid saved search ID",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    err = c.savedSearchService.DeleteSavedSearch(ctx.Request.Context(), id, username)
+    if err != nil {
+        writeSavedSearchError(ctx, "Failed to delete saved search", err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "Saved search deleted successfully",
+    })
+}
+
+// requireUsername returns the username of the JWT claims or writes a 401 response
+func requireUsername(ctx *gin.Context) (string, bool) {
+    username, err := utility.GetUsername(ctx)
+    if err != nil {
+        ctx.JSON(http.StatusUnauthorized, dtos.ErrorResponse{
+            Code:    http.StatusUnauthorized,
+            Message: "Unauthorized",
+            Details: err.Error(),
+        })
+        return "", false
+    }
+    return username, true
+}
+
+func writeSavedSearchError(ctx *gin.Context, message string, err error) {
+    code := http.StatusInternalServerError
+    switch {
+    case errors.Is(err, clienterrors.ErrNotFound):
+        code = http.StatusNotFound
+    case errors.Is(err, clienterrors.ErrDuplicateName):
+        code = http.StatusConflict
+    }
+
+    ctx.JSON(code, dtos.ErrorResponse{
+        Code:    code,
+        Message: message,
+        Details: err.Error(),
+    })
+}
+
+// ================= HISTORY CONTROLLER =================
+
+type HistoryController struct {
+    historyService  *services.HistoryService
+    providerService *services.ProviderService
+}
+
+func NewHistoryController(historyService *services.HistoryService, providerService *services.ProviderService) *HistoryController {
+    return &HistoryController{
+        historyService:  historyService,
+        providerService: providerService,
+    }
+}
+
+// GetProviderHistory godoc
+// @Summary Get provider change history
+// @Description Get who changed which fields of a provider, newest first. Deleted providers keep their history.
+// @Tags providerDetail
+// @Produce json
+// @Param id path int true "Provider ID"
+// @Param field_name query string false "Only changes of this field (e.g. bank_account_number)"
+// @Param changed_by query string false "Only changes by this user"
+// @Param date_from query string false "Changed from (RFC3339)"
+// @Param date_to query string false "Changed to (RFC3339)"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(10)
+// @Success 200 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/providers/{id}/history [get]
+// @Security BearerAuth
+func (c *HistoryController) GetProviderHistory(ctx *gin.Context) {
+    idStr := ctx.Param("id")
+    id, err := strconv.Atoi(idStr)
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid provider ID",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    var req dtos.HistorySearchRequestDTO
+    if err := ctx.ShouldBindQuery(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid query parameters",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    if _, err := c.providerService.GetProviderIncludingDeleted(ctx.Request.Context(), id); err != nil {
+        writeProviderError(ctx, "Failed to get provider history", err)
+        return
+    }
+
+    result, err := c.historyService.GetEntityHistory(ctx.Request.Context(), dtos.HistoryEntityProvider, id, req)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
+            Code:    http.StatusInternalServerError,
+            Message: "Failed to get provider history",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "Provider history retrieved successfully",
+        Data:    result,
+    })
+}
+
+// SearchHistory godoc
+// @Summary Change history report
+// @Description Report of changes to providers, templates, schedules and fields, e.g. everything changed between two dates
+// @Tags providerDetail
+// @Produce json
+// @Param entity_type query string false "provider, template, schedule or field"
+// @Param entity_id query int false "Entity ID"
+// @Param action query string false "create, update, delete or restore"
+// @Param field_name query string false "Only changes of this field"
+// @Param changed_by query string false "Only changes by this user"
+// @Param date_from query string false "Changed from (RFC3339)"
+// @Param date_to query string false "Changed to (RFC3339)"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(10)
+// @Success 200 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/history [get]
+// @Security BearerAuth
+func (c *HistoryController) SearchHistory(ctx *gin.Context) {
+    var req dtos.HistorySearchRequestDTO
+    if err := ctx.ShouldBindQuery(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid query parameters",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    result, err := c.historyService.SearchHistory(ctx.Request.Context(), req)
+    if err != nil {
+        ctx.JSON(http.StatusInternalServerError, dtos.ErrorResponse{
+            Code:    http.StatusInternalServerError,
+            Message: "Failed to get change history",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "Change history retrieved successfully",
+        Data:    result,
+    })
+}
+
+// ================= API KEY CONTROLLER =================
+
+type APIKeyController struct {
+    apiKeyService *services.APIKeyService
+}
+
+func NewAPIKeyController(apiKeyService *services.APIKeyService) *APIKeyController {
+    return &APIKeyController{
+        apiKeyService: apiKeyService,
+    }
+}
+
+// GetAPIKeys godoc
+// @Summary List API keys
+// @Description List the API keys of machine clients with their usage counters. Keys are never returned.
+// @Tags providerDetail
+// @Produce json
+// @Success 200 {object} dtos.APIResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/admin/api-keys [get]
+// @Security BearerAuth
+func (c *APIKeyController) GetAPIKeys(ctx *gin.Context) {
+    keys, err := c.apiKeyService.GetAPIKeys(ctx.Request.Context())
+    if err != nil {
+        writeAPIKeyError(ctx, "Failed to get API keys", err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "API keys retrieved successfully",
+        Data:    keys,
+    })
+}
+
+// CreateAPIKey godoc
+// @Summary Create an API key
+// @Description Create an API key for read-only search or export access. The key is only returned in this response.
+// @Tags providerDetail
+// @Accept json
+// @Produce json
+// @Param apiKey body dtos.CreateAPIKeyRequestDTO true "API key data"
+// @Success 201 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/admin/api-keys [post]
+// @Security BearerAuth
+func (c *APIKeyController) CreateAPIKey(ctx *gin.Context) {
+    var req dtos.CreateAPIKeyRequestDTO
+    if err := ctx.ShouldBindJSON(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid request body",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    key, err := c.apiKeyService.CreateAPIKey(ctx.Request.Context(), req)
+    if err != nil {
+        writeAPIKeyError(ctx, "Failed to create API key", err)
+        return
+    }
+
+    ctx.JSON(http.StatusCreated, dtos.APIResponse{
+        Success: true,
+        Message: "API key created successfully, store the key now as it cannot be shown again",
+        Data:    key,
+    })
+}
+
+// RotateAPIKey godoc
+// @Summary Rotate an API key
+// @Description Replace an API key with a new key with the same scopes and revoke the old one. The new key is only returned in this response.
+// @Tags providerDetail
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 201 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 409 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/admin/api-keys/{id}/rotate [post]
+// @Security BearerAuth
+func (c *APIKeyController) RotateAPIKey(ctx *gin.Context) {
+    id, ok := apiKeyID(ctx)
+    if !ok {
+        return
+    }
+
+    key, err := c.apiKeyService.RotateAPIKey(ctx.Request.Context(), id)
+    if err != nil {
+        writeAPIKeyError(ctx, "Failed to rotate API key", err)
+        return
+    }
+
+    ctx.JSON(http.StatusCreated, dtos.APIResponse{
+        Success: true,
+        Message: "API key rotated successfully, store the new key now as it cannot be shown again",
+        Data:    key,
+    })
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Revoke an API key, requests made with it are rejected from now on
+// @Tags providerDetail
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 200 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 409 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/admin/api-keys/{id} [delete]
+// @Security BearerAuth
+func (c *APIKeyController) RevokeAPIKey(ctx *gin.Context) {
+    id, ok := apiKeyID(ctx)
+    if !ok {
+        return
+    }
+
+    if err := c.apiKeyService.RevokeAPIKey(ctx.Request.Context(), id); err != nil {
+        writeAPIKeyError(ctx, "Failed to revoke API key", err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "API key revoked successfully",
+    })
+}
+
+// GetAPIKeyUsage godoc
+// @Summary Get API key usage
+// @Description Get the latest requests made with an API key, newest first
+// @Tags providerDetail
+// @Produce json
+// @Param id path int true "API key ID"
+// @Param limit query int false "Number of requests" default(100)
+// @Success 200 {object} dtos.APIResponse
+// @Failure 400 {object} dtos.ErrorResponse
+// @Failure 404 {object} dtos.ErrorResponse
+// @Failure 500 {object} dtos.ErrorResponse
+// @Router /provider-detail/admin/api-keys/{id}/usage [get]
+// @Security BearerAuth
+func (c *APIKeyController) GetAPIKeyUsage(ctx *gin.Context) {
+    id, ok := apiKeyID(ctx)
+    if !ok {
+        return
+    }
+
+    var req dtos.APIKeyUsageRequestDTO
+    if err := ctx.ShouldBindQuery(&req); err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid query parameters",
+            Details: err.Error(),
+        })
+        return
+    }
+
+    usage, err := c.apiKeyService.GetAPIKeyUsage(ctx.Request.Context(), id, req)
+    if err != nil {
+        writeAPIKeyError(ctx, "Failed to get API key usage", err)
+        return
+    }
+
+    ctx.JSON(http.StatusOK, dtos.APIResponse{
+        Success: true,
+        Message: "API key usage retrieved successfully",
+        Data:    usage,
+    })
+}
+
+// apiKeyID returns the :id path parameter or writes a 400 response
+func apiKeyID(ctx *gin.Context) (int, bool) {
+    id, err := strconv.Atoi(ctx.Param("id"))
+    if err != nil {
+        ctx.JSON(http.StatusBadRequest, dtos.ErrorResponse{
+            Code:    http.StatusBadRequest,
+            Message: "Invalid API key ID",
+            Details: err.Error(),
+        })
+        return 0, false
+    }
+    return id, true
+}
+
+func writeAPIKeyError(ctx *gin.Context, message string, err error) {
+    code := http.StatusInternalServerError
+    switch {
+    case errors.Is(err, clienterrors.ErrInvalidInput):
+        code = http.StatusBadRequest
+    case errors.Is(err, clienterrors.ErrNotFound):
+        code = http.StatusNotFound
+    case errors.Is(err, clienterrors.ErrAlreadyDeleted):
+        code = http.StatusConflict
+    }
+
+    ctx.JSON(code, dtos.ErrorResponse{
+        Code:    code,
+        Message: message,
+        Details: err.Error(),
+    })
+}
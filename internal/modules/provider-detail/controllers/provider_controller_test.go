@@ -0,0 +1,202 @@
+package controllers
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+    "github.com/go-playground/validator/v10"
+    "provider-report-api/internal/modules/provider-detail/dtos"
+    "provider-report-api/internal/modules/provider-detail/repositories/memory"
+    "provider-report-api/internal/modules/provider-detail/services"
+    "provider-report-api/pkg/utility"
+)
+
+var registerValidations sync.Once
+
+// testTaxID is a Thai tax ID with a valid check digit
+const testTaxID = "1234567890121"
+
+// taxIDFieldRepository reports the tax ID as sensitive, which the memory field repository has
+// no way to store
+type taxIDFieldRepository struct {
+    *memory.FieldRepository
+}
+
+func (r taxIDFieldRepository) GetSensitiveFields(ctx context.Context) ([]dtos.AvailableFieldDTO, error) {
+    maskType := utility.MaskTypeLast4
+    return []dtos.AvailableFieldDTO{{FieldCode: "provider_tax_id", Sensitivity: dtos.FieldSensitivitySensitive, MaskType: &maskType}}, nil
+}
+
+// newUpdateTestRouter serves the provider read and update routes on memory repositories,
+// holding one provider whose masked tax ID is all callers see
+func newUpdateTestRouter(t *testing.T) (*gin.Engine, *dtos.ProviderDTO, *memory.ProviderRepository) {
+    t.Helper()
+    gin.SetMode(gin.TestMode)
+    registerValidations.Do(func() {
+        if err := dtos.RegisterProviderValidations(binding.Validator.Engine().(*validator.Validate)); err != nil {
+            t.Fatal(err)
+        }
+    })
+
+    db := memory.NewDB()
+    providerRepo := memory.NewProviderRepository(db)
+    fieldRepo := taxIDFieldRepository{memory.NewFieldRepository(db)}
+    history := services.NewHistoryService(memory.NewHistoryRepository(db), fieldRepo)
+    providerService := services.NewProviderService(providerRepo, services.NewExportService(), fieldRepo, memory.NewTemplateRepository(db), history, memory.NewDataScopeRepository(db), nil, nil, 0, nil)
+
+    provider := &dtos.ProviderDTO{ProviderCode: "P001", NameThai: "โรงพยาบาลทดสอบ", ProviderType: "Hospital", Province: "Bangkok", ProviderStatus: "Active", ProviderTaxID: utility.StringPtr(testTaxID)}
+    if err := providerRepo.Create(context.Background(), provider); err != nil {
+        t.Fatal(err)
+    }
+
+    c := NewProviderController(providerService)
+    r := gin.New()
+    r.GET("/providers/:id", c.GetProvider)
+    r.PUT("/providers/:id", c.UpdateProvider)
+    r.PATCH("/providers/:id", c.PatchProvider)
+    return r, provider, providerRepo
+}
+
+func putProvider(t *testing.T, r *gin.Engine, provider *dtos.ProviderDTO, name, ifMatch string) *httptest.ResponseRecorder {
+    t.Helper()
+    req := dtos.NewUpdateProviderRequest(provider)
+    req.NameThai = name
+    body, err := json.Marshal(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    httpReq := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/providers/%d", provider.ID), bytes.NewReader(body))
+    httpReq.Header.Set("Content-Type", "application/json")
+    if ifMatch != "" {
+        httpReq.Header.Set("If-Match", ifMatch)
+    }
+    w := httptest.NewRecorder()
+    r.ServeHTTP(w, httpReq)
+    return w
+}
+
+// decodeProvider returns the provider in the data of a response
+func decodeProvider(t *testing.T, w *httptest.ResponseRecorder) dtos.ProviderDTO {
+    t.Helper()
+    var body struct {
+        Data dtos.ProviderDTO `json:"data"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+        t.Fatal(err)
+    }
+    return body.Data
+}
+
+func TestUpdateProviderWithStaleETag(t *testing.T) {
+    r, provider, _ := newUpdateTestRouter(t)
+
+    w := httptest.NewRecorder()
+    r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/providers/%d", provider.ID), nil))
+    etag := w.Header().Get("ETag")
+    if w.Code != http.StatusOK || etag != `"1"` {
+        t.Fatalf("GET = %d with ETag %s, want 200 with ETag \"1\"", w.Code, etag)
+    }
+
+    // Two clients edit the provider they both read at version 1
+    first := putProvider(t, r, provider, "โรงพยาบาลหนึ่ง", etag)
+    if first.Code != http.StatusOK || first.Header().Get("ETag") != `"2"` {
+        t.Fatalf("first PUT = %d with ETag %s, want 200 with ETag \"2\": %s", first.Code, first.Header().Get("ETag"), first.Body)
+    }
+
+    second := putProvider(t, r, provider, "โรงพยาบาลสอง", etag)
+    if second.Code != http.StatusPreconditionFailed {
+        t.Fatalf("second PUT = %d, want 412: %s", second.Code, second.Body)
+    }
+    // The client gets the current provider to re-apply its change to
+    if got := second.Header().Get("ETag"); got != `"2"` {
+        t.Errorf("412 ETag = %s, want \"2\"", got)
+    }
+    if current := decodeProvider(t, second); current.NameThai != "โรงพยาบาลหนึ่ง" || current.Version != 2 {
+        t.Errorf("412 data = %q version %d, want the first client's change at version 2", current.NameThai, current.Version)
+    }
+
+    retried := putProvider(t, r, provider, "โรงพยาบาลสอง", second.Header().Get("ETag"))
+    if retried.Code != http.StatusOK {
+        t.Errorf("PUT with the new ETag = %d, want 200: %s", retried.Code, retried.Body)
+    }
+}
+
+func TestConcurrentUpdateProviderReturnsPreconditionFailed(t *testing.T) {
+    r, provider, _ := newUpdateTestRouter(t)
+
+    names := []string{"โรงพยาบาลหนึ่ง", "โรงพยาบาลสอง", "โรงพยาบาลสาม", "โรงพยาบาลสี่"}
+    codes := make([]int, len(names))
+    var wg sync.WaitGroup
+    for i, name := range names {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            codes[i] = putProvider(t, r, provider, name, `"1"`).Code
+        }()
+    }
+    wg.Wait()
+
+    counts := make(map[int]int)
+    for _, code := range codes {
+        counts[code]++
+    }
+    if counts[http.StatusOK] != 1 || counts[http.StatusPreconditionFailed] != len(names)-1 {
+        t.Errorf("status codes = %v, want one 200 and %d 412", codes, len(names)-1)
+    }
+}
+
+func TestUpdateProviderWithMaskedTaxID(t *testing.T) {
+    r, provider, providerRepo := newUpdateTestRouter(t)
+
+    w := httptest.NewRecorder()
+    r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/providers/%d", provider.ID), nil))
+    shown := decodeProvider(t, w)
+    if shown.ProviderTaxID == nil || *shown.ProviderTaxID == testTaxID {
+        t.Fatalf("GET tax ID = %v, want it masked", shown.ProviderTaxID)
+    }
+
+    // The client saves the provider it was shown, masked tax ID included
+    put := putProvider(t, r, &shown, "โรงพยาบาลหนึ่ง", w.Header().Get("ETag"))
+    if put.Code != http.StatusOK {
+        t.Fatalf("PUT with the masked tax ID = %d, want 200: %s", put.Code, put.Body)
+    }
+
+    body := fmt.Sprintf(`{"name_thai": "โรงพยาบาลสอง", "provider_tax_id": %q}`, *shown.ProviderTaxID)
+    httpReq := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/providers/%d", provider.ID), bytes.NewReader([]byte(body)))
+    httpReq.Header.Set("Content-Type", "application/merge-patch+json")
+    patch := httptest.NewRecorder()
+    r.ServeHTTP(patch, httpReq)
+    if patch.Code != http.StatusOK {
+        t.Fatalf("PATCH with the masked tax ID = %d, want 200: %s", patch.Code, patch.Body)
+    }
+
+    stored, err := providerRepo.GetByID(context.Background(), provider.ID, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored.ProviderTaxID == nil || *stored.ProviderTaxID != testTaxID || stored.NameThai != "โรงพยาบาลสอง" {
+        t.Errorf("stored provider = %q with tax ID %v, want the new name and the real tax ID", stored.NameThai, stored.ProviderTaxID)
+    }
+
+    // Anything else is still validated
+    invalid := dtos.NewUpdateProviderRequest(stored)
+    invalid.ProviderTaxID = utility.StringPtr("1234567890123")
+    data, err := json.Marshal(invalid)
+    if err != nil {
+        t.Fatal(err)
+    }
+    w = httptest.NewRecorder()
+    r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, fmt.Sprintf("/providers/%d", provider.ID), bytes.NewReader(data)))
+    if w.Code != http.StatusBadRequest {
+        t.Errorf("PUT with an invalid tax ID = %d, want 400: %s", w.Code, w.Body)
+    }
+}
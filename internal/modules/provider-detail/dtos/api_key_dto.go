@@ -0,0 +1,51 @@
+package dtos
+
+import "time"
+
+// APIKeyDTO is an API key of a machine client. The key itself is only returned once, when
+// it is created or rotated; the database keeps its hash.
+type APIKeyDTO struct {
+    ID         int             `json:"id" db:"id"`
+    Name       string          `json:"name" db:"name"`
+    KeyPrefix  string          `json:"key_prefix" db:"key_prefix"`
+    KeyHash    string          `json:"-" db:"key_hash"`
+    Scopes     JSONStringArray `json:"scopes" db:"scopes"`
+    UserRoleID *int            `json:"user_role_id" db:"user_role_id"`
+    ExpiresAt  *time.Time      `json:"expires_at" db:"expires_at"`
+    RevokedAt  *time.Time      `json:"revoked_at" db:"revoked_at"`
+    RevokedBy  *string         `json:"revoked_by" db:"revoked_by"`
+    LastUsedAt *time.Time      `json:"last_used_at" db:"last_used_at"`
+    UsageCount int64           `json:"usage_count" db:"usage_count"`
+    CreatedBy  string          `json:"created_by" db:"created_by"`
+    CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+    UpdatedAt  time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+type CreateAPIKeyRequestDTO struct {
+    Name   string   `json:"name" binding:"required,max=100"`
+    Scopes []string `json:"scopes" binding:"required,min=1,dive,oneof=search export"`
+    // UserRoleID applies the data scope of a user role to the key
+    UserRoleID *int       `json:"user_role_id"`
+    ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// APIKeyCreatedDTO is a new API key including the key, which cannot be retrieved again
+type APIKeyCreatedDTO struct {
+    APIKeyDTO
+    Key string `json:"key"`
+}
+
+// APIKeyUsageDTO is one request made with an API key
+type APIKeyUsageDTO struct {
+    ID       int64     `json:"id" db:"id"`
+    APIKeyID int       `json:"api_key_id" db:"api_key_id"`
+    Method   string    `json:"method" db:"method"`
+    Path     string    `json:"path" db:"path"`
+    Status   int       `json:"status" db:"status"`
+    ClientIP string    `json:"client_ip" db:"client_ip"`
+    UsedAt   time.Time `json:"used_at" db:"used_at"`
+}
+
+type APIKeyUsageRequestDTO struct {
+    Limit int `json:"limit" form:"limit"`
+}
@@ -0,0 +1,12 @@
+package dtos
+
+// DataScopeDTO limits the providers a user role can see in searches, summaries, stats,
+// exports and scheduled reports. Empty lists do not restrict; a role without a data scope
+// sees every provider.
+type DataScopeDTO struct {
+    UserRoleID     int             `json:"user_role_id" db:"user_role_id"`
+    Regions        JSONStringArray `json:"regions" db:"regions"`
+    Provinces      JSONStringArray `json:"provinces" db:"provinces"`
+    ProviderTypes  JSONStringArray `json:"provider_types" db:"provider_types"`
+    TPANetworkOnly bool            `json:"tpa_network_only" db:"tpa_network_only"`
+}
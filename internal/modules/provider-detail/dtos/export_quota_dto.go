@@ -0,0 +1,59 @@
+package dtos
+
+import "time"
+
+// ExportQuotaDTO is the daily export quota of a user role. Nil limits use the configured
+// defaults, 0 means unlimited.
+type ExportQuotaDTO struct {
+    UserRoleID     int    `json:"user_role_id" db:"user_role_id"`
+    DailyRowLimit  *int64 `json:"daily_row_limit" db:"daily_row_limit"`
+    DailyByteLimit *int64 `json:"daily_byte_limit" db:"daily_byte_limit"`
+}
+
+// ExportUsageDTO is what a user exported on one day
+type ExportUsageDTO struct {
+    Username  string    `json:"username" db:"username"`
+    UsageDate time.Time `json:"usage_date" db:"usage_date"`
+    RowCount  int64     `json:"row_count" db:"row_count"`
+    ByteCount int64     `json:"byte_count" db:"byte_count"`
+}
+
+// ExportQuotaStatusDTO is the quota of the current user for today. Limits of 0 are unlimited.
+type ExportQuotaStatusDTO struct {
+    RowLimit  int64     `json:"row_limit"`
+    RowsUsed  int64     `json:"rows_used"`
+    ByteLimit int64     `json:"byte_limit"`
+    BytesUsed int64     `json:"bytes_used"`
+    ResetAt   time.Time `json:"reset_at"`
+}
+
+// RowsRemaining returns the rows left today, -1 when rows are unlimited
+func (q *ExportQuotaStatusDTO) RowsRemaining() int64 {
+    return remaining(q.RowLimit, q.RowsUsed)
+}
+
+// BytesRemaining returns the bytes left today, -1 when the volume is unlimited
+func (q *ExportQuotaStatusDTO) BytesRemaining() int64 {
+    return remaining(q.ByteLimit, q.BytesUsed)
+}
+
+// Allows reports whether an export of rows and bytes fits in what is left today
+func (q *ExportQuotaStatusDTO) Allows(rows, bytes int64) bool {
+    if q.RowLimit > 0 && q.RowsUsed+rows > q.RowLimit {
+        return false
+    }
+    if q.ByteLimit > 0 && q.BytesUsed+bytes > q.ByteLimit {
+        return false
+    }
+    return true
+}
+
+func remaining(limit, used int64) int64 {
+    if limit <= 0 {
+        return -1
+    }
+    if used >= limit {
+        return 0
+    }
+    return limit - used
+}
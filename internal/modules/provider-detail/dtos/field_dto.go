@@ -1,5 +1,12 @@
 package dtos
 
+// Sensitivity of an available field. Sensitive fields are masked for users without the
+// VIEW_SENSITIVE action.
+const (
+    FieldSensitivityPublic    = "public"
+    FieldSensitivitySensitive = "sensitive"
+)
+
 type AvailableFieldDTO struct {
     ID              int     `json:"id" db:"id"`
     FieldCode       string  `json:"field_code" db:"field_code"`
@@ -13,6 +20,9 @@ type AvailableFieldDTO struct {
     IsActive        bool    `json:"is_active" db:"is_active"`
     SortOrder       *int    `json:"sort_order" db:"sort_order"`
     Description     *string `json:"description" db:"description"`
+    Sensitivity     string  `json:"sensitivity" db:"sensitivity"`
+    // MaskType selects how a sensitive value is masked: account, last4, email, name or full
+    MaskType        *string `json:"mask_type" db:"mask_type"`
 }
 
 type FieldListResponseDTO struct {
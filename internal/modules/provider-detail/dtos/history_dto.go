@@ -0,0 +1,54 @@
+package dtos
+
+import "time"
+
+// Entity types recorded in the change history
+const (
+    HistoryEntityProvider = "provider"
+    HistoryEntityTemplate = "template"
+    HistoryEntitySchedule = "schedule"
+    HistoryEntityField    = "field"
+)
+
+// Actions recorded in the change history
+const (
+    HistoryActionCreate  = "create"
+    HistoryActionUpdate  = "update"
+    HistoryActionDelete  = "delete"
+    HistoryActionRestore = "restore"
+)
+
+// ChangeHistoryDTO is one changed field of an entity. All rows written by the same
+// create/update/delete share a ChangeID; delete and restore have no field.
+type ChangeHistoryDTO struct {
+    ID         int       `json:"id" db:"id"`
+    ChangeID   string    `json:"change_id" db:"change_id"`
+    EntityType string    `json:"entity_type" db:"entity_type"`
+    EntityID   int       `json:"entity_id" db:"entity_id"`
+    Action     string    `json:"action" db:"action"`
+    FieldName  *string   `json:"field_name" db:"field_name"`
+    OldValue   *string   `json:"old_value" db:"old_value"`
+    NewValue   *string   `json:"new_value" db:"new_value"`
+    ChangedBy  string    `json:"changed_by" db:"changed_by"`
+    ChangedAt  time.Time `json:"changed_at" db:"changed_at"`
+}
+
+type HistorySearchRequestDTO struct {
+    EntityType string     `json:"entity_type" form:"entity_type" binding:"omitempty,oneof=provider template schedule field"`
+    EntityID   *int       `json:"entity_id" form:"entity_id"`
+    Action     string     `json:"action" form:"action" binding:"omitempty,oneof=create update delete restore"`
+    FieldName  string     `json:"field_name" form:"field_name"`
+    ChangedBy  string     `json:"changed_by" form:"changed_by"`
+    DateFrom   *time.Time `json:"date_from" form:"date_from"`
+    DateTo     *time.Time `json:"date_to" form:"date_to"`
+    Page       int        `json:"page" form:"page"`
+    Limit      int        `json:"limit" form:"limit"`
+}
+
+type HistoryListResponseDTO struct {
+    History    []ChangeHistoryDTO `json:"history"`
+    Total      int64              `json:"total"`
+    Page       int                `json:"page"`
+    Limit      int                `json:"limit"`
+    TotalPages int                `json:"total_pages"`
+}
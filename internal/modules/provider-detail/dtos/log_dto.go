@@ -17,6 +17,11 @@ type SentReportLogDTO struct {
     ErrorMessage    *string    `json:"error_message" db:"error_message"`
     RetryCount      int        `json:"retry_count" db:"retry_count"`
     ExecutionTimeMs *int       `json:"execution_time_ms" db:"execution_time_ms"`
+    RequestID       *string    `json:"request_id" db:"request_id"`
+    // NextRetryAt is when a pending report is retried
+    NextRetryAt     *time.Time `json:"next_retry_at" db:"next_retry_at"`
+    // ResentFromID is the log a manual resend was made from
+    ResentFromID    *int       `json:"resent_from_id" db:"resent_from_id"`
     
     // Joined fields
     TemplateName    string     `json:"template_name" db:"template_name"`
@@ -15,12 +15,13 @@ func (j *JSONStringArray) Scan(value interface{}) error {
         return nil
     }
     
-    bytes, ok := value.([]byte)
-    if !ok {
-        return errors.New("type assertion to []byte failed")
+    switch v := value.(type) {
+    case []byte:
+        return json.Unmarshal(v, j)
+    case string:
+        return json.Unmarshal([]byte(v), j)
     }
-    
-    return json.Unmarshal(bytes, j)
+    return errors.New("type assertion to []byte or string failed")
 }
 
 func (j JSONStringArray) Value() (driver.Value, error) {
@@ -81,6 +82,9 @@ type ProviderDTO struct {
     UpdatedAt           time.Time         `json:"updated_at" db:"updated_at"`
     CreatedBy           *string           `json:"created_by" db:"created_by"`
     UpdatedBy           *string           `json:"updated_by" db:"updated_by"`
+    DeletedAt           *time.Time        `json:"deleted_at,omitempty" db:"deleted_at"`
+    DeletedBy           *string           `json:"deleted_by,omitempty" db:"deleted_by"`
+    Version             int               `json:"version" db:"version"`
 }
 
 type ProviderSearchRequestDTO struct {
@@ -93,12 +97,20 @@ type ProviderSearchRequestDTO struct {
     BusinessType string     `json:"business_type" form:"business_type"`
     Page         int        `json:"page" form:"page"`
     Limit        int        `json:"limit" form:"limit"`
+
+    // Scope is the data scope of the caller's role, set by the service
+    Scope *DataScopeDTO `json:"-" form:"-"`
+
+    // IncludeDeleted also returns soft-deleted providers (for auditors)
+    IncludeDeleted bool `json:"include_deleted" form:"include_deleted"`
+    // OnlyDeleted restricts the search to soft-deleted providers (trash listing)
+    OnlyDeleted bool `json:"-" form:"-"`
 }
 
 type ProviderReportRequestDTO struct {
     SearchParams ProviderSearchRequestDTO `json:"search_params"`
     TemplateID   *int                     `json:"template_id"`
-    FormatType   string                   `json:"format_type"` // excel, pdf, word
+    FormatType   string                   `json:"format_type"` // excel, csv, pdf, word
     CustomFields []string                 `json:"custom_fields,omitempty"`
 }
 
@@ -117,27 +129,178 @@ type ProviderReportDataDTO struct {
     Total     int64                  `json:"total"`
 }
 
+// ProviderDetailsDTO holds every maintainable section of a provider. It is shared by
+// create, update (PUT) and merge patch (PATCH) requests.
+type ProviderDetailsDTO struct {
+    // Basic information
+    TitleThai         *string  `json:"title_thai" binding:"omitempty,max=100"`
+    NameThai          string   `json:"name_thai" binding:"required,max=255"`
+    TitleEng          *string  `json:"title_eng" binding:"omitempty,max=100"`
+    NameEng           *string  `json:"name_eng" binding:"omitempty,max=255"`
+    ProviderType      string   `json:"provider_type" binding:"required,max=100"`
+    RegisterStatus    *string  `json:"register_status" binding:"omitempty,max=100"`
+    BusinessType      *string  `json:"business_type" binding:"omitempty,max=100"`
+    BedSize           *string  `json:"bed_size" binding:"omitempty,max=50"`
+    EligibilityMethod *string  `json:"eligibility_method" binding:"omitempty,max=100"`
+    OpeningTime       *string  `json:"opening_time" binding:"omitempty,max=100"`
+    ProviderStatus    string   `json:"provider_status" binding:"omitempty,oneof=Active Inactive"`
+    IsTPANetwork      bool     `json:"is_tpa_network"`
+    HasIncident       bool     `json:"has_incident"`
+
+    // Address
+    BuildingNo        *string  `json:"building_no" binding:"omitempty,max=100"`
+    VillageNo         *string  `json:"village_no" binding:"omitempty,max=100"`
+    LaneAlley         *string  `json:"lane_alley" binding:"omitempty,max=100"`
+    Road              *string  `json:"road" binding:"omitempty,max=100"`
+    SubDistrict       *string  `json:"sub_district" binding:"omitempty,max=100"`
+    District          *string  `json:"district" binding:"omitempty,max=100"`
+    Province          string   `json:"province" binding:"required,max=100"`
+    Region            *string  `json:"region" binding:"omitempty,max=100"`
+    Country           *string  `json:"country" binding:"omitempty,max=100"`
+    PostCode          *string  `json:"post_code" binding:"omitempty,thai_post_code"`
+
+    // Contact
+    TitleName         *string  `json:"title_name" binding:"omitempty,max=255"`
+    Department        *string  `json:"department" binding:"omitempty,max=100"`
+    GeneralPhoneNo    *string  `json:"general_phone_no" binding:"omitempty,max=50"`
+    DirectPhoneNo     *string  `json:"direct_phone_no" binding:"omitempty,max=50"`
+    Email             *string  `json:"email" binding:"omitempty,email,max=255"`
+    EmailToList       *string  `json:"email_to_list" binding:"omitempty,email_list"`
+    EmailCCList       *string  `json:"email_cc_list" binding:"omitempty,email_list"`
+
+    // Tax
+    ProviderTaxID     *string    `json:"provider_tax_id" binding:"omitempty,thai_tax_id"`
+    WHTaxPercent      *float64   `json:"wh_tax_percent" binding:"omitempty,gte=0,lte=100"`
+    ExemptPercent     *float64   `json:"exempt_percent" binding:"omitempty,gte=0,lte=100"`
+    WHTaxExemptFrom   *time.Time `json:"wh_tax_exempt_from"`
+    WHTaxExemptTo     *time.Time `json:"wh_tax_exempt_to"`
+
+    // Payment
+    PaymentMethod     *string  `json:"payment_method" binding:"omitempty,max=50"`
+    PaymentBranchID   *string  `json:"payment_branch_id" binding:"omitempty,max=20"`
+    PayeeName         *string  `json:"payee_name" binding:"omitempty,max=255"`
+    BankAccountNumber *string  `json:"bank_account_number" binding:"omitempty,max=50"`
+    BankAccountType   *string  `json:"bank_account_type" binding:"omitempty,max=50"`
+    BankBranchName    *string  `json:"bank_branch_name" binding:"omitempty,max=100"`
+    BankName          *string  `json:"bank_name" binding:"omitempty,max=100"`
+
+    // Discount and pricing categories
+    DiscountCategories []string `json:"discount_categories" binding:"omitempty,max=20,dive,required,max=100"`
+    PricingCategories  []string `json:"pricing_categories" binding:"omitempty,max=20,dive,required,max=100"`
+}
+
 type CreateProviderRequestDTO struct {
-    ProviderCode      string  `json:"provider_code" binding:"required"`
-    NameThai          string  `json:"name_thai" binding:"required"`
-    NameEng           *string `json:"name_eng"`
-    ProviderType      string  `json:"provider_type" binding:"required"`
-    BusinessType      *string `json:"business_type"`
-    Province          string  `json:"province" binding:"required"`
-    GeneralPhoneNo    *string `json:"general_phone_no"`
-    IsTPANetwork      bool    `json:"is_tpa_network"`
-    ProviderStatus    string  `json:"provider_status"`
+    ProviderCode string `json:"provider_code" binding:"required,max=50"`
+    ProviderDetailsDTO
 }
 
 type UpdateProviderRequestDTO struct {
-    NameThai          string  `json:"name_thai" binding:"required"`
-    NameEng           *string `json:"name_eng"`
-    ProviderType      string  `json:"provider_type" binding:"required"`
-    BusinessType      *string `json:"business_type"`
-    Province          string  `json:"province" binding:"required"`
-    GeneralPhoneNo    *string `json:"general_phone_no"`
-    IsTPANetwork      bool    `json:"is_tpa_network"`
-    ProviderStatus    string  `json:"provider_status"`
+    ProviderDetailsDTO
+}
+
+// NewUpdateProviderRequest returns the maintainable sections of a provider, used as the
+// target document of a merge patch
+func NewUpdateProviderRequest(p *ProviderDTO) UpdateProviderRequestDTO {
+    return UpdateProviderRequestDTO{
+        ProviderDetailsDTO: ProviderDetailsDTO{
+            TitleThai:          p.TitleThai,
+            NameThai:           p.NameThai,
+            TitleEng:           p.TitleEng,
+            NameEng:            p.NameEng,
+            ProviderType:       p.ProviderType,
+            RegisterStatus:     p.RegisterStatus,
+            BusinessType:       p.BusinessType,
+            BedSize:            p.BedSize,
+            EligibilityMethod:  p.EligibilityMethod,
+            OpeningTime:        p.OpeningTime,
+            ProviderStatus:     p.ProviderStatus,
+            IsTPANetwork:       p.IsTPANetwork,
+            HasIncident:        p.HasIncident,
+            BuildingNo:         p.BuildingNo,
+            VillageNo:          p.VillageNo,
+            LaneAlley:          p.LaneAlley,
+            Road:               p.Road,
+            SubDistrict:        p.SubDistrict,
+            District:           p.District,
+            Province:           p.Province,
+            Region:             p.Region,
+            Country:            p.Country,
+            PostCode:           p.PostCode,
+            TitleName:          p.TitleName,
+            Department:         p.Department,
+            GeneralPhoneNo:     p.GeneralPhoneNo,
+            DirectPhoneNo:      p.DirectPhoneNo,
+            Email:              p.Email,
+            EmailToList:        p.EmailToList,
+            EmailCCList:        p.EmailCCList,
+            ProviderTaxID:      p.ProviderTaxID,
+            WHTaxPercent:       p.WHTaxPercent,
+            ExemptPercent:      p.ExemptPercent,
+            WHTaxExemptFrom:    p.WHTaxExemptFrom,
+            WHTaxExemptTo:      p.WHTaxExemptTo,
+            PaymentMethod:      p.PaymentMethod,
+            PaymentBranchID:    p.PaymentBranchID,
+            PayeeName:          p.PayeeName,
+            BankAccountNumber:  p.BankAccountNumber,
+            BankAccountType:    p.BankAccountType,
+            BankBranchName:     p.BankBranchName,
+            BankName:           p.BankName,
+            DiscountCategories: p.DiscountCategories,
+            PricingCategories:  p.PricingCategories,
+        },
+    }
+}
+
+// ApplyTo copies every maintainable section onto the provider
+func (d ProviderDetailsDTO) ApplyTo(p *ProviderDTO) {
+    p.TitleThai = d.TitleThai
+    p.NameThai = d.NameThai
+    p.TitleEng = d.TitleEng
+    p.NameEng = d.NameEng
+    p.ProviderType = d.ProviderType
+    p.RegisterStatus = d.RegisterStatus
+    p.BusinessType = d.BusinessType
+    p.BedSize = d.BedSize
+    p.EligibilityMethod = d.EligibilityMethod
+    p.OpeningTime = d.OpeningTime
+    p.ProviderStatus = d.ProviderStatus
+    p.IsTPANetwork = d.IsTPANetwork
+    p.HasIncident = d.HasIncident
+    p.BuildingNo = d.BuildingNo
+    p.VillageNo = d.VillageNo
+    p.LaneAlley = d.LaneAlley
+    p.Road = d.Road
+    p.SubDistrict = d.SubDistrict
+    p.District = d.District
+    p.Province = d.Province
+    p.Region = d.Region
+    p.Country = d.Country
+    p.PostCode = d.PostCode
+    p.TitleName = d.TitleName
+    p.Department = d.Department
+    p.GeneralPhoneNo = d.GeneralPhoneNo
+    p.DirectPhoneNo = d.DirectPhoneNo
+    p.Email = d.Email
+    p.EmailToList = d.EmailToList
+    p.EmailCCList = d.EmailCCList
+    p.ProviderTaxID = NormalizeTaxID(d.ProviderTaxID)
+    p.WHTaxPercent = d.WHTaxPercent
+    p.ExemptPercent = d.ExemptPercent
+    p.WHTaxExemptFrom = d.WHTaxExemptFrom
+    p.WHTaxExemptTo = d.WHTaxExemptTo
+    p.PaymentMethod = d.PaymentMethod
+    p.PaymentBranchID = d.PaymentBranchID
+    p.PayeeName = d.PayeeName
+    p.BankAccountNumber = d.BankAccountNumber
+    p.BankAccountType = d.BankAccountType
+    p.BankBranchName = d.BankBranchName
+    p.BankName = d.BankName
+    p.DiscountCategories = JSONStringArray(d.DiscountCategories)
+    p.PricingCategories = JSONStringArray(d.PricingCategories)
+
+    if p.ProviderStatus == "" {
+        p.ProviderStatus = "Active"
+    }
 }
 
 type ProviderStatsDTO struct {
@@ -0,0 +1,160 @@
+package dtos
+
+import (
+    "errors"
+    "fmt"
+    "reflect"
+    "strings"
+
+    "github.com/go-playground/validator/v10"
+)
+
+type FieldErrorDTO struct {
+    Field   string `json:"field"`
+    Message string `json:"message"`
+}
+
+// RegisterProviderValidations registers the custom binding tags used by provider requests
+// and reports validation errors with their JSON field names
+func RegisterProviderValidations(v *validator.Validate) error {
+    v.RegisterTagNameFunc(func(field reflect.StructField) string {
+        name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+        if name == "-" || name == "" {
+            return field.Name
+        }
+        return name
+    })
+
+    if err := v.RegisterValidation("thai_tax_id", func(fl validator.FieldLevel) bool {
+        return IsValidThaiTaxID(fl.Field().String())
+    }); err != nil {
+        return err
+    }
+
+    if err := v.RegisterValidation("thai_post_code", func(fl validator.FieldLevel) bool {
+        return isDigits(fl.Field().String(), 5)
+    }); err != nil {
+        return err
+    }
+
+    if err := v.RegisterValidation("email_list", func(fl validator.FieldLevel) bool {
+        for _, email := range SplitEmailList(fl.Field().String()) {
+            if v.Var(email, "email") != nil {
+                return false
+            }
+        }
+        return true
+    }); err != nil {
+        return err
+    }
+
+    v.RegisterStructValidation(validateProviderDetails, ProviderDetailsDTO{})
+    return nil
+}
+
+// validateProviderDetails checks rules spanning several fields
+func validateProviderDetails(sl validator.StructLevel) {
+    details := sl.Current().Interface().(ProviderDetailsDTO)
+
+    if details.WHTaxExemptTo != nil {
+        if details.WHTaxExemptFrom == nil {
+            sl.ReportError(details.WHTaxExemptFrom, "wh_tax_exempt_from", "WHTaxExemptFrom", "required_with", "wh_tax_exempt_to")
+        } else if details.WHTaxExemptTo.Before(*details.WHTaxExemptFrom) {
+            sl.ReportError(details.WHTaxExemptTo, "wh_tax_exempt_to", "WHTaxExemptTo", "gtefield", "wh_tax_exempt_from")
+        }
+    }
+}
+
+// IsValidThaiTaxID checks a 13-digit Thai tax ID (dashes and spaces allowed) against its check digit
+func IsValidThaiTaxID(value string) bool {
+    digits := strings.NewReplacer("-", "", " ", "").Replace(value)
+    if !isDigits(digits, 13) {
+        return false
+    }
+
+    sum := 0
+    for i := 0; i < 12; i++ {
+        sum += int(digits[i]-'0') * (13 - i)
+    }
+    check := (11 - sum%11) % 10
+    return check == int(digits[12]-'0')
+}
+
+// NormalizeTaxID strips formatting so tax IDs are stored as 13 digits
+func NormalizeTaxID(value *string) *string {
+    if value == nil {
+        return nil
+    }
+    digits := strings.NewReplacer("-", "", " ", "").Replace(*value)
+    return &digits
+}
+
+// SplitEmailList splits a comma or semicolon separated list of email addresses
+func SplitEmailList(value string) []string {
+    var emails []string
+    for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+        if email := strings.TrimSpace(part); email != "" {
+            emails = append(emails, email)
+        }
+    }
+    return emails
+}
+
+func isDigits(value string, length int) bool {
+    if len(value) != length {
+        return false
+    }
+    for _, r := range value {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// NewFieldErrors converts binding errors into field level messages. It returns nil when
+// err is not a validation error.
+func NewFieldErrors(err error) []FieldErrorDTO {
+    var validationErrors validator.ValidationErrors
+    if !errors.As(err, &validationErrors) {
+        return nil
+    }
+
+    fieldErrors := make([]FieldErrorDTO, 0, len(validationErrors))
+    for _, fe := range validationErrors {
+        fieldErrors = append(fieldErrors, FieldErrorDTO{
+            Field:   fe.Field(),
+            Message: fieldErrorMessage(fe),
+        })
+    }
+    return fieldErrors
+}
+
+func fieldErrorMessage(fe validator.FieldError) string {
+    switch fe.Tag() {
+    case "required":
+        return "is required"
+    case "required_with":
+        return fmt.Sprintf("is required when %s is set", fe.Param())
+    case "max":
+        return fmt.Sprintf("must be at most %s characters or items", fe.Param())
+    case "gte":
+        return fmt.Sprintf("must be greater than or equal to %s", fe.Param())
+    case "lte":
+        return fmt.Sprintf("must be less than or equal to %s", fe.Param())
+    case "gtefield":
+        return fmt.Sprintf("must not be before %s", fe.Param())
+    case "oneof":
+        return fmt.Sprintf("must be one of: %s", fe.Param())
+    case "email":
+        return "must be a valid email address"
+    case "email_list":
+        return "must be a comma or semicolon separated list of valid email addresses"
+    case "thai_tax_id":
+        return "must be a valid 13-digit Thai tax ID"
+    case "thai_post_code":
+        return "must be a 5-digit post code"
+    default:
+        return fmt.Sprintf("failed on the '%s' rule", fe.Tag())
+    }
+}
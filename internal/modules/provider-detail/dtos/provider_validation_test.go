@@ -0,0 +1,136 @@
+package dtos
+
+import (
+    "testing"
+    "time"
+
+    "github.com/go-playground/validator/v10"
+)
+
+// newValidator returns a validator reading the binding tags, like gin's, with the provider
+// validations registered
+func newValidator(t *testing.T) *validator.Validate {
+    t.Helper()
+    v := validator.New()
+    v.SetTagName("binding")
+    if err := RegisterProviderValidations(v); err != nil {
+        t.Fatal(err)
+    }
+    return v
+}
+
+// validDetails returns provider details that pass validation
+func validDetails() ProviderDetailsDTO {
+    return ProviderDetailsDTO{NameThai: "โรงพยาบาลกรุงเทพ", ProviderType: "Hospital", Province: "Bangkok"}
+}
+
+func stringPtr(s string) *string {
+    return &s
+}
+
+func TestIsValidThaiTaxID(t *testing.T) {
+    tests := []struct {
+        name  string
+        value string
+        want  bool
+    }{
+        {"valid", "1234567890121", true},
+        {"check digit 0 when 11 - sum%11 is 10", "3101234567010", true},
+        {"check digit 1 when 11 - sum%11 is 11", "3101234567061", true},
+        {"dashes", "1-2345-67890-12-1", true},
+        {"spaces", "1 2345 67890 12 1", true},
+        {"wrong check digit", "1234567890122", false},
+        {"check digit 1 when 11 - sum%11 is 10", "3101234567011", false},
+        {"too short", "123456789012", false},
+        {"too long", "12345678901210", false},
+        {"letters", "12345678901A1", false},
+        {"empty", "", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := IsValidThaiTaxID(tt.value); got != tt.want {
+                t.Errorf("IsValidThaiTaxID(%q) = %v, want %v", tt.value, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestProviderValidations(t *testing.T) {
+    v := newValidator(t)
+    tests := []struct {
+        name    string
+        edit    func(*ProviderDetailsDTO)
+        field   string
+        wantTag string
+    }{
+        {"valid", func(d *ProviderDetailsDTO) {}, "", ""},
+        {"tax id", func(d *ProviderDetailsDTO) { d.ProviderTaxID = stringPtr("1-2345-67890-12-1") }, "", ""},
+        {"invalid tax id", func(d *ProviderDetailsDTO) { d.ProviderTaxID = stringPtr("1234567890122") }, "provider_tax_id", "thai_tax_id"},
+        {"post code", func(d *ProviderDetailsDTO) { d.PostCode = stringPtr("10110") }, "", ""},
+        {"short post code", func(d *ProviderDetailsDTO) { d.PostCode = stringPtr("1011") }, "post_code", "thai_post_code"},
+        {"long post code", func(d *ProviderDetailsDTO) { d.PostCode = stringPtr("101100") }, "post_code", "thai_post_code"},
+        {"post code with letters", func(d *ProviderDetailsDTO) { d.PostCode = stringPtr("10I10") }, "post_code", "thai_post_code"},
+        {"single email", func(d *ProviderDetailsDTO) { d.EmailToList = stringPtr("ops@example.com") }, "", ""},
+        {"email list", func(d *ProviderDetailsDTO) { d.EmailToList = stringPtr("ops@example.com, finance@example.com;claims@example.com") }, "", ""},
+        {"email list with empty entries", func(d *ProviderDetailsDTO) { d.EmailCCList = stringPtr("ops@example.com,; ,") }, "", ""},
+        {"invalid email in list", func(d *ProviderDetailsDTO) { d.EmailToList = stringPtr("ops@example.com, finance") }, "email_to_list", "email_list"},
+        {"space separated emails", func(d *ProviderDetailsDTO) { d.EmailCCList = stringPtr("ops@example.com finance@example.com") }, "email_cc_list", "email_list"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            details := validDetails()
+            tt.edit(&details)
+            assertValidation(t, v.Struct(details), tt.field, tt.wantTag)
+        })
+    }
+}
+
+func TestExemptionDates(t *testing.T) {
+    v := newValidator(t)
+    from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+    before := from.AddDate(0, 0, -1)
+    after := from.AddDate(1, 0, 0)
+
+    tests := []struct {
+        name    string
+        from    *time.Time
+        to      *time.Time
+        field   string
+        wantTag string
+    }{
+        {"no exemption", nil, nil, "", ""},
+        {"open ended", &from, nil, "", ""},
+        {"from before to", &from, &after, "", ""},
+        {"single day", &from, &from, "", ""},
+        {"to before from", &from, &before, "wh_tax_exempt_to", "gtefield"},
+        {"to without from", nil, &after, "wh_tax_exempt_from", "required_with"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            details := validDetails()
+            details.WHTaxExemptFrom = tt.from
+            details.WHTaxExemptTo = tt.to
+            assertValidation(t, v.Struct(details), tt.field, tt.wantTag)
+        })
+    }
+}
+
+// assertValidation checks that err is nil when field is empty, or a single failure of field
+// on tag otherwise
+func assertValidation(t *testing.T, err error, field, tag string) {
+    t.Helper()
+    if field == "" {
+        if err != nil {
+            t.Errorf("validation failed: %v", err)
+        }
+        return
+    }
+
+    validationErrors, ok := err.(validator.ValidationErrors)
+    if !ok || len(validationErrors) != 1 {
+        t.Fatalf("validation error = %v, want a %s failure of %s", err, tag, field)
+    }
+    if fe := validationErrors[0]; fe.Field() != field || fe.Tag() != tag {
+        t.Errorf("%s failed on %s, want %s on %s", fe.Field(), fe.Tag(), field, tag)
+    }
+}
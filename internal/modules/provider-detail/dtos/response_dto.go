@@ -16,9 +16,10 @@ type PaginatedResponse struct {
 }
 
 type ErrorResponse struct {
-    Code    int    `json:"code"`
-    Message string `json:"message"`
-    Details string `json:"details,omitempty"`
+    Code    int             `json:"code"`
+    Message string          `json:"message"`
+    Details string          `json:"details,omitempty"`
+    Errors  []FieldErrorDTO `json:"errors,omitempty"`
 }
 
 type SuccessResponse struct {
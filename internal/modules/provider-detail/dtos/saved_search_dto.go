@@ -0,0 +1,66 @@
+package dtos
+
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "errors"
+    "time"
+)
+
+// SavedSearchSubModule is the sub-module key used for provider saved searches in Redis
+const SavedSearchSubModule = "provider-detail"
+
+// ProviderSearchCriteria stores a ProviderSearchRequestDTO as a JSON column
+type ProviderSearchCriteria ProviderSearchRequestDTO
+
+func (c *ProviderSearchCriteria) Scan(value interface{}) error {
+    if value == nil {
+        *c = ProviderSearchCriteria{}
+        return nil
+    }
+
+    switch v := value.(type) {
+    case []byte:
+        return json.Unmarshal(v, c)
+    case string:
+        return json.Unmarshal([]byte(v), c)
+    }
+    return errors.New("type assertion to []byte or string failed")
+}
+
+func (c ProviderSearchCriteria) Value() (driver.Value, error) {
+    return json.Marshal(c)
+}
+
+type SavedSearchDTO struct {
+    ID             int                    `json:"id" db:"id"`
+    Username       string                 `json:"username" db:"username"`
+    SearchName     string                 `json:"search_name" db:"search_name"`
+    SearchCriteria ProviderSearchCriteria `json:"search_criteria" db:"search_criteria"`
+    TemplateID     *int                   `json:"template_id" db:"template_id"`
+    FormatType     *string                `json:"format_type" db:"format_type"`
+    IsDefault      bool                   `json:"is_default" db:"is_default"`
+    CreatedAt      time.Time              `json:"created_at" db:"created_at"`
+    UpdatedAt      time.Time              `json:"updated_at" db:"updated_at"`
+}
+
+type CreateSavedSearchRequestDTO struct {
+    SearchName     string                   `json:"search_name" binding:"required,max=100"`
+    SearchCriteria ProviderSearchRequestDTO `json:"search_criteria"`
+    TemplateID     *int                     `json:"template_id"`
+    FormatType     *string                  `json:"format_type" binding:"omitempty,oneof=excel csv pdf word"`
+    IsDefault      bool                     `json:"is_default"`
+}
+
+type UpdateSavedSearchRequestDTO struct {
+    SearchName     string                   `json:"search_name" binding:"required,max=100"`
+    SearchCriteria ProviderSearchRequestDTO `json:"search_criteria"`
+    TemplateID     *int                     `json:"template_id"`
+    FormatType     *string                  `json:"format_type" binding:"omitempty,oneof=excel csv pdf word"`
+    IsDefault      bool                     `json:"is_default"`
+}
+
+type SavedSearchListResponseDTO struct {
+    SavedSearches []SavedSearchDTO `json:"saved_searches"`
+    Total         int              `json:"total"`
+}
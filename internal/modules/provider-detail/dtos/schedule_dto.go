@@ -15,12 +15,13 @@ func (j *JSONMap) Scan(value interface{}) error {
         return nil
     }
     
-    bytes, ok := value.([]byte)
-    if !ok {
-        return errors.New("type assertion to []byte failed")
+    switch v := value.(type) {
+    case []byte:
+        return json.Unmarshal(v, j)
+    case string:
+        return json.Unmarshal([]byte(v), j)
     }
-    
-    return json.Unmarshal(bytes, j)
+    return errors.New("type assertion to []byte or string failed")
 }
 
 func (j JSONMap) Value() (driver.Value, error) {
@@ -53,6 +54,8 @@ type ScheduleDTO struct {
     CreatedBy      string          `json:"created_by" db:"created_by"`
     UpdatedBy      *string         `json:"updated_by" db:"updated_by"`
     IsDeleted      bool            `json:"is_deleted" db:"is_deleted"`
+    Version        int             `json:"version" db:"version"`
+    OwnerRoleID    *int            `json:"owner_role_id" db:"owner_role_id"`
     
     // Joined fields
     TemplateName   string          `json:"template_name" db:"template_name"`
@@ -71,7 +74,7 @@ type CreateScheduleRequestDTO struct {
     StartTime      string                 `json:"start_time" binding:"required"`
     Timezone       string                 `json:"timezone"`
     SearchCriteria map[string]interface{} `json:"search_criteria"`
-    ExportFormat   string                 `json:"export_format" binding:"oneof=excel pdf word"`
+    ExportFormat   string                 `json:"export_format" binding:"oneof=excel csv pdf word"`
 }
 
 type UpdateScheduleRequestDTO struct {
@@ -88,7 +91,7 @@ type UpdateScheduleRequestDTO struct {
     Timezone       string                 `json:"timezone"`
     IsActive       bool                   `json:"is_active"`
     SearchCriteria map[string]interface{} `json:"search_criteria"`
-    ExportFormat   string                 `json:"export_format" binding:"oneof=excel pdf word"`
+    ExportFormat   string                 `json:"export_format" binding:"oneof=excel csv pdf word"`
 }
 
 type ScheduleListResponseDTO struct {
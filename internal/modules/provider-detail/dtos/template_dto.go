@@ -15,12 +15,13 @@ func (j *JSONFieldArray) Scan(value interface{}) error {
         return nil
     }
     
-    bytes, ok := value.([]byte)
-    if !ok {
-        return errors.New("type assertion to []byte failed")
+    switch v := value.(type) {
+    case []byte:
+        return json.Unmarshal(v, j)
+    case string:
+        return json.Unmarshal([]byte(v), j)
     }
-    
-    return json.Unmarshal(bytes, j)
+    return errors.New("type assertion to []byte or string failed")
 }
 
 func (j JSONFieldArray) Value() (driver.Value, error) {
@@ -44,6 +45,7 @@ type TemplateDTO struct {
     CreatedBy      string          `json:"created_by" db:"created_by"`
     UpdatedBy      *string         `json:"updated_by" db:"updated_by"`
     IsDeleted      bool            `json:"is_deleted" db:"is_deleted"`
+    Version        int             `json:"version" db:"version"`
 }
 
 type CreateTemplateRequestDTO struct {
@@ -0,0 +1,134 @@
+package repositories
+
+import (
+    "context"
+    "time"
+
+    "provider-report-api/internal/modules/provider-detail/dtos"
+)
+
+// The stores below are what the services need from the repositories. The SQL repositories
+// of this package implement them for the database, package memory implements them in memory
+// for tests.
+
+// ProviderStore reads and writes providers
+type ProviderStore interface {
+    Search(ctx context.Context, req dtos.ProviderSearchRequestDTO) ([]dtos.ProviderDTO, int64, error)
+    GetSummary(ctx context.Context, req dtos.ProviderSearchRequestDTO) (*dtos.ProviderSummaryDTO, error)
+    // The single provider methods treat a provider outside scope like a missing one. A nil
+    // scope does not restrict.
+    GetByID(ctx context.Context, id int, scope *dtos.DataScopeDTO) (*dtos.ProviderDTO, error)
+    GetByIDIncludingDeleted(ctx context.Context, id int, scope *dtos.DataScopeDTO) (*dtos.ProviderDTO, error)
+    Create(ctx context.Context, provider *dtos.ProviderDTO) error
+    Update(ctx context.Context, provider *dtos.ProviderDTO, scope *dtos.DataScopeDTO) error
+    Delete(ctx context.Context, id int, deletedBy *string, scope *dtos.DataScopeDTO) error
+    Restore(ctx context.Context, id int, restoredBy *string, scope *dtos.DataScopeDTO) error
+    GetProvinces(ctx context.Context, scope *dtos.DataScopeDTO) ([]string, error)
+    GetProviderTypes(ctx context.Context, scope *dtos.DataScopeDTO) ([]string, error)
+    GetProviderStats(ctx context.Context, scope *dtos.DataScopeDTO) (map[string]interface{}, error)
+}
+
+// TemplateStore reads and writes report templates
+type TemplateStore interface {
+    GetAll(ctx context.Context) ([]dtos.TemplateDTO, error)
+    GetByID(ctx context.Context, id int) (*dtos.TemplateDTO, error)
+    Create(ctx context.Context, template *dtos.TemplateDTO) error
+    Update(ctx context.Context, template *dtos.TemplateDTO) error
+    Delete(ctx context.Context, id int, deletedBy *string) error
+}
+
+// ScheduleStore reads and writes report schedules
+type ScheduleStore interface {
+    GetAll(ctx context.Context) ([]dtos.ScheduleDTO, error)
+    GetByID(ctx context.Context, id int) (*dtos.ScheduleDTO, error)
+    Create(ctx context.Context, schedule *dtos.ScheduleDTO) error
+    Update(ctx context.Context, schedule *dtos.ScheduleDTO) error
+    Delete(ctx context.Context, id int, deletedBy *string) error
+    GetActiveSchedules(ctx context.Context) ([]dtos.ScheduleDTO, error)
+    UpdateLastRun(ctx context.Context, id int) error
+}
+
+// LogStore reads and writes the logs of sent reports
+type LogStore interface {
+    GetSentReportLogs(ctx context.Context, req dtos.LogSearchRequestDTO) ([]dtos.SentReportLogDTO, int64, error)
+    GetByID(ctx context.Context, id int) (*dtos.SentReportLogDTO, error)
+    Create(ctx context.Context, log *dtos.SentReportLogDTO) error
+    UpdateStatus(ctx context.Context, id int, status string, errorMessage *string) error
+    // GetDueRetries returns up to limit pending logs whose retry is due at now, oldest first
+    GetDueRetries(ctx context.Context, now time.Time, limit int) ([]dtos.SentReportLogDTO, error)
+    // ClaimAttempt takes the log for one more attempt if its retry count is still retryCount,
+    // so two instances never send the same report twice. The retry count is incremented and
+    // the next retry moved to leaseUntil, when the report is retried if the attempt never ends.
+    ClaimAttempt(ctx context.Context, id, retryCount int, leaseUntil time.Time) (bool, error)
+    // UpdateAttempt stores the outcome of an attempt: status, error, next retry and file details
+    UpdateAttempt(ctx context.Context, log *dtos.SentReportLogDTO) error
+}
+
+// FieldStore reads and writes the fields available to templates
+type FieldStore interface {
+    GetAllFields(ctx context.Context) ([]dtos.AvailableFieldDTO, error)
+    GetFieldsByCategory(ctx context.Context, category string) ([]dtos.AvailableFieldDTO, error)
+    GetSensitiveFields(ctx context.Context) ([]dtos.AvailableFieldDTO, error)
+    GetFieldByCode(ctx context.Context, fieldCode string) (*dtos.AvailableFieldDTO, error)
+    ValidateFields(ctx context.Context, fieldCodes []string) ([]dtos.FieldValidationDTO, error)
+    GetFieldCategories(ctx context.Context) ([]string, error)
+    CreateField(ctx context.Context, field *dtos.AvailableFieldDTO) error
+    UpdateField(ctx context.Context, field *dtos.AvailableFieldDTO) error
+    DeleteField(ctx context.Context, id int) error
+    ExistsFieldCode(ctx context.Context, code string) (bool, error)
+    GetRequiredFields(ctx context.Context) ([]dtos.AvailableFieldDTO, error)
+    GetFieldsByType(ctx context.Context, fieldType string) ([]dtos.AvailableFieldDTO, error)
+    GetFieldsForExport(ctx context.Context, fieldCodes []string) ([]dtos.AvailableFieldDTO, error)
+}
+
+// SavedSearchStore reads and writes the saved searches of users
+type SavedSearchStore interface {
+    GetByUsername(ctx context.Context, username string) ([]dtos.SavedSearchDTO, error)
+    GetByID(ctx context.Context, id int, username string) (*dtos.SavedSearchDTO, error)
+    Create(ctx context.Context, search *dtos.SavedSearchDTO) error
+    Update(ctx context.Context, search *dtos.SavedSearchDTO) error
+    Delete(ctx context.Context, id int, username string) error
+    ClearDefault(ctx context.Context, username string, exceptID int) error
+}
+
+// HistoryStore reads and writes the change history
+type HistoryStore interface {
+    Create(ctx context.Context, entries []dtos.ChangeHistoryDTO) error
+    Search(ctx context.Context, req dtos.HistorySearchRequestDTO) ([]dtos.ChangeHistoryDTO, int64, error)
+}
+
+// DataScopeStore reads the data scopes of user roles
+type DataScopeStore interface {
+    GetByRoleID(ctx context.Context, userRoleID int) (*dtos.DataScopeDTO, error)
+}
+
+// APIKeyStore reads and writes API keys and their usage
+type APIKeyStore interface {
+    GetAll(ctx context.Context) ([]dtos.APIKeyDTO, error)
+    GetByID(ctx context.Context, id int) (*dtos.APIKeyDTO, error)
+    GetByPrefix(ctx context.Context, prefix string) (*dtos.APIKeyDTO, error)
+    Create(ctx context.Context, key *dtos.APIKeyDTO) error
+    Revoke(ctx context.Context, id int, revokedBy string) error
+    RecordUsage(ctx context.Context, usage *dtos.APIKeyUsageDTO) error
+    GetUsage(ctx context.Context, id int, limit int) ([]dtos.APIKeyUsageDTO, error)
+}
+
+// ExportQuotaStore reads export quotas and records export usage
+type ExportQuotaStore interface {
+    GetByRoleID(ctx context.Context, userRoleID int) (*dtos.ExportQuotaDTO, error)
+    GetUsage(ctx context.Context, username string, day time.Time) (*dtos.ExportUsageDTO, error)
+    AddUsage(ctx context.Context, usage *dtos.ExportUsageDTO) error
+}
+
+var (
+    _ ProviderStore    = (*ProviderRepository)(nil)
+    _ TemplateStore    = (*TemplateRepository)(nil)
+    _ ScheduleStore    = (*ScheduleRepository)(nil)
+    _ LogStore         = (*LogRepository)(nil)
+    _ FieldStore       = (*FieldRepository)(nil)
+    _ SavedSearchStore = (*SavedSearchRepository)(nil)
+    _ HistoryStore     = (*HistoryRepository)(nil)
+    _ DataScopeStore   = (*DataScopeRepository)(nil)
+    _ APIKeyStore      = (*APIKeyRepository)(nil)
+    _ ExportQuotaStore = (*ExportQuotaRepository)(nil)
+)
@@ -0,0 +1,112 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "regexp"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/jmoiron/sqlx"
+    "provider-report-api/pkg/sqldialect"
+)
+
+func newMockLogRepository(t *testing.T, driver string) (*LogRepository, sqlmock.Sqlmock) {
+    t.Helper()
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        if err := mock.ExpectationsWereMet(); err != nil {
+            t.Error(err)
+        }
+        db.Close()
+    })
+
+    dialect, err := sqldialect.New(driver)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return NewLogRepository(sqlx.NewDb(db, driver), dialect, time.Second), mock
+}
+
+// claimQuery matches the claim of an attempt: only the attempt the caller read can be claimed
+const claimQuery = `UPDATE sent_report_logs SET\s+retry_count = retry_count \+ 1,\s+next_retry_at = \$3\s+WHERE id = \$1 AND retry_count = \$2`
+
+func TestClaimAttempt(t *testing.T) {
+    repo, mock := newMockLogRepository(t, "postgres")
+    leaseUntil := time.Date(2024, 5, 1, 9, 31, 0, 0, time.UTC)
+
+    mock.ExpectExec(claimQuery).WithArgs(42, 2, leaseUntil).WillReturnResult(sqlmock.NewResult(0, 1))
+    claimed, err := repo.ClaimAttempt(context.Background(), 42, 2, leaseUntil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !claimed {
+        t.Error("ClaimAttempt = false, want the attempt claimed when the row was updated")
+    }
+}
+
+func TestClaimAttemptLostRace(t *testing.T) {
+    repo, mock := newMockLogRepository(t, "postgres")
+    leaseUntil := time.Date(2024, 5, 1, 9, 31, 0, 0, time.UTC)
+
+    // Another instance already moved retry_count on, so the row no longer matches
+    mock.ExpectExec(claimQuery).WithArgs(42, 2, leaseUntil).WillReturnResult(sqlmock.NewResult(0, 0))
+    claimed, err := repo.ClaimAttempt(context.Background(), 42, 2, leaseUntil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if claimed {
+        t.Error("ClaimAttempt = true, want false when no row matched the retry count")
+    }
+}
+
+func TestClaimAttemptError(t *testing.T) {
+    repo, mock := newMockLogRepository(t, "postgres")
+    leaseUntil := time.Date(2024, 5, 1, 9, 31, 0, 0, time.UTC)
+    dbErr := errors.New("connection reset")
+
+    mock.ExpectExec(claimQuery).WithArgs(42, 2, leaseUntil).WillReturnError(dbErr)
+    if _, err := repo.ClaimAttempt(context.Background(), 42, 2, leaseUntil); !errors.Is(err, dbErr) {
+        t.Errorf("ClaimAttempt = %v, want the database error", err)
+    }
+
+    mock.ExpectExec(claimQuery).WithArgs(42, 2, leaseUntil).WillReturnResult(sqlmock.NewErrorResult(dbErr))
+    if _, err := repo.ClaimAttempt(context.Background(), 42, 2, leaseUntil); !errors.Is(err, dbErr) {
+        t.Errorf("ClaimAttempt with a failing RowsAffected = %v, want the database error", err)
+    }
+}
+
+func TestGetDueRetries(t *testing.T) {
+    tests := []struct {
+        driver string
+        page   string
+    }{
+        {"postgres", ` LIMIT $2 OFFSET 0`},
+        {"mssql", ` OFFSET 0 ROWS FETCH NEXT $2 ROWS ONLY`},
+    }
+    for _, tt := range tests {
+        t.Run(tt.driver, func(t *testing.T) {
+            repo, mock := newMockLogRepository(t, tt.driver)
+            now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+            nextRetryAt := now.Add(-time.Minute)
+
+            // Runs whose lease ended are due again like any pending run
+            query := `WHERE l.status = 'pending' AND l.next_retry_at <= \$1\s+ORDER BY l.next_retry_at` + regexp.QuoteMeta(tt.page)
+            rows := sqlmock.NewRows([]string{"id", "template_id", "schedule_id", "recipients", "status", "retry_count", "next_retry_at", "template_name", "schedule_name"}).
+                AddRow(42, 1, 7, "ops@example.com", "pending", 2, nextRetryAt, "Providers", "Weekly providers")
+            mock.ExpectQuery(query).WithArgs(now, 20).WillReturnRows(rows)
+
+            logs, err := repo.GetDueRetries(context.Background(), now, 20)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(logs) != 1 || logs[0].ID != 42 || logs[0].RetryCount != 2 || logs[0].ScheduleID == nil || *logs[0].ScheduleID != 7 {
+                t.Errorf("GetDueRetries = %+v, want log 42 at retry 2", logs)
+            }
+        })
+    }
+}
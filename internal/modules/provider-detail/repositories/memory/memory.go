@@ -0,0 +1,1542 @@
+// Package memory implements the repository stores in memory, so services and controllers can
+// be tested without a database.
+//
+// The repositories share one DB, like the SQL repositories share their database, so a
+// schedule sees the name of its template and a log the names of its template and schedule.
+// Filters, ordering, pagination, optimistic locking and errors follow the SQL repositories.
+package memory
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    clienterrors "provider-report-api/constant/errors"
+    "provider-report-api/internal/modules/provider-detail/dtos"
+    "provider-report-api/internal/modules/provider-detail/repositories"
+)
+
+var errDuplicate = errors.New("duplicate key")
+
+// DB holds the tables of the in-memory repositories
+type DB struct {
+    // Now returns the timestamps of created and updated rows, time.Now by default
+    Now func() time.Time
+
+    mu          sync.Mutex
+    lastID      int
+    providers   map[int]dtos.ProviderDTO
+    templates   map[int]dtos.TemplateDTO
+    schedules   map[int]dtos.ScheduleDTO
+    logs        map[int]dtos.SentReportLogDTO
+    fields      map[int]dtos.AvailableFieldDTO
+    searches    map[int]dtos.SavedSearchDTO
+    history     []dtos.ChangeHistoryDTO
+    scopes      map[int]dtos.DataScopeDTO
+    apiKeys     map[int]dtos.APIKeyDTO
+    apiKeyUsage []dtos.APIKeyUsageDTO
+    quotas      map[int]dtos.ExportQuotaDTO
+    exportUsage map[string]dtos.ExportUsageDTO
+}
+
+func NewDB() *DB {
+    return &DB{
+        Now:         time.Now,
+        providers:   make(map[int]dtos.ProviderDTO),
+        templates:   make(map[int]dtos.TemplateDTO),
+        schedules:   make(map[int]dtos.ScheduleDTO),
+        logs:        make(map[int]dtos.SentReportLogDTO),
+        fields:      make(map[int]dtos.AvailableFieldDTO),
+        searches:    make(map[int]dtos.SavedSearchDTO),
+        scopes:      make(map[int]dtos.DataScopeDTO),
+        apiKeys:     make(map[int]dtos.APIKeyDTO),
+        quotas:      make(map[int]dtos.ExportQuotaDTO),
+        exportUsage: make(map[string]dtos.ExportUsageDTO),
+    }
+}
+
+// SetDataScope stores the data scope of scope.UserRoleID, which has no repository to write it
+func (db *DB) SetDataScope(scope dtos.DataScopeDTO) {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    db.scopes[scope.UserRoleID] = scope
+}
+
+// SetExportQuota stores the export quota of quota.UserRoleID, which has no repository to write it
+func (db *DB) SetExportQuota(quota dtos.ExportQuotaDTO) {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    db.quotas[quota.UserRoleID] = quota
+}
+
+// lock locks the tables unless ctx is done, like a query that is not started after its
+// context is cancelled
+func (db *DB) lock(ctx context.Context) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    db.mu.Lock()
+    return nil
+}
+
+// nextID returns a new ID, unique across tables so IDs of different entities never match by accident
+func (db *DB) nextID() int {
+    db.lastID++
+    return db.lastID
+}
+
+var (
+    _ repositories.ProviderStore    = (*ProviderRepository)(nil)
+    _ repositories.TemplateStore    = (*TemplateRepository)(nil)
+    _ repositories.ScheduleStore    = (*ScheduleRepository)(nil)
+    _ repositories.LogStore         = (*LogRepository)(nil)
+    _ repositories.FieldStore       = (*FieldRepository)(nil)
+    _ repositories.SavedSearchStore = (*SavedSearchRepository)(nil)
+    _ repositories.HistoryStore     = (*HistoryRepository)(nil)
+    _ repositories.DataScopeStore   = (*DataScopeRepository)(nil)
+    _ repositories.APIKeyStore      = (*APIKeyRepository)(nil)
+    _ repositories.ExportQuotaStore = (*ExportQuotaRepository)(nil)
+)
+
+// ProviderRepository handles provider data operations
+type ProviderRepository struct {
+    db *DB
+}
+
+func NewProviderRepository(db *DB) *ProviderRepository {
+    return &ProviderRepository{db: db}
+}
+
+func (r *ProviderRepository) Search(ctx context.Context, req dtos.ProviderSearchRequestDTO) ([]dtos.ProviderDTO, int64, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, 0, err
+    }
+    defer r.db.mu.Unlock()
+
+    providers := r.find(req)
+    sort.Slice(providers, func(i, j int) bool {
+        if !providers[i].CreatedAt.Equal(providers[j].CreatedAt) {
+            return providers[i].CreatedAt.After(providers[j].CreatedAt)
+        }
+        return providers[i].ID > providers[j].ID
+    })
+    return paginate(providers, req.Page, req.Limit), int64(len(providers)), nil
+}
+
+func (r *ProviderRepository) GetSummary(ctx context.Context, req dtos.ProviderSearchRequestDTO) (*dtos.ProviderSummaryDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    summary := dtos.ProviderSummaryDTO{Type: "Government"}
+    for _, provider := range r.find(req) {
+        switch provider.ProviderType {
+        case "Hospital":
+            summary.Hospital++
+        case "Clinic":
+            summary.Clinic++
+        }
+        summary.GrandTotal++
+    }
+    if req.ProvinceName != "" {
+        summary.Province = req.ProvinceName
+    }
+    return &summary, nil
+}
+
+// find returns the providers matching the filters of a search, unordered
+func (r *ProviderRepository) find(req dtos.ProviderSearchRequestDTO) []dtos.ProviderDTO {
+    var providers []dtos.ProviderDTO
+    for _, provider := range r.db.providers {
+        if matchesSearch(provider, req) {
+            providers = append(providers, provider)
+        }
+    }
+    return providers
+}
+
+func matchesSearch(provider dtos.ProviderDTO, req dtos.ProviderSearchRequestDTO) bool {
+    if req.ProviderName != "" && !containsFold(provider.NameThai, req.ProviderName) &&
+        (provider.NameEng == nil || !containsFold(*provider.NameEng, req.ProviderName)) {
+        return false
+    }
+    if req.ProvinceName != "" && !containsFold(provider.Province, req.ProvinceName) {
+        return false
+    }
+    if req.ProviderType != "" && provider.ProviderType != req.ProviderType {
+        return false
+    }
+    if req.BusinessType != "" && (provider.BusinessType == nil || *provider.BusinessType != req.BusinessType) {
+        return false
+    }
+    if req.IsTPANetwork != nil && provider.IsTPANetwork != *req.IsTPANetwork {
+        return false
+    }
+    if req.CreatedFrom != nil && !onOrAfterDay(provider.CreatedAt, *req.CreatedFrom) {
+        return false
+    }
+    if req.CreatedTo != nil && !onOrBeforeDay(provider.CreatedAt, *req.CreatedTo) {
+        return false
+    }
+    if !inScope(provider, req.Scope) {
+        return false
+    }
+
+    switch {
+    case req.OnlyDeleted:
+        return provider.DeletedAt != nil
+    case req.IncludeDeleted:
+        return true
+    default:
+        return provider.DeletedAt == nil
+    }
+}
+
+// inScope reports whether a role's data scope includes the provider. A nil scope does not restrict.
+func inScope(provider dtos.ProviderDTO, scope *dtos.DataScopeDTO) bool {
+    if scope == nil {
+        return true
+    }
+    if len(scope.Regions) > 0 && (provider.Region == nil || !contains(scope.Regions, *provider.Region)) {
+        return false
+    }
+    if len(scope.Provinces) > 0 && !contains(scope.Provinces, provider.Province) {
+        return false
+    }
+    if len(scope.ProviderTypes) > 0 && !contains(scope.ProviderTypes, provider.ProviderType) {
+        return false
+    }
+    if scope.TPANetworkOnly && !provider.IsTPANetwork {
+        return false
+    }
+    return true
+}
+
+// GetByID returns an active provider, or ErrProviderDeleted if it is deleted, does not exist
+// or is outside scope
+func (r *ProviderRepository) GetByID(ctx context.Context, id int, scope *dtos.DataScopeDTO) (*dtos.ProviderDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+    return r.getByID(id, scope, false)
+}
+
+// GetByIDIncludingDeleted returns a provider in scope even if it has been soft deleted
+func (r *ProviderRepository) GetByIDIncludingDeleted(ctx context.Context, id int, scope *dtos.DataScopeDTO) (*dtos.ProviderDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+    return r.getByID(id, scope, true)
+}
+
+func (r *ProviderRepository) getByID(id int, scope *dtos.DataScopeDTO, includeDeleted bool) (*dtos.ProviderDTO, error) {
+    provider, ok := r.db.providers[id]
+    if !ok || !inScope(provider, scope) || (!includeDeleted && provider.DeletedAt != nil) {
+        return nil, clienterrors.ErrProviderDeleted
+    }
+    return &provider, nil
+}
+
+func (r *ProviderRepository) Create(ctx context.Context, provider *dtos.ProviderDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    for _, existing := range r.db.providers {
+        if existing.ProviderCode == provider.ProviderCode {
+            return fmt.Errorf("failed to create provider: %w: provider code %q", errDuplicate, provider.ProviderCode)
+        }
+    }
+
+    now := r.db.Now()
+    stored := *provider
+    stored.ID = r.db.nextID()
+    stored.CreatedAt = now
+    stored.UpdatedAt = now
+    stored.UpdatedBy = nil
+    stored.DeletedAt = nil
+    stored.DeletedBy = nil
+    stored.Version = 1
+    r.db.providers[stored.ID] = stored
+
+    provider.ID = stored.ID
+    provider.CreatedAt = stored.CreatedAt
+    provider.UpdatedAt = stored.UpdatedAt
+    provider.Version = stored.Version
+    return nil
+}
+
+// Update saves the provider if its version still matches the stored one and it is in scope
+func (r *ProviderRepository) Update(ctx context.Context, provider *dtos.ProviderDTO, scope *dtos.DataScopeDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    stored, err := r.getByID(provider.ID, scope, false)
+    if err != nil {
+        return err
+    }
+    if stored.Version != provider.Version {
+        return clienterrors.ErrPreconditionFailed
+    }
+
+    updated := *provider
+    updated.ProviderCode = stored.ProviderCode
+    updated.CreatedAt = stored.CreatedAt
+    updated.CreatedBy = stored.CreatedBy
+    updated.DeletedAt = stored.DeletedAt
+    updated.DeletedBy = stored.DeletedBy
+    updated.UpdatedAt = r.db.Now()
+    updated.Version = stored.Version + 1
+    r.db.providers[updated.ID] = updated
+
+    provider.Version++
+    return nil
+}
+
+// Delete soft deletes a provider in scope
+func (r *ProviderRepository) Delete(ctx context.Context, id int, deletedBy *string, scope *dtos.DataScopeDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    provider, ok := r.db.providers[id]
+    if !ok || !inScope(provider, scope) {
+        return clienterrors.ErrProviderDeleted
+    }
+    if provider.DeletedAt != nil {
+        return clienterrors.ErrAlreadyDeleted
+    }
+
+    now := r.db.Now()
+    provider.DeletedAt = &now
+    provider.DeletedBy = deletedBy
+    r.db.providers[id] = provider
+    return nil
+}
+
+// Restore brings a soft-deleted provider in scope back
+func (r *ProviderRepository) Restore(ctx context.Context, id int, restoredBy *string, scope *dtos.DataScopeDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    provider, ok := r.db.providers[id]
+    if !ok || !inScope(provider, scope) {
+        return clienterrors.ErrProviderDeleted
+    }
+    if provider.DeletedAt == nil {
+        return clienterrors.ErrNotDeleted
+    }
+
+    provider.DeletedAt = nil
+    provider.DeletedBy = nil
+    provider.UpdatedBy = restoredBy
+    provider.UpdatedAt = r.db.Now()
+    r.db.providers[id] = provider
+    return nil
+}
+
+func (r *ProviderRepository) GetProvinces(ctx context.Context, scope *dtos.DataScopeDTO) ([]string, error) {
+    return r.distinct(ctx, scope, func(provider dtos.ProviderDTO) string { return provider.Province })
+}
+
+func (r *ProviderRepository) GetProviderTypes(ctx context.Context, scope *dtos.DataScopeDTO) ([]string, error) {
+    return r.distinct(ctx, scope, func(provider dtos.ProviderDTO) string { return provider.ProviderType })
+}
+
+// distinct returns the sorted distinct values of a column of the active providers in scope
+func (r *ProviderRepository) distinct(ctx context.Context, scope *dtos.DataScopeDTO, column func(dtos.ProviderDTO) string) ([]string, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    seen := make(map[string]bool)
+    var values []string
+    for _, provider := range r.db.providers {
+        if provider.DeletedAt != nil || !inScope(provider, scope) {
+            continue
+        }
+        if value := column(provider); !seen[value] {
+            seen[value] = true
+            values = append(values, value)
+        }
+    }
+    sort.Strings(values)
+    return values, nil
+}
+
+func (r *ProviderRepository) GetProviderStats(ctx context.Context, scope *dtos.DataScopeDTO) (map[string]interface{}, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    var totalProviders, totalHospitals, totalClinics, tpaNetworkProviders, activeProviders, inactiveProviders int
+    for _, provider := range r.db.providers {
+        if provider.DeletedAt != nil || !inScope(provider, scope) {
+            continue
+        }
+        totalProviders++
+        switch provider.ProviderType {
+        case "Hospital":
+            totalHospitals++
+        case "Clinic":
+            totalClinics++
+        }
+        if provider.IsTPANetwork {
+            tpaNetworkProviders++
+        }
+        switch provider.ProviderStatus {
+        case "Active":
+            activeProviders++
+        case "Inactive":
+            inactiveProviders++
+        }
+    }
+
+    return map[string]interface{}{
+        "total_providers":       totalProviders,
+        "total_hospitals":       totalHospitals,
+        "total_clinics":         totalClinics,
+        "tpa_network_providers": tpaNetworkProviders,
+        "active_providers":      activeProviders,
+        "inactive_providers":    inactiveProviders,
+    }, nil
+}
+
+// TemplateRepository handles template data operations
+type TemplateRepository struct {
+    db *DB
+}
+
+func NewTemplateRepository(db *DB) *TemplateRepository {
+    return &TemplateRepository{db: db}
+}
+
+func (r *TemplateRepository) GetAll(ctx context.Context) ([]dtos.TemplateDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    var templates []dtos.TemplateDTO
+    for _, template := range r.db.templates {
+        if !template.IsDeleted {
+            templates = append(templates, template)
+        }
+    }
+    sort.Slice(templates, func(i, j int) bool {
+        if templates[i].IsStandard != templates[j].IsStandard {
+            return templates[i].IsStandard
+        }
+        return newerFirst(templates[i].CreatedAt, templates[i].ID, templates[j].CreatedAt, templates[j].ID)
+    })
+    return templates, nil
+}
+
+func (r *TemplateRepository) GetByID(ctx context.Context, id int) (*dtos.TemplateDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    template, ok := r.db.templates[id]
+    if !ok || template.IsDeleted {
+        return nil, clienterrors.ErrNotFound
+    }
+    return &template, nil
+}
+
+func (r *TemplateRepository) Create(ctx context.Context, template *dtos.TemplateDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    now := r.db.Now()
+    stored := *template
+    stored.ID = r.db.nextID()
+    stored.CreatedAt = now
+    stored.UpdatedAt = now
+    stored.UpdatedBy = nil
+    stored.IsDeleted = false
+    stored.Version = 1
+    r.db.templates[stored.ID] = stored
+
+    template.ID = stored.ID
+    template.CreatedAt = stored.CreatedAt
+    template.UpdatedAt = stored.UpdatedAt
+    template.Version = stored.Version
+    return nil
+}
+
+// Update saves the template if its version still matches the stored one
+func (r *TemplateRepository) Update(ctx context.Context, template *dtos.TemplateDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    stored, ok := r.db.templates[template.ID]
+    if !ok || stored.IsDeleted {
+        return clienterrors.ErrNotFound
+    }
+    if stored.Version != template.Version {
+        return clienterrors.ErrPreconditionFailed
+    }
+
+    updated := *template
+    updated.CreatedAt = stored.CreatedAt
+    updated.CreatedBy = stored.CreatedBy
+    updated.IsDeleted = stored.IsDeleted
+    updated.UpdatedAt = r.db.Now()
+    updated.Version = stored.Version + 1
+    r.db.templates[updated.ID] = updated
+
+    template.Version++
+    return nil
+}
+
+func (r *TemplateRepository) Delete(ctx context.Context, id int, deletedBy *string) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    template, ok := r.db.templates[id]
+    if !ok {
+        return fmt.Errorf("template not found")
+    }
+    template.IsDeleted = true
+    template.UpdatedBy = deletedBy
+    template.UpdatedAt = r.db.Now()
+    r.db.templates[id] = template
+    return nil
+}
+
+// ScheduleRepository handles schedule data operations
+type ScheduleRepository struct {
+    db *DB
+}
+
+func NewScheduleRepository(db *DB) *ScheduleRepository {
+    return &ScheduleRepository{db: db}
+}
+
+func (r *ScheduleRepository) GetAll(ctx context.Context) ([]dtos.ScheduleDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    var schedules []dtos.ScheduleDTO
+    for _, schedule := range r.db.schedules {
+        if !schedule.IsDeleted {
+            schedules = append(schedules, r.withTemplateName(schedule))
+        }
+    }
+    sort.Slice(schedules, func(i, j int) bool {
+        return newerFirst(schedules[i].CreatedAt, schedules[i].ID, schedules[j].CreatedAt, schedules[j].ID)
+    })
+    return schedules, nil
+}
+
+func (r *ScheduleRepository) GetByID(ctx context.Context, id int) (*dtos.ScheduleDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    schedule, ok := r.db.schedules[id]
+    if !ok || schedule.IsDeleted {
+        return nil, clienterrors.ErrNotFound
+    }
+    schedule = r.withTemplateName(schedule)
+    return &schedule, nil
+}
+
+// withTemplateName fills the joined template name
+func (r *ScheduleRepository) withTemplateName(schedule dtos.ScheduleDTO) dtos.ScheduleDTO {
+    schedule.TemplateName = r.db.templates[schedule.TemplateID].TemplateName
+    return schedule
+}
+
+func (r *ScheduleRepository) Create(ctx context.Context, schedule *dtos.ScheduleDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    if _, ok := r.db.templates[schedule.TemplateID]; !ok {
+        return fmt.Errorf("failed to create schedule: template %d does not exist", schedule.TemplateID)
+    }
+
+    now := r.db.Now()
+    stored := *schedule
+    stored.ID = r.db.nextID()
+    stored.IsActive = true
+    stored.LastRunAt = nil
+    stored.NextRunAt = nil
+    stored.CreatedAt = now
+    stored.UpdatedAt = now
+    stored.UpdatedBy = nil
+    stored.IsDeleted = false
+    stored.Version = 1
+    stored.TemplateName = ""
+    r.db.schedules[stored.ID] = stored
+
+    schedule.ID = stored.ID
+    schedule.CreatedAt = stored.CreatedAt
+    schedule.UpdatedAt = stored.UpdatedAt
+    schedule.Version = stored.Version
+    return nil
+}
+
+// Update saves the schedule if its version still matches the stored one
+func (r *ScheduleRepository) Update(ctx context.Context, schedule *dtos.ScheduleDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    stored, ok := r.db.schedules[schedule.ID]
+    if !ok || stored.IsDeleted {
+        return clienterrors.ErrNotFound
+    }
+    if stored.Version != schedule.Version {
+        return clienterrors.ErrPreconditionFailed
+    }
+    if _, ok := r.db.templates[schedule.TemplateID]; !ok {
+        return fmt.Errorf("failed to update schedule: template %d does not exist", schedule.TemplateID)
+    }
+
+    updated := *schedule
+    updated.LastRunAt = stored.LastRunAt
+    updated.NextRunAt = stored.NextRunAt
+    updated.CreatedAt = stored.CreatedAt
+    updated.CreatedBy = stored.CreatedBy
+    updated.IsDeleted = stored.IsDeleted
+    updated.OwnerRoleID = stored.OwnerRoleID
+    updated.UpdatedAt = r.db.Now()
+    updated.Version = stored.Version + 1
+    updated.TemplateName = ""
+    r.db.schedules[updated.ID] = updated
+
+    schedule.Version++
+    return nil
+}
+
+func (r *ScheduleRepository) Delete(ctx context.Context, id int, deletedBy *string) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    schedule, ok := r.db.schedules[id]
+    if !ok {
+        return fmt.Errorf("schedule not found")
+    }
+    schedule.IsDeleted = true
+    schedule.UpdatedBy = deletedBy
+    schedule.UpdatedAt = r.db.Now()
+    r.db.schedules[id] = schedule
+    return nil
+}
+
+// GetActiveSchedules returns the active schedules that have not ended, soonest next run
+// first and schedules without a next run last
+func (r *ScheduleRepository) GetActiveSchedules(ctx context.Context) ([]dtos.ScheduleDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    today := startOfDay(r.db.Now())
+    var schedules []dtos.ScheduleDTO
+    for _, schedule := range r.db.schedules {
+        if !schedule.IsActive || schedule.IsDeleted {
+            continue
+        }
+        if schedule.EndDate != nil && startOfDay(*schedule.EndDate).Before(today) {
+            continue
+        }
+        schedules = append(schedules, r.withTemplateName(schedule))
+    }
+    sort.Slice(schedules, func(i, j int) bool {
+        a, b := schedules[i].NextRunAt, schedules[j].NextRunAt
+        switch {
+        case a == nil || b == nil:
+            if (a == nil) != (b == nil) {
+                return b == nil
+            }
+        case !a.Equal(*b):
+            return a.Before(*b)
+        }
+        return schedules[i].ID < schedules[j].ID
+    })
+    return schedules, nil
+}
+
+func (r *ScheduleRepository) UpdateLastRun(ctx context.Context, id int) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    schedule, ok := r.db.schedules[id]
+    if !ok {
+        return nil
+    }
+    now := r.db.Now()
+    schedule.LastRunAt = &now
+    schedule.UpdatedAt = now
+    r.db.schedules[id] = schedule
+    return nil
+}
+
+// LogRepository handles log data operations
+type LogRepository struct {
+    db *DB
+}
+
+func NewLogRepository(db *DB) *LogRepository {
+    return &LogRepository{db: db}
+}
+
+func (r *LogRepository) GetSentReportLogs(ctx context.Context, req dtos.LogSearchRequestDTO) ([]dtos.SentReportLogDTO, int64, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, 0, err
+    }
+    defer r.db.mu.Unlock()
+
+    var logs []dtos.SentReportLogDTO
+    for _, log := range r.db.logs {
+        if req.TemplateID != nil && log.TemplateID != *req.TemplateID {
+            continue
+        }
+        if req.ScheduleID != nil && (log.ScheduleID == nil || *log.ScheduleID != *req.ScheduleID) {
+            continue
+        }
+        if req.Status != "" && log.Status != req.Status {
+            continue
+        }
+        if req.DateFrom != nil && !onOrAfterDay(log.SentAt, *req.DateFrom) {
+            continue
+        }
+        if req.DateTo != nil && !onOrBeforeDay(log.SentAt, *req.DateTo) {
+            continue
+        }
+        logs = append(logs, r.withNames(log))
+    }
+    sort.Slice(logs, func(i, j int) bool {
+        return newerFirst(logs[i].SentAt, logs[i].ID, logs[j].SentAt, logs[j].ID)
+    })
+    return paginate(logs, req.Page, req.Limit), int64(len(logs)), nil
+}
+
+func (r *LogRepository) GetByID(ctx context.Context, id int) (*dtos.SentReportLogDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    log, ok := r.db.logs[id]
+    if !ok {
+        return nil, clienterrors.ErrNotFound
+    }
+    log = r.withNames(log)
+    return &log, nil
+}
+
+// withNames fills the joined template and schedule names
+func (r *LogRepository) withNames(log dtos.SentReportLogDTO) dtos.SentReportLogDTO {
+    log.TemplateName = r.db.templates[log.TemplateID].TemplateName
+    log.ScheduleName = nil
+    if log.ScheduleID != nil {
+        if schedule, ok := r.db.schedules[*log.ScheduleID]; ok {
+            name := schedule.ScheduleName
+            log.ScheduleName = &name
+        }
+    }
+    return log
+}
+
+func (r *LogRepository) Create(ctx context.Context, log *dtos.SentReportLogDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    if _, ok := r.db.templates[log.TemplateID]; !ok {
+        return fmt.Errorf("failed to create sent report log: template %d does not exist", log.TemplateID)
+    }
+    if log.ScheduleID != nil {
+        if _, ok := r.db.schedules[*log.ScheduleID]; !ok {
+            return fmt.Errorf("failed to create sent report log: schedule %d does not exist", *log.ScheduleID)
+        }
+    }
+
+    stored := *log
+    stored.ID = r.db.nextID()
+    stored.SentAt = r.db.Now()
+    stored.TemplateName = ""
+    stored.ScheduleName = nil
+    r.db.logs[stored.ID] = stored
+
+    log.ID = stored.ID
+    log.SentAt = stored.SentAt
+    return nil
+}
+
+func (r *LogRepository) UpdateStatus(ctx context.Context, id int, status string, errorMessage *string) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    log, ok := r.db.logs[id]
+    if !ok {
+        return nil
+    }
+    log.Status = status
+    log.ErrorMessage = errorMessage
+    r.db.logs[id] = log
+    return nil
+}
+
+func (r *LogRepository) GetDueRetries(ctx context.Context, now time.Time, limit int) ([]dtos.SentReportLogDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    var logs []dtos.SentReportLogDTO
+    for _, log := range r.db.logs {
+        if log.Status == "pending" && log.NextRetryAt != nil && !log.NextRetryAt.After(now) {
+            logs = append(logs, r.withNames(log))
+        }
+    }
+    sort.Slice(logs, func(i, j int) bool {
+        return logs[i].NextRetryAt.Before(*logs[j].NextRetryAt)
+    })
+    if len(logs) > limit {
+        logs = logs[:limit]
+    }
+    return logs, nil
+}
+
+func (r *LogRepository) ClaimAttempt(ctx context.Context, id, retryCount int, leaseUntil time.Time) (bool, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return false, err
+    }
+    defer r.db.mu.Unlock()
+
+    log, ok := r.db.logs[id]
+    if !ok || log.RetryCount != retryCount {
+        return false, nil
+    }
+    log.RetryCount++
+    log.NextRetryAt = &leaseUntil
+    r.db.logs[id] = log
+    return true, nil
+}
+
+func (r *LogRepository) UpdateAttempt(ctx context.Context, attempt *dtos.SentReportLogDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    log, ok := r.db.logs[attempt.ID]
+    if !ok {
+        return nil
+    }
+    log.Status = attempt.Status
+    log.ErrorMessage = attempt.ErrorMessage
+    log.NextRetryAt = attempt.NextRetryAt
+    log.FileName = attempt.FileName
+    log.FileSizeKB = attempt.FileSizeKB
+    log.TotalRecords = attempt.TotalRecords
+    log.ExecutionTimeMs = attempt.ExecutionTimeMs
+    log.RequestID = attempt.RequestID
+    r.db.logs[attempt.ID] = log
+    return nil
+}
+
+// FieldRepository handles field data operations
+type FieldRepository struct {
+    db *DB
+}
+
+func NewFieldRepository(db *DB) *FieldRepository {
+    return &FieldRepository{db: db}
+}
+
+func (r *FieldRepository) GetAllFields(ctx context.Context) ([]dtos.AvailableFieldDTO, error) {
+    return r.find(ctx, func(field dtos.AvailableFieldDTO) bool { return field.IsActive })
+}
+
+func (r *FieldRepository) GetFieldsByCategory(ctx context.Context, category string) ([]dtos.AvailableFieldDTO, error) {
+    return r.find(ctx, func(field dtos.AvailableFieldDTO) bool {
+        return field.IsActive && field.FieldCategory == category
+    })
+}
+
+// GetSensitiveFields returns the fields that are masked for users without access to sensitive data
+func (r *FieldRepository) GetSensitiveFields(ctx context.Context) ([]dtos.AvailableFieldDTO, error) {
+    return r.find(ctx, func(field dtos.AvailableFieldDTO) bool {
+        return field.Sensitivity == dtos.FieldSensitivitySensitive
+    })
+}
+
+func (r *FieldRepository) GetFieldByCode(ctx context.Context, fieldCode string) (*dtos.AvailableFieldDTO, error) {
+    fields, err := r.find(ctx, func(field dtos.AvailableFieldDTO) bool {
+        return field.IsActive && field.FieldCode == fieldCode
+    })
+    if err != nil {
+        return nil, err
+    }
+    if len(fields) == 0 {
+        return nil, fmt.Errorf("failed to get field by code: %w", sql.ErrNoRows)
+    }
+    return &fields[0], nil
+}
+
+func (r *FieldRepository) ValidateFields(ctx context.Context, fieldCodes []string) ([]dtos.FieldValidationDTO, error) {
+    var results []dtos.FieldValidationDTO
+    for _, code := range fieldCodes {
+        if _, err := r.GetFieldByCode(ctx, code); err != nil {
+            if ctx.Err() != nil {
+                return nil, err
+            }
+            results = append(results, dtos.FieldValidationDTO{
+                FieldCode: code,
+                IsValid:   false,
+                Message:   "Field code not found or inactive",
+            })
+            continue
+        }
+        results = append(results, dtos.FieldValidationDTO{
+            FieldCode: code,
+            IsValid:   true,
+            Message:   "Field is valid",
+        })
+    }
+    return results, nil
+}
+
+func (r *FieldRepository) GetFieldCategories(ctx context.Context) ([]string, error) {
+    fields, err := r.find(ctx, func(field dtos.AvailableFieldDTO) bool { return field.IsActive })
+    if err != nil {
+        return nil, err
+    }
+    var categories []string
+    for _, field := range fields {
+        if !contains(categories, field.FieldCategory) {
+            categories = append(categories, field.FieldCategory)
+        }
+    }
+    return categories, nil
+}
+
+func (r *FieldRepository) CreateField(ctx context.Context, field *dtos.AvailableFieldDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    for _, existing := range r.db.fields {
+        if existing.FieldCode == field.FieldCode {
+            return fmt.Errorf("failed to create field: %w: field code %q", errDuplicate, field.FieldCode)
+        }
+    }
+
+    stored := *field
+    stored.ID = r.db.nextID()
+    stored.IsActive = true
+    stored.Sensitivity = dtos.FieldSensitivityPublic
+    stored.MaskType = nil
+    r.db.fields[stored.ID] = stored
+
+    field.ID = stored.ID
+    return nil
+}
+
+func (r *FieldRepository) UpdateField(ctx context.Context, field *dtos.AvailableFieldDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    stored, ok := r.db.fields[field.ID]
+    if !ok {
+        return fmt.Errorf("field not found")
+    }
+
+    updated := *field
+    updated.FieldCode = stored.FieldCode
+    updated.Sensitivity = stored.Sensitivity
+    updated.MaskType = stored.MaskType
+    r.db.fields[updated.ID] = updated
+    return nil
+}
+
+func (r *FieldRepository) DeleteField(ctx context.Context, id int) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    field, ok := r.db.fields[id]
+    if !ok {
+        return fmt.Errorf("field not found")
+    }
+    field.IsActive = false
+    r.db.fields[id] = field
+    return nil
+}
+
+func (r *FieldRepository) ExistsFieldCode(ctx context.Context, code string) (bool, error) {
+    fields, err := r.find(ctx, func(field dtos.AvailableFieldDTO) bool { return field.FieldCode == code })
+    return len(fields) > 0, err
+}
+
+func (r *FieldRepository) GetRequiredFields(ctx context.Context) ([]dtos.AvailableFieldDTO, error) {
+    return r.find(ctx, func(field dtos.AvailableFieldDTO) bool {
+        return field.IsActive && field.IsRequired
+    })
+}
+
+func (r *FieldRepository) GetFieldsByType(ctx context.Context, fieldType string) ([]dtos.AvailableFieldDTO, error) {
+    return r.find(ctx, func(field dtos.AvailableFieldDTO) bool {
+        return field.IsActive && field.FieldType == fieldType
+    })
+}
+
+func (r *FieldRepository) GetFieldsForExport(ctx context.Context, fieldCodes []string) ([]dtos.AvailableFieldDTO, error) {
+    if len(fieldCodes) == 0 {
+        return []dtos.AvailableFieldDTO{}, nil
+    }
+    return r.find(ctx, func(field dtos.AvailableFieldDTO) bool {
+        return field.IsActive && contains(fieldCodes, field.FieldCode)
+    })
+}
+
+// find returns the fields matching filter ordered by category and sort order, fields without
+// a sort order last
+func (r *FieldRepository) find(ctx context.Context, filter func(dtos.AvailableFieldDTO) bool) ([]dtos.AvailableFieldDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    var fields []dtos.AvailableFieldDTO
+    for _, field := range r.db.fields {
+        if filter(field) {
+            fields = append(fields, field)
+        }
+    }
+    sort.Slice(fields, func(i, j int) bool {
+        a, b := fields[i], fields[j]
+        if a.FieldCategory != b.FieldCategory {
+            return a.FieldCategory < b.FieldCategory
+        }
+        switch {
+        case a.SortOrder == nil || b.SortOrder == nil:
+            if (a.SortOrder == nil) != (b.SortOrder == nil) {
+                return b.SortOrder == nil
+            }
+        case *a.SortOrder != *b.SortOrder:
+            return *a.SortOrder < *b.SortOrder
+        }
+        return a.ID < b.ID
+    })
+    return fields, nil
+}
+
+// SavedSearchRepository handles saved search data operations
+type SavedSearchRepository struct {
+    db *DB
+}
+
+func NewSavedSearchRepository(db *DB) *SavedSearchRepository {
+    return &SavedSearchRepository{db: db}
+}
+
+func (r *SavedSearchRepository) GetByUsername(ctx context.Context, username string) ([]dtos.SavedSearchDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    var searches []dtos.SavedSearchDTO
+    for _, search := range r.db.searches {
+        if search.Username == username {
+            searches = append(searches, search)
+        }
+    }
+    sort.Slice(searches, func(i, j int) bool {
+        if searches[i].IsDefault != searches[j].IsDefault {
+            return searches[i].IsDefault
+        }
+        return searches[i].SearchName < searches[j].SearchName
+    })
+    return searches, nil
+}
+
+func (r *SavedSearchRepository) GetByID(ctx context.Context, id int, username string) (*dtos.SavedSearchDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    search, ok := r.db.searches[id]
+    if !ok || search.Username != username {
+        return nil, clienterrors.ErrNotFound
+    }
+    return &search, nil
+}
+
+func (r *SavedSearchRepository) Create(ctx context.Context, search *dtos.SavedSearchDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    if err := r.checkUnique(*search); err != nil {
+        return fmt.Errorf("failed to create saved search: %w", err)
+    }
+
+    now := r.db.Now()
+    stored := *search
+    stored.ID = r.db.nextID()
+    stored.CreatedAt = now
+    stored.UpdatedAt = now
+    r.db.searches[stored.ID] = stored
+
+    search.ID = stored.ID
+    search.CreatedAt = stored.CreatedAt
+    search.UpdatedAt = stored.UpdatedAt
+    return nil
+}
+
+func (r *SavedSearchRepository) Update(ctx context.Context, search *dtos.SavedSearchDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    stored, ok := r.db.searches[search.ID]
+    if !ok || stored.Username != search.Username {
+        return clienterrors.ErrNotFound
+    }
+    if err := r.checkUnique(*search); err != nil {
+        return fmt.Errorf("failed to update saved search: %w", err)
+    }
+
+    updated := *search
+    updated.CreatedAt = stored.CreatedAt
+    updated.UpdatedAt = r.db.Now()
+    r.db.searches[updated.ID] = updated
+    return nil
+}
+
+// checkUnique enforces one search name per user, like the unique constraint of saved_searches
+func (r *SavedSearchRepository) checkUnique(search dtos.SavedSearchDTO) error {
+    for _, existing := range r.db.searches {
+        if existing.ID != search.ID && existing.Username == search.Username && existing.SearchName == search.SearchName {
+            return fmt.Errorf("%w: search name %q", errDuplicate, search.SearchName)
+        }
+    }
+    return nil
+}
+
+func (r *SavedSearchRepository) Delete(ctx context.Context, id int, username string) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    search, ok := r.db.searches[id]
+    if !ok || search.Username != username {
+        return clienterrors.ErrNotFound
+    }
+    delete(r.db.searches, id)
+    return nil
+}
+
+// ClearDefault unsets the default flag on every saved search of the user except the given one
+func (r *SavedSearchRepository) ClearDefault(ctx context.Context, username string, exceptID int) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    for id, search := range r.db.searches {
+        if search.Username == username && id != exceptID && search.IsDefault {
+            search.IsDefault = false
+            r.db.searches[id] = search
+        }
+    }
+    return nil
+}
+
+// HistoryRepository handles change history data operations
+type HistoryRepository struct {
+    db *DB
+}
+
+func NewHistoryRepository(db *DB) *HistoryRepository {
+    return &HistoryRepository{db: db}
+}
+
+func (r *HistoryRepository) Create(ctx context.Context, entries []dtos.ChangeHistoryDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    now := r.db.Now()
+    for _, entry := range entries {
+        entry.ID = r.db.nextID()
+        entry.ChangedAt = now
+        r.db.history = append(r.db.history, entry)
+    }
+    return nil
+}
+
+func (r *HistoryRepository) Search(ctx context.Context, req dtos.HistorySearchRequestDTO) ([]dtos.ChangeHistoryDTO, int64, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, 0, err
+    }
+    defer r.db.mu.Unlock()
+
+    var history []dtos.ChangeHistoryDTO
+    for _, entry := range r.db.history {
+        if req.EntityType != "" && entry.EntityType != req.EntityType {
+            continue
+        }
+        if req.EntityID != nil && entry.EntityID != *req.EntityID {
+            continue
+        }
+        if req.Action != "" && entry.Action != req.Action {
+            continue
+        }
+        if req.FieldName != "" && (entry.FieldName == nil || *entry.FieldName != req.FieldName) {
+            continue
+        }
+        if req.ChangedBy != "" && entry.ChangedBy != req.ChangedBy {
+            continue
+        }
+        if req.DateFrom != nil && !onOrAfterDay(entry.ChangedAt, *req.DateFrom) {
+            continue
+        }
+        if req.DateTo != nil && !onOrBeforeDay(entry.ChangedAt, *req.DateTo) {
+            continue
+        }
+        history = append(history, entry)
+    }
+    sort.SliceStable(history, func(i, j int) bool {
+        if !history[i].ChangedAt.Equal(history[j].ChangedAt) {
+            return history[i].ChangedAt.After(history[j].ChangedAt)
+        }
+        return history[i].ID < history[j].ID
+    })
+    return paginate(history, req.Page, req.Limit), int64(len(history)), nil
+}
+
+// DataScopeRepository handles the row-level data scopes of user roles, set with DB.SetDataScope
+type DataScopeRepository struct {
+    db *DB
+}
+
+func NewDataScopeRepository(db *DB) *DataScopeRepository {
+    return &DataScopeRepository{db: db}
+}
+
+// GetByRoleID returns the data scope of a user role, or nil when the role is not restricted
+func (r *DataScopeRepository) GetByRoleID(ctx context.Context, userRoleID int) (*dtos.DataScopeDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    scope, ok := r.db.scopes[userRoleID]
+    if !ok {
+        return nil, nil
+    }
+    return &scope, nil
+}
+
+// APIKeyRepository handles API key data operations
+type APIKeyRepository struct {
+    db *DB
+}
+
+func NewAPIKeyRepository(db *DB) *APIKeyRepository {
+    return &APIKeyRepository{db: db}
+}
+
+func (r *APIKeyRepository) GetAll(ctx context.Context) ([]dtos.APIKeyDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    var keys []dtos.APIKeyDTO
+    for _, key := range r.db.apiKeys {
+        keys = append(keys, key)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        return newerFirst(keys[i].CreatedAt, keys[i].ID, keys[j].CreatedAt, keys[j].ID)
+    })
+    return keys, nil
+}
+
+func (r *APIKeyRepository) GetByID(ctx context.Context, id int) (*dtos.APIKeyDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    key, ok := r.db.apiKeys[id]
+    if !ok {
+        return nil, clienterrors.ErrNotFound
+    }
+    return &key, nil
+}
+
+func (r *APIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*dtos.APIKeyDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    for _, key := range r.db.apiKeys {
+        if key.KeyPrefix == prefix {
+            return &key, nil
+        }
+    }
+    return nil, clienterrors.ErrNotFound
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *dtos.APIKeyDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    for _, existing := range r.db.apiKeys {
+        if existing.KeyPrefix == key.KeyPrefix {
+            return fmt.Errorf("failed to create API key: %w: key prefix %q", errDuplicate, key.KeyPrefix)
+        }
+    }
+
+    now := r.db.Now()
+    stored := *key
+    stored.ID = r.db.nextID()
+    stored.RevokedAt = nil
+    stored.RevokedBy = nil
+    stored.LastUsedAt = nil
+    stored.UsageCount = 0
+    stored.CreatedAt = now
+    stored.UpdatedAt = now
+    r.db.apiKeys[stored.ID] = stored
+
+    key.ID = stored.ID
+    key.CreatedAt = stored.CreatedAt
+    key.UpdatedAt = stored.UpdatedAt
+    return nil
+}
+
+// Revoke disables a key. Revoking an already revoked key is an error.
+func (r *APIKeyRepository) Revoke(ctx context.Context, id int, revokedBy string) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    key, ok := r.db.apiKeys[id]
+    if !ok {
+        return clienterrors.ErrNotFound
+    }
+    if key.RevokedAt != nil {
+        return clienterrors.ErrAlreadyDeleted
+    }
+
+    now := r.db.Now()
+    key.RevokedAt = &now
+    key.RevokedBy = &revokedBy
+    key.UpdatedAt = now
+    r.db.apiKeys[id] = key
+    return nil
+}
+
+// RecordUsage stores a request made with a key and updates its usage counters
+func (r *APIKeyRepository) RecordUsage(ctx context.Context, usage *dtos.APIKeyUsageDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    key, ok := r.db.apiKeys[usage.APIKeyID]
+    if !ok {
+        return fmt.Errorf("failed to record API key usage: API key %d does not exist", usage.APIKeyID)
+    }
+
+    now := r.db.Now()
+    stored := *usage
+    stored.ID = int64(r.db.nextID())
+    stored.UsedAt = now
+    r.db.apiKeyUsage = append(r.db.apiKeyUsage, stored)
+
+    key.LastUsedAt = &now
+    key.UsageCount++
+    r.db.apiKeys[key.ID] = key
+    return nil
+}
+
+// GetUsage returns the latest requests made with a key, newest first
+func (r *APIKeyRepository) GetUsage(ctx context.Context, id int, limit int) ([]dtos.APIKeyUsageDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    var usage []dtos.APIKeyUsageDTO
+    for i := len(r.db.apiKeyUsage) - 1; i >= 0 && len(usage) < limit; i-- {
+        if r.db.apiKeyUsage[i].APIKeyID == id {
+            usage = append(usage, r.db.apiKeyUsage[i])
+        }
+    }
+    return usage, nil
+}
+
+// ExportQuotaRepository handles the daily export quotas of roles, set with DB.SetExportQuota,
+// and the export usage of users
+type ExportQuotaRepository struct {
+    db *DB
+}
+
+func NewExportQuotaRepository(db *DB) *ExportQuotaRepository {
+    return &ExportQuotaRepository{db: db}
+}
+
+// GetByRoleID returns the export quota of a user role, or nil when the role has none
+func (r *ExportQuotaRepository) GetByRoleID(ctx context.Context, userRoleID int) (*dtos.ExportQuotaDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    quota, ok := r.db.quotas[userRoleID]
+    if !ok {
+        return nil, nil
+    }
+    return &quota, nil
+}
+
+// GetUsage returns what a user exported on day, zero when nothing was exported yet
+func (r *ExportQuotaRepository) GetUsage(ctx context.Context, username string, day time.Time) (*dtos.ExportUsageDTO, error) {
+    if err := r.db.lock(ctx); err != nil {
+        return nil, err
+    }
+    defer r.db.mu.Unlock()
+
+    usage, ok := r.db.exportUsage[usageKey(username, day)]
+    if !ok {
+        usage = dtos.ExportUsageDTO{Username: username, UsageDate: day}
+    }
+    return &usage, nil
+}
+
+// AddUsage adds an export to the usage of the user on usage.UsageDate
+func (r *ExportQuotaRepository) AddUsage(ctx context.Context, usage *dtos.ExportUsageDTO) error {
+    if err := r.db.lock(ctx); err != nil {
+        return err
+    }
+    defer r.db.mu.Unlock()
+
+    key := usageKey(usage.Username, usage.UsageDate)
+    stored, ok := r.db.exportUsage[key]
+    if !ok {
+        stored = dtos.ExportUsageDTO{Username: usage.Username, UsageDate: usage.UsageDate}
+    }
+    stored.RowCount += usage.RowCount
+    stored.ByteCount += usage.ByteCount
+    r.db.exportUsage[key] = stored
+    return nil
+}
+
+func usageKey(username string, day time.Time) string {
+    return username + "|" + day.Format("2006-01-02")
+}
+
+// paginate returns one page of items, with the defaults of the SQL repositories
+func paginate[T any](items []T, page, limit int) []T {
+    if limit == 0 {
+        limit = 10
+    }
+    offset := (page - 1) * limit
+    if offset < 0 {
+        offset = 0
+    }
+    if offset >= len(items) {
+        return nil
+    }
+    end := offset + limit
+    if end > len(items) {
+        end = len(items)
+    }
+    return items[offset:end]
+}
+
+// newerFirst orders rows by creation time, newest first, then by ID
+func newerFirst(a time.Time, aID int, b time.Time, bID int) bool {
+    if !a.Equal(b) {
+        return a.After(b)
+    }
+    return aID > bID
+}
+
+// onOrAfterDay reports whether t is on or after the start of day, like created_at >= 'YYYY-MM-DD'
+func onOrAfterDay(t, day time.Time) bool {
+    return !t.Before(time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, t.Location()))
+}
+
+// onOrBeforeDay reports whether t is on or before the end of day, like created_at <= 'YYYY-MM-DD 23:59:59'
+func onOrBeforeDay(t, day time.Time) bool {
+    return !t.After(time.Date(day.Year(), day.Month(), day.Day(), 23, 59, 59, 0, t.Location()))
+}
+
+func startOfDay(t time.Time) time.Time {
+    return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// containsFold reports whether substr is in s ignoring case, like ILIKE '%substr%'
+func containsFold(s, substr string) bool {
+    return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func contains(values []string, value string) bool {
+    for _, v := range values {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
@@ -1,23 +1,43 @@
 package repositories
 
 import (
+    "context"
+    "database/sql"
+    "errors"
     "fmt"
     "strings"
+    "time"
 
     "github.com/jmoiron/sqlx"
+    clienterrors "provider-report-api/constant/errors"
     "provider-report-api/internal/modules/provider-detail/dtos"
+    "provider-report-api/pkg/sqldialect"
 )
 
+// withQueryTimeout bounds the queries of one repository call by timeout, on top of the
+// deadline of ctx. A timeout of 0 leaves only ctx.
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+    if timeout <= 0 {
+        return context.WithCancel(ctx)
+    }
+    return context.WithTimeout(ctx, timeout)
+}
+
 // ProviderRepository handles provider data operations
 type ProviderRepository struct {
-    db *sqlx.DB
+    db           *sqlx.DB
+    dialect      sqldialect.Dialect
+    queryTimeout time.Duration
 }
 
-func NewProviderRepository(db *sqlx.DB) *ProviderRepository {
-    return &ProviderRepository{db: db}
+func NewProviderRepository(db *sqlx.DB, dialect sqldialect.Dialect, queryTimeout time.Duration) *ProviderRepository {
+    return &ProviderRepository{db: db, dialect: dialect, queryTimeout: queryTimeout}
 }
 
-func (r *ProviderRepository) Search(req dtos.ProviderSearchRequestDTO) ([]dtos.ProviderDTO, int64, error) {
+func (r *ProviderRepository) Search(ctx context.Context, req dtos.ProviderSearchRequestDTO) ([]dtos.ProviderDTO, int64, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var conditions []string
     var args []interface{}
     argIndex := 1
@@ -36,13 +56,14 @@ func (r *ProviderRepository) Search(req dtos.ProviderSearchRequestDTO) ([]dtos.P
 
     // Add search conditions
     if req.ProviderName != "" {
-        conditions = append(conditions, fmt.Sprintf("(p.name_thai ILIKE $%d OR p.name_eng ILIKE $%d)", argIndex, argIndex))
+        placeholder := fmt.Sprintf("$%d", argIndex)
+        conditions = append(conditions, "("+r.dialect.ILike("p.name_thai", placeholder)+" OR "+r.dialect.ILike("p.name_eng", placeholder)+")")
         args = append(args, "%"+req.ProviderName+"%")
         argIndex++
     }
 
     if req.ProvinceName != "" {
-        conditions = append(conditions, fmt.Sprintf("p.province ILIKE $%d", argIndex))
+        conditions = append(conditions, r.dialect.ILike("p.province", fmt.Sprintf("$%d", argIndex)))
         args = append(args, "%"+req.ProvinceName+"%")
         argIndex++
     }
@@ -77,6 +98,12 @@ func (r *ProviderRepository) Search(req dtos.ProviderSearchRequestDTO) ([]dtos.P
         argIndex++
     }
 
+    conditions = append(conditions, scopeConditions(r.dialect, req.Scope, &args, &argIndex)...)
+
+    if condition := deletedCondition(req); condition != "" {
+        conditions = append(conditions, condition)
+    }
+
     // Add conditions to queries
     if len(conditions) > 0 {
         conditionStr := " AND " + strings.Join(conditions, " AND ")
@@ -86,7 +113,7 @@ func (r *ProviderRepository) Search(req dtos.ProviderSearchRequestDTO) ([]dtos.P
 
     // Get total count
     var total int64
-    err := r.db.Get(&total, countQuery, args...)
+    err := r.db.GetContext(ctx, &total, countQuery, args...)
     if err != nil {
         return nil, 0, fmt.Errorf("failed to get provider count: %w", err)
     }
@@ -100,12 +127,12 @@ func (r *ProviderRepository) Search(req dtos.ProviderSearchRequestDTO) ([]dtos.P
         offset = 0
     }
 
-    baseQuery += fmt.Sprintf(" ORDER BY p.created_at DESC LIMIT $%d OFFSET $%d", argIndex, argIndex+1)
+    baseQuery += " ORDER BY p.created_at DESC" + r.dialect.Paginate(fmt.Sprintf("$%d", argIndex), fmt.Sprintf("$%d", argIndex+1))
     args = append(args, req.Limit, offset)
 
     // Execute query
     var providers []dtos.ProviderDTO
-    err = r.db.Select(&providers, baseQuery, args...)
+    err = r.db.SelectContext(ctx, &providers, baseQuery, args...)
     if err != nil {
         return nil, 0, fmt.Errorf("failed to search providers: %w", err)
     }
@@ -113,7 +140,10 @@ func (r *ProviderRepository) Search(req dtos.ProviderSearchRequestDTO) ([]dtos.P
     return providers, total, nil
 }
 
-func (r *ProviderRepository) GetSummary(req dtos.ProviderSearchRequestDTO) (*dtos.ProviderSummaryDTO, error) {
+func (r *ProviderRepository) GetSummary(ctx context.Context, req dtos.ProviderSearchRequestDTO) (*dtos.ProviderSummaryDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var conditions []string
     var args []interface{}
     argIndex := 1
@@ -129,13 +159,14 @@ func (r *ProviderRepository) GetSummary(req dtos.ProviderSearchRequestDTO) (*dto
 
     // Add same search conditions as in Search method
     if req.ProviderName != "" {
-        conditions = append(conditions, fmt.Sprintf("(p.name_thai ILIKE $%d OR p.name_eng ILIKE $%d)", argIndex, argIndex))
+        placeholder := fmt.Sprintf("$%d", argIndex)
+        conditions = append(conditions, "("+r.dialect.ILike("p.name_thai", placeholder)+" OR "+r.dialect.ILike("p.name_eng", placeholder)+")")
         args = append(args, "%"+req.ProviderName+"%")
         argIndex++
     }
 
     if req.ProvinceName != "" {
-        conditions = append(conditions, fmt.Sprintf("p.province ILIKE $%d", argIndex))
+        conditions = append(conditions, r.dialect.ILike("p.province", fmt.Sprintf("$%d", argIndex)))
         args = append(args, "%"+req.ProvinceName+"%")
         argIndex++
     }
@@ -170,12 +201,18 @@ func (r *ProviderRepository) GetSummary(req dtos.ProviderSearchRequestDTO) (*dto
         argIndex++
     }
 
+    conditions = append(conditions, scopeConditions(r.dialect, req.Scope, &args, &argIndex)...)
+
+    if condition := deletedCondition(req); condition != "" {
+        conditions = append(conditions, condition)
+    }
+
     if len(conditions) > 0 {
         query += " AND " + strings.Join(conditions, " AND ")
     }
 
     var summary dtos.ProviderSummaryDTO
-    err := r.db.Get(&summary, query, args...)
+    err := r.db.GetContext(ctx, &summary, query, args...)
     if err != nil {
         return nil, fmt.Errorf("failed to get provider summary: %w", err)
     }
@@ -189,17 +226,113 @@ func (r *ProviderRepository) GetSummary(req dtos.ProviderSearchRequestDTO) (*dto
     return &summary, nil
 }
 
-func (r *ProviderRepository) GetByID(id int) (*dtos.ProviderDTO, error) {
+// scopeConditions returns the filters of a role's data scope and appends their arguments.
+// A nil scope does not restrict.
+func scopeConditions(dialect sqldialect.Dialect, scope *dtos.DataScopeDTO, args *[]interface{}, argIndex *int) []string {
+    if scope == nil {
+        return nil
+    }
+
+    var conditions []string
+    if len(scope.Regions) > 0 {
+        conditions = append(conditions, "p.region IN ("+bindList(scope.Regions, args, argIndex)+")")
+    }
+    if len(scope.Provinces) > 0 {
+        conditions = append(conditions, "p.province IN ("+bindList(scope.Provinces, args, argIndex)+")")
+    }
+    if len(scope.ProviderTypes) > 0 {
+        conditions = append(conditions, "p.provider_type IN ("+bindList(scope.ProviderTypes, args, argIndex)+")")
+    }
+    if scope.TPANetworkOnly {
+        conditions = append(conditions, "p.is_tpa_network = "+dialect.Bool(true))
+    }
+    return conditions
+}
+
+// bindList appends values as arguments and returns their placeholders
+func bindList(values []string, args *[]interface{}, argIndex *int) string {
+    placeholders := make([]string, len(values))
+    for i, value := range values {
+        placeholders[i] = fmt.Sprintf("$%d", *argIndex)
+        *args = append(*args, value)
+        *argIndex++
+    }
+    return strings.Join(placeholders, ", ")
+}
+
+// scopeRowFilter returns the data scope as an " AND id IN (...)" suffix for statements on the
+// providers table that can't alias it, such as UPDATE on SQL Server
+func scopeRowFilter(dialect sqldialect.Dialect, scope *dtos.DataScopeDTO, args *[]interface{}, argIndex *int) string {
+    conditions := scopeConditions(dialect, scope, args, argIndex)
+    if len(conditions) == 0 {
+        return ""
+    }
+    return " AND id IN (SELECT p.id FROM providers p WHERE " + strings.Join(conditions, " AND ") + ")"
+}
+
+// scopeWhere returns the data scope filters as an " AND ..." suffix for queries without
+// other arguments
+func scopeWhere(dialect sqldialect.Dialect, scope *dtos.DataScopeDTO) (string, []interface{}) {
+    var args []interface{}
+    argIndex := 1
+    conditions := scopeConditions(dialect, scope, &args, &argIndex)
+    if len(conditions) == 0 {
+        return "", nil
+    }
+    return " AND " + strings.Join(conditions, " AND "), args
+}
+
+// deletedCondition returns the soft delete filter for a provider search
+func deletedCondition(req dtos.ProviderSearchRequestDTO) string {
+    switch {
+    case req.OnlyDeleted:
+        return "p.deleted_at IS NOT NULL"
+    case req.IncludeDeleted:
+        return ""
+    default:
+        return "p.deleted_at IS NULL"
+    }
+}
+
+// GetByID returns an active provider, or ErrProviderDeleted if it is deleted, does not exist
+// or is outside scope
+func (r *ProviderRepository) GetByID(ctx context.Context, id int, scope *dtos.DataScopeDTO) (*dtos.ProviderDTO, error) {
+    return r.getByID(ctx, id, scope, false)
+}
+
+// GetByIDIncludingDeleted returns a provider in scope even if it has been soft deleted
+func (r *ProviderRepository) GetByIDIncludingDeleted(ctx context.Context, id int, scope *dtos.DataScopeDTO) (*dtos.ProviderDTO, error) {
+    return r.getByID(ctx, id, scope, true)
+}
+
+func (r *ProviderRepository) getByID(ctx context.Context, id int, scope *dtos.DataScopeDTO, includeDeleted bool) (*dtos.ProviderDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    args := []interface{}{id}
+    argIndex := 2
+    conditions := append([]string{"p.id = $1"}, scopeConditions(r.dialect, scope, &args, &argIndex)...)
+    if !includeDeleted {
+        conditions = append(conditions, "p.deleted_at IS NULL")
+    }
+    query := "SELECT p.* FROM providers p WHERE " + strings.Join(conditions, " AND ")
+
     var provider dtos.ProviderDTO
-    err := r.db.Get(&provider, "SELECT * FROM providers WHERE id = $1", id)
+    err := r.db.GetContext(ctx, &provider, query, args...)
     if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, clienterrors.ErrProviderDeleted
+        }
         return nil, fmt.Errorf("failed to get provider by ID: %w", err)
     }
     return &provider, nil
 }
 
-func (r *ProviderRepository) Create(provider *dtos.ProviderDTO) error {
-    query := `
+func (r *ProviderRepository) Create(ctx context.Context, provider *dtos.ProviderDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`
         INSERT INTO providers (
             provider_code, title_thai, name_thai, title_eng, name_eng,
             provider_type, register_status, business_type, bed_size,
@@ -211,7 +344,7 @@ func (r *ProviderRepository) Create(provider *dtos.ProviderDTO) error {
             payment_method, payment_branch_id, payee_name, bank_account_number,
             bank_account_type, bank_branch_name, bank_name, is_tpa_network,
             has_incident, discount_categories, pricing_categories, created_by
-        ) VALUES (
+        ) %s VALUES (
             :provider_code, :title_thai, :name_thai, :title_eng, :name_eng,
             :provider_type, :register_status, :business_type, :bed_size,
             :eligibility_method, :province, :region, :country, :provider_tax_id,
@@ -222,17 +355,17 @@ func (r *ProviderRepository) Create(provider *dtos.ProviderDTO) error {
             :payment_method, :payment_branch_id, :payee_name, :bank_account_number,
             :bank_account_type, :bank_branch_name, :bank_name, :is_tpa_network,
             :has_incident, :discount_categories, :pricing_categories, :created_by
-        ) RETURNING id, created_at, updated_at
-    `
+        ) %s
+    `, r.dialect.Output("id", "created_at", "updated_at", "version"), r.dialect.Returning("id", "created_at", "updated_at", "version"))
 
-    rows, err := r.db.NamedQuery(query, provider)
+    rows, err := r.db.NamedQueryContext(ctx, query, provider)
     if err != nil {
         return fmt.Errorf("failed to create provider: %w", err)
     }
     defer rows.Close()
 
     if rows.Next() {
-        err = rows.Scan(&provider.ID, &provider.CreatedAt, &provider.UpdatedAt)
+        err = rows.Scan(&provider.ID, &provider.CreatedAt, &provider.UpdatedAt, &provider.Version)
         if err != nil {
             return fmt.Errorf("failed to scan created provider: %w", err)
         }
@@ -241,23 +374,73 @@ func (r *ProviderRepository) Create(provider *dtos.ProviderDTO) error {
     return nil
 }
 
-func (r *ProviderRepository) Update(provider *dtos.ProviderDTO) error {
+// Update saves the provider if its version still matches the stored one and it is in scope
+func (r *ProviderRepository) Update(ctx context.Context, provider *dtos.ProviderDTO, scope *dtos.DataScopeDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     query := `
         UPDATE providers SET
+            title_thai = :title_thai,
             name_thai = :name_thai,
+            title_eng = :title_eng,
             name_eng = :name_eng,
             provider_type = :provider_type,
+            register_status = :register_status,
             business_type = :business_type,
+            bed_size = :bed_size,
+            eligibility_method = :eligibility_method,
+            opening_time = :opening_time,
+            provider_status = :provider_status,
+            is_tpa_network = :is_tpa_network,
+            has_incident = :has_incident,
+            building_no = :building_no,
+            village_no = :village_no,
+            lane_alley = :lane_alley,
+            road = :road,
+            sub_district = :sub_district,
+            district = :district,
             province = :province,
+            region = :region,
+            country = :country,
+            post_code = :post_code,
+            title_name = :title_name,
+            department = :department,
             general_phone_no = :general_phone_no,
-            is_tpa_network = :is_tpa_network,
-            provider_status = :provider_status,
+            direct_phone_no = :direct_phone_no,
+            email = :email,
+            email_to_list = :email_to_list,
+            email_cc_list = :email_cc_list,
+            provider_tax_id = :provider_tax_id,
+            wh_tax_percent = :wh_tax_percent,
+            exempt_percent = :exempt_percent,
+            wh_tax_exempt_from = :wh_tax_exempt_from,
+            wh_tax_exempt_to = :wh_tax_exempt_to,
+            payment_method = :payment_method,
+            payment_branch_id = :payment_branch_id,
+            payee_name = :payee_name,
+            bank_account_number = :bank_account_number,
+            bank_account_type = :bank_account_type,
+            bank_branch_name = :bank_branch_name,
+            bank_name = :bank_name,
+            discount_categories = :discount_categories,
+            pricing_categories = :pricing_categories,
             updated_by = :updated_by,
-            updated_at = CURRENT_TIMESTAMP
-        WHERE id = :id
+            updated_at = CURRENT_TIMESTAMP,
+            version = version + 1
+        WHERE id = :id AND deleted_at IS NULL AND version = :version
     `
 
-    result, err := r.db.NamedExec(query, provider)
+    // The scope filter binds positional arguments, so the named ones are bound first
+    query, args, err := sqlx.Named(query, provider)
+    if err != nil {
+        return fmt.Errorf("failed to bind provider update: %w", err)
+    }
+    query = sqlx.Rebind(sqlx.DOLLAR, query)
+    argIndex := len(args) + 1
+    query += scopeRowFilter(r.dialect, scope, &args, &argIndex)
+
+    result, err := r.db.ExecContext(ctx, query, args...)
     if err != nil {
         return fmt.Errorf("failed to update provider: %w", err)
     }
@@ -268,15 +451,32 @@ func (r *ProviderRepository) Update(provider *dtos.ProviderDTO) error {
     }
 
     if rowsAffected == 0 {
-        return fmt.Errorf("provider not found")
+        // Either the provider is gone or another request updated it first
+        if _, err := r.GetByID(ctx, provider.ID, scope); err != nil {
+            return err
+        }
+        return clienterrors.ErrPreconditionFailed
     }
 
+    provider.Version++
     return nil
 }
 
-func (r *ProviderRepository) Delete(id int) error {
-    query := `DELETE FROM providers WHERE id = $1`
-    result, err := r.db.Exec(query, id)
+// Delete soft deletes a provider in scope. Provider master data is referenced by claims
+// history, so rows are never removed.
+func (r *ProviderRepository) Delete(ctx context.Context, id int, deletedBy *string, scope *dtos.DataScopeDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    args := []interface{}{id, deletedBy}
+    argIndex := 3
+    query := `
+        UPDATE providers SET
+            deleted_at = CURRENT_TIMESTAMP,
+            deleted_by = $2
+        WHERE id = $1 AND deleted_at IS NULL
+    ` + scopeRowFilter(r.dialect, scope, &args, &argIndex)
+    result, err := r.db.ExecContext(ctx, query, args...)
     if err != nil {
         return fmt.Errorf("failed to delete provider: %w", err)
     }
@@ -287,45 +487,108 @@ func (r *ProviderRepository) Delete(id int) error {
     }
 
     if rowsAffected == 0 {
-        return fmt.Errorf("provider not found")
+        return r.missingRowError(ctx, id, scope, clienterrors.ErrAlreadyDeleted)
+    }
+
+    return nil
+}
+
+// Restore brings a soft-deleted provider in scope back
+func (r *ProviderRepository) Restore(ctx context.Context, id int, restoredBy *string, scope *dtos.DataScopeDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    args := []interface{}{id, restoredBy}
+    argIndex := 3
+    query := `
+        UPDATE providers SET
+            deleted_at = NULL,
+            deleted_by = NULL,
+            updated_by = $2,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND deleted_at IS NOT NULL
+    ` + scopeRowFilter(r.dialect, scope, &args, &argIndex)
+    result, err := r.db.ExecContext(ctx, query, args...)
+    if err != nil {
+        return fmt.Errorf("failed to restore provider: %w", err)
+    }
+
+    rowsAffected, err := result.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("failed to get rows affected: %w", err)
+    }
+
+    if rowsAffected == 0 {
+        return r.missingRowError(ctx, id, scope, clienterrors.ErrNotDeleted)
     }
 
     return nil
 }
 
-func (r *ProviderRepository) GetProvinces() ([]string, error) {
+// missingRowError explains why a delete or restore did not touch any row: the provider
+// either does not exist in scope, or is already in the requested state (stateErr).
+func (r *ProviderRepository) missingRowError(ctx context.Context, id int, scope *dtos.DataScopeDTO, stateErr error) error {
+    args := []interface{}{id}
+    argIndex := 2
+    query := `SELECT COUNT(*) FROM providers WHERE id = $1` + scopeRowFilter(r.dialect, scope, &args, &argIndex)
+
+    var count int
+    err := r.db.GetContext(ctx, &count, query, args...)
+    if err != nil {
+        return fmt.Errorf("failed to check provider existence: %w", err)
+    }
+    if count == 0 {
+        return clienterrors.ErrProviderDeleted
+    }
+    return stateErr
+}
+
+func (r *ProviderRepository) GetProvinces(ctx context.Context, scope *dtos.DataScopeDTO) ([]string, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var provinces []string
-    query := `SELECT DISTINCT province FROM providers WHERE province IS NOT NULL ORDER BY province`
-    err := r.db.Select(&provinces, query)
+    scopeFilter, args := scopeWhere(r.dialect, scope)
+    query := `SELECT DISTINCT p.province FROM providers p WHERE p.province IS NOT NULL AND p.deleted_at IS NULL` + scopeFilter + ` ORDER BY p.province`
+    err := r.db.SelectContext(ctx, &provinces, query, args...)
     if err != nil {
         return nil, fmt.Errorf("failed to get provinces: %w", err)
     }
     return provinces, nil
 }
 
-func (r *ProviderRepository) GetProviderTypes() ([]string, error) {
+func (r *ProviderRepository) GetProviderTypes(ctx context.Context, scope *dtos.DataScopeDTO) ([]string, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var types []string
-    query := `SELECT DISTINCT provider_type FROM providers WHERE provider_type IS NOT NULL ORDER BY provider_type`
-    err := r.db.Select(&types, query)
+    scopeFilter, args := scopeWhere(r.dialect, scope)
+    query := `SELECT DISTINCT p.provider_type FROM providers p WHERE p.provider_type IS NOT NULL AND p.deleted_at IS NULL` + scopeFilter + ` ORDER BY p.provider_type`
+    err := r.db.SelectContext(ctx, &types, query, args...)
     if err != nil {
         return nil, fmt.Errorf("failed to get provider types: %w", err)
     }
     return types, nil
 }
 
-func (r *ProviderRepository) GetProviderStats() (map[string]interface{}, error) {
+func (r *ProviderRepository) GetProviderStats(ctx context.Context, scope *dtos.DataScopeDTO) (map[string]interface{}, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    scopeFilter, args := scopeWhere(r.dialect, scope)
     query := `
         SELECT 
             COUNT(*) as total_providers,
             COUNT(CASE WHEN provider_type = 'Hospital' THEN 1 END) as total_hospitals,
             COUNT(CASE WHEN provider_type = 'Clinic' THEN 1 END) as total_clinics,
-            COUNT(CASE WHEN is_tpa_network = true THEN 1 END) as tpa_network_providers,
+            COUNT(CASE WHEN is_tpa_network = ` + r.dialect.Bool(true) + ` THEN 1 END) as tpa_network_providers,
             COUNT(CASE WHEN provider_status = 'Active' THEN 1 END) as active_providers,
             COUNT(CASE WHEN provider_status = 'Inactive' THEN 1 END) as inactive_providers
-        FROM providers
-    `
+        FROM providers p
+        WHERE p.deleted_at IS NULL
+    ` + scopeFilter
 
-    row := r.db.QueryRow(query)
+    row := r.db.QueryRowContext(ctx, query, args...)
     
     var totalProviders, totalHospitals, totalClinics, tpaNetworkProviders, activeProviders, inactiveProviders int
     err := row.Scan(&totalProviders, &totalHospitals, &totalClinics, &tpaNetworkProviders, &activeProviders, &inactiveProviders)
@@ -347,56 +610,70 @@ func (r *ProviderRepository) GetProviderStats() (map[string]interface{}, error)
 
 // TemplateRepository handles template data operations
 type TemplateRepository struct {
-    db *sqlx.DB
+    db           *sqlx.DB
+    dialect      sqldialect.Dialect
+    queryTimeout time.Duration
 }
 
-func NewTemplateRepository(db *sqlx.DB) *TemplateRepository {
-    return &TemplateRepository{db: db}
+func NewTemplateRepository(db *sqlx.DB, dialect sqldialect.Dialect, queryTimeout time.Duration) *TemplateRepository {
+    return &TemplateRepository{db: db, dialect: dialect, queryTimeout: queryTimeout}
 }
 
-func (r *TemplateRepository) GetAll() ([]dtos.TemplateDTO, error) {
+func (r *TemplateRepository) GetAll(ctx context.Context) ([]dtos.TemplateDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var templates []dtos.TemplateDTO
-    query := `
+    query := fmt.Sprintf(`
         SELECT * FROM templates 
-        WHERE is_deleted = false 
+        WHERE is_deleted = %s 
         ORDER BY is_standard DESC, created_at DESC
-    `
-    err := r.db.Select(&templates, query)
+    `, r.dialect.Bool(false))
+    err := r.db.SelectContext(ctx, &templates, query)
     if err != nil {
         return nil, fmt.Errorf("failed to get templates: %w", err)
     }
     return templates, nil
 }
 
-func (r *TemplateRepository) GetByID(id int) (*dtos.TemplateDTO, error) {
+func (r *TemplateRepository) GetByID(ctx context.Context, id int) (*dtos.TemplateDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var template dtos.TemplateDTO
-    query := `SELECT * FROM templates WHERE id = $1 AND is_deleted = false`
-    err := r.db.Get(&template, query, id)
+    query := fmt.Sprintf(`SELECT * FROM templates WHERE id = $1 AND is_deleted = %s`, r.dialect.Bool(false))
+    err := r.db.GetContext(ctx, &template, query, id)
     if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, clienterrors.ErrNotFound
+        }
         return nil, fmt.Errorf("failed to get template by ID: %w", err)
     }
     return &template, nil
 }
 
-func (r *TemplateRepository) Create(template *dtos.TemplateDTO) error {
-    query := `
+func (r *TemplateRepository) Create(ctx context.Context, template *dtos.TemplateDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`
         INSERT INTO templates (
             template_name, is_standard, description, header_fields, 
             data_fields, summary_fields, field_positions, created_by
-        ) VALUES (
+        ) %s VALUES (
             :template_name, :is_standard, :description, :header_fields,
             :data_fields, :summary_fields, :field_positions, :created_by
-        ) RETURNING id, created_at, updated_at
-    `
+        ) %s
+    `, r.dialect.Output("id", "created_at", "updated_at", "version"), r.dialect.Returning("id", "created_at", "updated_at", "version"))
 
-    rows, err := r.db.NamedQuery(query, template)
+    rows, err := r.db.NamedQueryContext(ctx, query, template)
     if err != nil {
         return fmt.Errorf("failed to create template: %w", err)
     }
     defer rows.Close()
 
     if rows.Next() {
-        err = rows.Scan(&template.ID, &template.CreatedAt, &template.UpdatedAt)
+        err = rows.Scan(&template.ID, &template.CreatedAt, &template.UpdatedAt, &template.Version)
         if err != nil {
             return fmt.Errorf("failed to scan created template: %w", err)
         }
@@ -405,8 +682,12 @@ func (r *TemplateRepository) Create(template *dtos.TemplateDTO) error {
     return nil
 }
 
-func (r *TemplateRepository) Update(template *dtos.TemplateDTO) error {
-    query := `
+// Update saves the template if its version still matches the stored one
+func (r *TemplateRepository) Update(ctx context.Context, template *dtos.TemplateDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`
         UPDATE templates SET
             template_name = :template_name,
             is_standard = :is_standard,
@@ -416,11 +697,12 @@ func (r *TemplateRepository) Update(template *dtos.TemplateDTO) error {
             summary_fields = :summary_fields,
             field_positions = :field_positions,
             updated_by = :updated_by,
-            updated_at = CURRENT_TIMESTAMP
-        WHERE id = :id AND is_deleted = false
-    `
+            updated_at = CURRENT_TIMESTAMP,
+            version = version + 1
+        WHERE id = :id AND is_deleted = %s AND version = :version
+    `, r.dialect.Bool(false))
 
-    result, err := r.db.NamedExec(query, template)
+    result, err := r.db.NamedExecContext(ctx, query, template)
     if err != nil {
         return fmt.Errorf("failed to update template: %w", err)
     }
@@ -431,15 +713,22 @@ func (r *TemplateRepository) Update(template *dtos.TemplateDTO) error {
     }
 
     if rowsAffected == 0 {
-        return fmt.Errorf("template not found")
+        if _, err := r.GetByID(ctx, template.ID); err != nil {
+            return err
+        }
+        return clienterrors.ErrPreconditionFailed
     }
 
+    template.Version++
     return nil
 }
 
-func (r *TemplateRepository) Delete(id int) error {
-    query := `UPDATE templates SET is_deleted = true, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
-    result, err := r.db.Exec(query, id)
+func (r *TemplateRepository) Delete(ctx context.Context, id int, deletedBy *string) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`UPDATE templates SET is_deleted = %s, updated_by = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, r.dialect.Bool(true))
+    result, err := r.db.ExecContext(ctx, query, id, deletedBy)
     if err != nil {
         return fmt.Errorf("failed to delete template: %w", err)
     }
@@ -458,65 +747,79 @@ func (r *TemplateRepository) Delete(id int) error {
 
 // ScheduleRepository handles schedule data operations
 type ScheduleRepository struct {
-    db *sqlx.DB
+    db           *sqlx.DB
+    dialect      sqldialect.Dialect
+    queryTimeout time.Duration
 }
 
-func NewScheduleRepository(db *sqlx.DB) *ScheduleRepository {
-    return &ScheduleRepository{db: db}
+func NewScheduleRepository(db *sqlx.DB, dialect sqldialect.Dialect, queryTimeout time.Duration) *ScheduleRepository {
+    return &ScheduleRepository{db: db, dialect: dialect, queryTimeout: queryTimeout}
 }
 
-func (r *ScheduleRepository) GetAll() ([]dtos.ScheduleDTO, error) {
+func (r *ScheduleRepository) GetAll(ctx context.Context) ([]dtos.ScheduleDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var schedules []dtos.ScheduleDTO
-    query := `
+    query := fmt.Sprintf(`
         SELECT s.*, t.template_name
         FROM schedules s
         LEFT JOIN templates t ON s.template_id = t.id
-        WHERE s.is_deleted = false
+        WHERE s.is_deleted = %s
         ORDER BY s.created_at DESC
-    `
-    err := r.db.Select(&schedules, query)
+    `, r.dialect.Bool(false))
+    err := r.db.SelectContext(ctx, &schedules, query)
     if err != nil {
         return nil, fmt.Errorf("failed to get schedules: %w", err)
     }
     return schedules, nil
 }
 
-func (r *ScheduleRepository) GetByID(id int) (*dtos.ScheduleDTO, error) {
+func (r *ScheduleRepository) GetByID(ctx context.Context, id int) (*dtos.ScheduleDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var schedule dtos.ScheduleDTO
-    query := `
+    query := fmt.Sprintf(`
         SELECT s.*, t.template_name
         FROM schedules s
         LEFT JOIN templates t ON s.template_id = t.id
-        WHERE s.id = $1 AND s.is_deleted = false
-    `
-    err := r.db.Get(&schedule, query, id)
+        WHERE s.id = $1 AND s.is_deleted = %s
+    `, r.dialect.Bool(false))
+    err := r.db.GetContext(ctx, &schedule, query, id)
     if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, clienterrors.ErrNotFound
+        }
         return nil, fmt.Errorf("failed to get schedule by ID: %w", err)
     }
     return &schedule, nil
 }
 
-func (r *ScheduleRepository) Create(schedule *dtos.ScheduleDTO) error {
-    query := `
+func (r *ScheduleRepository) Create(ctx context.Context, schedule *dtos.ScheduleDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`
         INSERT INTO schedules (
             schedule_name, template_id, email_to, email_cc, email_bcc,
             frequency, schedule_days, start_date, end_date, start_time,
-            timezone, search_criteria, export_format, created_by
-        ) VALUES (
+            timezone, search_criteria, export_format, created_by, owner_role_id
+        ) %s VALUES (
             :schedule_name, :template_id, :email_to, :email_cc, :email_bcc,
             :frequency, :schedule_days, :start_date, :end_date, :start_time,
-            :timezone, :search_criteria, :export_format, :created_by
-        ) RETURNING id, created_at, updated_at
-    `
+            :timezone, :search_criteria, :export_format, :created_by, :owner_role_id
+        ) %s
+    `, r.dialect.Output("id", "created_at", "updated_at", "version"), r.dialect.Returning("id", "created_at", "updated_at", "version"))
 
-    rows, err := r.db.NamedQuery(query, schedule)
+    rows, err := r.db.NamedQueryContext(ctx, query, schedule)
     if err != nil {
         return fmt.Errorf("failed to create schedule: %w", err)
     }
     defer rows.Close()
 
     if rows.Next() {
-        err = rows.Scan(&schedule.ID, &schedule.CreatedAt, &schedule.UpdatedAt)
+        err = rows.Scan(&schedule.ID, &schedule.CreatedAt, &schedule.UpdatedAt, &schedule.Version)
         if err != nil {
             return fmt.Errorf("failed to scan created schedule: %w", err)
         }
@@ -525,8 +828,12 @@ func (r *ScheduleRepository) Create(schedule *dtos.ScheduleDTO) error {
     return nil
 }
 
-func (r *ScheduleRepository) Update(schedule *dtos.ScheduleDTO) error {
-    query := `
+// Update saves the schedule if its version still matches the stored one
+func (r *ScheduleRepository) Update(ctx context.Context, schedule *dtos.ScheduleDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`
         UPDATE schedules SET
             schedule_name = :schedule_name,
             template_id = :template_id,
@@ -543,11 +850,12 @@ func (r *ScheduleRepository) Update(schedule *dtos.ScheduleDTO) error {
             search_criteria = :search_criteria,
             export_format = :export_format,
             updated_by = :updated_by,
-            updated_at = CURRENT_TIMESTAMP
-        WHERE id = :id AND is_deleted = false
-    `
+            updated_at = CURRENT_TIMESTAMP,
+            version = version + 1
+        WHERE id = :id AND is_deleted = %s AND version = :version
+    `, r.dialect.Bool(false))
 
-    result, err := r.db.NamedExec(query, schedule)
+    result, err := r.db.NamedExecContext(ctx, query, schedule)
     if err != nil {
         return fmt.Errorf("failed to update schedule: %w", err)
     }
@@ -558,15 +866,22 @@ func (r *ScheduleRepository) Update(schedule *dtos.ScheduleDTO) error {
     }
 
     if rowsAffected == 0 {
-        return fmt.Errorf("schedule not found")
+        if _, err := r.GetByID(ctx, schedule.ID); err != nil {
+            return err
+        }
+        return clienterrors.ErrPreconditionFailed
     }
 
+    schedule.Version++
     return nil
 }
 
-func (r *ScheduleRepository) Delete(id int) error {
-    query := `UPDATE schedules SET is_deleted = true, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
-    result, err := r.db.Exec(query, id)
+func (r *ScheduleRepository) Delete(ctx context.Context, id int, deletedBy *string) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`UPDATE schedules SET is_deleted = %s, updated_by = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, r.dialect.Bool(true))
+    result, err := r.db.ExecContext(ctx, query, id, deletedBy)
     if err != nil {
         return fmt.Errorf("failed to delete schedule: %w", err)
     }
@@ -583,31 +898,37 @@ func (r *ScheduleRepository) Delete(id int) error {
     return nil
 }
 
-func (r *ScheduleRepository) GetActiveSchedules() ([]dtos.ScheduleDTO, error) {
+func (r *ScheduleRepository) GetActiveSchedules(ctx context.Context) ([]dtos.ScheduleDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var schedules []dtos.ScheduleDTO
-    query := `
+    query := fmt.Sprintf(`
         SELECT s.*, t.template_name
         FROM schedules s
         LEFT JOIN templates t ON s.template_id = t.id
-        WHERE s.is_active = true AND s.is_deleted = false
-        AND (s.end_date IS NULL OR s.end_date >= CURRENT_DATE)
+        WHERE s.is_active = %s AND s.is_deleted = %s
+        AND (s.end_date IS NULL OR s.end_date >= %s)
         ORDER BY s.next_run_at ASC
-    `
-    err := r.db.Select(&schedules, query)
+    `, r.dialect.Bool(true), r.dialect.Bool(false), r.dialect.CurrentDate())
+    err := r.db.SelectContext(ctx, &schedules, query)
     if err != nil {
         return nil, fmt.Errorf("failed to get active schedules: %w", err)
     }
     return schedules, nil
 }
 
-func (r *ScheduleRepository) UpdateLastRun(id int) error {
+func (r *ScheduleRepository) UpdateLastRun(ctx context.Context, id int) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     query := `
         UPDATE schedules SET
             last_run_at = CURRENT_TIMESTAMP,
             updated_at = CURRENT_TIMESTAMP
         WHERE id = $1
     `
-    _, err := r.db.Exec(query, id)
+    _, err := r.db.ExecContext(ctx, query, id)
     if err != nil {
         return fmt.Errorf("failed to update last run: %w", err)
     }
@@ -616,14 +937,19 @@ func (r *ScheduleRepository) UpdateLastRun(id int) error {
 
 // LogRepository handles log data operations
 type LogRepository struct {
-    db *sqlx.DB
+    db           *sqlx.DB
+    dialect      sqldialect.Dialect
+    queryTimeout time.Duration
 }
 
-func NewLogRepository(db *sqlx.DB) *LogRepository {
-    return &LogRepository{db: db}
+func NewLogRepository(db *sqlx.DB, dialect sqldialect.Dialect, queryTimeout time.Duration) *LogRepository {
+    return &LogRepository{db: db, dialect: dialect, queryTimeout: queryTimeout}
 }
 
-func (r *LogRepository) GetSentReportLogs(req dtos.LogSearchRequestDTO) ([]dtos.SentReportLogDTO, int64, error) {
+func (r *LogRepository) GetSentReportLogs(ctx context.Context, req dtos.LogSearchRequestDTO) ([]dtos.SentReportLogDTO, int64, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var conditions []string
     var args []interface{}
     argIndex := 1
@@ -685,7 +1011,7 @@ func (r *LogRepository) GetSentReportLogs(req dtos.LogSearchRequestDTO) ([]dtos.
 
     // Get total count
     var total int64
-    err := r.db.Get(&total, countQuery, args...)
+    err := r.db.GetContext(ctx, &total, countQuery, args...)
     if err != nil {
         return nil, 0, fmt.Errorf("failed to get log count: %w", err)
     }
@@ -699,12 +1025,12 @@ func (r *LogRepository) GetSentReportLogs(req dtos.LogSearchRequestDTO) ([]dtos.
         offset = 0
     }
 
-    baseQuery += fmt.Sprintf(" ORDER BY l.sent_at DESC LIMIT $%d OFFSET $%d", argIndex, argIndex+1)
+    baseQuery += " ORDER BY l.sent_at DESC" + r.dialect.Paginate(fmt.Sprintf("$%d", argIndex), fmt.Sprintf("$%d", argIndex+1))
     args = append(args, req.Limit, offset)
 
     // Execute query
     var logs []dtos.SentReportLogDTO
-    err = r.db.Select(&logs, baseQuery, args...)
+    err = r.db.SelectContext(ctx, &logs, baseQuery, args...)
     if err != nil {
         return nil, 0, fmt.Errorf("failed to get sent report logs: %w", err)
     }
@@ -712,7 +1038,10 @@ func (r *LogRepository) GetSentReportLogs(req dtos.LogSearchRequestDTO) ([]dtos.
     return logs, total, nil
 }
 
-func (r *LogRepository) GetByID(id int) (*dtos.SentReportLogDTO, error) {
+func (r *LogRepository) GetByID(ctx context.Context, id int) (*dtos.SentReportLogDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var log dtos.SentReportLogDTO
     query := `
         SELECT 
@@ -724,27 +1053,35 @@ func (r *LogRepository) GetByID(id int) (*dtos.SentReportLogDTO, error) {
         LEFT JOIN schedules s ON l.schedule_id = s.id
         WHERE l.id = $1
     `
-    err := r.db.Get(&log, query, id)
+    err := r.db.GetContext(ctx, &log, query, id)
     if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, clienterrors.ErrNotFound
+        }
         return nil, fmt.Errorf("failed to get sent report log by ID: %w", err)
     }
     return &log, nil
 }
 
-func (r *LogRepository) Create(log *dtos.SentReportLogDTO) error {
-    query := `
+func (r *LogRepository) Create(ctx context.Context, log *dtos.SentReportLogDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`
         INSERT INTO sent_report_logs (
             template_id, schedule_id, recipients, subject, file_name,
             file_size_kb, export_format, total_records, status,
-            error_message, retry_count, execution_time_ms
-        ) VALUES (
+            error_message, retry_count, execution_time_ms, request_id,
+            next_retry_at, resent_from_id
+        ) %s VALUES (
             :template_id, :schedule_id, :recipients, :subject, :file_name,
             :file_size_kb, :export_format, :total_records, :status,
-            :error_message, :retry_count, :execution_time_ms
-        ) RETURNING id, sent_at
-    `
+            :error_message, :retry_count, :execution_time_ms, :request_id,
+            :next_retry_at, :resent_from_id
+        ) %s
+    `, r.dialect.Output("id", "sent_at"), r.dialect.Returning("id", "sent_at"))
 
-    rows, err := r.db.NamedQuery(query, log)
+    rows, err := r.db.NamedQueryContext(ctx, query, log)
     if err != nil {
         return fmt.Errorf("failed to create sent report log: %w", err)
     }
@@ -760,74 +1097,170 @@ func (r *LogRepository) Create(log *dtos.SentReportLogDTO) error {
     return nil
 }
 
-func (r *LogRepository) UpdateStatus(id int, status string, errorMessage *string) error {
+func (r *LogRepository) UpdateStatus(ctx context.Context, id int, status string, errorMessage *string) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     query := `
         UPDATE sent_report_logs SET
             status = $2,
             error_message = $3
         WHERE id = $1
     `
-    _, err := r.db.Exec(query, id, status, errorMessage)
+    _, err := r.db.ExecContext(ctx, query, id, status, errorMessage)
     if err != nil {
         return fmt.Errorf("failed to update log status: %w", err)
     }
     return nil
 }
 
+func (r *LogRepository) GetDueRetries(ctx context.Context, now time.Time, limit int) ([]dtos.SentReportLogDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := `
+        SELECT 
+            l.*,
+            t.template_name,
+            s.schedule_name
+        FROM sent_report_logs l
+        LEFT JOIN templates t ON l.template_id = t.id
+        LEFT JOIN schedules s ON l.schedule_id = s.id
+        WHERE l.status = 'pending' AND l.next_retry_at <= $1
+        ORDER BY l.next_retry_at` + r.dialect.Paginate("$2", "0")
+
+    var logs []dtos.SentReportLogDTO
+    if err := r.db.SelectContext(ctx, &logs, query, now, limit); err != nil {
+        return nil, fmt.Errorf("failed to get due report retries: %w", err)
+    }
+    return logs, nil
+}
+
+func (r *LogRepository) ClaimAttempt(ctx context.Context, id, retryCount int, leaseUntil time.Time) (bool, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := `
+        UPDATE sent_report_logs SET
+            retry_count = retry_count + 1,
+            next_retry_at = $3
+        WHERE id = $1 AND retry_count = $2
+    `
+    result, err := r.db.ExecContext(ctx, query, id, retryCount, leaseUntil)
+    if err != nil {
+        return false, fmt.Errorf("failed to claim report attempt: %w", err)
+    }
+    affected, err := result.RowsAffected()
+    if err != nil {
+        return false, fmt.Errorf("failed to claim report attempt: %w", err)
+    }
+    return affected == 1, nil
+}
+
+func (r *LogRepository) UpdateAttempt(ctx context.Context, log *dtos.SentReportLogDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := `
+        UPDATE sent_report_logs SET
+            status = :status,
+            error_message = :error_message,
+            next_retry_at = :next_retry_at,
+            file_name = :file_name,
+            file_size_kb = :file_size_kb,
+            total_records = :total_records,
+            execution_time_ms = :execution_time_ms,
+            request_id = :request_id
+        WHERE id = :id
+    `
+    if _, err := r.db.NamedExecContext(ctx, query, log); err != nil {
+        return fmt.Errorf("failed to update report attempt: %w", err)
+    }
+    return nil
+}
+
 // FieldRepository handles field data operations
 type FieldRepository struct {
-    db *sqlx.DB
+    db           *sqlx.DB
+    dialect      sqldialect.Dialect
+    queryTimeout time.Duration
 }
 
-func NewFieldRepository(db *sqlx.DB) *FieldRepository {
-    return &FieldRepository{db: db}
+func NewFieldRepository(db *sqlx.DB, dialect sqldialect.Dialect, queryTimeout time.Duration) *FieldRepository {
+    return &FieldRepository{db: db, dialect: dialect, queryTimeout: queryTimeout}
 }
 
-func (r *FieldRepository) GetAllFields() ([]dtos.AvailableFieldDTO, error) {
+func (r *FieldRepository) GetAllFields(ctx context.Context) ([]dtos.AvailableFieldDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var fields []dtos.AvailableFieldDTO
-    query := `
+    query := fmt.Sprintf(`
         SELECT * FROM available_fields 
-        WHERE is_active = true 
+        WHERE is_active = %s 
         ORDER BY field_category, sort_order
-    `
-    err := r.db.Select(&fields, query)
+    `, r.dialect.Bool(true))
+    err := r.db.SelectContext(ctx, &fields, query)
     if err != nil {
         return nil, fmt.Errorf("failed to get available fields: %w", err)
     }
     return fields, nil
 }
 
-func (r *FieldRepository) GetFieldsByCategory(category string) ([]dtos.AvailableFieldDTO, error) {
+func (r *FieldRepository) GetFieldsByCategory(ctx context.Context, category string) ([]dtos.AvailableFieldDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var fields []dtos.AvailableFieldDTO
-    query := `
+    query := fmt.Sprintf(`
         SELECT * FROM available_fields 
-        WHERE field_category = $1 AND is_active = true 
+        WHERE field_category = $1 AND is_active = %s 
         ORDER BY sort_order
-    `
-    err := r.db.Select(&fields, query, category)
+    `, r.dialect.Bool(true))
+    err := r.db.SelectContext(ctx, &fields, query, category)
     if err != nil {
         return nil, fmt.Errorf("failed to get fields by category: %w", err)
     }
     return fields, nil
 }
 
-func (r *FieldRepository) GetFieldByCode(fieldCode string) (*dtos.AvailableFieldDTO, error) {
+// GetSensitiveFields returns the fields that are masked for users without access to sensitive data
+func (r *FieldRepository) GetSensitiveFields(ctx context.Context) ([]dtos.AvailableFieldDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    var fields []dtos.AvailableFieldDTO
+    query := `SELECT * FROM available_fields WHERE sensitivity = $1`
+    err := r.db.SelectContext(ctx, &fields, query, dtos.FieldSensitivitySensitive)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get sensitive fields: %w", err)
+    }
+    return fields, nil
+}
+
+func (r *FieldRepository) GetFieldByCode(ctx context.Context, fieldCode string) (*dtos.AvailableFieldDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var field dtos.AvailableFieldDTO
-    query := `SELECT * FROM available_fields WHERE field_code = $1 AND is_active = true`
-    err := r.db.Get(&field, query, fieldCode)
+    query := fmt.Sprintf(`SELECT * FROM available_fields WHERE field_code = $1 AND is_active = %s`, r.dialect.Bool(true))
+    err := r.db.GetContext(ctx, &field, query, fieldCode)
     if err != nil {
         return nil, fmt.Errorf("failed to get field by code: %w", err)
     }
     return &field, nil
 }
 
-func (r *FieldRepository) ValidateFields(fieldCodes []string) ([]dtos.FieldValidationDTO, error) {
+func (r *FieldRepository) ValidateFields(ctx context.Context, fieldCodes []string) ([]dtos.FieldValidationDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var results []dtos.FieldValidationDTO
     
     for _, code := range fieldCodes {
         var count int
-        query := `SELECT COUNT(*) FROM available_fields WHERE field_code = $1 AND is_active = true`
-        err := r.db.Get(&count, query, code)
+        query := fmt.Sprintf(`SELECT COUNT(*) FROM available_fields WHERE field_code = $1 AND is_active = %s`, r.dialect.Bool(true))
+        err := r.db.GetContext(ctx, &count, query, code)
         if err != nil {
             results = append(results, dtos.FieldValidationDTO{
                 FieldCode: code,
@@ -855,35 +1288,41 @@ func (r *FieldRepository) ValidateFields(fieldCodes []string) ([]dtos.FieldValid
     return results, nil
 }
 
-func (r *FieldRepository) GetFieldCategories() ([]string, error) {
+func (r *FieldRepository) GetFieldCategories(ctx context.Context) ([]string, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var categories []string
-    query := `
+    query := fmt.Sprintf(`
         SELECT DISTINCT field_category 
         FROM available_fields 
-        WHERE is_active = true 
+        WHERE is_active = %s 
         ORDER BY field_category
-    `
-    err := r.db.Select(&categories, query)
+    `, r.dialect.Bool(true))
+    err := r.db.SelectContext(ctx, &categories, query)
     if err != nil {
         return nil, fmt.Errorf("failed to get field categories: %w", err)
     }
     return categories, nil
 }
 
-func (r *FieldRepository) CreateField(field *dtos.AvailableFieldDTO) error {
-    query := `
+func (r *FieldRepository) CreateField(ctx context.Context, field *dtos.AvailableFieldDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`
         INSERT INTO available_fields (
             field_code, field_name_thai, field_name_eng, field_type,
             field_category, data_source, format_example, is_required,
             sort_order, description
-        ) VALUES (
+        ) %s VALUES (
             :field_code, :field_name_thai, :field_name_eng, :field_type,
             :field_category, :data_source, :format_example, :is_required,
             :sort_order, :description
-        ) RETURNING id
-    `
+        ) %s
+    `, r.dialect.Output("id"), r.dialect.Returning("id"))
 
-    rows, err := r.db.NamedQuery(query, field)
+    rows, err := r.db.NamedQueryContext(ctx, query, field)
     if err != nil {
         return fmt.Errorf("failed to create field: %w", err)
     }
@@ -899,7 +1338,10 @@ func (r *FieldRepository) CreateField(field *dtos.AvailableFieldDTO) error {
     return nil
 }
 
-func (r *FieldRepository) UpdateField(field *dtos.AvailableFieldDTO) error {
+func (r *FieldRepository) UpdateField(ctx context.Context, field *dtos.AvailableFieldDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     query := `
         UPDATE available_fields SET
             field_name_thai = :field_name_thai,
@@ -915,7 +1357,7 @@ func (r *FieldRepository) UpdateField(field *dtos.AvailableFieldDTO) error {
         WHERE id = :id
     `
 
-    result, err := r.db.NamedExec(query, field)
+    result, err := r.db.NamedExecContext(ctx, query, field)
     if err != nil {
         return fmt.Errorf("failed to update field: %w", err)
     }
@@ -932,9 +1374,12 @@ func (r *FieldRepository) UpdateField(field *dtos.AvailableFieldDTO) error {
     return nil
 }
 
-func (r *FieldRepository) DeleteField(id int) error {
-    query := `UPDATE available_fields SET is_active = false WHERE id = $1`
-    result, err := r.db.Exec(query, id)
+func (r *FieldRepository) DeleteField(ctx context.Context, id int) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`UPDATE available_fields SET is_active = %s WHERE id = $1`, r.dialect.Bool(false))
+    result, err := r.db.ExecContext(ctx, query, id)
     if err != nil {
         return fmt.Errorf("failed to delete field: %w", err)
     }
@@ -951,45 +1396,57 @@ func (r *FieldRepository) DeleteField(id int) error {
     return nil
 }
 
-func (r *FieldRepository) ExistsFieldCode(code string) (bool, error) {
+func (r *FieldRepository) ExistsFieldCode(ctx context.Context, code string) (bool, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var count int
     query := `SELECT COUNT(*) FROM available_fields WHERE field_code = $1`
-    err := r.db.Get(&count, query, code)
+    err := r.db.GetContext(ctx, &count, query, code)
     if err != nil {
         return false, fmt.Errorf("failed to check field code existence: %w", err)
     }
     return count > 0, nil
 }
 
-func (r *FieldRepository) GetRequiredFields() ([]dtos.AvailableFieldDTO, error) {
+func (r *FieldRepository) GetRequiredFields(ctx context.Context) ([]dtos.AvailableFieldDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var fields []dtos.AvailableFieldDTO
-    query := `
+    query := fmt.Sprintf(`
         SELECT * FROM available_fields 
-        WHERE is_required = true AND is_active = true 
+        WHERE is_required = %s AND is_active = %s 
         ORDER BY field_category, sort_order
-    `
-    err := r.db.Select(&fields, query)
+    `, r.dialect.Bool(true), r.dialect.Bool(true))
+    err := r.db.SelectContext(ctx, &fields, query)
     if err != nil {
         return nil, fmt.Errorf("failed to get required fields: %w", err)
     }
     return fields, nil
 }
 
-func (r *FieldRepository) GetFieldsByType(fieldType string) ([]dtos.AvailableFieldDTO, error) {
+func (r *FieldRepository) GetFieldsByType(ctx context.Context, fieldType string) ([]dtos.AvailableFieldDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     var fields []dtos.AvailableFieldDTO
-    query := `
+    query := fmt.Sprintf(`
         SELECT * FROM available_fields 
-        WHERE field_type = $1 AND is_active = true 
+        WHERE field_type = $1 AND is_active = %s 
         ORDER BY field_category, sort_order
-    `
-    err := r.db.Select(&fields, query, fieldType)
+    `, r.dialect.Bool(true))
+    err := r.db.SelectContext(ctx, &fields, query, fieldType)
     if err != nil {
         return nil, fmt.Errorf("failed to get fields by type: %w", err)
     }
     return fields, nil
 }
 
-func (r *FieldRepository) GetFieldsForExport(fieldCodes []string) ([]dtos.AvailableFieldDTO, error) {
+func (r *FieldRepository) GetFieldsForExport(ctx context.Context, fieldCodes []string) ([]dtos.AvailableFieldDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
     if len(fieldCodes) == 0 {
         return []dtos.AvailableFieldDTO{}, nil
     }
@@ -1004,14 +1461,534 @@ func (r *FieldRepository) GetFieldsForExport(fieldCodes []string) ([]dtos.Availa
 
     query := fmt.Sprintf(`
         SELECT * FROM available_fields 
-        WHERE field_code IN (%s) AND is_active = true 
+        WHERE field_code IN (%s) AND is_active = %s 
         ORDER BY field_category, sort_order
-    `, strings.Join(placeholders, ","))
+    `, strings.Join(placeholders, ","), r.dialect.Bool(true))
 
     var fields []dtos.AvailableFieldDTO
-    err := r.db.Select(&fields, query, args...)
+    err := r.db.SelectContext(ctx, &fields, query, args...)
     if err != nil {
         return nil, fmt.Errorf("failed to get fields for export: %w", err)
     }
     return fields, nil
-}
\ No newline at end of file
+}
+// SavedSearchRepository handles saved search data operations
+type SavedSearchRepository struct {
+    db           *sqlx.DB
+    dialect      sqldialect.Dialect
+    queryTimeout time.Duration
+}
+
+func NewSavedSearchRepository(db *sqlx.DB, dialect sqldialect.Dialect, queryTimeout time.Duration) *SavedSearchRepository {
+    return &SavedSearchRepository{db: db, dialect: dialect, queryTimeout: queryTimeout}
+}
+
+func (r *SavedSearchRepository) GetByUsername(ctx context.Context, username string) ([]dtos.SavedSearchDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    var searches []dtos.SavedSearchDTO
+    query := `
+        SELECT * FROM saved_searches
+        WHERE username = $1
+        ORDER BY is_default DESC, search_name
+    `
+    err := r.db.SelectContext(ctx, &searches, query, username)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get saved searches: %w", err)
+    }
+    return searches, nil
+}
+
+func (r *SavedSearchRepository) GetByID(ctx context.Context, id int, username string) (*dtos.SavedSearchDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    var search dtos.SavedSearchDTO
+    query := `SELECT * FROM saved_searches WHERE id = $1 AND username = $2`
+    err := r.db.GetContext(ctx, &search, query, id, username)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, clienterrors.ErrNotFound
+        }
+        return nil, fmt.Errorf("failed to get saved search by ID: %w", err)
+    }
+    return &search, nil
+}
+
+func (r *SavedSearchRepository) Create(ctx context.Context, search *dtos.SavedSearchDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`
+        INSERT INTO saved_searches (
+            username, search_name, search_criteria, template_id, format_type, is_default
+        ) %s VALUES (
+            :username, :search_name, :search_criteria, :template_id, :format_type, :is_default
+        ) %s
+    `, r.dialect.Output("id", "created_at", "updated_at"), r.dialect.Returning("id", "created_at", "updated_at"))
+
+    rows, err := r.db.NamedQueryContext(ctx, query, search)
+    if err != nil {
+        return fmt.Errorf("failed to create saved search: %w", err)
+    }
+    defer rows.Close()
+
+    if rows.Next() {
+        err = rows.Scan(&search.ID, &search.CreatedAt, &search.UpdatedAt)
+        if err != nil {
+            return fmt.Errorf("failed to scan created saved search: %w", err)
+        }
+    }
+
+    return nil
+}
+
+func (r *SavedSearchRepository) Update(ctx context.Context, search *dtos.SavedSearchDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := `
+        UPDATE saved_searches SET
+            search_name = :search_name,
+            search_criteria = :search_criteria,
+            template_id = :template_id,
+            format_type = :format_type,
+            is_default = :is_default,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = :id AND username = :username
+    `
+
+    result, err := r.db.NamedExecContext(ctx, query, search)
+    if err != nil {
+        return fmt.Errorf("failed to update saved search: %w", err)
+    }
+
+    rowsAffected, err := result.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("failed to get rows affected: %w", err)
+    }
+
+    if rowsAffected == 0 {
+        return clienterrors.ErrNotFound
+    }
+
+    return nil
+}
+
+func (r *SavedSearchRepository) Delete(ctx context.Context, id int, username string) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := `DELETE FROM saved_searches WHERE id = $1 AND username = $2`
+    result, err := r.db.ExecContext(ctx, query, id, username)
+    if err != nil {
+        return fmt.Errorf("failed to delete saved search: %w", err)
+    }
+
+    rowsAffected, err := result.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("failed to get rows affected: %w", err)
+    }
+
+    if rowsAffected == 0 {
+        return clienterrors.ErrNotFound
+    }
+
+    return nil
+}
+
+// ClearDefault unsets the default flag on every saved search of the user except the given one
+func (r *SavedSearchRepository) ClearDefault(ctx context.Context, username string, exceptID int) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`UPDATE saved_searches SET is_default = %s WHERE username = $1 AND id <> $2 AND is_default = %s`, r.dialect.Bool(false), r.dialect.Bool(true))
+    _, err := r.db.ExecContext(ctx, query, username, exceptID)
+    if err != nil {
+        return fmt.Errorf("failed to clear default saved search: %w", err)
+    }
+    return nil
+}
+
+// HistoryRepository handles change history data operations
+type HistoryRepository struct {
+    db           *sqlx.DB
+    dialect      sqldialect.Dialect
+    queryTimeout time.Duration
+}
+
+func NewHistoryRepository(db *sqlx.DB, dialect sqldialect.Dialect, queryTimeout time.Duration) *HistoryRepository {
+    return &HistoryRepository{db: db, dialect: dialect, queryTimeout: queryTimeout}
+}
+
+// Create inserts all rows of one change in a single statement
+func (r *HistoryRepository) Create(ctx context.Context, entries []dtos.ChangeHistoryDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    if len(entries) == 0 {
+        return nil
+    }
+
+    query := `
+        INSERT INTO change_history (
+            change_id, entity_type, entity_id, action, field_name, old_value, new_value, changed_by
+        ) VALUES (
+            :change_id, :entity_type, :entity_id, :action, :field_name, :old_value, :new_value, :changed_by
+        )
+    `
+
+    _, err := r.db.NamedExecContext(ctx, query, entries)
+    if err != nil {
+        return fmt.Errorf("failed to create change history: %w", err)
+    }
+    return nil
+}
+
+func (r *HistoryRepository) Search(ctx context.Context, req dtos.HistorySearchRequestDTO) ([]dtos.ChangeHistoryDTO, int64, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    var conditions []string
+    var args []interface{}
+    argIndex := 1
+
+    baseQuery := `SELECT * FROM change_history WHERE 1=1`
+    countQuery := `SELECT COUNT(*) FROM change_history WHERE 1=1`
+
+    if req.EntityType != "" {
+        conditions = append(conditions, fmt.Sprintf("entity_type = $%d", argIndex))
+        args = append(args, req.EntityType)
+        argIndex++
+    }
+
+    if req.EntityID != nil {
+        conditions = append(conditions, fmt.Sprintf("entity_id = $%d", argIndex))
+        args = append(args, *req.EntityID)
+        argIndex++
+    }
+
+    if req.Action != "" {
+        conditions = append(conditions, fmt.Sprintf("action = $%d", argIndex))
+        args = append(args, req.Action)
+        argIndex++
+    }
+
+    if req.FieldName != "" {
+        conditions = append(conditions, fmt.Sprintf("field_name = $%d", argIndex))
+        args = append(args, req.FieldName)
+        argIndex++
+    }
+
+    if req.ChangedBy != "" {
+        conditions = append(conditions, fmt.Sprintf("changed_by = $%d", argIndex))
+        args = append(args, req.ChangedBy)
+        argIndex++
+    }
+
+    if req.DateFrom != nil {
+        conditions = append(conditions, fmt.Sprintf("changed_at >= $%d", argIndex))
+        args = append(args, req.DateFrom.Format("2006-01-02"))
+        argIndex++
+    }
+
+    if req.DateTo != nil {
+        conditions = append(conditions, fmt.Sprintf("changed_at <= $%d", argIndex))
+        args = append(args, req.DateTo.Format("2006-01-02 23:59:59"))
+        argIndex++
+    }
+
+    if len(conditions) > 0 {
+        conditionStr := " AND " + strings.Join(conditions, " AND ")
+        baseQuery += conditionStr
+        countQuery += conditionStr
+    }
+
+    var total int64
+    err := r.db.GetContext(ctx, &total, countQuery, args...)
+    if err != nil {
+        return nil, 0, fmt.Errorf("failed to get change history count: %w", err)
+    }
+
+    if req.Limit == 0 {
+        req.Limit = 10
+    }
+    offset := (req.Page - 1) * req.Limit
+    if offset < 0 {
+        offset = 0
+    }
+
+    baseQuery += " ORDER BY changed_at DESC, id" + r.dialect.Paginate(fmt.Sprintf("$%d", argIndex), fmt.Sprintf("$%d", argIndex+1))
+    args = append(args, req.Limit, offset)
+
+    var history []dtos.ChangeHistoryDTO
+    err = r.db.SelectContext(ctx, &history, baseQuery, args...)
+    if err != nil {
+        return nil, 0, fmt.Errorf("failed to get change history: %w", err)
+    }
+
+    return history, total, nil
+}
+
+// DataScopeRepository handles the row-level data scopes of user roles
+type DataScopeRepository struct {
+    db           *sqlx.DB
+    dialect      sqldialect.Dialect
+    queryTimeout time.Duration
+}
+
+func NewDataScopeRepository(db *sqlx.DB, dialect sqldialect.Dialect, queryTimeout time.Duration) *DataScopeRepository {
+    return &DataScopeRepository{db: db, dialect: dialect, queryTimeout: queryTimeout}
+}
+
+// GetByRoleID returns the data scope of a user role, or nil when the role is not restricted
+func (r *DataScopeRepository) GetByRoleID(ctx context.Context, userRoleID int) (*dtos.DataScopeDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    var scope dtos.DataScopeDTO
+    query := `
+        SELECT user_role_id, regions, provinces, provider_types, tpa_network_only
+        FROM report_data_scopes
+        WHERE user_role_id = $1
+    `
+    err := r.db.GetContext(ctx, &scope, query, userRoleID)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("failed to get data scope: %w", err)
+    }
+    return &scope, nil
+}
+
+// APIKeyRepository handles API key data operations
+type APIKeyRepository struct {
+    db           *sqlx.DB
+    dialect      sqldialect.Dialect
+    queryTimeout time.Duration
+}
+
+func NewAPIKeyRepository(db *sqlx.DB, dialect sqldialect.Dialect, queryTimeout time.Duration) *APIKeyRepository {
+    return &APIKeyRepository{db: db, dialect: dialect, queryTimeout: queryTimeout}
+}
+
+func (r *APIKeyRepository) GetAll(ctx context.Context) ([]dtos.APIKeyDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    var keys []dtos.APIKeyDTO
+    query := `SELECT * FROM api_keys ORDER BY created_at DESC`
+    err := r.db.SelectContext(ctx, &keys, query)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get API keys: %w", err)
+    }
+    return keys, nil
+}
+
+func (r *APIKeyRepository) GetByID(ctx context.Context, id int) (*dtos.APIKeyDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    var key dtos.APIKeyDTO
+    query := `SELECT * FROM api_keys WHERE id = $1`
+    err := r.db.GetContext(ctx, &key, query, id)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, clienterrors.ErrNotFound
+        }
+        return nil, fmt.Errorf("failed to get API key by ID: %w", err)
+    }
+    return &key, nil
+}
+
+func (r *APIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*dtos.APIKeyDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    var key dtos.APIKeyDTO
+    query := `SELECT * FROM api_keys WHERE key_prefix = $1`
+    err := r.db.GetContext(ctx, &key, query, prefix)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, clienterrors.ErrNotFound
+        }
+        return nil, fmt.Errorf("failed to get API key by prefix: %w", err)
+    }
+    return &key, nil
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *dtos.APIKeyDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := fmt.Sprintf(`
+        INSERT INTO api_keys (
+            name, key_prefix, key_hash, scopes, user_role_id, expires_at, created_by
+        ) %s VALUES (
+            :name, :key_prefix, :key_hash, :scopes, :user_role_id, :expires_at, :created_by
+        ) %s
+    `, r.dialect.Output("id", "created_at", "updated_at"), r.dialect.Returning("id", "created_at", "updated_at"))
+
+    rows, err := r.db.NamedQueryContext(ctx, query, key)
+    if err != nil {
+        return fmt.Errorf("failed to create API key: %w", err)
+    }
+    defer rows.Close()
+
+    if rows.Next() {
+        err = rows.Scan(&key.ID, &key.CreatedAt, &key.UpdatedAt)
+        if err != nil {
+            return fmt.Errorf("failed to scan created API key: %w", err)
+        }
+    }
+
+    return nil
+}
+
+// Revoke disables a key. Revoking an already revoked key is an error.
+func (r *APIKeyRepository) Revoke(ctx context.Context, id int, revokedBy string) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := `
+        UPDATE api_keys
+        SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $2, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND revoked_at IS NULL
+    `
+    result, err := r.db.ExecContext(ctx, query, id, revokedBy)
+    if err != nil {
+        return fmt.Errorf("failed to revoke API key: %w", err)
+    }
+
+    rowsAffected, err := result.RowsAffected()
+    if err != nil {
+        return fmt.Errorf("failed to get rows affected: %w", err)
+    }
+
+    if rowsAffected == 0 {
+        var count int
+        if err := r.db.GetContext(ctx, &count, `SELECT COUNT(*) FROM api_keys WHERE id = $1`, id); err != nil {
+            return fmt.Errorf("failed to check API key: %w", err)
+        }
+        if count == 0 {
+            return clienterrors.ErrNotFound
+        }
+        return clienterrors.ErrAlreadyDeleted
+    }
+
+    return nil
+}
+
+// RecordUsage stores a request made with a key and updates its usage counters
+func (r *APIKeyRepository) RecordUsage(ctx context.Context, usage *dtos.APIKeyUsageDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    tx, err := r.db.BeginTxx(ctx, nil)
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    query := `
+        INSERT INTO api_key_usage (api_key_id, method, path, status, client_ip)
+        VALUES (:api_key_id, :method, :path, :status, :client_ip)
+    `
+    if _, err := tx.NamedExecContext(ctx, query, usage); err != nil {
+        return fmt.Errorf("failed to record API key usage: %w", err)
+    }
+
+    query = `UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, usage_count = usage_count + 1 WHERE id = $1`
+    if _, err := tx.ExecContext(ctx, query, usage.APIKeyID); err != nil {
+        return fmt.Errorf("failed to update API key usage: %w", err)
+    }
+
+    return tx.Commit()
+}
+
+// GetUsage returns the latest requests made with a key, newest first
+func (r *APIKeyRepository) GetUsage(ctx context.Context, id int, limit int) ([]dtos.APIKeyUsageDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    var usage []dtos.APIKeyUsageDTO
+    query := `
+        SELECT * FROM api_key_usage
+        WHERE api_key_id = $1
+        ORDER BY used_at DESC
+    ` + r.dialect.Paginate("$2", "0")
+    err := r.db.SelectContext(ctx, &usage, query, id, limit)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get API key usage: %w", err)
+    }
+    return usage, nil
+}
+
+// ExportQuotaRepository handles the daily export quotas of roles and the export usage of users
+type ExportQuotaRepository struct {
+    db           *sqlx.DB
+    dialect      sqldialect.Dialect
+    queryTimeout time.Duration
+}
+
+func NewExportQuotaRepository(db *sqlx.DB, dialect sqldialect.Dialect, queryTimeout time.Duration) *ExportQuotaRepository {
+    return &ExportQuotaRepository{db: db, dialect: dialect, queryTimeout: queryTimeout}
+}
+
+// GetByRoleID returns the export quota of a user role, or nil when the role has none
+func (r *ExportQuotaRepository) GetByRoleID(ctx context.Context, userRoleID int) (*dtos.ExportQuotaDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    var quota dtos.ExportQuotaDTO
+    query := `
+        SELECT user_role_id, daily_row_limit, daily_byte_limit
+        FROM export_quotas
+        WHERE user_role_id = $1
+    `
+    err := r.db.GetContext(ctx, &quota, query, userRoleID)
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("failed to get export quota: %w", err)
+    }
+    return &quota, nil
+}
+
+// GetUsage returns what a user exported on day, zero when nothing was exported yet
+func (r *ExportQuotaRepository) GetUsage(ctx context.Context, username string, day time.Time) (*dtos.ExportUsageDTO, error) {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    usage := dtos.ExportUsageDTO{Username: username, UsageDate: day}
+    query := `
+        SELECT username, usage_date, row_count, byte_count
+        FROM export_usage
+        WHERE username = $1 AND usage_date = $2
+    `
+    err := r.db.GetContext(ctx, &usage, query, username, day)
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        return nil, fmt.Errorf("failed to get export usage: %w", err)
+    }
+    return &usage, nil
+}
+
+// AddUsage adds an export to the usage of the user on usage.UsageDate
+func (r *ExportQuotaRepository) AddUsage(ctx context.Context, usage *dtos.ExportUsageDTO) error {
+    ctx, cancel := withQueryTimeout(ctx, r.queryTimeout)
+    defer cancel()
+
+    query := r.dialect.Upsert(sqldialect.Upsert{
+        Table:     "export_usage",
+        Columns:   []string{"username", "usage_date", "row_count", "byte_count"},
+        Keys:      []string{"username", "usage_date"},
+        Increment: []string{"row_count", "byte_count"},
+    })
+    if _, err := r.db.NamedExecContext(ctx, query, usage); err != nil {
+        return fmt.Errorf("failed to record export usage: %w", err)
+    }
+    return nil
+}
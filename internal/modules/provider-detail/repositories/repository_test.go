@@ -0,0 +1,111 @@
+package repositories
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/DATA-DOG/go-sqlmock"
+    "github.com/jmoiron/sqlx"
+    clienterrors "provider-report-api/constant/errors"
+    "provider-report-api/internal/modules/provider-detail/dtos"
+    "provider-report-api/pkg/sqldialect"
+)
+
+// testDrivers are the database drivers repositories are tested with
+var testDrivers = []string{"postgres", "mssql"}
+
+// newMockDB returns a mocked database for driver and the driver's dialect
+func newMockDB(t *testing.T, driver string) (*sqlx.DB, sqldialect.Dialect, sqlmock.Sqlmock) {
+    t.Helper()
+    db, mock, err := sqlmock.New()
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        if err := mock.ExpectationsWereMet(); err != nil {
+            t.Error(err)
+        }
+        db.Close()
+    })
+
+    dialect, err := sqldialect.New(driver)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return sqlx.NewDb(db, driver), dialect, mock
+}
+
+// versionedRow is what GetByID finds when an update matched no row: the row at its current version
+func versionedRow(id, version int) *sqlmock.Rows {
+    return sqlmock.NewRows([]string{"id", "version"}).AddRow(id, version)
+}
+
+// versionGuardTests runs update for each driver against the outcomes of its versioned UPDATE:
+// the row matched, the row exists at another version, and the row is gone
+func versionGuardTests(t *testing.T, table, selectQuery string, notFound error, update func(db *sqlx.DB, dialect sqldialect.Dialect, version int) (int, error)) {
+    // sqlx binds named parameters as $n for postgres and ? for the mssql driver
+    updateQuery := `UPDATE ` + table + ` SET .* WHERE id = (\$\d+|\?) AND .*version = (\$\d+|\?)`
+
+    for _, driver := range testDrivers {
+        t.Run(driver+"/current version", func(t *testing.T) {
+            db, dialect, mock := newMockDB(t, driver)
+            mock.ExpectExec(updateQuery).WillReturnResult(sqlmock.NewResult(0, 1))
+            version, err := update(db, dialect, 3)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if version != 4 {
+                t.Errorf("version = %d after update, want 4", version)
+            }
+        })
+
+        t.Run(driver+"/stale version", func(t *testing.T) {
+            db, dialect, mock := newMockDB(t, driver)
+            // Another request updated the row first, so the versioned UPDATE matches nothing
+            mock.ExpectExec(updateQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+            mock.ExpectQuery(selectQuery).WithArgs(7).WillReturnRows(versionedRow(7, 4))
+            version, err := update(db, dialect, 3)
+            if !errors.Is(err, clienterrors.ErrPreconditionFailed) {
+                t.Fatalf("update = %v, want ErrPreconditionFailed", err)
+            }
+            if version != 3 {
+                t.Errorf("version = %d after a failed update, want it unchanged", version)
+            }
+        })
+
+        t.Run(driver+"/deleted", func(t *testing.T) {
+            db, dialect, mock := newMockDB(t, driver)
+            mock.ExpectExec(updateQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+            mock.ExpectQuery(selectQuery).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"id", "version"}))
+            if _, err := update(db, dialect, 3); !errors.Is(err, notFound) {
+                t.Fatalf("update = %v, want %v", err, notFound)
+            }
+        })
+    }
+}
+
+func TestProviderUpdateChecksVersion(t *testing.T) {
+    versionGuardTests(t, "providers", `SELECT p\.\* FROM providers p WHERE p\.id = \$1`, clienterrors.ErrProviderDeleted, func(db *sqlx.DB, dialect sqldialect.Dialect, version int) (int, error) {
+        provider := &dtos.ProviderDTO{ID: 7, ProviderCode: "P001", NameThai: "โรงพยาบาลทดสอบ", Version: version}
+        err := NewProviderRepository(db, dialect, time.Second).Update(context.Background(), provider, nil)
+        return provider.Version, err
+    })
+}
+
+func TestTemplateUpdateChecksVersion(t *testing.T) {
+    versionGuardTests(t, "templates", `SELECT \* FROM templates WHERE id = \$1`, clienterrors.ErrNotFound, func(db *sqlx.DB, dialect sqldialect.Dialect, version int) (int, error) {
+        template := &dtos.TemplateDTO{ID: 7, TemplateName: "Providers", Version: version}
+        err := NewTemplateRepository(db, dialect, time.Second).Update(context.Background(), template)
+        return template.Version, err
+    })
+}
+
+func TestScheduleUpdateChecksVersion(t *testing.T) {
+    versionGuardTests(t, "schedules", `FROM schedules s .* WHERE s.id = \$1`, clienterrors.ErrNotFound, func(db *sqlx.DB, dialect sqldialect.Dialect, version int) (int, error) {
+        schedule := &dtos.ScheduleDTO{ID: 7, ScheduleName: "Weekly providers", TemplateID: 1, Version: version}
+        err := NewScheduleRepository(db, dialect, time.Second).Update(context.Background(), schedule)
+        return schedule.Version, err
+    })
+}
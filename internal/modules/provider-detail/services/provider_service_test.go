@@ -0,0 +1,393 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "testing"
+
+    "github.com/gin-gonic/gin/binding"
+    "github.com/go-playground/validator/v10"
+    clienterrors "provider-report-api/constant/errors"
+    "provider-report-api/internal/modules/provider-detail/dtos"
+    "provider-report-api/internal/modules/provider-detail/repositories/memory"
+    "provider-report-api/pkg/utility"
+)
+
+// roleBangkok is a user role whose data scope is limited to Bangkok
+const roleBangkok = 5
+
+var registerValidations sync.Once
+
+// sensitiveFieldRepository reports the email field as sensitive, which the memory field
+// repository has no way to store
+type sensitiveFieldRepository struct {
+    *memory.FieldRepository
+}
+
+func (r sensitiveFieldRepository) GetSensitiveFields(ctx context.Context) ([]dtos.AvailableFieldDTO, error) {
+    maskType := utility.MaskTypeEmail
+    return []dtos.AvailableFieldDTO{{FieldCode: "email", Sensitivity: dtos.FieldSensitivitySensitive, MaskType: &maskType}}, nil
+}
+
+type providerTestEnv struct {
+    db           *memory.DB
+    providerRepo *memory.ProviderRepository
+    fieldRepo    *memory.FieldRepository
+    templateRepo *memory.TemplateRepository
+    history      *HistoryService
+    service      *ProviderService
+}
+
+func newProviderTestEnv(t *testing.T) *providerTestEnv {
+    t.Helper()
+    registerValidations.Do(func() {
+        if err := dtos.RegisterProviderValidations(binding.Validator.Engine().(*validator.Validate)); err != nil {
+            t.Fatal(err)
+        }
+    })
+    db := memory.NewDB()
+    db.SetDataScope(dtos.DataScopeDTO{UserRoleID: roleBangkok, Provinces: dtos.JSONStringArray{"Bangkok"}})
+
+    env := &providerTestEnv{
+        db:           db,
+        providerRepo: memory.NewProviderRepository(db),
+        fieldRepo:    memory.NewFieldRepository(db),
+        templateRepo: memory.NewTemplateRepository(db),
+    }
+    fields := sensitiveFieldRepository{env.fieldRepo}
+    env.history = NewHistoryService(memory.NewHistoryRepository(db), fields)
+    env.service = NewProviderService(env.providerRepo, NewExportService(), fields, env.templateRepo, env.history, memory.NewDataScopeRepository(db), nil, nil, 0, nil)
+    return env
+}
+
+func (env *providerTestEnv) createProvider(t *testing.T, code, province string) *dtos.ProviderDTO {
+    t.Helper()
+    provider := &dtos.ProviderDTO{
+        ProviderCode:   code,
+        NameThai:       "โรงพยาบาล " + code,
+        ProviderType:   "Hospital",
+        Province:       province,
+        ProviderStatus: "Active",
+        Email:          utility.StringPtr("contact@" + code + ".example.com"),
+    }
+    if err := env.providerRepo.Create(context.Background(), provider); err != nil {
+        t.Fatal(err)
+    }
+    return provider
+}
+
+// bangkokContext is a request of a user limited to Bangkok
+func bangkokContext() context.Context {
+    ctx := utility.ContextWithUsername(context.Background(), "somchai")
+    return utility.ContextWithUserRoleID(ctx, roleBangkok)
+}
+
+func providerCodes(providers []dtos.ProviderDTO) []string {
+    codes := make([]string, len(providers))
+    for i, provider := range providers {
+        codes[i] = provider.ProviderCode
+    }
+    return codes
+}
+
+func TestSearchProvidersAppliesDataScope(t *testing.T) {
+    env := newProviderTestEnv(t)
+    env.createProvider(t, "P001", "Bangkok")
+    env.createProvider(t, "P002", "Chiang Mai")
+
+    providers, total, err := env.service.SearchProviders(bangkokContext(), dtos.ProviderSearchRequestDTO{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if codes := providerCodes(providers); total != 1 || len(codes) != 1 || codes[0] != "P001" {
+        t.Errorf("SearchProviders = %v (total %d), want only the Bangkok provider", codes, total)
+    }
+
+    // Without a role, as with authentication off, the search is not restricted
+    _, total, err = env.service.SearchProviders(context.Background(), dtos.ProviderSearchRequestDTO{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if total != 2 {
+        t.Errorf("SearchProviders without a role found %d providers, want 2", total)
+    }
+}
+
+func TestSearchProvidersMasksSensitiveFields(t *testing.T) {
+    env := newProviderTestEnv(t)
+    env.createProvider(t, "P001", "Bangkok")
+
+    providers, _, err := env.service.SearchProviders(bangkokContext(), dtos.ProviderSearchRequestDTO{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(providers) != 1 || providers[0].Email == nil || *providers[0].Email == "contact@P001.example.com" {
+        t.Fatalf("SearchProviders = %+v, want the email masked", providers)
+    }
+
+    ctx := utility.ContextWithSensitiveDataAccess(bangkokContext(), true)
+    providers, _, err = env.service.SearchProviders(ctx, dtos.ProviderSearchRequestDTO{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(providers) != 1 || providers[0].Email == nil || *providers[0].Email != "contact@P001.example.com" {
+        t.Errorf("SearchProviders = %+v, want the email in clear for a user allowed to see it", providers)
+    }
+}
+
+func TestSearchProvidersIncludeDeletedRequiresAudit(t *testing.T) {
+    env := newProviderTestEnv(t)
+    env.createProvider(t, "P001", "Bangkok")
+    deleted := env.createProvider(t, "P002", "Bangkok")
+    if err := env.service.DeleteProvider(bangkokContext(), deleted.ID); err != nil {
+        t.Fatal(err)
+    }
+
+    req := dtos.ProviderSearchRequestDTO{IncludeDeleted: true}
+    _, total, err := env.service.SearchProviders(bangkokContext(), req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if total != 1 {
+        t.Errorf("SearchProviders with include_deleted and no AUDIT found %d providers, want 1", total)
+    }
+
+    ctx := utility.ContextWithDeletedDataAccess(bangkokContext(), true)
+    _, total, err = env.service.SearchProviders(ctx, req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if total != 2 {
+        t.Errorf("SearchProviders with include_deleted for an auditor found %d providers, want 2", total)
+    }
+}
+
+func TestReportsCountReturnedRowsAgainstQuota(t *testing.T) {
+    env := newProviderTestEnv(t)
+    for _, code := range []string{"P001", "P002", "P003", "P004"} {
+        env.createProvider(t, code, "Bangkok")
+    }
+    rowLimit := int64(5)
+    env.db.SetExportQuota(dtos.ExportQuotaDTO{UserRoleID: roleBangkok, DailyRowLimit: &rowLimit})
+    quotas := NewExportQuotaService(memory.NewExportQuotaRepository(env.db), 0, 0)
+    service := NewProviderService(env.providerRepo, NewExportService(), env.fieldRepo, env.templateRepo, env.history, memory.NewDataScopeRepository(env.db), nil, quotas, 0, nil)
+
+    // Each request returns a page of 2 of the 4 matching providers
+    req := dtos.ProviderReportRequestDTO{SearchParams: dtos.ProviderSearchRequestDTO{Page: 1, Limit: 2}, FormatType: "csv", CustomFields: []string{"provider_code"}}
+    _, quota, err := service.GenerateReport(bangkokContext(), req)
+    if err != nil {
+        t.Fatalf("GenerateReport: %v", err)
+    }
+    if quota.RowsUsed != 2 {
+        t.Errorf("rows used = %d after a report of 2 rows, want 2", quota.RowsUsed)
+    }
+
+    _, _, _, quota, err = service.ExportReport(bangkokContext(), req)
+    if err != nil {
+        t.Fatalf("ExportReport: %v", err)
+    }
+    if quota.RowsUsed != 4 {
+        t.Errorf("rows used = %d after an export of 2 rows, want 4", quota.RowsUsed)
+    }
+
+    if _, _, err := service.GenerateReport(bangkokContext(), req); !errors.Is(err, clienterrors.ErrQuotaExceeded) {
+        t.Errorf("GenerateReport past the row limit = %v, want ErrQuotaExceeded", err)
+    }
+}
+
+func TestUpdateProvider(t *testing.T) {
+    env := newProviderTestEnv(t)
+    provider := env.createProvider(t, "P001", "Bangkok")
+    ctx := bangkokContext()
+
+    // Clients edit what they were shown, including the masked email
+    shown, err := env.service.GetProviderByID(ctx, provider.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    req := dtos.NewUpdateProviderRequest(shown)
+    req.NameThai = "โรงพยาบาลกรุงเทพ"
+
+    version := shown.Version
+    updated, err := env.service.UpdateProvider(ctx, provider.ID, req, &version)
+    if err != nil {
+        t.Fatalf("UpdateProvider: %v", err)
+    }
+    if updated.NameThai != "โรงพยาบาลกรุงเทพ" || updated.Version != version+1 {
+        t.Errorf("UpdateProvider = %q version %d, want the new name at version %d", updated.NameThai, updated.Version, version+1)
+    }
+
+    stored, err := env.providerRepo.GetByID(context.Background(), provider.ID, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored.Email == nil || *stored.Email != "contact@P001.example.com" {
+        t.Errorf("stored email = %v, the masked value replaced the real one", stored.Email)
+    }
+    if stored.UpdatedBy == nil || *stored.UpdatedBy != "somchai" {
+        t.Errorf("updated_by = %v, want somchai", stored.UpdatedBy)
+    }
+
+    history, err := env.history.GetEntityHistory(context.Background(), dtos.HistoryEntityProvider, provider.ID, dtos.HistorySearchRequestDTO{Action: dtos.HistoryActionUpdate})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(history.History) != 1 || *history.History[0].FieldName != "name_thai" {
+        t.Errorf("update history = %+v, want a single name_thai change", history.History)
+    }
+
+    // The version the client read is now stale
+    if _, err := env.service.UpdateProvider(ctx, provider.ID, req, &version); !errors.Is(err, clienterrors.ErrPreconditionFailed) {
+        t.Errorf("UpdateProvider with a stale version = %v, want ErrPreconditionFailed", err)
+    }
+}
+
+func TestUpdateProviderOutsideScope(t *testing.T) {
+    env := newProviderTestEnv(t)
+    provider := env.createProvider(t, "P001", "Chiang Mai")
+
+    req := dtos.NewUpdateProviderRequest(provider)
+    if _, err := env.service.UpdateProvider(bangkokContext(), provider.ID, req, nil); !errors.Is(err, clienterrors.ErrProviderDeleted) {
+        t.Errorf("UpdateProvider outside the data scope = %v, want ErrProviderDeleted", err)
+    }
+}
+
+func TestDeleteAndRestoreProvider(t *testing.T) {
+    env := newProviderTestEnv(t)
+    provider := env.createProvider(t, "P001", "Bangkok")
+    ctx := bangkokContext()
+
+    if err := env.service.DeleteProvider(ctx, provider.ID); err != nil {
+        t.Fatalf("DeleteProvider: %v", err)
+    }
+    if _, err := env.service.GetProviderByID(ctx, provider.ID); !errors.Is(err, clienterrors.ErrProviderDeleted) {
+        t.Errorf("GetProviderByID of a deleted provider = %v, want ErrProviderDeleted", err)
+    }
+    if err := env.service.DeleteProvider(ctx, provider.ID); !errors.Is(err, clienterrors.ErrAlreadyDeleted) {
+        t.Errorf("DeleteProvider twice = %v, want ErrAlreadyDeleted", err)
+    }
+
+    trash, total, err := env.service.GetDeletedProviders(ctx, dtos.ProviderSearchRequestDTO{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if total != 1 || trash[0].ID != provider.ID || trash[0].DeletedBy == nil || *trash[0].DeletedBy != "somchai" {
+        t.Errorf("GetDeletedProviders = %+v, want the provider deleted by somchai", trash)
+    }
+
+    restored, err := env.service.RestoreProvider(ctx, provider.ID)
+    if err != nil {
+        t.Fatalf("RestoreProvider: %v", err)
+    }
+    if restored.DeletedAt != nil || restored.UpdatedBy == nil || *restored.UpdatedBy != "somchai" {
+        t.Errorf("RestoreProvider = %+v, want a live provider restored by somchai", restored)
+    }
+    if _, err := env.service.RestoreProvider(ctx, provider.ID); !errors.Is(err, clienterrors.ErrNotDeleted) {
+        t.Errorf("RestoreProvider of a live provider = %v, want ErrNotDeleted", err)
+    }
+
+    history, err := env.history.GetEntityHistory(context.Background(), dtos.HistoryEntityProvider, provider.ID, dtos.HistorySearchRequestDTO{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    actions := make(map[string]int)
+    for _, entry := range history.History {
+        actions[entry.Action]++
+    }
+    if actions[dtos.HistoryActionDelete] != 1 || actions[dtos.HistoryActionRestore] != 1 {
+        t.Errorf("history actions = %v, want one delete and one restore", actions)
+    }
+}
+
+func TestDeleteProviderOutsideScope(t *testing.T) {
+    env := newProviderTestEnv(t)
+    provider := env.createProvider(t, "P001", "Chiang Mai")
+
+    if err := env.service.DeleteProvider(bangkokContext(), provider.ID); !errors.Is(err, clienterrors.ErrProviderDeleted) {
+        t.Errorf("DeleteProvider outside the data scope = %v, want ErrProviderDeleted", err)
+    }
+    if _, err := env.providerRepo.GetByID(context.Background(), provider.ID, nil); err != nil {
+        t.Errorf("provider outside the data scope was deleted: %v", err)
+    }
+}
+
+// gatedProviderRepository holds every read of a provider until all the expected readers have
+// read it, so concurrent writers all start from the same version
+type gatedProviderRepository struct {
+    *memory.ProviderRepository
+    readers sync.WaitGroup
+}
+
+func (r *gatedProviderRepository) GetByID(ctx context.Context, id int, scope *dtos.DataScopeDTO) (*dtos.ProviderDTO, error) {
+    provider, err := r.ProviderRepository.GetByID(ctx, id, scope)
+    r.readers.Done()
+    r.readers.Wait()
+    return provider, err
+}
+
+func TestConcurrentUpdatesOfTheSameVersion(t *testing.T) {
+    for _, withIfMatch := range []bool{false, true} {
+        t.Run(fmt.Sprintf("if-match=%v", withIfMatch), func(t *testing.T) {
+            env := newProviderTestEnv(t)
+            provider := env.createProvider(t, "P001", "Bangkok")
+            repo := &gatedProviderRepository{ProviderRepository: env.providerRepo}
+            service := NewProviderService(repo, NewExportService(), env.fieldRepo, env.templateRepo, env.history, memory.NewDataScopeRepository(env.db), nil, nil, 0, nil)
+
+            names := []string{"โรงพยาบาลหนึ่ง", "โรงพยาบาลสอง"}
+            errs := make([]error, len(names))
+            repo.readers.Add(len(names))
+            var wg sync.WaitGroup
+            for i, name := range names {
+                wg.Add(1)
+                go func() {
+                    defer wg.Done()
+                    req := dtos.NewUpdateProviderRequest(provider)
+                    req.NameThai = name
+                    var expectedVersion *int
+                    if withIfMatch {
+                        version := provider.Version
+                        expectedVersion = &version
+                    }
+                    _, errs[i] = service.UpdateProvider(bangkokContext(), provider.ID, req, expectedVersion)
+                }()
+            }
+            wg.Wait()
+
+            // Both writers passed the version check, the repository lets only one of them write
+            winner := -1
+            for i, err := range errs {
+                switch {
+                case err == nil:
+                    if winner >= 0 {
+                        t.Fatal("both writers updated the same version")
+                    }
+                    winner = i
+                case !errors.Is(err, clienterrors.ErrPreconditionFailed):
+                    t.Errorf("writer %d: %v, want ErrPreconditionFailed", i, err)
+                }
+            }
+            if winner < 0 {
+                t.Fatalf("no writer succeeded: %v", errs)
+            }
+
+            stored, err := env.providerRepo.GetByID(context.Background(), provider.ID, nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if stored.NameThai != names[winner] || stored.Version != provider.Version+1 {
+                t.Errorf("stored %q version %d, want %q at version %d", stored.NameThai, stored.Version, names[winner], provider.Version+1)
+            }
+
+            // The loser's change is not in the history either
+            history, err := env.history.GetEntityHistory(context.Background(), dtos.HistoryEntityProvider, provider.ID, dtos.HistorySearchRequestDTO{Action: dtos.HistoryActionUpdate})
+            if err != nil {
+                t.Fatal(err)
+            }
+            if history.Total != 1 {
+                t.Errorf("recorded %d updates, want 1", history.Total)
+            }
+        })
+    }
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "testing"
+
+    "github.com/alicebob/miniredis/v2"
+    clienterrors "provider-report-api/constant/errors"
+    "provider-report-api/internal/modules/provider-detail/dtos"
+    "provider-report-api/internal/modules/provider-detail/repositories/memory"
+    "provider-report-api/pkg/utility"
+)
+
+const savedSearchKey = "save:search:" + dtos.SavedSearchSubModule + ":somchai"
+
+// countingSavedSearchRepository counts the reads that go to the database
+type countingSavedSearchRepository struct {
+    *memory.SavedSearchRepository
+
+    mu    sync.Mutex
+    reads int
+}
+
+func (r *countingSavedSearchRepository) GetByUsername(ctx context.Context, username string) ([]dtos.SavedSearchDTO, error) {
+    r.mu.Lock()
+    r.reads++
+    r.mu.Unlock()
+    return r.SavedSearchRepository.GetByUsername(ctx, username)
+}
+
+func (r *countingSavedSearchRepository) readCount() int {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.reads
+}
+
+func newSavedSearchTestService(t *testing.T) (*SavedSearchService, *countingSavedSearchRepository, *miniredis.Miniredis) {
+    t.Helper()
+    mr := miniredis.RunT(t)
+    redisService, err := utility.ConnectRedisService(mr.Addr(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { redisService.Close() })
+
+    db := memory.NewDB()
+    repo := &countingSavedSearchRepository{SavedSearchRepository: memory.NewSavedSearchRepository(db)}
+    return NewSavedSearchService(repo, memory.NewTemplateRepository(db), redisService), repo, mr
+}
+
+func createSearch(t *testing.T, s *SavedSearchService, username, name string, isDefault bool) *dtos.SavedSearchDTO {
+    t.Helper()
+    search, err := s.CreateSavedSearch(context.Background(), username, dtos.CreateSavedSearchRequestDTO{
+        SearchName:     name,
+        SearchCriteria: dtos.ProviderSearchRequestDTO{ProvinceName: "Bangkok"},
+        IsDefault:      isDefault,
+    })
+    if err != nil {
+        t.Fatalf("CreateSavedSearch(%q): %v", name, err)
+    }
+    return search
+}
+
+func searchNames(searches []dtos.SavedSearchDTO) []string {
+    names := make([]string, len(searches))
+    for i, search := range searches {
+        names[i] = search.SearchName
+    }
+    return names
+}
+
+func TestSavedSearchesAreServedFromRedis(t *testing.T) {
+    s, repo, mr := newSavedSearchTestService(t)
+    ctx := context.Background()
+
+    createSearch(t, s, "somchai", "Bangkok hospitals", false)
+    if !mr.Exists(savedSearchKey) {
+        t.Fatalf("saved searches were not cached in %s", savedSearchKey)
+    }
+
+    reads := repo.readCount()
+    searches, err := s.GetSavedSearches(ctx, "somchai")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(searches) != 1 || searches[0].SearchName != "Bangkok hospitals" || searches[0].SearchCriteria.ProvinceName != "Bangkok" {
+        t.Errorf("GetSavedSearches = %+v, want the Bangkok hospitals search", searches)
+    }
+    if got := repo.readCount(); got != reads {
+        t.Errorf("GetSavedSearches read the database %d times with Redis up", got-reads)
+    }
+}
+
+func TestSavedSearchWritesRefreshRedis(t *testing.T) {
+    s, _, mr := newSavedSearchTestService(t)
+    ctx := context.Background()
+
+    first := createSearch(t, s, "somchai", "Bangkok hospitals", true)
+    second := createSearch(t, s, "somchai", "Clinics", true)
+
+    // The cached list must reflect the single default and the deletion
+    searches, err := s.GetSavedSearches(ctx, "somchai")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, search := range searches {
+        if search.IsDefault != (search.ID == second.ID) {
+            t.Errorf("search %q is_default = %v", search.SearchName, search.IsDefault)
+        }
+    }
+
+    if err := s.DeleteSavedSearch(ctx, first.ID, "somchai"); err != nil {
+        t.Fatal(err)
+    }
+    cached, err := mr.Get(savedSearchKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    searches, err = s.GetSavedSearches(ctx, "somchai")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if names := searchNames(searches); len(names) != 1 || names[0] != "Clinics" {
+        t.Errorf("GetSavedSearches = %v after delete, want [Clinics] (cached %s)", names, cached)
+    }
+
+    if _, err := s.GetSavedSearch(ctx, first.ID, "somchai"); !errors.Is(err, clienterrors.ErrNotFound) {
+        t.Errorf("GetSavedSearch of a deleted search = %v, want ErrNotFound", err)
+    }
+}
+
+func TestSavedSearchesAreKeptPerUser(t *testing.T) {
+    s, _, _ := newSavedSearchTestService(t)
+    ctx := context.Background()
+
+    search := createSearch(t, s, "somchai", "Bangkok hospitals", false)
+    createSearch(t, s, "malee", "Bangkok hospitals", false)
+
+    searches, err := s.GetSavedSearches(ctx, "malee")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(searches) != 1 || searches[0].ID == search.ID {
+        t.Errorf("GetSavedSearches(malee) = %+v, want only her own search", searches)
+    }
+    if _, err := s.GetSavedSearch(ctx, search.ID, "malee"); !errors.Is(err, clienterrors.ErrNotFound) {
+        t.Errorf("GetSavedSearch of another user's search = %v, want ErrNotFound", err)
+    }
+}
+
+func TestSavedSearchesFallBackToDatabaseWhenRedisIsDown(t *testing.T) {
+    s, repo, mr := newSavedSearchTestService(t)
+    ctx := context.Background()
+
+    createSearch(t, s, "somchai", "Bangkok hospitals", false)
+    mr.Close()
+
+    reads := repo.readCount()
+    searches, err := s.GetSavedSearches(ctx, "somchai")
+    if err != nil {
+        t.Fatalf("GetSavedSearches with Redis down: %v", err)
+    }
+    if names := searchNames(searches); len(names) != 1 || names[0] != "Bangkok hospitals" {
+        t.Errorf("GetSavedSearches = %v, want [Bangkok hospitals]", names)
+    }
+    if repo.readCount() == reads {
+        t.Error("GetSavedSearches did not read the database with Redis down")
+    }
+
+    // Writes keep working and still enforce unique names
+    created := createSearch(t, s, "somchai", "Clinics", false)
+    _, err = s.CreateSavedSearch(ctx, "somchai", dtos.CreateSavedSearchRequestDTO{SearchName: "clinics"})
+    if !errors.Is(err, clienterrors.ErrDuplicateName) {
+        t.Errorf("CreateSavedSearch of a duplicate name = %v, want ErrDuplicateName", err)
+    }
+    if err := s.DeleteSavedSearch(ctx, created.ID, "somchai"); err != nil {
+        t.Errorf("DeleteSavedSearch with Redis down: %v", err)
+    }
+
+    searches, err = s.GetSavedSearches(ctx, "somchai")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if names := searchNames(searches); len(names) != 1 || names[0] != "Bangkok hospitals" {
+        t.Errorf("GetSavedSearches = %v after the writes, want [Bangkok hospitals]", names)
+    }
+}
+
+func TestSavedSearchesReloadInvalidCache(t *testing.T) {
+    s, repo, mr := newSavedSearchTestService(t)
+    ctx := context.Background()
+
+    createSearch(t, s, "somchai", "Bangkok hospitals", false)
+    mr.Set(savedSearchKey, `{"not":"a list"}`)
+
+    reads := repo.readCount()
+    searches, err := s.GetSavedSearches(ctx, "somchai")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if names := searchNames(searches); len(names) != 1 || names[0] != "Bangkok hospitals" {
+        t.Errorf("GetSavedSearches = %v, want [Bangkok hospitals]", names)
+    }
+    if repo.readCount() == reads {
+        t.Error("an invalid cached value was not reloaded from the database")
+    }
+
+    // The reload repaired the cache
+    reads = repo.readCount()
+    if _, err := s.GetSavedSearches(ctx, "somchai"); err != nil {
+        t.Fatal(err)
+    }
+    if got := repo.readCount(); got != reads {
+        t.Errorf("GetSavedSearches read the database again after the cache was repaired")
+    }
+}
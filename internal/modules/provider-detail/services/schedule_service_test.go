@@ -0,0 +1,650 @@
+package services
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "io"
+    "mime"
+    "mime/multipart"
+    "net/mail"
+    "net/smtp"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    config "provider-report-api/configs"
+    clienterrors "provider-report-api/constant/errors"
+    "provider-report-api/internal/modules/provider-detail/dtos"
+    "provider-report-api/internal/modules/provider-detail/repositories"
+    "provider-report-api/internal/modules/provider-detail/repositories/memory"
+)
+
+// sentMail is a message handed to the stub SMTP server
+type sentMail struct {
+    to  []string
+    msg []byte
+}
+
+// stubMailer stands in for smtp.SendMail, failing while err is set
+type stubMailer struct {
+    mu   sync.Mutex
+    err  error
+    sent []sentMail
+}
+
+func (m *stubMailer) sendMail(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.err != nil {
+        return m.err
+    }
+    m.sent = append(m.sent, sentMail{to: to, msg: msg})
+    return nil
+}
+
+func (m *stubMailer) fail(err error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.err = err
+}
+
+func (m *stubMailer) messages() []sentMail {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return append([]sentMail(nil), m.sent...)
+}
+
+type scheduleTestEnv struct {
+    *providerTestEnv
+    scheduleRepo *memory.ScheduleRepository
+    logRepo      *memory.LogRepository
+    mailer       *stubMailer
+    email        *EmailService
+    service      *ScheduleService
+    schedule     *dtos.ScheduleDTO
+}
+
+// newScheduleTestEnv returns a schedule service with a CSV schedule of the Bangkok role, mailing
+// through a stub, and providers in and out of the role's data scope
+func newScheduleTestEnv(t *testing.T, retry config.ScheduleRetry) *scheduleTestEnv {
+    t.Helper()
+    ctx := context.Background()
+    env := &scheduleTestEnv{providerTestEnv: newProviderTestEnv(t), mailer: &stubMailer{}}
+    env.scheduleRepo = memory.NewScheduleRepository(env.db)
+    env.logRepo = memory.NewLogRepository(env.db)
+
+    for _, field := range []dtos.AvailableFieldDTO{
+        {FieldCode: "provider_code", FieldNameThai: "รหัส", FieldNameEng: "Provider Code", FieldType: "text", FieldCategory: "basic"},
+        {FieldCode: "name_thai", FieldNameThai: "ชื่อ", FieldNameEng: "Name", FieldType: "text", FieldCategory: "basic"},
+    } {
+        if err := env.fieldRepo.CreateField(ctx, &field); err != nil {
+            t.Fatal(err)
+        }
+    }
+    template := &dtos.TemplateDTO{TemplateName: "Providers", DataFields: dtos.JSONFieldArray{"provider_code", "name_thai"}, CreatedBy: "admin"}
+    if err := env.templateRepo.Create(ctx, template); err != nil {
+        t.Fatal(err)
+    }
+    ownerRoleID := roleBangkok
+    env.schedule = &dtos.ScheduleDTO{
+        ScheduleName:   "Weekly providers",
+        TemplateID:     template.ID,
+        EmailTo:        "ops@example.com",
+        Frequency:      "weekly",
+        StartDate:      time.Now(),
+        StartTime:      "08:00",
+        Timezone:       "Asia/Bangkok",
+        SearchCriteria: dtos.JSONMap{},
+        ExportFormat:   "csv",
+        CreatedBy:      "admin",
+        OwnerRoleID:    &ownerRoleID,
+    }
+    if err := env.scheduleRepo.Create(ctx, env.schedule); err != nil {
+        t.Fatal(err)
+    }
+
+    env.createProvider(t, "P001", "Bangkok")
+    env.createProvider(t, "P002", "Chiang Mai")
+
+    env.email = NewEmailService(&config.Config{SMTPHost: "smtp.example.com", SMTPPort: "25", SMTPFrom: "reports@example.com"}, nil)
+    env.email.sendMail = env.mailer.sendMail
+    env.service = env.newService(env.logRepo, 0, retry)
+    return env
+}
+
+// newService returns a schedule service on the test repositories with its own log store and
+// run timeout, like another instance of the API
+func (env *scheduleTestEnv) newService(logRepo repositories.LogStore, runTimeout time.Duration, retry config.ScheduleRetry) *ScheduleService {
+    return NewScheduleService(env.scheduleRepo, env.templateRepo, env.email, env.history, env.providerTestEnv.service, logRepo, nil, runTimeout, nil, retry)
+}
+
+// scheduleLogs returns the sent report logs of the test schedule, newest first
+func (env *scheduleTestEnv) scheduleLogs(t *testing.T) []dtos.SentReportLogDTO {
+    t.Helper()
+    logs, _, err := env.logRepo.GetSentReportLogs(context.Background(), dtos.LogSearchRequestDTO{ScheduleID: &env.schedule.ID})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return logs
+}
+
+// attachment returns the name and content of the attachment of a message
+func attachment(t *testing.T, msg []byte) (string, string) {
+    t.Helper()
+    parsed, err := mail.ReadMessage(strings.NewReader(string(msg)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    reader := multipart.NewReader(parsed.Body, params["boundary"])
+    for {
+        part, err := reader.NextPart()
+        if err == io.EOF {
+            t.Fatal("message has no attachment")
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        if part.FileName() == "" {
+            continue
+        }
+        data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+        if err != nil {
+            t.Fatal(err)
+        }
+        return part.FileName(), string(data)
+    }
+}
+
+var testRetry = config.ScheduleRetry{Limit: 3, Backoff: time.Minute, MaxBackoff: time.Hour, PollInterval: time.Minute}
+
+func TestRunScheduleEmailsReport(t *testing.T) {
+    env := newScheduleTestEnv(t, testRetry)
+
+    result, err := env.service.RunSchedule(context.Background(), env.schedule.ID)
+    if err != nil {
+        t.Fatalf("RunSchedule: %v", err)
+    }
+    if result.Status != "success" || result.RecordCount != 1 || result.Recipients != "ops@example.com" {
+        t.Errorf("RunSchedule = %+v, want one record sent to ops@example.com", result)
+    }
+
+    sent := env.mailer.messages()
+    if len(sent) != 1 || len(sent[0].to) != 1 || sent[0].to[0] != "ops@example.com" {
+        t.Fatalf("sent %+v, want one message to ops@example.com", sent)
+    }
+    filename, data := attachment(t, sent[0].msg)
+    if !strings.HasSuffix(filename, ".csv") {
+        t.Errorf("attachment %q, want a CSV file", filename)
+    }
+    // The report runs with the owner's data scope whoever triggers it
+    if !strings.Contains(data, "Provider Code,Name") || !strings.Contains(data, "P001") || strings.Contains(data, "P002") {
+        t.Errorf("attachment =\n%s\nwant the template columns and only the Bangkok provider", data)
+    }
+
+    logs := env.scheduleLogs(t)
+    if len(logs) != 1 || logs[0].Status != "success" || logs[0].TotalRecords == nil || *logs[0].TotalRecords != 1 || logs[0].NextRetryAt != nil {
+        t.Errorf("logs = %+v, want one successful run of 1 record", logs)
+    }
+    schedule, err := env.scheduleRepo.GetByID(context.Background(), env.schedule.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if schedule.LastRunAt == nil {
+        t.Error("last_run_at was not set after the run")
+    }
+}
+
+func TestRunScheduleFailureIsPending(t *testing.T) {
+    env := newScheduleTestEnv(t, testRetry)
+    env.mailer.fail(errors.New("421 service not available"))
+
+    startedAt := time.Now()
+    if _, err := env.service.RunSchedule(context.Background(), env.schedule.ID); err == nil {
+        t.Fatal("RunSchedule succeeded although the email failed")
+    }
+
+    logs := env.scheduleLogs(t)
+    if len(logs) != 1 {
+        t.Fatalf("logs = %+v, want one", logs)
+    }
+    entry := logs[0]
+    if entry.Status != "pending" || entry.RetryCount != 0 || entry.ErrorMessage == nil || !strings.Contains(*entry.ErrorMessage, "421") {
+        t.Errorf("log = %+v, want a pending run with the SMTP error", entry)
+    }
+    if entry.NextRetryAt == nil || entry.NextRetryAt.Before(startedAt.Add(testRetry.Backoff)) || entry.NextRetryAt.After(time.Now().Add(testRetry.Backoff)) {
+        t.Errorf("next_retry_at = %v, want the first backoff from now", entry.NextRetryAt)
+    }
+
+    schedule, err := env.scheduleRepo.GetByID(context.Background(), env.schedule.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if schedule.LastRunAt != nil {
+        t.Error("last_run_at was set by a failed run")
+    }
+}
+
+func TestRunScheduleFailureWithoutRetries(t *testing.T) {
+    env := newScheduleTestEnv(t, config.ScheduleRetry{})
+    env.mailer.fail(errors.New("421 service not available"))
+
+    if _, err := env.service.RunSchedule(context.Background(), env.schedule.ID); err == nil {
+        t.Fatal("RunSchedule succeeded although the email failed")
+    }
+    if logs := env.scheduleLogs(t); len(logs) != 1 || logs[0].Status != "failed" || logs[0].NextRetryAt != nil {
+        t.Errorf("logs = %+v, want one failed run with retries off", logs)
+    }
+}
+
+func TestRunScheduleWithoutOwner(t *testing.T) {
+    env := newScheduleTestEnv(t, testRetry)
+    schedule := *env.schedule
+    schedule.ID = 0
+    schedule.OwnerRoleID = nil
+    if err := env.scheduleRepo.Create(context.Background(), &schedule); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := env.service.RunSchedule(context.Background(), schedule.ID); !errors.Is(err, clienterrors.ErrInvalidInput) {
+        t.Errorf("RunSchedule without an owner = %v, want ErrInvalidInput", err)
+    }
+    if sent := env.mailer.messages(); len(sent) != 0 {
+        t.Errorf("sent %d messages for a schedule without an owner", len(sent))
+    }
+}
+
+// racingScheduleRepository runs race once, right after the first read of a schedule, like
+// another writer saving it meanwhile
+type racingScheduleRepository struct {
+    *memory.ScheduleRepository
+    race func()
+}
+
+func (r *racingScheduleRepository) GetByID(ctx context.Context, id int) (*dtos.ScheduleDTO, error) {
+    schedule, err := r.ScheduleRepository.GetByID(ctx, id)
+    if race := r.race; race != nil {
+        r.race = nil
+        race()
+    }
+    return schedule, err
+}
+
+// updateRequest returns an update of the test schedule that renames it to name
+func (env *scheduleTestEnv) updateRequest(name string) dtos.UpdateScheduleRequestDTO {
+    return dtos.UpdateScheduleRequestDTO{
+        ScheduleName:   name,
+        TemplateID:     env.schedule.TemplateID,
+        EmailTo:        env.schedule.EmailTo,
+        Frequency:      env.schedule.Frequency,
+        StartDate:      env.schedule.StartDate,
+        StartTime:      env.schedule.StartTime,
+        Timezone:       env.schedule.Timezone,
+        IsActive:       true,
+        SearchCriteria: map[string]interface{}{},
+        ExportFormat:   env.schedule.ExportFormat,
+    }
+}
+
+func TestUpdateScheduleWithStaleVersion(t *testing.T) {
+    env := newScheduleTestEnv(t, testRetry)
+    ctx := bangkokContext()
+
+    version := env.schedule.Version
+    updated, err := env.service.UpdateSchedule(ctx, env.schedule.ID, env.updateRequest("Renamed"), &version)
+    if err != nil {
+        t.Fatalf("UpdateSchedule: %v", err)
+    }
+    if updated.Version != version+1 {
+        t.Errorf("version = %d, want %d", updated.Version, version+1)
+    }
+
+    // The version the client read is now stale
+    if _, err := env.service.UpdateSchedule(ctx, env.schedule.ID, env.updateRequest("Overwritten"), &version); !errors.Is(err, clienterrors.ErrPreconditionFailed) {
+        t.Errorf("UpdateSchedule with a stale version = %v, want ErrPreconditionFailed", err)
+    }
+    stored, err := env.scheduleRepo.GetByID(context.Background(), env.schedule.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored.ScheduleName != "Renamed" || stored.Version != version+1 {
+        t.Errorf("stored %q version %d, the stale update was saved", stored.ScheduleName, stored.Version)
+    }
+}
+
+func TestUpdateScheduleSavedMeanwhile(t *testing.T) {
+    env := newScheduleTestEnv(t, testRetry)
+    repo := &racingScheduleRepository{ScheduleRepository: env.scheduleRepo}
+    repo.race = func() {
+        other := *env.schedule
+        other.ScheduleName = "Saved meanwhile"
+        if err := env.scheduleRepo.Update(context.Background(), &other); err != nil {
+            t.Fatal(err)
+        }
+    }
+    service := NewScheduleService(repo, env.templateRepo, env.email, env.history, env.providerTestEnv.service, env.logRepo, nil, 0, nil, testRetry)
+
+    // The version matched when it was checked, the save still loses to the other writer
+    version := env.schedule.Version
+    if _, err := service.UpdateSchedule(bangkokContext(), env.schedule.ID, env.updateRequest("Renamed"), &version); !errors.Is(err, clienterrors.ErrPreconditionFailed) {
+        t.Errorf("UpdateSchedule = %v, want ErrPreconditionFailed", err)
+    }
+    stored, err := env.scheduleRepo.GetByID(context.Background(), env.schedule.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored.ScheduleName != "Saved meanwhile" {
+        t.Errorf("stored %q, want the other writer's save", stored.ScheduleName)
+    }
+}
+
+func TestResendReport(t *testing.T) {
+    env := newScheduleTestEnv(t, testRetry)
+    ctx := context.Background()
+    env.mailer.fail(errors.New("421 service not available"))
+    if _, err := env.service.RunSchedule(ctx, env.schedule.ID); err == nil {
+        t.Fatal("RunSchedule succeeded although the email failed")
+    }
+    failed := env.scheduleLogs(t)[0]
+
+    // The resend goes to the recipients of the log, not the ones the schedule has now
+    schedule, err := env.scheduleRepo.GetByID(ctx, env.schedule.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    schedule.EmailTo = "new-team@example.com"
+    if err := env.scheduleRepo.Update(ctx, schedule); err != nil {
+        t.Fatal(err)
+    }
+
+    env.mailer.fail(nil)
+    result, err := env.service.ResendReport(ctx, failed.ID)
+    if err != nil {
+        t.Fatalf("ResendReport: %v", err)
+    }
+    if result.Recipients != "ops@example.com" || result.RecordCount != 1 {
+        t.Errorf("ResendReport = %+v, want 1 record sent to ops@example.com", result)
+    }
+    if sent := env.mailer.messages(); len(sent) != 1 || sent[0].to[0] != "ops@example.com" {
+        t.Errorf("sent %+v, want one message to ops@example.com", sent)
+    }
+
+    // The original log keeps its failure, the resend is logged on its own
+    original, err := env.logRepo.GetByID(ctx, failed.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if original.Status != failed.Status || original.RetryCount != 0 || original.ErrorMessage == nil {
+        t.Errorf("original log = %+v, want it unchanged", original)
+    }
+    logs := env.scheduleLogs(t)
+    if len(logs) != 2 {
+        t.Fatalf("got %d logs, want the original and the resend", len(logs))
+    }
+    var resend *dtos.SentReportLogDTO
+    for i := range logs {
+        if logs[i].ID != failed.ID {
+            resend = &logs[i]
+        }
+    }
+    if resend.ResentFromID == nil || *resend.ResentFromID != failed.ID {
+        t.Errorf("resent_from_id = %v, want %d", resend.ResentFromID, failed.ID)
+    }
+    if resend.Status != "success" || resend.Recipients != "ops@example.com" || resend.NextRetryAt != nil {
+        t.Errorf("resend log = %+v, want a success sent to ops@example.com", resend)
+    }
+}
+
+func TestFailedResendIsNotRetried(t *testing.T) {
+    env := newScheduleTestEnv(t, testRetry)
+    ctx := context.Background()
+    failed := env.pendingRun(t)
+
+    env.mailer.fail(errors.New("421 service not available"))
+    if _, err := env.service.ResendReport(ctx, failed.ID); err == nil {
+        t.Fatal("ResendReport succeeded although the email failed")
+    }
+
+    var resend *dtos.SentReportLogDTO
+    for _, entry := range env.scheduleLogs(t) {
+        if entry.ResentFromID != nil && *entry.ResentFromID == failed.ID {
+            resend = &entry
+        }
+    }
+    if resend == nil {
+        t.Fatal("the failed resend was not logged")
+    }
+    if resend.Status != "failed" || resend.NextRetryAt != nil || resend.ErrorMessage == nil {
+        t.Errorf("resend log = %+v, want a final failure", resend)
+    }
+
+    due, err := env.logRepo.GetDueRetries(ctx, time.Now().Add(time.Hour), 10)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, entry := range due {
+        if entry.ID == resend.ID {
+            t.Errorf("the failed resend %d is due for a retry", resend.ID)
+        }
+    }
+}
+
+func TestResendReportWithoutSchedule(t *testing.T) {
+    env := newScheduleTestEnv(t, testRetry)
+    entry := &dtos.SentReportLogDTO{TemplateID: env.schedule.TemplateID, Recipients: "ops@example.com", Status: "failed"}
+    if err := env.logRepo.Create(context.Background(), entry); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := env.service.ResendReport(context.Background(), entry.ID); !errors.Is(err, clienterrors.ErrInvalidInput) {
+        t.Errorf("ResendReport of a log without schedule = %v, want ErrInvalidInput", err)
+    }
+    if _, err := env.service.ResendReport(context.Background(), entry.ID+100); !errors.Is(err, clienterrors.ErrNotFound) {
+        t.Errorf("ResendReport of a missing log = %v, want ErrNotFound", err)
+    }
+}
+
+func TestRetryFailedRunsResendsDueReports(t *testing.T) {
+    env := newScheduleTestEnv(t, config.ScheduleRetry{Limit: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, PollInterval: 10 * time.Millisecond})
+    env.mailer.fail(errors.New("421 service not available"))
+    if _, err := env.service.RunSchedule(context.Background(), env.schedule.ID); err == nil {
+        t.Fatal("RunSchedule succeeded although the email failed")
+    }
+    env.mailer.fail(nil)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        env.service.RetryFailedRuns(ctx)
+        close(done)
+    }()
+    defer func() {
+        cancel()
+        <-done
+    }()
+
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        logs := env.scheduleLogs(t)
+        if logs[0].Status == "success" {
+            if logs[0].RetryCount != 1 {
+                t.Errorf("retry_count = %d, want 1", logs[0].RetryCount)
+            }
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("log = %+v, the pending run was not retried", logs[0])
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    if sent := env.mailer.messages(); len(sent) != 1 {
+        t.Errorf("sent %d messages, want the report resent once", len(sent))
+    }
+}
+
+// crashedLogRepository drops the outcome of attempts, like an instance that died after
+// claiming an attempt and before recording it
+type crashedLogRepository struct {
+    *memory.LogRepository
+}
+
+func (r crashedLogRepository) UpdateAttempt(ctx context.Context, attempt *dtos.SentReportLogDTO) error {
+    return nil
+}
+
+// pendingRun fails a run of the test schedule and returns its pending log
+func (env *scheduleTestEnv) pendingRun(t *testing.T) dtos.SentReportLogDTO {
+    t.Helper()
+    env.mailer.fail(errors.New("421 service not available"))
+    defer env.mailer.fail(nil)
+    if _, err := env.service.RunSchedule(context.Background(), env.schedule.ID); err == nil {
+        t.Fatal("RunSchedule succeeded although the email failed")
+    }
+    entry := env.scheduleLogs(t)[0]
+    if entry.Status != "pending" {
+        t.Fatalf("log = %+v, want a pending run", entry)
+    }
+    return entry
+}
+
+func TestRetryBackoff(t *testing.T) {
+    s := &ScheduleService{retry: config.ScheduleRetry{Limit: 10, Backoff: time.Minute, MaxBackoff: 10 * time.Minute}}
+
+    want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+    for i, backoff := range want {
+        if got := s.retryBackoff(i + 1); got != backoff {
+            t.Errorf("retryBackoff(%d) = %v, want %v", i+1, got, backoff)
+        }
+    }
+    if got := s.retryBackoff(60); got != 10*time.Minute {
+        t.Errorf("retryBackoff(60) = %v, want the 10m cap without overflowing", got)
+    }
+}
+
+func TestRetryAttemptIsClaimedOnce(t *testing.T) {
+    env := newScheduleTestEnv(t, testRetry)
+    entry := env.pendingRun(t)
+
+    // Two instances picked up the same due retry
+    first, second := entry, entry
+    if _, err := env.service.attempt(context.Background(), &first); err != nil {
+        t.Fatalf("first attempt: %v", err)
+    }
+    if _, err := env.service.attempt(context.Background(), &second); !errors.Is(err, clienterrors.ErrPreconditionFailed) {
+        t.Errorf("second attempt = %v, want ErrPreconditionFailed", err)
+    }
+
+    if sent := env.mailer.messages(); len(sent) != 1 {
+        t.Errorf("sent %d messages, want the report sent once", len(sent))
+    }
+    stored, err := env.logRepo.GetByID(context.Background(), entry.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored.Status != "success" || stored.RetryCount != 1 {
+        t.Errorf("log = %+v, want a success at retry 1", stored)
+    }
+}
+
+func TestRetryLeaseExpiresAfterCrash(t *testing.T) {
+    env := newScheduleTestEnv(t, testRetry)
+    entry := env.pendingRun(t)
+    ctx := context.Background()
+
+    // An instance with a 5 minute run timeout claims the retry and dies before recording it
+    runTimeout := 5 * time.Minute
+    crashed := env.newService(crashedLogRepository{env.logRepo}, runTimeout, testRetry)
+    env.mailer.fail(errors.New("421 service not available"))
+    claimedAt := time.Now()
+    crashed.attempt(ctx, &entry)
+    env.mailer.fail(nil)
+
+    stored, err := env.logRepo.GetByID(ctx, entry.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    lease := runTimeout + retryLeaseMargin
+    if stored.Status != "pending" || stored.RetryCount != 1 || stored.NextRetryAt == nil ||
+        stored.NextRetryAt.Before(claimedAt.Add(lease)) || stored.NextRetryAt.After(time.Now().Add(lease)) {
+        t.Fatalf("log = %+v, want a pending retry 1 leased for %v", stored, lease)
+    }
+
+    // Nobody else picks it up while the lease holds
+    due, err := env.logRepo.GetDueRetries(ctx, time.Now(), retryBatchSize)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(due) != 0 {
+        t.Errorf("GetDueRetries during the lease = %+v, want none", due)
+    }
+
+    // Once the lease is over the retry is due again and the next attempt takes over
+    due, err = env.logRepo.GetDueRetries(ctx, stored.NextRetryAt.Add(time.Second), retryBatchSize)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(due) != 1 || due[0].ID != entry.ID {
+        t.Fatalf("GetDueRetries after the lease = %+v, want log %d", due, entry.ID)
+    }
+    if _, err := env.service.attempt(ctx, &due[0]); err != nil {
+        t.Fatalf("attempt after the lease: %v", err)
+    }
+
+    stored, err = env.logRepo.GetByID(ctx, entry.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored.Status != "success" || stored.RetryCount != 2 || stored.NextRetryAt != nil {
+        t.Errorf("log = %+v, want a success at retry 2", stored)
+    }
+
+    // The crashed instance's stale copy can no longer be claimed
+    if _, err := env.service.attempt(ctx, &entry); !errors.Is(err, clienterrors.ErrPreconditionFailed) {
+        t.Errorf("attempt with the crashed instance's retry count = %v, want ErrPreconditionFailed", err)
+    }
+}
+
+func TestRetryLimitMarksRunFailed(t *testing.T) {
+    env := newScheduleTestEnv(t, config.ScheduleRetry{Limit: 2, Backoff: time.Minute, MaxBackoff: time.Hour, PollInterval: time.Minute})
+    entry := env.pendingRun(t)
+    ctx := context.Background()
+    env.mailer.fail(errors.New("421 service not available"))
+
+    // The first retry fails and waits for the second backoff
+    startedAt := time.Now()
+    if _, err := env.service.attempt(ctx, &entry); err == nil {
+        t.Fatal("attempt succeeded although the email failed")
+    }
+    stored, err := env.logRepo.GetByID(ctx, entry.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored.Status != "pending" || stored.RetryCount != 1 || stored.NextRetryAt == nil ||
+        stored.NextRetryAt.Before(startedAt.Add(2*time.Minute)) || stored.NextRetryAt.After(time.Now().Add(2*time.Minute)) {
+        t.Fatalf("log = %+v, want retry 1 pending for the 2m backoff", stored)
+    }
+
+    // The last retry allowed fails for good
+    if _, err := env.service.attempt(ctx, stored); err == nil {
+        t.Fatal("attempt succeeded although the email failed")
+    }
+    stored, err = env.logRepo.GetByID(ctx, entry.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored.Status != "failed" || stored.RetryCount != 2 || stored.NextRetryAt != nil || stored.ErrorMessage == nil {
+        t.Errorf("log = %+v, want failed after 2 retries", stored)
+    }
+    due, err := env.logRepo.GetDueRetries(ctx, time.Now().Add(24*time.Hour), retryBatchSize)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(due) != 0 {
+        t.Errorf("GetDueRetries = %+v, a failed run is still retried", due)
+    }
+}
@@ -2,53 +2,248 @@ package services
 
 import (
     "bytes"
+    "context"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
     "fmt"
+    "mime"
+    "mime/multipart"
+    "net"
     "net/smtp"
+    "net/textproto"
+    "path"
+    "reflect"
+    "sort"
     "strconv"
+    "strings"
+    "sync"
     "time"
 
+    "github.com/gin-gonic/gin/binding"
+    "github.com/google/uuid"
     "github.com/xuri/excelize/v2"
     config "provider-report-api/configs" // ใช้ alias
+    clienterrors "provider-report-api/constant/errors"
     "provider-report-api/internal/modules/provider-detail/dtos"
     "provider-report-api/internal/modules/provider-detail/repositories"
+    "provider-report-api/pkg/audit"
+    "provider-report-api/pkg/auth"
+    "provider-report-api/pkg/logging"
+    "provider-report-api/pkg/metrics"
+    "provider-report-api/pkg/tracing"
+    "provider-report-api/pkg/utility"
+
+    "go.opentelemetry.io/otel/attribute"
 )
 
 // ProviderService handles provider business logic
 type ProviderService struct {
-    providerRepo *repositories.ProviderRepository
+    providerRepo repositories.ProviderStore
     exportService *ExportService
-    fieldRepo    *repositories.FieldRepository
+    fieldRepo    repositories.FieldStore
+    templateRepo repositories.TemplateStore
+    history      *HistoryService
+    scopeRepo    repositories.DataScopeStore
+    auditor      *audit.Auditor
+    quotas       *ExportQuotaService
+    exportTimeout time.Duration
+    metrics      *metrics.Metrics
 }
 
-func NewProviderService(providerRepo *repositories.ProviderRepository, exportService *ExportService, fieldRepo *repositories.FieldRepository) *ProviderService {
+// NewProviderService creates the provider service. exportTimeout bounds ExportReport, 0 means
+// exports only end with their request. Exports are reported to m, which may be nil.
+func NewProviderService(providerRepo repositories.ProviderStore, exportService *ExportService, fieldRepo repositories.FieldStore, templateRepo repositories.TemplateStore, history *HistoryService, scopeRepo repositories.DataScopeStore, auditor *audit.Auditor, quotas *ExportQuotaService, exportTimeout time.Duration, m *metrics.Metrics) *ProviderService {
     return &ProviderService{
         providerRepo:  providerRepo,
         exportService: exportService,
         fieldRepo:     fieldRepo,
+        templateRepo:  templateRepo,
+        history:       history,
+        scopeRepo:     scopeRepo,
+        auditor:       auditor,
+        quotas:        quotas,
+        exportTimeout: exportTimeout,
+        metrics:       m,
     }
 }
 
-func (s *ProviderService) SearchProviders(req dtos.ProviderSearchRequestDTO) ([]dtos.ProviderDTO, int64, error) {
-    return s.providerRepo.Search(req)
+// dataScope returns the data scope of the user role carried by ctx. Requests without a role
+// (auth disabled) are not restricted.
+func (s *ProviderService) dataScope(ctx context.Context) (*dtos.DataScopeDTO, error) {
+    userRoleID, ok := utility.UserRoleIDFromContext(ctx)
+    if !ok || s.scopeRepo == nil {
+        return nil, nil
+    }
+    return s.scopeRepo.GetByRoleID(ctx, userRoleID)
+}
+
+// scopedSearch applies the data scope of ctx to a search request
+func (s *ProviderService) scopedSearch(ctx context.Context, req dtos.ProviderSearchRequestDTO) (dtos.ProviderSearchRequestDTO, error) {
+    scope, err := s.dataScope(ctx)
+    if err != nil {
+        return req, err
+    }
+    req.Scope = scope
+    // Only auditors see deleted providers, the routes check the query string and this covers
+    // the search criteria of reports, exports and schedules
+    if req.IncludeDeleted && !utility.CanViewDeletedData(ctx) {
+        req.IncludeDeleted = false
+    }
+    return req, nil
+}
+
+// sensitiveFields returns the mask type of every sensitive field by field code
+func (s *ProviderService) sensitiveFields(ctx context.Context) (map[string]string, error) {
+    return loadSensitiveFields(ctx, s.fieldRepo)
+}
+
+// maskProviders masks the sensitive fields of providers in place unless ctx may see them
+func (s *ProviderService) maskProviders(ctx context.Context, providers []dtos.ProviderDTO) error {
+    if utility.CanViewSensitiveData(ctx) {
+        return nil
+    }
+
+    sensitiveFields, err := s.sensitiveFields(ctx)
+    if err != nil {
+        return err
+    }
+    for i := range providers {
+        maskSensitiveFields(&providers[i], sensitiveFields)
+    }
+    return nil
+}
+
+// maskProvider returns a copy of provider with its sensitive fields masked unless ctx may see them
+func (s *ProviderService) maskProvider(ctx context.Context, provider *dtos.ProviderDTO) (*dtos.ProviderDTO, error) {
+    masked := []dtos.ProviderDTO{*provider}
+    if err := s.maskProviders(ctx, masked); err != nil {
+        return nil, err
+    }
+    return &masked[0], nil
+}
+
+// auditExport records an export in the audit trail. Exports that contain unmasked sensitive
+// fields are recorded as warnings with the fields that were exported. Fields the export can't
+// render are left out, their column is blank.
+func (s *ProviderService) auditExport(ctx context.Context, req dtos.ProviderReportRequestDTO, fields []dtos.AvailableFieldDTO, total int64) error {
+    data := map[string]interface{}{
+        "total_records": total,
+        "format_type":   req.FormatType,
+        "template_id":   req.TemplateID,
+        "criteria":      req.SearchParams,
+    }
+    level := audit.LevelInfo
+
+    if utility.CanViewSensitiveData(ctx) {
+        sensitiveFields, err := s.sensitiveFields(ctx)
+        if err != nil {
+            return err
+        }
+
+        var exported []string
+        for _, field := range fields {
+            if _, ok := sensitiveFields[field.FieldCode]; !ok {
+                continue
+            }
+            if _, ok := providerFieldIndexes[field.FieldCode]; ok {
+                exported = append(exported, field.FieldCode)
+            }
+        }
+        if len(exported) > 0 {
+            data["unmasked_sensitive_fields"] = exported
+            level = audit.LevelWarn
+        }
+    }
+
+    emitAudit(s.auditor, ctx, auditActionExport, level, data)
+    return nil
+}
+
+func (s *ProviderService) SearchProviders(ctx context.Context, req dtos.ProviderSearchRequestDTO) ([]dtos.ProviderDTO, int64, error) {
+    req, err := s.scopedSearch(ctx, req)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    providers, total, err := s.providerRepo.Search(ctx, req)
+    if err != nil {
+        return nil, 0, err
+    }
+    if err := s.maskProviders(ctx, providers); err != nil {
+        return nil, 0, err
+    }
+    return providers, total, nil
+}
+
+func (s *ProviderService) GetProviderSummary(ctx context.Context, req dtos.ProviderSearchRequestDTO) (*dtos.ProviderSummaryDTO, error) {
+    req, err := s.scopedSearch(ctx, req)
+    if err != nil {
+        return nil, err
+    }
+    return s.providerRepo.GetSummary(ctx, req)
 }
 
-func (s *ProviderService) GetProviderSummary(req dtos.ProviderSearchRequestDTO) (*dtos.ProviderSummaryDTO, error) {
-    return s.providerRepo.GetSummary(req)
+// GenerateReport returns the report data within the daily export quota of the current user, the
+// same way ExportReport does. The rows count against the quota, the JSON response does not count
+// against the byte volume since no file is produced.
+func (s *ProviderService) GenerateReport(ctx context.Context, req dtos.ProviderReportRequestDTO) (*dtos.ProviderReportDataDTO, *dtos.ExportQuotaStatusDTO, error) {
+    if s.quotas == nil {
+        reportData, err := s.generateReport(ctx, req)
+        return reportData, nil, err
+    }
+
+    quota, err := s.quotas.Status(ctx)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to get export quota: %w", err)
+    }
+    if quota.RowsRemaining() == 0 || quota.BytesRemaining() == 0 {
+        return nil, quota, clienterrors.ErrQuotaExceeded
+    }
+
+    reportData, err := s.generateReport(ctx, req)
+    if err != nil {
+        return nil, quota, err
+    }
+    // Only the returned page counts, Total is every match of the search
+    rows := int64(len(reportData.Providers))
+    if !quota.Allows(rows, 0) {
+        return nil, quota, fmt.Errorf("%w: the report has %d rows", clienterrors.ErrQuotaExceeded, rows)
+    }
+
+    if err := s.quotas.Record(ctx, rows, 0); err != nil {
+        logging.FromContext(ctx).Error("failed to record report usage", "user", actorFromContext(ctx), "error", err)
+    } else {
+        quota.RowsUsed += rows
+    }
+    return reportData, quota, nil
 }
 
-func (s *ProviderService) GenerateReport(req dtos.ProviderReportRequestDTO) (*dtos.ProviderReportDataDTO, error) {
+func (s *ProviderService) generateReport(ctx context.Context, req dtos.ProviderReportRequestDTO) (*dtos.ProviderReportDataDTO, error) {
+    searchParams, err := s.scopedSearch(ctx, req.SearchParams)
+    if err != nil {
+        return nil, err
+    }
+    req.SearchParams = searchParams
+
     // Get provider data
-    providers, total, err := s.providerRepo.Search(req.SearchParams)
+    providers, total, err := s.providerRepo.Search(ctx, req.SearchParams)
     if err != nil {
         return nil, fmt.Errorf("failed to search providers: %w", err)
     }
 
     // Get summary
-    summary, err := s.providerRepo.GetSummary(req.SearchParams)
+    summary, err := s.providerRepo.GetSummary(ctx, req.SearchParams)
     if err != nil {
         return nil, fmt.Errorf("failed to get provider summary: %w", err)
     }
 
+    if err := s.maskProviders(ctx, providers); err != nil {
+        return nil, err
+    }
+
     // Generate header information
     header := map[string]interface{}{
         "generated_at":    time.Now(),
@@ -66,137 +261,365 @@ func (s *ProviderService) GenerateReport(req dtos.ProviderReportRequestDTO) (*dt
     }, nil
 }
 
-func (s *ProviderService) ExportReport(req dtos.ProviderReportRequestDTO) ([]byte, string, string, error) {
+// ExportReport exports the report within the daily export quota of the current user. The
+// quota status is returned with ErrQuotaExceeded too, and is nil when quotas are disabled.
+// The export stops when ctx is cancelled or the export timeout expires.
+func (s *ProviderService) ExportReport(ctx context.Context, req dtos.ProviderReportRequestDTO) ([]byte, string, string, *dtos.ExportQuotaStatusDTO, error) {
+    ctx, cancel := withTimeout(ctx, s.exportTimeout)
+    defer cancel()
+
+    if s.quotas == nil {
+        data, filename, contentType, _, err := s.exportReport(ctx, req)
+        return data, filename, contentType, nil, err
+    }
+
+    quota, err := s.quotas.Status(ctx)
+    if err != nil {
+        return nil, "", "", nil, fmt.Errorf("failed to get export quota: %w", err)
+    }
+    if quota.RowsRemaining() == 0 || quota.BytesRemaining() == 0 {
+        return nil, "", "", quota, clienterrors.ErrQuotaExceeded
+    }
+
+    data, filename, contentType, total, err := s.exportReport(ctx, req)
+    if err != nil {
+        return nil, "", "", quota, err
+    }
+
+    // The size is only known once the file is generated, so the last export of the day may be
+    // refused after the work is done. Concurrent exports can overshoot the quota slightly.
+    size := int64(len(data))
+    if !quota.Allows(total, size) {
+        return nil, "", "", quota, fmt.Errorf("%w: the export has %d rows and %d bytes", clienterrors.ErrQuotaExceeded, total, size)
+    }
+
+    if err := s.quotas.Record(ctx, total, size); err != nil {
+        // The user already has the file; losing one usage record is better than failing the export
+        logging.FromContext(ctx).Error("failed to record export usage", "user", actorFromContext(ctx), "error", err)
+    } else {
+        quota.RowsUsed += total
+        quota.BytesUsed += size
+    }
+    return data, filename, contentType, quota, nil
+}
+
+// exportReport exports the report and also returns the number of providers written to the file
+func (s *ProviderService) exportReport(ctx context.Context, req dtos.ProviderReportRequestDTO) ([]byte, string, string, int64, error) {
+    format := exportFormat(req.FormatType)
+    ctx, span := tracing.Start(ctx, "report.export", attribute.String("report.format", format))
+    startedAt := time.Now()
+
+    data, filename, contentType, total, err := s.renderReport(ctx, req)
+
+    s.metrics.ObserveExport(format, time.Since(startedAt), total, int64(len(data)), err)
+    span.SetAttributes(attribute.Int64("report.rows", total), attribute.Int("report.bytes", len(data)))
+    tracing.End(span, err)
+    return data, filename, contentType, total, err
+}
+
+// exportFormat returns the format an export is rendered in, Excel unless CSV, PDF or Word is asked
+func exportFormat(formatType string) string {
+    switch formatType {
+    case "csv", "pdf", "word":
+        return formatType
+    default:
+        return "excel"
+    }
+}
+
+func (s *ProviderService) renderReport(ctx context.Context, req dtos.ProviderReportRequestDTO) ([]byte, string, string, int64, error) {
     // Generate report data
-    reportData, err := s.GenerateReport(req)
+    reportData, err := s.generateReport(ctx, req)
     if err != nil {
-        return nil, "", "", fmt.Errorf("failed to generate report: %w", err)
+        return nil, "", "", 0, fmt.Errorf("failed to generate report: %w", err)
+    }
+
+    fields, err := s.exportFields(ctx, req)
+    if err != nil {
+        return nil, "", "", 0, err
+    }
+
+    if err := s.auditExport(ctx, req, fields, reportData.Total); err != nil {
+        return nil, "", "", 0, err
+    }
+
+    // Don't start building the file for a client that is gone
+    if err := ctx.Err(); err != nil {
+        return nil, "", "", 0, err
+    }
+
+    // Export based on format. The queries above have their own spans, this one times the file.
+    renderCtx, span := tracing.Start(ctx, "report.render", attribute.Int("report.fields", len(fields)))
+    var data []byte
+    var filename, contentType string
+    switch exportFormat(req.FormatType) {
+    case "csv":
+        data, filename, contentType, err = s.exportService.ExportToCSV(renderCtx, reportData, fields)
+    case "pdf":
+        data, filename, contentType, err = s.exportService.ExportToPDF(renderCtx, reportData, fields)
+    case "word":
+        data, filename, contentType, err = s.exportService.ExportToWord(renderCtx, reportData, fields)
+    default:
+        data, filename, contentType, err = s.exportService.ExportToExcel(renderCtx, reportData, fields)
+    }
+    tracing.End(span, err)
+    // Total counts every match of the search, the file has the rows of the requested page
+    return data, filename, contentType, int64(len(reportData.Providers)), err
+}
+
+// exportFields returns the columns of an export: the custom fields of the request, else the data
+// fields of its template, else every available field
+func (s *ProviderService) exportFields(ctx context.Context, req dtos.ProviderReportRequestDTO) ([]dtos.AvailableFieldDTO, error) {
+    codes := req.CustomFields
+    if len(codes) == 0 && req.TemplateID != nil && s.templateRepo != nil {
+        template, err := s.templateRepo.GetByID(ctx, *req.TemplateID)
+        if err != nil {
+            return nil, fmt.Errorf("failed to get template: %w", err)
+        }
+        codes = template.DataFields
     }
 
-    // Get fields for export
     var fields []dtos.AvailableFieldDTO
-    if len(req.CustomFields) > 0 {
-        fields, err = s.fieldRepo.GetFieldsForExport(req.CustomFields)
+    var err error
+    if len(codes) > 0 {
+        fields, err = s.fieldRepo.GetFieldsForExport(ctx, codes)
         if err != nil {
-            return nil, "", "", fmt.Errorf("failed to get custom fields: %w", err)
+            return nil, fmt.Errorf("failed to get custom fields: %w", err)
         }
     } else {
         // Use all available fields
-        fields, err = s.fieldRepo.GetAllFields()
+        fields, err = s.fieldRepo.GetAllFields(ctx)
         if err != nil {
-            return nil, "", "", fmt.Errorf("failed to get all fields: %w", err)
+            return nil, fmt.Errorf("failed to get all fields: %w", err)
         }
     }
-
-    // Export based on format
-    switch req.FormatType {
-    case "excel":
-        return s.exportService.ExportToExcel(reportData, fields)
-    case "pdf":
-        return s.exportService.ExportToPDF(reportData, fields)
-    case "word":
-        return s.exportService.ExportToWord(reportData, fields)
-    default:
-        return s.exportService.ExportToExcel(reportData, fields)
-    }
+    return fields, nil
 }
 
-func (s *ProviderService) GetProvinces() ([]string, error) {
-    return s.providerRepo.GetProvinces()
+func (s *ProviderService) GetProvinces(ctx context.Context) ([]string, error) {
+    scope, err := s.dataScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return s.providerRepo.GetProvinces(ctx, scope)
 }
 
-func (s *ProviderService) GetProviderTypes() ([]string, error) {
-    return s.providerRepo.GetProviderTypes()
+func (s *ProviderService) GetProviderTypes(ctx context.Context) ([]string, error) {
+    scope, err := s.dataScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return s.providerRepo.GetProviderTypes(ctx, scope)
 }
 
-func (s *ProviderService) GetProviderStats() (map[string]interface{}, error) {
-    return s.providerRepo.GetProviderStats()
+func (s *ProviderService) GetProviderStats(ctx context.Context) (map[string]interface{}, error) {
+    scope, err := s.dataScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return s.providerRepo.GetProviderStats(ctx, scope)
 }
 
-func (s *ProviderService) CreateProvider(req dtos.CreateProviderRequestDTO) (*dtos.ProviderDTO, error) {
+func (s *ProviderService) CreateProvider(ctx context.Context, req dtos.CreateProviderRequestDTO) (*dtos.ProviderDTO, error) {
+    createdBy := actorFromContext(ctx)
     provider := &dtos.ProviderDTO{
-        ProviderCode:   req.ProviderCode,
-        NameThai:       req.NameThai,
-        NameEng:        req.NameEng,
-        ProviderType:   req.ProviderType,
-        BusinessType:   req.BusinessType,
-        Province:       req.Province,
-        GeneralPhoneNo: req.GeneralPhoneNo,
-        IsTPANetwork:   req.IsTPANetwork,
-        ProviderStatus: req.ProviderStatus,
+        ProviderCode: req.ProviderCode,
+        CreatedBy:    &createdBy,
     }
+    req.ProviderDetailsDTO.ApplyTo(provider)
 
-    err := s.providerRepo.Create(provider)
+    err := s.providerRepo.Create(ctx, provider)
     if err != nil {
         return nil, fmt.Errorf("failed to create provider: %w", err)
     }
 
-    return provider, nil
+    s.history.Record(ctx, dtos.HistoryEntityProvider, provider.ID, dtos.HistoryActionCreate, createdBy, nil, provider)
+    auditChange(s.auditor, ctx, dtos.HistoryEntityProvider, provider.ID, dtos.HistoryActionCreate, nil, provider)
+    return s.maskProvider(ctx, provider)
 }
 
-func (s *ProviderService) GetProvider(id int) (*dtos.ProviderDTO, error) {
-    return s.providerRepo.GetByID(id)
+func (s *ProviderService) GetProvider(ctx context.Context, id int) (*dtos.ProviderDTO, error) {
+    scope, err := s.dataScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return s.providerRepo.GetByID(ctx, id, scope)
 }
 
-func (s *ProviderService) GetProviderByID(id int) (*dtos.ProviderDTO, error) {
-    return s.providerRepo.GetByID(id)
+// GetProviderByID returns a provider with its sensitive fields masked for the caller.
+// Providers outside the caller's data scope are reported as missing.
+func (s *ProviderService) GetProviderByID(ctx context.Context, id int) (*dtos.ProviderDTO, error) {
+    provider, err := s.GetProvider(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    return s.maskProvider(ctx, provider)
 }
 
-func (s *ProviderService) UpdateProvider(id int, req dtos.UpdateProviderRequestDTO) (*dtos.ProviderDTO, error) {
-    provider, err := s.providerRepo.GetByID(id)
+// UpdateProvider replaces the provider sections and validates them. expectedVersion is the
+// If-Match version of the caller, nil when the caller did not send one.
+func (s *ProviderService) UpdateProvider(ctx context.Context, id int, req dtos.UpdateProviderRequestDTO, expectedVersion *int) (*dtos.ProviderDTO, error) {
+    provider, err := s.GetProvider(ctx, id)
     if err != nil {
         return nil, fmt.Errorf("provider not found: %w", err)
     }
 
-    // Update fields
-    provider.NameThai = req.NameThai
-    provider.NameEng = req.NameEng
-    provider.ProviderType = req.ProviderType
-    provider.BusinessType = req.BusinessType
-    provider.Province = req.Province
-    provider.GeneralPhoneNo = req.GeneralPhoneNo
-    provider.IsTPANetwork = req.IsTPANetwork
-    provider.ProviderStatus = req.ProviderStatus
+    if err := checkVersion(expectedVersion, provider.Version); err != nil {
+        return nil, err
+    }
+
+    return s.saveProvider(ctx, provider, req)
+}
+
+// PatchProvider applies a JSON merge patch (RFC 7396) to the maintainable sections of a
+// provider. The merged document is validated exactly like a full update.
+func (s *ProviderService) PatchProvider(ctx context.Context, id int, patch []byte, expectedVersion *int) (*dtos.ProviderDTO, error) {
+    provider, err := s.GetProvider(ctx, id)
+    if err != nil {
+        return nil, fmt.Errorf("provider not found: %w", err)
+    }
+
+    if err := checkVersion(expectedVersion, provider.Version); err != nil {
+        return nil, err
+    }
+
+    current, err := json.Marshal(dtos.NewUpdateProviderRequest(provider))
+    if err != nil {
+        return nil, fmt.Errorf("failed to encode provider: %w", err)
+    }
+
+    merged, err := utility.MergePatch(current, patch)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", clienterrors.ErrInvalidInput, err)
+    }
+
+    var req dtos.UpdateProviderRequestDTO
+    decoder := json.NewDecoder(bytes.NewReader(merged))
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(&req); err != nil {
+        return nil, fmt.Errorf("%w: %v", clienterrors.ErrInvalidInput, err)
+    }
+
+    return s.saveProvider(ctx, provider, req)
+}
 
-    err = s.providerRepo.Update(provider)
+func (s *ProviderService) saveProvider(ctx context.Context, provider *dtos.ProviderDTO, req dtos.UpdateProviderRequestDTO) (*dtos.ProviderDTO, error) {
+    updatedBy := actorFromContext(ctx)
+    sensitiveFields, err := s.sensitiveFields(ctx)
+    if err != nil {
+        return nil, err
+    }
+    scope, err := s.dataScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    before := *provider
+    req.ProviderDetailsDTO.ApplyTo(provider)
+    provider.UpdatedBy = &updatedBy
+    // Clients send back the masked values they were shown, which must not replace the real ones.
+    // They are restored first so a masked tax ID doesn't fail validation.
+    keepMaskedValues(&before, provider, sensitiveFields)
+    restored := dtos.NewUpdateProviderRequest(provider)
+    if err := binding.Validator.ValidateStruct(&restored); err != nil {
+        return nil, err
+    }
+
+    err = s.providerRepo.Update(ctx, provider, scope)
     if err != nil {
         return nil, fmt.Errorf("failed to update provider: %w", err)
     }
 
-    return provider, nil
+    s.history.Record(ctx, dtos.HistoryEntityProvider, provider.ID, dtos.HistoryActionUpdate, updatedBy, &before, provider)
+    auditChange(s.auditor, ctx, dtos.HistoryEntityProvider, provider.ID, dtos.HistoryActionUpdate, &before, provider)
+    return s.maskProvider(ctx, provider)
+}
+
+// GetProviderIncludingDeleted returns a provider in the caller's data scope even if it is in
+// the trash
+func (s *ProviderService) GetProviderIncludingDeleted(ctx context.Context, id int) (*dtos.ProviderDTO, error) {
+    scope, err := s.dataScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    provider, err := s.providerRepo.GetByIDIncludingDeleted(ctx, id, scope)
+    if err != nil {
+        return nil, err
+    }
+    return s.maskProvider(ctx, provider)
+}
+
+// GetDeletedProviders lists soft-deleted providers matching the search
+func (s *ProviderService) GetDeletedProviders(ctx context.Context, req dtos.ProviderSearchRequestDTO) ([]dtos.ProviderDTO, int64, error) {
+    req.OnlyDeleted = true
+    return s.SearchProviders(ctx, req)
+}
+
+func (s *ProviderService) DeleteProvider(ctx context.Context, id int) error {
+    deletedBy := actorFromContext(ctx)
+    scope, err := s.dataScope(ctx)
+    if err != nil {
+        return err
+    }
+    err = s.providerRepo.Delete(ctx, id, &deletedBy, scope)
+    if err != nil {
+        return err
+    }
+
+    s.history.Record(ctx, dtos.HistoryEntityProvider, id, dtos.HistoryActionDelete, deletedBy, nil, nil)
+    auditChange(s.auditor, ctx, dtos.HistoryEntityProvider, id, dtos.HistoryActionDelete, nil, nil)
+    return nil
 }
 
-func (s *ProviderService) DeleteProvider(id int) error {
-    return s.providerRepo.Delete(id)
+func (s *ProviderService) RestoreProvider(ctx context.Context, id int) (*dtos.ProviderDTO, error) {
+    restoredBy := actorFromContext(ctx)
+    scope, err := s.dataScope(ctx)
+    if err != nil {
+        return nil, err
+    }
+    err = s.providerRepo.Restore(ctx, id, &restoredBy, scope)
+    if err != nil {
+        return nil, err
+    }
+
+    s.history.Record(ctx, dtos.HistoryEntityProvider, id, dtos.HistoryActionRestore, restoredBy, nil, nil)
+    auditChange(s.auditor, ctx, dtos.HistoryEntityProvider, id, dtos.HistoryActionRestore, nil, nil)
+    return s.GetProviderByID(ctx, id)
 }
 
 // TemplateService handles template business logic
 type TemplateService struct {
-    templateRepo *repositories.TemplateRepository
-    fieldRepo    *repositories.FieldRepository
+    templateRepo repositories.TemplateStore
+    fieldRepo    repositories.FieldStore
+    history      *HistoryService
+    auditor      *audit.Auditor
 }
 
-func NewTemplateService(templateRepo *repositories.TemplateRepository, fieldRepo *repositories.FieldRepository) *TemplateService {
+func NewTemplateService(templateRepo repositories.TemplateStore, fieldRepo repositories.FieldStore, history *HistoryService, auditor *audit.Auditor) *TemplateService {
     return &TemplateService{
         templateRepo: templateRepo,
         fieldRepo:    fieldRepo,
+        history:      history,
+        auditor:      auditor,
     }
 }
 
-func (s *TemplateService) GetAllTemplates() ([]dtos.TemplateDTO, error) {
-    return s.templateRepo.GetAll()
+func (s *TemplateService) GetAllTemplates(ctx context.Context) ([]dtos.TemplateDTO, error) {
+    return s.templateRepo.GetAll(ctx)
 }
 
-func (s *TemplateService) GetTemplate(id int) (*dtos.TemplateDTO, error) {
-    return s.templateRepo.GetByID(id)
+func (s *TemplateService) GetTemplate(ctx context.Context, id int) (*dtos.TemplateDTO, error) {
+    return s.templateRepo.GetByID(ctx, id)
 }
 
-func (s *TemplateService) CreateTemplate(req dtos.CreateTemplateRequestDTO) (*dtos.TemplateDTO, error) {
+func (s *TemplateService) CreateTemplate(ctx context.Context, req dtos.CreateTemplateRequestDTO) (*dtos.TemplateDTO, error) {
+    createdBy := actorFromContext(ctx)
+
     // Validate fields
     allFields := append(req.HeaderFields, req.DataFields...)
     allFields = append(allFields, req.SummaryFields...)
     
-    validationResults, err := s.fieldRepo.ValidateFields(allFields)
+    validationResults, err := s.fieldRepo.ValidateFields(ctx, allFields)
     if err != nil {
         return nil, fmt.Errorf("failed to validate fields: %w", err)
     }
@@ -215,28 +638,36 @@ func (s *TemplateService) CreateTemplate(req dtos.CreateTemplateRequestDTO) (*dt
         DataFields:     dtos.JSONFieldArray(req.DataFields),
         SummaryFields:  dtos.JSONFieldArray(req.SummaryFields),
         FieldPositions: &req.FieldPositions,
-        CreatedBy:      "system", // TODO: Get from context
+        CreatedBy:      createdBy,
     }
 
-    err = s.templateRepo.Create(template)
+    err = s.templateRepo.Create(ctx, template)
     if err != nil {
         return nil, fmt.Errorf("failed to create template: %w", err)
     }
 
+    s.history.Record(ctx, dtos.HistoryEntityTemplate, template.ID, dtos.HistoryActionCreate, createdBy, nil, template)
+    auditChange(s.auditor, ctx, dtos.HistoryEntityTemplate, template.ID, dtos.HistoryActionCreate, nil, template)
     return template, nil
 }
 
-func (s *TemplateService) UpdateTemplate(id int, req dtos.UpdateTemplateRequestDTO) (*dtos.TemplateDTO, error) {
-    template, err := s.templateRepo.GetByID(id)
+func (s *TemplateService) UpdateTemplate(ctx context.Context, id int, req dtos.UpdateTemplateRequestDTO, expectedVersion *int) (*dtos.TemplateDTO, error) {
+    updatedBy := actorFromContext(ctx)
+
+    template, err := s.templateRepo.GetByID(ctx, id)
     if err != nil {
         return nil, fmt.Errorf("template not found: %w", err)
     }
 
+    if err := checkVersion(expectedVersion, template.Version); err != nil {
+        return nil, err
+    }
+
     // Validate fields
     allFields := append(req.HeaderFields, req.DataFields...)
     allFields = append(allFields, req.SummaryFields...)
     
-    validationResults, err := s.fieldRepo.ValidateFields(allFields)
+    validationResults, err := s.fieldRepo.ValidateFields(ctx, allFields)
     if err != nil {
         return nil, fmt.Errorf("failed to validate fields: %w", err)
     }
@@ -248,6 +679,7 @@ func (s *TemplateService) UpdateTemplate(id int, req dtos.UpdateTemplateRequestD
     }
 
     // Update fields
+    before := *template
     template.TemplateName = req.TemplateName
     template.IsStandard = req.IsStandard
     template.Description = &req.Description
@@ -255,46 +687,82 @@ func (s *TemplateService) UpdateTemplate(id int, req dtos.UpdateTemplateRequestD
     template.DataFields = dtos.JSONFieldArray(req.DataFields)
     template.SummaryFields = dtos.JSONFieldArray(req.SummaryFields)
     template.FieldPositions = &req.FieldPositions
-    template.UpdatedBy = stringPtr("system") // TODO: Get from context
+    template.UpdatedBy = &updatedBy
 
-    err = s.templateRepo.Update(template)
+    err = s.templateRepo.Update(ctx, template)
     if err != nil {
         return nil, fmt.Errorf("failed to update template: %w", err)
     }
 
+    s.history.Record(ctx, dtos.HistoryEntityTemplate, template.ID, dtos.HistoryActionUpdate, updatedBy, &before, template)
+    auditChange(s.auditor, ctx, dtos.HistoryEntityTemplate, template.ID, dtos.HistoryActionUpdate, &before, template)
     return template, nil
 }
 
-func (s *TemplateService) DeleteTemplate(id int) error {
-    return s.templateRepo.Delete(id)
+func (s *TemplateService) DeleteTemplate(ctx context.Context, id int) error {
+    deletedBy := actorFromContext(ctx)
+    err := s.templateRepo.Delete(ctx, id, &deletedBy)
+    if err != nil {
+        return err
+    }
+
+    s.history.Record(ctx, dtos.HistoryEntityTemplate, id, dtos.HistoryActionDelete, deletedBy, nil, nil)
+    auditChange(s.auditor, ctx, dtos.HistoryEntityTemplate, id, dtos.HistoryActionDelete, nil, nil)
+    return nil
 }
 
 // ScheduleService handles schedule business logic
 type ScheduleService struct {
-    scheduleRepo  *repositories.ScheduleRepository
-    templateRepo  *repositories.TemplateRepository
-    emailService  *EmailService
+    scheduleRepo    repositories.ScheduleStore
+    templateRepo    repositories.TemplateStore
+    emailService    *EmailService
+    history         *HistoryService
+    providerService *ProviderService
+    logRepo         repositories.LogStore
+    auditor         *audit.Auditor
+    runTimeout      time.Duration
+    metrics         *metrics.Metrics
+    retry           config.ScheduleRetry
+
+    // The schedule runs in progress are counted so shutdown can wait for them. idle is closed
+    // when running drops to 0 while someone waits.
+    mu       sync.Mutex
+    running  int
+    draining bool
+    idle     chan struct{}
 }
 
-func NewScheduleService(scheduleRepo *repositories.ScheduleRepository, templateRepo *repositories.TemplateRepository, emailService *EmailService) *ScheduleService {
+// NewScheduleService creates the schedule service. runTimeout bounds a schedule run including
+// its email, 0 means runs only end with their request. Runs are reported to m, which may be nil.
+// Failed runs are retried as set by retry, see RetryFailedRuns.
+func NewScheduleService(scheduleRepo repositories.ScheduleStore, templateRepo repositories.TemplateStore, emailService *EmailService, history *HistoryService, providerService *ProviderService, logRepo repositories.LogStore, auditor *audit.Auditor, runTimeout time.Duration, m *metrics.Metrics, retry config.ScheduleRetry) *ScheduleService {
     return &ScheduleService{
-        scheduleRepo: scheduleRepo,
-        templateRepo: templateRepo,
-        emailService: emailService,
+        scheduleRepo:    scheduleRepo,
+        templateRepo:    templateRepo,
+        emailService:    emailService,
+        history:         history,
+        providerService: providerService,
+        logRepo:         logRepo,
+        auditor:         auditor,
+        runTimeout:      runTimeout,
+        metrics:         m,
+        retry:           retry,
     }
 }
 
-func (s *ScheduleService) GetAllSchedules() ([]dtos.ScheduleDTO, error) {
-    return s.scheduleRepo.GetAll()
+func (s *ScheduleService) GetAllSchedules(ctx context.Context) ([]dtos.ScheduleDTO, error) {
+    return s.scheduleRepo.GetAll(ctx)
 }
 
-func (s *ScheduleService) GetSchedule(id int) (*dtos.ScheduleDTO, error) {
-    return s.scheduleRepo.GetByID(id)
+func (s *ScheduleService) GetSchedule(ctx context.Context, id int) (*dtos.ScheduleDTO, error) {
+    return s.scheduleRepo.GetByID(ctx, id)
 }
 
-func (s *ScheduleService) CreateSchedule(req dtos.CreateScheduleRequestDTO) (*dtos.ScheduleDTO, error) {
+func (s *ScheduleService) CreateSchedule(ctx context.Context, req dtos.CreateScheduleRequestDTO) (*dtos.ScheduleDTO, error) {
+    createdBy := actorFromContext(ctx)
+
     // Validate template exists
-    _, err := s.templateRepo.GetByID(req.TemplateID)
+    _, err := s.templateRepo.GetByID(ctx, req.TemplateID)
     if err != nil {
         return nil, fmt.Errorf("template not found: %w", err)
     }
@@ -317,25 +785,38 @@ func (s *ScheduleService) CreateSchedule(req dtos.CreateScheduleRequestDTO) (*dt
         IsActive:       true,
         SearchCriteria: searchCriteria,
         ExportFormat:   req.ExportFormat,
-        CreatedBy:      "system", // TODO: Get from context
+        CreatedBy:      createdBy,
+    }
+
+    // The report always runs with the data scope of the role that created it
+    if userRoleID, ok := utility.UserRoleIDFromContext(ctx); ok {
+        schedule.OwnerRoleID = &userRoleID
     }
 
-    err = s.scheduleRepo.Create(schedule)
+    err = s.scheduleRepo.Create(ctx, schedule)
     if err != nil {
         return nil, fmt.Errorf("failed to create schedule: %w", err)
     }
 
+    s.history.Record(ctx, dtos.HistoryEntitySchedule, schedule.ID, dtos.HistoryActionCreate, createdBy, nil, schedule)
+    auditChange(s.auditor, ctx, dtos.HistoryEntitySchedule, schedule.ID, dtos.HistoryActionCreate, nil, schedule)
     return schedule, nil
 }
 
-func (s *ScheduleService) UpdateSchedule(id int, req dtos.UpdateScheduleRequestDTO) (*dtos.ScheduleDTO, error) {
-    schedule, err := s.scheduleRepo.GetByID(id)
+func (s *ScheduleService) UpdateSchedule(ctx context.Context, id int, req dtos.UpdateScheduleRequestDTO, expectedVersion *int) (*dtos.ScheduleDTO, error) {
+    updatedBy := actorFromContext(ctx)
+
+    schedule, err := s.scheduleRepo.GetByID(ctx, id)
     if err != nil {
         return nil, fmt.Errorf("schedule not found: %w", err)
     }
 
+    if err := checkVersion(expectedVersion, schedule.Version); err != nil {
+        return nil, err
+    }
+
     // Validate template exists
-    _, err = s.templateRepo.GetByID(req.TemplateID)
+    _, err = s.templateRepo.GetByID(ctx, req.TemplateID)
     if err != nil {
         return nil, fmt.Errorf("template not found: %w", err)
     }
@@ -344,6 +825,7 @@ func (s *ScheduleService) UpdateSchedule(id int, req dtos.UpdateScheduleRequestD
     searchCriteria := dtos.JSONMap(req.SearchCriteria)
 
     // Update fields
+    before := *schedule
     schedule.ScheduleName = req.ScheduleName
     schedule.TemplateID = req.TemplateID
     schedule.EmailTo = req.EmailTo
@@ -358,123 +840,1140 @@ func (s *ScheduleService) UpdateSchedule(id int, req dtos.UpdateScheduleRequestD
     schedule.IsActive = req.IsActive
     schedule.SearchCriteria = searchCriteria
     schedule.ExportFormat = req.ExportFormat
-    schedule.UpdatedBy = stringPtr("system") // TODO: Get from context
+    schedule.UpdatedBy = &updatedBy
+    // Schedules created before data scoping have no owner and can't run until someone takes
+    // them over by saving them
+    if schedule.OwnerRoleID == nil {
+        if userRoleID, ok := utility.UserRoleIDFromContext(ctx); ok {
+            schedule.OwnerRoleID = &userRoleID
+        }
+    }
 
-    err = s.scheduleRepo.Update(schedule)
+    err = s.scheduleRepo.Update(ctx, schedule)
     if err != nil {
         return nil, fmt.Errorf("failed to update schedule: %w", err)
     }
 
+    s.history.Record(ctx, dtos.HistoryEntitySchedule, schedule.ID, dtos.HistoryActionUpdate, updatedBy, &before, schedule)
+    auditChange(s.auditor, ctx, dtos.HistoryEntitySchedule, schedule.ID, dtos.HistoryActionUpdate, &before, schedule)
     return schedule, nil
 }
 
-func (s *ScheduleService) DeleteSchedule(id int) error {
-    return s.scheduleRepo.Delete(id)
+func (s *ScheduleService) DeleteSchedule(ctx context.Context, id int) error {
+    deletedBy := actorFromContext(ctx)
+    err := s.scheduleRepo.Delete(ctx, id, &deletedBy)
+    if err != nil {
+        return err
+    }
+
+    s.history.Record(ctx, dtos.HistoryEntitySchedule, id, dtos.HistoryActionDelete, deletedBy, nil, nil)
+    auditChange(s.auditor, ctx, dtos.HistoryEntitySchedule, id, dtos.HistoryActionDelete, nil, nil)
+    return nil
 }
 
-func (s *ScheduleService) RunSchedule(id int) (*dtos.RunScheduleResponseDTO, error) {
-    schedule, err := s.scheduleRepo.GetByID(id)
+// RunSchedule exports the report of a schedule and emails it. The run stops when ctx is
+// cancelled or the run timeout expires; the run is logged either way, and a failed run is
+// logged as pending until its retries are used up.
+func (s *ScheduleService) RunSchedule(ctx context.Context, id int) (*dtos.RunScheduleResponseDTO, error) {
+    if !s.startRun() {
+        s.metrics.ScheduleRunRejected()
+        return nil, clienterrors.ErrShuttingDown
+    }
+    defer s.finishRun()
+
+    ctx, cancel := withTimeout(ctx, s.runTimeout)
+    defer cancel()
+
+    ctx, span := tracing.Start(ctx, "schedule.run", attribute.Int("schedule.id", id))
+    var err error
+    defer func() { tracing.End(span, err) }()
+
+    schedule, err := s.scheduleRepo.GetByID(ctx, id)
     if err != nil {
         return nil, fmt.Errorf("schedule not found: %w", err)
     }
 
-    // TODO: Implement actual schedule execution
-    // This would involve:
-    // 1. Converting SearchCriteria to ProviderSearchRequestDTO
-    // 2. Generating report
-    // 3. Sending email
-    // 4. Logging the result
+    req, err := scheduleReportRequest(schedule)
+    if err != nil {
+        return nil, err
+    }
+
+    startedAt := time.Now()
+    data, filename, total, err := s.deliver(ctx, schedule, req)
+
+    s.metrics.ObserveScheduleRun(time.Since(startedAt), err)
+    s.logRun(ctx, schedule, nil, filename, len(data), int(total), time.Since(startedAt), err)
+    s.auditRun(ctx, schedule, int(total), err)
+    if err != nil {
+        return nil, fmt.Errorf("failed to run schedule: %w", err)
+    }
 
     // Update last run time
-    err = s.scheduleRepo.UpdateLastRun(id)
+    err = s.scheduleRepo.UpdateLastRun(ctx, id)
     if err != nil {
         return nil, fmt.Errorf("failed to update last run: %w", err)
     }
 
     return &dtos.RunScheduleResponseDTO{
         Message:     "Schedule executed successfully",
-        ExecutedAt:  time.Now(),
+        ExecutedAt:  startedAt,
         Recipients:  schedule.EmailTo,
-        RecordCount: 0, // TODO: Get actual count
-        FileSize:    "0 KB", // TODO: Get actual size
+        RecordCount: int(total),
+        FileSize:    fmt.Sprintf("%d KB", fileSizeKB(len(data))),
         Status:      "success",
     }, nil
 }
 
-// LogService handles log business logic
-type LogService struct {
-    logRepo *repositories.LogRepository
+// deliver exports the report of schedule and emails it to schedule.EmailTo. The schedule
+// must have an owner role, see scheduleReportRequest.
+func (s *ScheduleService) deliver(ctx context.Context, schedule *dtos.ScheduleDTO, req dtos.ProviderReportRequestDTO) ([]byte, string, int64, error) {
+    // Run with the owner's data scope so the report never contains rows the owner can't see,
+    // whoever triggered the run
+    ctx = utility.ContextWithUserRoleID(ctx, *schedule.OwnerRoleID)
+    // Emailed reports leave the system, so sensitive fields are always masked
+    ctx = utility.ContextWithSensitiveDataAccess(ctx, false)
+
+    data, filename, _, total, err := s.providerService.exportReport(ctx, req)
+    if err == nil {
+        err = s.emailService.SendScheduledReport(ctx, *schedule, data, filename)
+    }
+    return data, filename, total, err
 }
 
-func NewLogService(logRepo *repositories.LogRepository) *LogService {
-    return &LogService{
-        logRepo: logRepo,
+// retryBatchSize is the number of due retries RetryFailedRuns picks up per poll
+const retryBatchSize = 20
+
+// retryLeaseMargin is added to the run timeout for the time a claimed retry is held. A retry
+// whose instance died is picked up again once the lease is over.
+const retryLeaseMargin = time.Minute
+
+// retryLeaseWithoutTimeout is the lease of a claimed retry when runs have no timeout
+const retryLeaseWithoutTimeout = 30 * time.Minute
+
+// RetryFailedRuns retries the pending schedule runs whose backoff is over, polling every
+// ScheduleRetry.PollInterval until ctx is done. Retries stop once the service drains; the
+// attempt in progress is waited for like any other run, and stops when ctx is cancelled.
+func (s *ScheduleService) RetryFailedRuns(ctx context.Context) {
+    if s.logRepo == nil || s.retry.Limit <= 0 {
+        return
+    }
+
+    ticker := time.NewTicker(s.retry.PollInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        logs, err := s.logRepo.GetDueRetries(ctx, time.Now(), retryBatchSize)
+        if err != nil {
+            if ctx.Err() == nil {
+                logging.FromContext(ctx).Error("failed to look up due report retries", "error", err)
+            }
+            continue
+        }
+        for i := range logs {
+            if ctx.Err() != nil || !s.startRun() {
+                break
+            }
+            if _, err := s.attempt(ctx, &logs[i]); err != nil && !errors.Is(err, clienterrors.ErrPreconditionFailed) {
+                logging.FromContext(ctx).Warn("report retry failed", "log_id", logs[i].ID, "attempt", logs[i].RetryCount, "error", err)
+            }
+            s.finishRun()
+        }
     }
 }
 
-func (s *LogService) GetSentReportLogs(req dtos.LogSearchRequestDTO) (*dtos.LogListResponseDTO, error) {
-    logs, total, err := s.logRepo.GetSentReportLogs(req)
-    if err != nil {
-        return nil, fmt.Errorf("failed to get sent report logs: %w", err)
+// ResendReport regenerates the report of a sent report log and emails it again to the
+// recipients of the log. The resend is logged as a new run linked to the original log, which
+// is left as it was. A failed resend is final: the user who asked for it sees the error, so it
+// is not retried.
+func (s *ScheduleService) ResendReport(ctx context.Context, logID int) (*dtos.RunScheduleResponseDTO, error) {
+    if !s.startRun() {
+        s.metrics.ScheduleRunRejected()
+        return nil, clienterrors.ErrShuttingDown
     }
+    defer s.finishRun()
 
-    if req.Page == 0 {
-        req.Page = 1
+    if s.logRepo == nil {
+        return nil, errors.New("sent report logs are not available")
     }
-    if req.Limit == 0 {
-        req.Limit = 10
+    original, err := s.logRepo.GetByID(ctx, logID)
+    if err != nil {
+        return nil, fmt.Errorf("sent report log not found: %w", err)
     }
-
-    totalPages := int(total) / req.Limit
-    if int(total)%req.Limit > 0 {
-        totalPages++
+    if original.ScheduleID == nil {
+        return nil, fmt.Errorf("%w: sent report log %d has no schedule to regenerate it from", clienterrors.ErrInvalidInput, logID)
     }
 
-    return &dtos.LogListResponseDTO{
-        Logs:       logs,
-        Total:      total,
-        Page:       req.Page,
-        Limit:      req.Limit,
-        TotalPages: totalPages,
-    }, nil
-}
+    ctx, cancel := withTimeout(ctx, s.runTimeout)
+    defer cancel()
 
-func (s *LogService) GetSentReportLog(id int) (*dtos.SentReportLogDTO, error) {
-    return s.logRepo.GetByID(id)
-}
+    ctx, span := tracing.Start(ctx, "schedule.resend", attribute.Int("sent_report_log.id", logID))
+    defer func() { tracing.End(span, err) }()
 
-func (s *LogService) CreateLog(req dtos.CreateLogRequestDTO) (*dtos.SentReportLogDTO, error) {
-    log := &dtos.SentReportLogDTO{
-        TemplateID:      req.TemplateID,
-        ScheduleID:      req.ScheduleID,
-        Recipients:      req.Recipients,
-        Subject:         req.Subject,
-        FileName:        req.FileName,
-        FileSizeKB:      req.FileSizeKB,
-        ExportFormat:    req.ExportFormat,
-        TotalRecords:    req.TotalRecords,
-        Status:          req.Status,
-        ErrorMessage:    req.ErrorMessage,
-        RetryCount:      req.RetryCount,
-        ExecutionTimeMs: req.ExecutionTimeMs,
+    schedule, err := s.scheduleRepo.GetByID(ctx, *original.ScheduleID)
+    if err != nil {
+        return nil, fmt.Errorf("schedule not found: %w", err)
     }
+    // The report goes to the recipients it was meant for, even if the schedule changed since
+    target := *schedule
+    target.EmailTo = original.Recipients
 
-    err := s.logRepo.Create(log)
+    req, err := scheduleReportRequest(&target)
     if err != nil {
-        return nil, fmt.Errorf("failed to create log: %w", err)
+        return nil, err
     }
 
-    return log, nil
-}
+    startedAt := time.Now()
+    data, filename, total, err := s.deliver(ctx, &target, req)
 
-// ExportService handles file export operations
-type ExportService struct{}
+    s.metrics.ObserveScheduleRun(time.Since(startedAt), err)
+    s.logRun(ctx, &target, &original.ID, filename, len(data), int(total), time.Since(startedAt), err)
+    s.auditRun(ctx, &target, int(total), err)
+    if err != nil {
+        return nil, fmt.Errorf("failed to resend report: %w", err)
+    }
 
-func NewExportService() *ExportService {
-    return &ExportService{}
-}
+    if err := s.scheduleRepo.UpdateLastRun(ctx, schedule.ID); err != nil {
+        logging.FromContext(ctx).Warn("failed to update last run", "schedule_id", schedule.ID, "error", err)
+    }
 
-func (s *ExportService) ExportToExcel(data *dtos.ProviderReportDataDTO, fields []dtos.AvailableFieldDTO) ([]byte, string, string, error) {
+    return &dtos.RunScheduleResponseDTO{
+        Message:     "Report resent successfully",
+        ExecutedAt:  startedAt,
+        Recipients:  target.EmailTo,
+        RecordCount: int(total),
+        FileSize:    fmt.Sprintf("%d KB", fileSizeKB(len(data))),
+        Status:      "success",
+    }, nil
+}
+
+// attempt claims the next retry of entry, regenerates its report and sends it to the
+// recipients of the log, then records the outcome on the log. It fails with
+// ErrPreconditionFailed when another attempt claimed the log first.
+func (s *ScheduleService) attempt(ctx context.Context, entry *dtos.SentReportLogDTO) (*dtos.RunScheduleResponseDTO, error) {
+    lease := retryLeaseWithoutTimeout
+    if s.runTimeout > 0 {
+        lease = s.runTimeout + retryLeaseMargin
+    }
+    claimed, err := s.logRepo.ClaimAttempt(ctx, entry.ID, entry.RetryCount, time.Now().Add(lease))
+    if err != nil {
+        return nil, err
+    }
+    if !claimed {
+        return nil, fmt.Errorf("%w: sent report log %d is already being retried", clienterrors.ErrPreconditionFailed, entry.ID)
+    }
+    entry.RetryCount++
+
+    ctx, cancel := withTimeout(ctx, s.runTimeout)
+    defer cancel()
+
+    ctx, span := tracing.Start(ctx, "schedule.retry",
+        attribute.Int("sent_report_log.id", entry.ID),
+        attribute.Int("schedule.retry_count", entry.RetryCount),
+    )
+    defer func() { tracing.End(span, err) }()
+
+    if entry.ScheduleID == nil {
+        err = fmt.Errorf("%w: sent report log %d has no schedule to regenerate it from", clienterrors.ErrInvalidInput, entry.ID)
+        s.recordAttempt(ctx, entry, nil, "", 0, 0, 0, err, true)
+        return nil, err
+    }
+    schedule, err := s.scheduleRepo.GetByID(ctx, *entry.ScheduleID)
+    if err != nil {
+        // Without its schedule the report can't be regenerated, so retrying is pointless
+        s.recordAttempt(ctx, entry, nil, "", 0, 0, 0, err, true)
+        return nil, fmt.Errorf("schedule not found: %w", err)
+    }
+    // The report goes to the recipients it was meant for, even if the schedule changed since
+    target := *schedule
+    target.EmailTo = entry.Recipients
+
+    req, err := scheduleReportRequest(&target)
+    if err != nil {
+        s.recordAttempt(ctx, entry, &target, "", 0, 0, 0, err, true)
+        return nil, err
+    }
+
+    startedAt := time.Now()
+    data, filename, total, err := s.deliver(ctx, &target, req)
+
+    s.metrics.ObserveScheduleRun(time.Since(startedAt), err)
+    s.recordAttempt(ctx, entry, &target, filename, len(data), int(total), time.Since(startedAt), err, false)
+    s.auditRun(ctx, &target, int(total), err)
+    if err != nil {
+        return nil, fmt.Errorf("failed to retry report: %w", err)
+    }
+
+    if err := s.scheduleRepo.UpdateLastRun(ctx, schedule.ID); err != nil {
+        logging.FromContext(ctx).Warn("failed to update last run", "schedule_id", schedule.ID, "error", err)
+    }
+
+    return &dtos.RunScheduleResponseDTO{
+        Message:     "Report resent successfully",
+        ExecutedAt:  startedAt,
+        Recipients:  target.EmailTo,
+        RecordCount: int(total),
+        FileSize:    fmt.Sprintf("%d KB", fileSizeKB(len(data))),
+        Status:      "success",
+    }, nil
+}
+
+// recordAttempt writes the outcome of an attempt to its log. A failed attempt stays pending
+// with its next retry scheduled until the retry limit is reached, or right away when final,
+// and is then marked failed with the error.
+func (s *ScheduleService) recordAttempt(ctx context.Context, entry *dtos.SentReportLogDTO, schedule *dtos.ScheduleDTO, filename string, size, total int, elapsed time.Duration, runErr error, final bool) {
+    entry.Status = "success"
+    entry.ErrorMessage = nil
+    entry.NextRetryAt = nil
+    if schedule != nil {
+        sizeKB := fileSizeKB(size)
+        executionTimeMs := int(elapsed.Milliseconds())
+        entry.FileName = optionalString(filename)
+        entry.FileSizeKB = &sizeKB
+        entry.TotalRecords = &total
+        entry.ExecutionTimeMs = &executionTimeMs
+    }
+    if requestID := logging.RequestIDFromContext(ctx); requestID != "" {
+        entry.RequestID = &requestID
+    }
+
+    if runErr != nil {
+        entry.Status = "failed"
+        entry.ErrorMessage = stringPtr(runErr.Error())
+        if !final && entry.RetryCount < s.retry.Limit {
+            entry.Status = "pending"
+            nextRetryAt := time.Now().Add(s.retryBackoff(entry.RetryCount + 1))
+            entry.NextRetryAt = &nextRetryAt
+        }
+    }
+
+    logger := logging.FromContext(ctx).With("log_id", entry.ID, "attempt", entry.RetryCount, "status", entry.Status)
+    if runErr != nil {
+        logger.Warn("report attempt failed", "next_retry_at", entry.NextRetryAt, "error", runErr)
+    } else {
+        logger.Info("report attempt succeeded")
+    }
+
+    if err := s.logRepo.UpdateAttempt(context.WithoutCancel(ctx), entry); err != nil {
+        logger.Error("failed to record report attempt", "error", err)
+    }
+}
+
+// retryBackoff returns the wait before retry n (1 for the first), doubling from
+// ScheduleRetry.Backoff up to ScheduleRetry.MaxBackoff
+func (s *ScheduleService) retryBackoff(n int) time.Duration {
+    backoff := s.retry.Backoff
+    for i := 1; i < n && backoff < s.retry.MaxBackoff; i++ {
+        backoff *= 2
+    }
+    return min(backoff, s.retry.MaxBackoff)
+}
+
+// startRun registers a run unless the service is draining
+func (s *ScheduleService) startRun() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.draining {
+        return false
+    }
+    s.running++
+    return true
+}
+
+func (s *ScheduleService) finishRun() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.running--
+    if s.running == 0 && s.idle != nil {
+        close(s.idle)
+        s.idle = nil
+    }
+}
+
+// Drain stops the service from starting new schedule runs, they fail with ErrShuttingDown
+func (s *ScheduleService) Drain() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.draining = true
+}
+
+// RunState returns the number of schedule runs in progress and whether Drain was called
+func (s *ScheduleService) RunState() (running int, draining bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.running, s.draining
+}
+
+// Wait waits until the schedule runs in progress are done, or returns the error of ctx
+func (s *ScheduleService) Wait(ctx context.Context) error {
+    s.mu.Lock()
+    if s.running == 0 {
+        s.mu.Unlock()
+        return nil
+    }
+    if s.idle == nil {
+        s.idle = make(chan struct{})
+    }
+    idle := s.idle
+    s.mu.Unlock()
+
+    select {
+    case <-idle:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// scheduleReportRequest converts the saved search criteria of a schedule to a report request.
+// Schedules without an owner role are refused: their data scope is unknown, and running them
+// with the scope of whoever triggers the run could mail rows the owner can't see.
+func scheduleReportRequest(schedule *dtos.ScheduleDTO) (dtos.ProviderReportRequestDTO, error) {
+    if schedule.OwnerRoleID == nil {
+        return dtos.ProviderReportRequestDTO{}, fmt.Errorf("%w: schedule %d has no owner role, save it again to take it over", clienterrors.ErrInvalidInput, schedule.ID)
+    }
+
+    var searchParams dtos.ProviderSearchRequestDTO
+    criteria, err := json.Marshal(schedule.SearchCriteria)
+    if err != nil {
+        return dtos.ProviderReportRequestDTO{}, fmt.Errorf("failed to encode search criteria: %w", err)
+    }
+    if err := json.Unmarshal(criteria, &searchParams); err != nil {
+        return dtos.ProviderReportRequestDTO{}, fmt.Errorf("invalid search criteria: %w", err)
+    }
+
+    templateID := schedule.TemplateID
+    return dtos.ProviderReportRequestDTO{
+        SearchParams: searchParams,
+        TemplateID:   &templateID,
+        FormatType:   schedule.ExportFormat,
+    }, nil
+}
+
+// logRun records a schedule run in the sent report log. Failures are only logged so they
+// don't hide the result of the run. Cancelled and timed out runs are logged as well.
+func (s *ScheduleService) logRun(ctx context.Context, schedule *dtos.ScheduleDTO, resentFrom *int, filename string, size, total int, elapsed time.Duration, runErr error) {
+    if s.logRepo == nil {
+        return
+    }
+
+    scheduleID := schedule.ID
+    sizeKB := fileSizeKB(size)
+    executionTimeMs := int(elapsed.Milliseconds())
+    entry := &dtos.SentReportLogDTO{
+        TemplateID:      schedule.TemplateID,
+        ScheduleID:      &scheduleID,
+        Recipients:      schedule.EmailTo,
+        Subject:         stringPtr(fmt.Sprintf("Scheduled Report: %s", schedule.ScheduleName)),
+        FileName:        optionalString(filename),
+        FileSizeKB:      &sizeKB,
+        ExportFormat:    optionalString(schedule.ExportFormat),
+        TotalRecords:    &total,
+        Status:          "success",
+        ExecutionTimeMs: &executionTimeMs,
+        RequestID:       optionalString(logging.RequestIDFromContext(ctx)),
+        ResentFromID:    resentFrom,
+    }
+    if runErr != nil {
+        entry.Status = "failed"
+        entry.ErrorMessage = stringPtr(runErr.Error())
+        if resentFrom == nil && s.retry.Limit > 0 {
+            entry.Status = "pending"
+            nextRetryAt := time.Now().Add(s.retryBackoff(1))
+            entry.NextRetryAt = &nextRetryAt
+        }
+    }
+
+    if err := s.logRepo.Create(context.WithoutCancel(ctx), entry); err != nil {
+        logging.FromContext(ctx).Error("failed to log schedule run", "schedule_id", schedule.ID, "error", err)
+    }
+}
+
+// auditRun records a schedule run in the audit trail
+func (s *ScheduleService) auditRun(ctx context.Context, schedule *dtos.ScheduleDTO, total int, runErr error) {
+    data := map[string]interface{}{
+        "schedule_id":   schedule.ID,
+        "template_id":   schedule.TemplateID,
+        "recipients":    schedule.EmailTo,
+        "total_records": total,
+        "status":        "success",
+    }
+    level := audit.LevelInfo
+    if runErr != nil {
+        data["status"] = "failed"
+        data["error"] = runErr.Error()
+        level = audit.LevelWarn
+    }
+    emitAudit(s.auditor, ctx, auditActionScheduleRun, level, data)
+}
+
+// fileSizeKB rounds a file size up to whole kilobytes
+func fileSizeKB(size int) int {
+    return (size + 1023) / 1024
+}
+
+// LogService handles log business logic
+type LogService struct {
+    logRepo repositories.LogStore
+}
+
+func NewLogService(logRepo repositories.LogStore) *LogService {
+    return &LogService{
+        logRepo: logRepo,
+    }
+}
+
+func (s *LogService) GetSentReportLogs(ctx context.Context, req dtos.LogSearchRequestDTO) (*dtos.LogListResponseDTO, error) {
+    logs, total, err := s.logRepo.GetSentReportLogs(ctx, req)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get sent report logs: %w", err)
+    }
+
+    if req.Page == 0 {
+        req.Page = 1
+    }
+    if req.Limit == 0 {
+        req.Limit = 10
+    }
+
+    totalPages := int(total) / req.Limit
+    if int(total)%req.Limit > 0 {
+        totalPages++
+    }
+
+    return &dtos.LogListResponseDTO{
+        Logs:       logs,
+        Total:      total,
+        Page:       req.Page,
+        Limit:      req.Limit,
+        TotalPages: totalPages,
+    }, nil
+}
+
+func (s *LogService) GetSentReportLog(ctx context.Context, id int) (*dtos.SentReportLogDTO, error) {
+    return s.logRepo.GetByID(ctx, id)
+}
+
+func (s *LogService) CreateLog(ctx context.Context, req dtos.CreateLogRequestDTO) (*dtos.SentReportLogDTO, error) {
+    log := &dtos.SentReportLogDTO{
+        TemplateID:      req.TemplateID,
+        ScheduleID:      req.ScheduleID,
+        Recipients:      req.Recipients,
+        Subject:         req.Subject,
+        FileName:        req.FileName,
+        FileSizeKB:      req.FileSizeKB,
+        ExportFormat:    req.ExportFormat,
+        TotalRecords:    req.TotalRecords,
+        Status:          req.Status,
+        ErrorMessage:    req.ErrorMessage,
+        RetryCount:      req.RetryCount,
+        ExecutionTimeMs: req.ExecutionTimeMs,
+    }
+
+    err := s.logRepo.Create(ctx, log)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create log: %w", err)
+    }
+
+    return log, nil
+}
+
+// SearchPreferenceStore is the cache used for saved searches, satisfied by utility.RedisService
+type SearchPreferenceStore interface {
+    GetSearchPreference(ctx context.Context, subModule, username string) (interface{}, error)
+    SetSearchPreference(ctx context.Context, subModule, username string, reqSearch interface{}) error
+}
+
+// SavedSearchService handles saved search business logic. Saved searches are kept per user
+// in the preference store and fall back to the database when the store is unavailable.
+type SavedSearchService struct {
+    savedSearchRepo repositories.SavedSearchStore
+    templateRepo    repositories.TemplateStore
+    store           SearchPreferenceStore
+}
+
+func NewSavedSearchService(savedSearchRepo repositories.SavedSearchStore, templateRepo repositories.TemplateStore, store SearchPreferenceStore) *SavedSearchService {
+    return &SavedSearchService{
+        savedSearchRepo: savedSearchRepo,
+        templateRepo:    templateRepo,
+        store:           store,
+    }
+}
+
+func (s *SavedSearchService) GetSavedSearches(ctx context.Context, username string) ([]dtos.SavedSearchDTO, error) {
+    if s.store != nil {
+        cached, err := s.store.GetSearchPreference(ctx, dtos.SavedSearchSubModule, username)
+        if err == nil {
+            searches, err := decodeSavedSearches(cached)
+            if err == nil {
+                return searches, nil
+            }
+            logging.FromContext(ctx).Warn("invalid cached saved searches", "user", username, "error", err)
+        }
+    }
+
+    return s.reloadSavedSearches(ctx, username)
+}
+
+func (s *SavedSearchService) GetSavedSearch(ctx context.Context, id int, username string) (*dtos.SavedSearchDTO, error) {
+    searches, err := s.GetSavedSearches(ctx, username)
+    if err != nil {
+        return nil, err
+    }
+
+    for i := range searches {
+        if searches[i].ID == id {
+            return &searches[i], nil
+        }
+    }
+
+    return nil, clienterrors.ErrNotFound
+}
+
+func (s *SavedSearchService) CreateSavedSearch(ctx context.Context, username string, req dtos.CreateSavedSearchRequestDTO) (*dtos.SavedSearchDTO, error) {
+    if err := s.validateSavedSearch(ctx, 0, username, req.SearchName, req.TemplateID); err != nil {
+        return nil, err
+    }
+
+    search := &dtos.SavedSearchDTO{
+        Username:       username,
+        SearchName:     req.SearchName,
+        SearchCriteria: dtos.ProviderSearchCriteria(req.SearchCriteria),
+        TemplateID:     req.TemplateID,
+        FormatType:     req.FormatType,
+        IsDefault:      req.IsDefault,
+    }
+
+    err := s.savedSearchRepo.Create(ctx, search)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create saved search: %w", err)
+    }
+
+    if err := s.afterWrite(ctx, search); err != nil {
+        return nil, err
+    }
+
+    return search, nil
+}
+
+func (s *SavedSearchService) UpdateSavedSearch(ctx context.Context, id int, username string, req dtos.UpdateSavedSearchRequestDTO) (*dtos.SavedSearchDTO, error) {
+    search, err := s.savedSearchRepo.GetByID(ctx, id, username)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := s.validateSavedSearch(ctx, id, username, req.SearchName, req.TemplateID); err != nil {
+        return nil, err
+    }
+
+    search.SearchName = req.SearchName
+    search.SearchCriteria = dtos.ProviderSearchCriteria(req.SearchCriteria)
+    search.TemplateID = req.TemplateID
+    search.FormatType = req.FormatType
+    search.IsDefault = req.IsDefault
+
+    err = s.savedSearchRepo.Update(ctx, search)
+    if err != nil {
+        return nil, fmt.Errorf("failed to update saved search: %w", err)
+    }
+
+    if err := s.afterWrite(ctx, search); err != nil {
+        return nil, err
+    }
+
+    return search, nil
+}
+
+func (s *SavedSearchService) DeleteSavedSearch(ctx context.Context, id int, username string) error {
+    err := s.savedSearchRepo.Delete(ctx, id, username)
+    if err != nil {
+        return err
+    }
+
+    _, err = s.reloadSavedSearches(ctx, username)
+    return err
+}
+
+// validateSavedSearch checks the name is unique for the user and the default template exists
+func (s *SavedSearchService) validateSavedSearch(ctx context.Context, id int, username, name string, templateID *int) error {
+    searches, err := s.GetSavedSearches(ctx, username)
+    if err != nil {
+        return err
+    }
+
+    for _, search := range searches {
+        if search.ID != id && strings.EqualFold(search.SearchName, name) {
+            return fmt.Errorf("saved search %q: %w", name, clienterrors.ErrDuplicateName)
+        }
+    }
+
+    if templateID != nil {
+        if _, err := s.templateRepo.GetByID(ctx, *templateID); err != nil {
+            return fmt.Errorf("template not found: %w", err)
+        }
+    }
+
+    return nil
+}
+
+// afterWrite keeps a single default search per user and refreshes the cached list
+func (s *SavedSearchService) afterWrite(ctx context.Context, search *dtos.SavedSearchDTO) error {
+    if search.IsDefault {
+        if err := s.savedSearchRepo.ClearDefault(ctx, search.Username, search.ID); err != nil {
+            return err
+        }
+    }
+
+    _, err := s.reloadSavedSearches(ctx, search.Username)
+    return err
+}
+
+// reloadSavedSearches reads the user's saved searches from the database and re-populates the store
+func (s *SavedSearchService) reloadSavedSearches(ctx context.Context, username string) ([]dtos.SavedSearchDTO, error) {
+    searches, err := s.savedSearchRepo.GetByUsername(ctx, username)
+    if err != nil {
+        return nil, err
+    }
+    if searches == nil {
+        searches = []dtos.SavedSearchDTO{}
+    }
+
+    if s.store != nil {
+        if err := s.store.SetSearchPreference(ctx, dtos.SavedSearchSubModule, username, searches); err != nil {
+            logging.FromContext(ctx).Warn("failed to cache saved searches", "user", username, "error", err)
+        }
+    }
+
+    return searches, nil
+}
+
+func decodeSavedSearches(value interface{}) ([]dtos.SavedSearchDTO, error) {
+    raw, err := json.Marshal(value)
+    if err != nil {
+        return nil, err
+    }
+
+    searches := []dtos.SavedSearchDTO{}
+    if err := json.Unmarshal(raw, &searches); err != nil {
+        return nil, err
+    }
+    return searches, nil
+}
+
+// HistoryService records and reads the field-level change history of providers,
+// templates, schedules and fields
+type HistoryService struct {
+    historyRepo repositories.HistoryStore
+    fieldRepo   repositories.FieldStore
+}
+
+func NewHistoryService(historyRepo repositories.HistoryStore, fieldRepo repositories.FieldStore) *HistoryService {
+    return &HistoryService{
+        historyRepo: historyRepo,
+        fieldRepo:   fieldRepo,
+    }
+}
+
+// historyIgnoredFields are bookkeeping columns that change on every write
+var historyIgnoredFields = map[string]bool{
+    "id":            true,
+    "created_at":    true,
+    "updated_at":    true,
+    "created_by":    true,
+    "updated_by":    true,
+    "deleted_at":    true,
+    "deleted_by":    true,
+    "is_deleted":    true,
+    "version":       true,
+    "last_run_at":   true,
+    "next_run_at":   true,
+    "template_name": true,
+}
+
+// Record stores who changed which fields of an entity. before is nil for a create; after is
+// nil for a delete or restore, which are recorded without fields. The change itself is
+// already committed, so a failure to record is logged instead of returned, and the entries
+// are written even if ctx is cancelled meanwhile.
+func (s *HistoryService) Record(ctx context.Context, entityType string, entityID int, action, changedBy string, before, after interface{}) {
+    if s == nil {
+        return
+    }
+
+    entry := dtos.ChangeHistoryDTO{
+        ChangeID:   uuid.NewString(),
+        EntityType: entityType,
+        EntityID:   entityID,
+        Action:     action,
+        ChangedBy:  changedBy,
+    }
+
+    var entries []dtos.ChangeHistoryDTO
+    if after == nil {
+        entries = append(entries, entry)
+    } else {
+        if before == nil {
+            before = zeroValueOf(after)
+        }
+
+        names := jsonFieldNames(after)
+        for label, values := range utility.DiffStructs(before, after) {
+            name := label
+            if jsonName, ok := names[label]; ok {
+                name = jsonName
+            }
+            if historyIgnoredFields[name] {
+                continue
+            }
+
+            fieldEntry := entry
+            fieldEntry.FieldName = &name
+            fieldEntry.OldValue = optionalString(values[0])
+            fieldEntry.NewValue = optionalString(values[1])
+            entries = append(entries, fieldEntry)
+        }
+        sort.Slice(entries, func(i, j int) bool {
+            return *entries[i].FieldName < *entries[j].FieldName
+        })
+    }
+
+    if err := s.historyRepo.Create(context.WithoutCancel(ctx), entries); err != nil {
+        logging.FromContext(ctx).Error("failed to record change history", "action", action, "entity_type", entityType, "entity_id", entityID, "error", err)
+    }
+}
+
+// GetEntityHistory returns the changes of a single entity, newest first
+func (s *HistoryService) GetEntityHistory(ctx context.Context, entityType string, entityID int, req dtos.HistorySearchRequestDTO) (*dtos.HistoryListResponseDTO, error) {
+    req.EntityType = entityType
+    req.EntityID = &entityID
+    return s.SearchHistory(ctx, req)
+}
+
+// SearchHistory returns the changes matching the filters, e.g. everything changed between
+// two dates or every change of one field
+func (s *HistoryService) SearchHistory(ctx context.Context, req dtos.HistorySearchRequestDTO) (*dtos.HistoryListResponseDTO, error) {
+    history, total, err := s.historyRepo.Search(ctx, req)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get change history: %w", err)
+    }
+
+    if err := s.maskHistory(ctx, history); err != nil {
+        return nil, err
+    }
+
+    if req.Page == 0 {
+        req.Page = 1
+    }
+    if req.Limit == 0 {
+        req.Limit = 10
+    }
+
+    totalPages := int(total) / req.Limit
+    if int(total)%req.Limit > 0 {
+        totalPages++
+    }
+
+    return &dtos.HistoryListResponseDTO{
+        History:    history,
+        Total:      total,
+        Page:       req.Page,
+        Limit:      req.Limit,
+        TotalPages: totalPages,
+    }, nil
+}
+
+// maskHistory masks the old and new values of sensitive provider fields unless ctx may see them
+func (s *HistoryService) maskHistory(ctx context.Context, history []dtos.ChangeHistoryDTO) error {
+    if utility.CanViewSensitiveData(ctx) {
+        return nil
+    }
+
+    sensitiveFields, err := loadSensitiveFields(ctx, s.fieldRepo)
+    if err != nil {
+        return err
+    }
+    for i := range history {
+        entry := &history[i]
+        if entry.EntityType != dtos.HistoryEntityProvider || entry.FieldName == nil {
+            continue
+        }
+        maskType, ok := sensitiveFields[*entry.FieldName]
+        if !ok {
+            continue
+        }
+        if entry.OldValue != nil {
+            entry.OldValue = stringPtr(utility.MaskValue(*entry.OldValue, maskType))
+        }
+        if entry.NewValue != nil {
+            entry.NewValue = stringPtr(utility.MaskValue(*entry.NewValue, maskType))
+        }
+    }
+    return nil
+}
+
+// zeroValueOf returns an empty value of the same type as v, used as "before" of a create
+func zeroValueOf(v interface{}) interface{} {
+    t := reflect.TypeOf(v)
+    if t.Kind() == reflect.Ptr {
+        return reflect.New(t.Elem()).Interface()
+    }
+    return reflect.Zero(t).Interface()
+}
+
+// jsonFieldNames maps the CompareStructs labels of a struct to its JSON field names
+func jsonFieldNames(v interface{}) map[string]string {
+    t := reflect.TypeOf(v)
+    if t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+
+    names := make(map[string]string, t.NumField())
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        label := field.Tag.Get("label")
+        if label == "" {
+            label = field.Name
+        }
+        if name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]; name != "" && name != "-" {
+            names[label] = name
+        }
+    }
+    return names
+}
+
+// APIKeyService manages the API keys of machine clients and authenticates them
+type APIKeyService struct {
+    apiKeyRepo repositories.APIKeyStore
+}
+
+func NewAPIKeyService(apiKeyRepo repositories.APIKeyStore) *APIKeyService {
+    return &APIKeyService{
+        apiKeyRepo: apiKeyRepo,
+    }
+}
+
+const (
+    defaultAPIKeyUsageLimit = 100
+    maxAPIKeyUsageLimit     = 1000
+)
+
+func (s *APIKeyService) GetAPIKeys(ctx context.Context) ([]dtos.APIKeyDTO, error) {
+    return s.apiKeyRepo.GetAll(ctx)
+}
+
+// CreateAPIKey creates a key and returns it. The key cannot be retrieved afterwards.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, req dtos.CreateAPIKeyRequestDTO) (*dtos.APIKeyCreatedDTO, error) {
+    if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+        return nil, fmt.Errorf("%w: expires_at must be in the future", clienterrors.ErrInvalidInput)
+    }
+
+    key := &dtos.APIKeyDTO{
+        Name:       req.Name,
+        Scopes:     dtos.JSONStringArray(req.Scopes),
+        UserRoleID: req.UserRoleID,
+        ExpiresAt:  req.ExpiresAt,
+        CreatedBy:  actorFromContext(ctx),
+    }
+    return s.issue(ctx, key)
+}
+
+// RotateAPIKey replaces a key with a new one with the same name, scopes, role and expiry and
+// revokes the old key
+func (s *APIKeyService) RotateAPIKey(ctx context.Context, id int) (*dtos.APIKeyCreatedDTO, error) {
+    rotatedBy := actorFromContext(ctx)
+
+    old, err := s.apiKeyRepo.GetByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if old.RevokedAt != nil {
+        return nil, clienterrors.ErrAlreadyDeleted
+    }
+
+    key := &dtos.APIKeyDTO{
+        Name:       old.Name,
+        Scopes:     old.Scopes,
+        UserRoleID: old.UserRoleID,
+        ExpiresAt:  old.ExpiresAt,
+        CreatedBy:  rotatedBy,
+    }
+    created, err := s.issue(ctx, key)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := s.apiKeyRepo.Revoke(ctx, id, rotatedBy); err != nil {
+        return nil, fmt.Errorf("failed to revoke rotated API key: %w", err)
+    }
+    return created, nil
+}
+
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id int) error {
+    return s.apiKeyRepo.Revoke(ctx, id, actorFromContext(ctx))
+}
+
+// GetAPIKeyUsage returns the latest requests made with a key
+func (s *APIKeyService) GetAPIKeyUsage(ctx context.Context, id int, req dtos.APIKeyUsageRequestDTO) ([]dtos.APIKeyUsageDTO, error) {
+    if _, err := s.apiKeyRepo.GetByID(ctx, id); err != nil {
+        return nil, err
+    }
+
+    limit := req.Limit
+    if limit <= 0 {
+        limit = defaultAPIKeyUsageLimit
+    }
+    if limit > maxAPIKeyUsageLimit {
+        limit = maxAPIKeyUsageLimit
+    }
+    return s.apiKeyRepo.GetUsage(ctx, id, limit)
+}
+
+// AuthenticateAPIKey returns the client of a key that is valid, not revoked and not expired
+func (s *APIKeyService) AuthenticateAPIKey(ctx context.Context, key string) (*auth.APIKey, error) {
+    prefix, err := auth.APIKeyPrefix(key)
+    if err != nil {
+        return nil, err
+    }
+
+    stored, err := s.apiKeyRepo.GetByPrefix(ctx, prefix)
+    if err != nil {
+        if errors.Is(err, clienterrors.ErrNotFound) {
+            return nil, auth.ErrInvalidAPIKey
+        }
+        return nil, err
+    }
+
+    if subtle.ConstantTimeCompare([]byte(auth.HashAPIKey(key)), []byte(stored.KeyHash)) != 1 {
+        return nil, auth.ErrInvalidAPIKey
+    }
+    if stored.RevokedAt != nil {
+        return nil, auth.ErrAPIKeyRevoked
+    }
+    if stored.ExpiresAt != nil && time.Now().After(*stored.ExpiresAt) {
+        return nil, auth.ErrAPIKeyExpired
+    }
+
+    return &auth.APIKey{
+        ID:         stored.ID,
+        Name:       stored.Name,
+        Scopes:     stored.Scopes,
+        UserRoleID: stored.UserRoleID,
+    }, nil
+}
+
+// RecordAPIKeyUsage stores a request made with a key, also when the client went away. Failures
+// are only logged so they never fail the request.
+func (s *APIKeyService) RecordAPIKeyUsage(ctx context.Context, keyID int, method, path string, status int, clientIP string) {
+    usage := &dtos.APIKeyUsageDTO{
+        APIKeyID: keyID,
+        Method:   method,
+        Path:     path,
+        Status:   status,
+        ClientIP: clientIP,
+    }
+    if err := s.apiKeyRepo.RecordUsage(context.WithoutCancel(ctx), usage); err != nil {
+        logging.FromContext(ctx).Error("failed to record API key usage", "api_key_id", keyID, "error", err)
+    }
+}
+
+// issue generates the key of a new API key record and stores its hash
+func (s *APIKeyService) issue(ctx context.Context, key *dtos.APIKeyDTO) (*dtos.APIKeyCreatedDTO, error) {
+    secret, prefix, err := auth.GenerateAPIKey()
+    if err != nil {
+        return nil, fmt.Errorf("failed to generate API key: %w", err)
+    }
+    key.KeyPrefix = prefix
+    key.KeyHash = auth.HashAPIKey(secret)
+
+    if err := s.apiKeyRepo.Create(ctx, key); err != nil {
+        return nil, err
+    }
+    return &dtos.APIKeyCreatedDTO{APIKeyDTO: *key, Key: secret}, nil
+}
+
+// ExportQuotaService enforces the daily export quotas of user roles. Usage is counted per user
+// and resets at midnight server time.
+type ExportQuotaService struct {
+    quotaRepo        repositories.ExportQuotaStore
+    defaultRowLimit  int64
+    defaultByteLimit int64
+    now              func() time.Time
+}
+
+// NewExportQuotaService creates the quota service. The default limits apply to roles without
+// an export quota, 0 means unlimited.
+func NewExportQuotaService(quotaRepo repositories.ExportQuotaStore, defaultRowLimit, defaultByteLimit int64) *ExportQuotaService {
+    return &ExportQuotaService{
+        quotaRepo:        quotaRepo,
+        defaultRowLimit:  defaultRowLimit,
+        defaultByteLimit: defaultByteLimit,
+        now:              time.Now,
+    }
+}
+
+// Status returns the quota of the current user for today
+func (s *ExportQuotaService) Status(ctx context.Context) (*dtos.ExportQuotaStatusDTO, error) {
+    day := s.today()
+    status := &dtos.ExportQuotaStatusDTO{
+        RowLimit:  s.defaultRowLimit,
+        ByteLimit: s.defaultByteLimit,
+        ResetAt:   day.AddDate(0, 0, 1),
+    }
+
+    if userRoleID, ok := utility.UserRoleIDFromContext(ctx); ok {
+        quota, err := s.quotaRepo.GetByRoleID(ctx, userRoleID)
+        if err != nil {
+            return nil, err
+        }
+        if quota != nil && quota.DailyRowLimit != nil {
+            status.RowLimit = *quota.DailyRowLimit
+        }
+        if quota != nil && quota.DailyByteLimit != nil {
+            status.ByteLimit = *quota.DailyByteLimit
+        }
+    }
+
+    usage, err := s.quotaRepo.GetUsage(ctx, actorFromContext(ctx), day)
+    if err != nil {
+        return nil, err
+    }
+    status.RowsUsed = usage.RowCount
+    status.BytesUsed = usage.ByteCount
+    return status, nil
+}
+
+// Record adds an export to today's usage of the current user
+func (s *ExportQuotaService) Record(ctx context.Context, rows, bytes int64) error {
+    return s.quotaRepo.AddUsage(ctx, &dtos.ExportUsageDTO{
+        Username:  actorFromContext(ctx),
+        UsageDate: s.today(),
+        RowCount:  rows,
+        ByteCount: bytes,
+    })
+}
+
+func (s *ExportQuotaService) today() time.Time {
+    now := s.now()
+    return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// ExportService handles file export operations
+type ExportService struct{}
+
+func NewExportService() *ExportService {
+    return &ExportService{}
+}
+
+// exportCheckInterval is how many rows are written between checks for a cancelled export
+const exportCheckInterval = 1000
+
+// ExportToExcel writes the report to an xlsx file. It stops with the error of ctx once ctx is done.
+func (s *ExportService) ExportToExcel(ctx context.Context, data *dtos.ProviderReportDataDTO, fields []dtos.AvailableFieldDTO) ([]byte, string, string, error) {
     f := excelize.NewFile()
     defer f.Close()
 
@@ -487,10 +1986,15 @@ func (s *ExportService) ExportToExcel(data *dtos.ProviderReportDataDTO, fields [
 
     // Add data rows
     for rowIdx, provider := range data.Providers {
+        if rowIdx%exportCheckInterval == 0 {
+            if err := ctx.Err(); err != nil {
+                return nil, "", "", err
+            }
+        }
         dataRow := rowIdx + 2
         for i, field := range fields {
             colName, _ := excelize.ColumnNumberToName(i + 1)
-            value := s.getProviderFieldValue(provider, field.FieldCode)
+            value := providerFieldValue(&provider, field.FieldCode)
             f.SetCellValue("Sheet1", colName+strconv.Itoa(dataRow), value)
         }
     }
@@ -508,76 +2012,130 @@ func (s *ExportService) ExportToExcel(data *dtos.ProviderReportDataDTO, fields [
     return buf.Bytes(), filename, contentType, nil
 }
 
-func (s *ExportService) ExportToPDF(data *dtos.ProviderReportDataDTO, fields []dtos.AvailableFieldDTO) ([]byte, string, string, error) {
+// ExportToCSV writes the report to a UTF-8 CSV file. The byte order mark lets Excel read the Thai
+// names. It stops with the error of ctx once ctx is done.
+func (s *ExportService) ExportToCSV(ctx context.Context, data *dtos.ProviderReportDataDTO, fields []dtos.AvailableFieldDTO) ([]byte, string, string, error) {
+    var buf bytes.Buffer
+    buf.WriteString("\ufeff")
+    w := csv.NewWriter(&buf)
+
+    record := make([]string, len(fields))
+    for i, field := range fields {
+        record[i] = field.FieldNameEng
+    }
+    if err := w.Write(record); err != nil {
+        return nil, "", "", fmt.Errorf("failed to write CSV file: %w", err)
+    }
+
+    for rowIdx := range data.Providers {
+        if rowIdx%exportCheckInterval == 0 {
+            if err := ctx.Err(); err != nil {
+                return nil, "", "", err
+            }
+        }
+        for i, field := range fields {
+            record[i] = fmt.Sprint(providerFieldValue(&data.Providers[rowIdx], field.FieldCode))
+        }
+        if err := w.Write(record); err != nil {
+            return nil, "", "", fmt.Errorf("failed to write CSV file: %w", err)
+        }
+    }
+
+    w.Flush()
+    if err := w.Error(); err != nil {
+        return nil, "", "", fmt.Errorf("failed to write CSV file: %w", err)
+    }
+
+    filename := fmt.Sprintf("provider_report_%s.csv", time.Now().Format("20060102_150405"))
+    return buf.Bytes(), filename, "text/csv; charset=utf-8", nil
+}
+
+func (s *ExportService) ExportToPDF(ctx context.Context, data *dtos.ProviderReportDataDTO, fields []dtos.AvailableFieldDTO) ([]byte, string, string, error) {
     // TODO: Implement PDF export
     return nil, "", "", fmt.Errorf("PDF export not implemented yet")
 }
 
-func (s *ExportService) ExportToWord(data *dtos.ProviderReportDataDTO, fields []dtos.AvailableFieldDTO) ([]byte, string, string, error) {
+func (s *ExportService) ExportToWord(ctx context.Context, data *dtos.ProviderReportDataDTO, fields []dtos.AvailableFieldDTO) ([]byte, string, string, error) {
     // TODO: Implement Word export
     return nil, "", "", fmt.Errorf("Word export not implemented yet")
 }
 
-func (s *ExportService) getProviderFieldValue(provider dtos.ProviderDTO, fieldCode string) interface{} {
-    switch fieldCode {
-    case "provider_code":
-        return provider.ProviderCode
-    case "name_thai":
-        return provider.NameThai
-    case "name_eng":
-        if provider.NameEng != nil {
-            return *provider.NameEng
-        }
-        return ""
-    case "provider_type":
-        return provider.ProviderType
-    case "business_type":
-        if provider.BusinessType != nil {
-            return *provider.BusinessType
-        }
+// providerFieldValue returns the value of the provider field with the given JSON name as it is
+// written to an export. Values are taken as they are, so masked fields stay masked. Unknown fields
+// and nil values are blank.
+func providerFieldValue(provider *dtos.ProviderDTO, fieldCode string) interface{} {
+    index, ok := providerFieldIndexes[fieldCode]
+    if !ok {
         return ""
-    case "province":
-        return provider.Province
-    case "general_phone_no":
-        if provider.GeneralPhoneNo != nil {
-            return *provider.GeneralPhoneNo
+    }
+
+    field := reflect.ValueOf(provider).Elem().Field(index)
+    if field.Kind() == reflect.Ptr {
+        if field.IsNil() {
+            return ""
         }
-        return ""
-    case "is_tpa_network":
-        return provider.IsTPANetwork
-    case "provider_status":
-        return provider.ProviderStatus
-    case "created_at":
-        return provider.CreatedAt.Format("2006-01-02 15:04:05")
+        field = field.Elem()
+    }
+    switch value := field.Interface().(type) {
+    case time.Time:
+        return value.Format("2006-01-02 15:04:05")
+    case dtos.JSONStringArray:
+        return strings.Join(value, ", ")
     default:
-        return ""
+        return value
     }
 }
 
 // EmailService handles email operations
 type EmailService struct {
-    config *config.Config
+    config  *config.Config
+    metrics *metrics.Metrics
+    // sendMail hands a message to the SMTP server, smtp.SendMail outside tests
+    sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
 }
 
-func NewEmailService(cfg *config.Config) *EmailService {
+// NewEmailService creates the email service. Sends are reported to m, which may be nil.
+func NewEmailService(cfg *config.Config, m *metrics.Metrics) *EmailService {
     return &EmailService{
-        config: cfg,
+        config:   cfg,
+        metrics:  m,
+        sendMail: smtp.SendMail,
     }
 }
 
-func (s *EmailService) SendEmail(to, subject, body string, attachment []byte, filename string) error {
+// SendEmail sends a message, with attachment as a file named filename when it is not empty,
+// unless ctx is already done. net/smtp can't be cancelled, so a send that has started runs to
+// completion.
+func (s *EmailService) SendEmail(ctx context.Context, to, subject, body string, attachment []byte, filename string) (err error) {
+    ctx, span := tracing.Start(ctx, "smtp.send",
+        attribute.String("server.address", s.config.SMTPHost),
+        attribute.Int("email.attachment_bytes", len(attachment)),
+    )
+    startedAt := time.Now()
+    defer func() {
+        s.metrics.ObserveEmail(time.Since(startedAt), err)
+        tracing.End(span, err)
+    }()
+
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+
     // แก้ไขจาก s.config.SMTPUsername เป็น s.config.SMTPUser
     // แก้ไขจาก s.config.SMTPPassword เป็น s.config.SMTPPass
     auth := smtp.PlainAuth("", s.config.SMTPUser, s.config.SMTPPass, s.config.SMTPHost)
     
-    msg := fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s", to, subject, body)
+    msg, err := buildMessage(s.config.SMTPFrom, to, subject, body, attachment, filename)
+    if err != nil {
+        return fmt.Errorf("failed to build email: %w", err)
+    }
     
-    err := smtp.SendMail(
+    err = s.sendMail(
         s.config.SMTPHost+":"+s.config.SMTPPort,
         auth,
         s.config.SMTPFrom,
         []string{to},
-        []byte(msg),
+        msg,
     )
     
     if err != nil {
@@ -587,14 +2145,240 @@ func (s *EmailService) SendEmail(to, subject, body string, attachment []byte, fi
     return nil
 }
 
-func (s *EmailService) SendScheduledReport(schedule dtos.ScheduleDTO, reportData []byte, filename string) error {
+// buildMessage returns the MIME message of an email. A non-empty attachment is added as a
+// base64 encoded part named filename, typed from its extension.
+func buildMessage(from, to, subject, body string, attachment []byte, filename string) ([]byte, error) {
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n", from, to, mime.QEncoding.Encode("utf-8", subject))
+    if len(attachment) == 0 {
+        buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+        buf.WriteString(body)
+        return buf.Bytes(), nil
+    }
+
+    writer := multipart.NewWriter(&buf)
+    fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", writer.Boundary())
+
+    part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+    if err != nil {
+        return nil, err
+    }
+    if _, err := part.Write([]byte(body)); err != nil {
+        return nil, err
+    }
+
+    contentType := mime.TypeByExtension(path.Ext(filename))
+    if contentType == "" {
+        contentType = "application/octet-stream"
+    }
+    part, err = writer.CreatePart(textproto.MIMEHeader{
+        "Content-Type":              {contentType},
+        "Content-Transfer-Encoding": {"base64"},
+        "Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filename})},
+    })
+    if err != nil {
+        return nil, err
+    }
+    // RFC 2045 limits encoded lines to 76 characters, which is 57 bytes of the file
+    for start := 0; start < len(attachment); start += 57 {
+        line := base64.StdEncoding.EncodeToString(attachment[start:min(start+57, len(attachment))])
+        if _, err := part.Write([]byte(line + "\r\n")); err != nil {
+            return nil, err
+        }
+    }
+
+    if err := writer.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// CheckConnection checks that the SMTP server accepts connections, without logging in
+func (s *EmailService) CheckConnection(ctx context.Context) error {
+    var dialer net.Dialer
+    conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.config.SMTPHost, s.config.SMTPPort))
+    if err != nil {
+        return fmt.Errorf("SMTP server unreachable: %w", err)
+    }
+    return conn.Close()
+}
+
+func (s *EmailService) SendScheduledReport(ctx context.Context, schedule dtos.ScheduleDTO, reportData []byte, filename string) error {
     subject := fmt.Sprintf("Scheduled Report: %s", schedule.ScheduleName)
     body := fmt.Sprintf("This is an automated report generated at %s", time.Now().Format("2006-01-02 15:04:05"))
     
-    return s.SendEmail(schedule.EmailTo, subject, body, reportData, filename)
+    return s.SendEmail(ctx, schedule.EmailTo, subject, body, reportData, filename)
+}
+
+// auditSubModule is the sub-module of the audit events emitted by this module
+const auditSubModule = "provider-detail"
+
+// Audit actions that are not entity changes, see auditChange for those
+const (
+    auditActionExport      = "report.export"
+    auditActionScheduleRun = "schedule.run"
+)
+
+// emitAudit queues an audit event for the actor of ctx
+func emitAudit(auditor *audit.Auditor, ctx context.Context, action, level string, data map[string]interface{}) {
+    auditor.Emit(audit.Event{
+        Action:    action,
+        Username:  actorFromContext(ctx),
+        Data:      data,
+        Level:     level,
+        SubModule: auditSubModule,
+        RequestID: logging.RequestIDFromContext(ctx),
+    })
+}
+
+// auditChange records a create, update, delete or restore as "<entity>.<action>". Only the
+// names of changed fields are sent, the values stay in the change history.
+func auditChange(auditor *audit.Auditor, ctx context.Context, entityType string, entityID int, action string, before, after interface{}) {
+    data := map[string]interface{}{
+        "entity_type": entityType,
+        "entity_id":   entityID,
+    }
+    if after != nil {
+        if before == nil {
+            before = zeroValueOf(after)
+        }
+        names := jsonFieldNames(after)
+        var changed []string
+        for label := range utility.DiffStructs(before, after) {
+            name := label
+            if jsonName, ok := names[label]; ok {
+                name = jsonName
+            }
+            if !historyIgnoredFields[name] {
+                changed = append(changed, name)
+            }
+        }
+        sort.Strings(changed)
+        data["changed_fields"] = changed
+    }
+    emitAudit(auditor, ctx, entityType+"."+action, audit.LevelInfo, data)
+}
+
+// loadSensitiveFields returns the mask type of every sensitive field by field code
+func loadSensitiveFields(ctx context.Context, fieldRepo repositories.FieldStore) (map[string]string, error) {
+    fields, err := fieldRepo.GetSensitiveFields(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    maskTypes := make(map[string]string, len(fields))
+    for _, field := range fields {
+        maskType := utility.MaskTypeFull
+        if field.MaskType != nil {
+            maskType = *field.MaskType
+        }
+        maskTypes[field.FieldCode] = maskType
+    }
+    return maskTypes, nil
+}
+
+// maskSensitiveFields masks the string fields of provider whose JSON name is a sensitive
+// field code. Masked values get new pointers so copies of provider keep the real values.
+func maskSensitiveFields(provider *dtos.ProviderDTO, sensitiveFields map[string]string) {
+    v := reflect.ValueOf(provider).Elem()
+    for name, index := range providerFieldIndexes {
+        maskType, ok := sensitiveFields[name]
+        if !ok {
+            continue
+        }
+
+        field := v.Field(index)
+        switch field.Kind() {
+        case reflect.String:
+            field.SetString(utility.MaskValue(field.String(), maskType))
+        case reflect.Ptr:
+            if field.IsNil() || field.Elem().Kind() != reflect.String {
+                continue
+            }
+            masked := utility.MaskValue(field.Elem().String(), maskType)
+            field.Set(reflect.ValueOf(&masked))
+        }
+    }
 }
 
+// keepMaskedValues restores the sensitive fields of after that still hold the masked form of
+// their value in before
+func keepMaskedValues(before, after *dtos.ProviderDTO, sensitiveFields map[string]string) {
+    b := reflect.ValueOf(before).Elem()
+    a := reflect.ValueOf(after).Elem()
+    for name, index := range providerFieldIndexes {
+        maskType, ok := sensitiveFields[name]
+        if !ok {
+            continue
+        }
+
+        old, oldOK := stringField(b.Field(index))
+        current, currentOK := stringField(a.Field(index))
+        if oldOK && currentOK && current != old && current == utility.MaskValue(old, maskType) {
+            a.Field(index).Set(b.Field(index))
+        }
+    }
+}
+
+func stringField(field reflect.Value) (string, bool) {
+    switch field.Kind() {
+    case reflect.String:
+        return field.String(), true
+    case reflect.Ptr:
+        if !field.IsNil() && field.Elem().Kind() == reflect.String {
+            return field.Elem().String(), true
+        }
+    }
+    return "", false
+}
+
+// providerFieldIndexes maps the JSON names of ProviderDTO to their field index
+var providerFieldIndexes = func() map[string]int {
+    t := reflect.TypeOf(dtos.ProviderDTO{})
+    indexes := make(map[string]int, t.NumField())
+    for i := 0; i < t.NumField(); i++ {
+        name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+        if name != "" && name != "-" {
+            indexes[name] = i
+        }
+    }
+    return indexes
+}()
+
 // Helper functions
 func stringPtr(s string) *string {
     return &s
+}
+
+// actorFromContext returns the authenticated user carried by ctx, or "system" for writes made
+// without a user (auth disabled or background jobs)
+func actorFromContext(ctx context.Context) string {
+    if username, ok := utility.UsernameFromContext(ctx); ok {
+        return username
+    }
+    return "system"
+}
+
+// withTimeout bounds ctx by timeout, a timeout of 0 leaves ctx as it is
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+    if timeout <= 0 {
+        return context.WithCancel(ctx)
+    }
+    return context.WithTimeout(ctx, timeout)
+}
+
+// checkVersion fails with ErrPreconditionFailed when the version the caller last saw is stale
+func checkVersion(expected *int, current int) error {
+    if expected != nil && *expected != current {
+        return clienterrors.ErrPreconditionFailed
+    }
+    return nil
+}
+
+// optionalString returns nil for an empty string so the column is stored as NULL
+func optionalString(s string) *string {
+    if s == "" {
+        return nil
+    }
+    return &s
 }
\ No newline at end of file
@@ -0,0 +1,99 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    clienterrors "provider-report-api/constant/errors"
+    "provider-report-api/internal/modules/provider-detail/dtos"
+    "provider-report-api/internal/modules/provider-detail/repositories/memory"
+)
+
+// racingTemplateRepository runs race once, right after the first read of a template, like
+// another writer saving it meanwhile
+type racingTemplateRepository struct {
+    *memory.TemplateRepository
+    race func()
+}
+
+func (r *racingTemplateRepository) GetByID(ctx context.Context, id int) (*dtos.TemplateDTO, error) {
+    template, err := r.TemplateRepository.GetByID(ctx, id)
+    if race := r.race; race != nil {
+        r.race = nil
+        race()
+    }
+    return template, err
+}
+
+// newTemplateTestTemplate stores a template on the provider_code field
+func newTemplateTestTemplate(t *testing.T, env *providerTestEnv) *dtos.TemplateDTO {
+    t.Helper()
+    field := dtos.AvailableFieldDTO{FieldCode: "provider_code", FieldNameThai: "รหัส", FieldNameEng: "Provider Code", FieldType: "text", FieldCategory: "basic"}
+    if err := env.fieldRepo.CreateField(context.Background(), &field); err != nil {
+        t.Fatal(err)
+    }
+    template := &dtos.TemplateDTO{TemplateName: "Providers", DataFields: dtos.JSONFieldArray{"provider_code"}, CreatedBy: "admin"}
+    if err := env.templateRepo.Create(context.Background(), template); err != nil {
+        t.Fatal(err)
+    }
+    return template
+}
+
+func TestUpdateTemplateWithStaleVersion(t *testing.T) {
+    env := newProviderTestEnv(t)
+    template := newTemplateTestTemplate(t, env)
+    service := NewTemplateService(env.templateRepo, env.fieldRepo, env.history, nil)
+    ctx := bangkokContext()
+
+    req := dtos.UpdateTemplateRequestDTO{TemplateName: "Renamed", DataFields: []string{"provider_code"}}
+    version := template.Version
+    updated, err := service.UpdateTemplate(ctx, template.ID, req, &version)
+    if err != nil {
+        t.Fatalf("UpdateTemplate: %v", err)
+    }
+    if updated.Version != version+1 {
+        t.Errorf("version = %d, want %d", updated.Version, version+1)
+    }
+
+    // The version the client read is now stale
+    req.TemplateName = "Overwritten"
+    if _, err := service.UpdateTemplate(ctx, template.ID, req, &version); !errors.Is(err, clienterrors.ErrPreconditionFailed) {
+        t.Errorf("UpdateTemplate with a stale version = %v, want ErrPreconditionFailed", err)
+    }
+    stored, err := env.templateRepo.GetByID(context.Background(), template.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored.TemplateName != "Renamed" || stored.Version != version+1 {
+        t.Errorf("stored %q version %d, the stale update was saved", stored.TemplateName, stored.Version)
+    }
+}
+
+func TestUpdateTemplateSavedMeanwhile(t *testing.T) {
+    env := newProviderTestEnv(t)
+    template := newTemplateTestTemplate(t, env)
+    repo := &racingTemplateRepository{TemplateRepository: env.templateRepo}
+    repo.race = func() {
+        other := *template
+        other.TemplateName = "Saved meanwhile"
+        if err := env.templateRepo.Update(context.Background(), &other); err != nil {
+            t.Fatal(err)
+        }
+    }
+    service := NewTemplateService(repo, env.fieldRepo, env.history, nil)
+
+    // The version matched when it was checked, the save still loses to the other writer
+    req := dtos.UpdateTemplateRequestDTO{TemplateName: "Renamed", DataFields: []string{"provider_code"}}
+    version := template.Version
+    if _, err := service.UpdateTemplate(bangkokContext(), template.ID, req, &version); !errors.Is(err, clienterrors.ErrPreconditionFailed) {
+        t.Errorf("UpdateTemplate = %v, want ErrPreconditionFailed", err)
+    }
+    stored, err := env.templateRepo.GetByID(context.Background(), template.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stored.TemplateName != "Saved meanwhile" {
+        t.Errorf("stored %q, want the other writer's save", stored.TemplateName)
+    }
+}
@@ -1,9 +1,11 @@
 package routers
 
 import (
+	"provider-report-api/internal/middleware"
 	providerControllers "provider-report-api/internal/modules/provider-detail/controllers"
 	"provider-report-api/internal/modules/provider-detail/repositories"
 	"provider-report-api/internal/modules/provider-detail/services"
+	"provider-report-api/pkg/auth"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,7 +16,20 @@ type Dependencies struct {
 	TemplateService *services.TemplateService
 	ScheduleService *services.ScheduleService
 	LogService      *services.LogService
-	FieldRepo       *repositories.FieldRepository
+	FieldRepo       repositories.FieldStore
+
+	SavedSearchService *services.SavedSearchService
+	HistoryService     *services.HistoryService
+
+	// AuthEnabled requires a valid JWT and menu permission on every report route
+	AuthEnabled   bool
+	TokenVerifier auth.Verifier
+	// APIKeyService manages and authenticates the API keys of machine clients
+	APIKeyService *services.APIKeyService
+	// PermissionCache loads and caches the menu permissions of users
+	PermissionCache *middleware.PermissionCache
+	// RateLimiter limits the requests of each user, API key or IP, nil disables it
+	RateLimiter *middleware.RateLimiter
 }
 
 func InitializeRoutes(r *gin.Engine, deps *Dependencies) {
@@ -22,6 +37,12 @@ func InitializeRoutes(r *gin.Engine, deps *Dependencies) {
 	globalRoute := r.Group("/tpa-api")
 
 	reportRoute := globalRoute.Group("/report")
+	if deps.AuthEnabled {
+		reportRoute.Use(middleware.AuthMiddleware(deps.TokenVerifier, deps.APIKeyService), middleware.PermissionMiddleware(deps.PermissionCache))
+	}
+	if deps.RateLimiter != nil {
+		reportRoute.Use(middleware.RateLimitMiddleware(deps.RateLimiter))
+	}
 
 	// Define group path for provider-detail module
 	providerRoute := reportRoute.Group("/provider-detail")
@@ -33,6 +54,13 @@ func InitializeRoutes(r *gin.Engine, deps *Dependencies) {
 			deps.ScheduleService,
 			deps.LogService,
 			deps.FieldRepo,
+			deps.SavedSearchService,
+			deps.HistoryService,
+			deps.APIKeyService,
 		)
+
+		if deps.AuthEnabled {
+			providerRoute.DELETE("/admin/permissions/:userId", middleware.InvalidatePermissionsHandler(deps.PermissionCache))
+		}
 	}
 }
\ No newline at end of file
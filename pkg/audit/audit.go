@@ -0,0 +1,244 @@
+// Package audit ships structured audit events of report actions (exports, schedule runs,
+// template and provider changes) to one or more sinks. Events are buffered and delivered in
+// the background with retries, so a sink outage never fails or slows down the request that
+// emitted them.
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Levels of audit events
+const (
+	LevelInfo = "info"
+	LevelWarn = "warn"
+)
+
+// Event is one audited action. The JSON form matches the entries SaveAuditLog sends to Logstash.
+type Event struct {
+	ID        string      `json:"id"`
+	Action    string      `json:"action"`
+	Username  string      `json:"username"`
+	Data      interface{} `json:"data"`
+	Timestamp time.Time   `json:"timestamp"`
+	Level     string      `json:"level"`
+	Service   string      `json:"service"`
+	SubModule string      `json:"subModule"`
+	// RequestID is the X-Request-ID of the request that caused the event
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// AuditSink delivers audit events to a destination
+type AuditSink interface {
+	Name() string
+	Write(ctx context.Context, events []Event) error
+}
+
+// Options tune the delivery of an Auditor. Zero values use the defaults.
+type Options struct {
+	// Service tags every event, see constant.PROVIDER_REPORT_INDEX
+	Service string
+	// BufferSize is the number of events queued per sink before new events are dropped
+	BufferSize int
+	// BatchSize is the maximum number of events written at once
+	BatchSize int
+	// FlushInterval is how long a partial batch waits for more events
+	FlushInterval time.Duration
+	// MaxAttempts is how often a batch is tried before it is dropped
+	MaxAttempts int
+	// RetryBackoff is the wait after the first failed attempt, doubled after each failure
+	RetryBackoff time.Duration
+	// WriteTimeout limits a single write to a sink
+	WriteTimeout time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.BufferSize <= 0 {
+		o.BufferSize = 1000
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 50
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = time.Second
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = time.Second
+	}
+	if o.WriteTimeout <= 0 {
+		o.WriteTimeout = 10 * time.Second
+	}
+	return o
+}
+
+// Auditor queues events and delivers them to its sinks in the background. Every sink has its
+// own queue, so a slow or failing sink does not hold up the others.
+type Auditor struct {
+	options Options
+	queues  []*sinkQueue
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type sinkQueue struct {
+	sink   AuditSink
+	events chan Event
+}
+
+// NewAuditor starts delivering to sinks. Call Close to flush queued events on shutdown.
+func NewAuditor(options Options, sinks ...AuditSink) *Auditor {
+	a := &Auditor{
+		options: options.withDefaults(),
+		done:    make(chan struct{}),
+	}
+	for _, sink := range sinks {
+		queue := &sinkQueue{sink: sink, events: make(chan Event, a.options.BufferSize)}
+		a.queues = append(a.queues, queue)
+		a.wg.Add(1)
+		go a.run(queue)
+	}
+	return a
+}
+
+// Emit queues an event without blocking. A nil Auditor discards events, and events are dropped
+// when a sink's queue is full.
+func (a *Auditor) Emit(event Event) {
+	if a == nil {
+		return
+	}
+	select {
+	case <-a.done:
+		return
+	default:
+	}
+
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	if event.Level == "" {
+		event.Level = LevelInfo
+	}
+	if event.Service == "" {
+		event.Service = a.options.Service
+	}
+
+	for _, queue := range a.queues {
+		select {
+		case queue.events <- event:
+		default:
+			slog.Error("audit: queue is full, dropping event", "sink", queue.sink.Name(), "action", event.Action, "event_id", event.ID, "request_id", event.RequestID)
+		}
+	}
+}
+
+// Close stops accepting events and waits until the queued events are delivered or ctx ends
+func (a *Auditor) Close(ctx context.Context) error {
+	if a == nil {
+		return nil
+	}
+	a.closeOnce.Do(func() { close(a.done) })
+
+	flushed := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run batches the events of one sink until the auditor is closed
+func (a *Auditor) run(queue *sinkQueue) {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.options.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, a.options.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			a.deliver(queue.sink, batch)
+			batch = make([]Event, 0, a.options.BatchSize)
+		}
+	}
+
+	for {
+		select {
+		case event := <-queue.events:
+			batch = append(batch, event)
+			if len(batch) >= a.options.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-a.done:
+			// Drain what was queued before Close
+			for {
+				select {
+				case event := <-queue.events:
+					batch = append(batch, event)
+					if len(batch) >= a.options.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver writes a batch with retries. Batches that still fail are logged and dropped. Once the
+// auditor is closed a failed batch gets a single last try, so shutdown doesn't wait out the
+// backoff.
+func (a *Auditor) deliver(sink AuditSink, batch []Event) {
+	backoff := a.options.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := a.write(sink, batch)
+		if err == nil {
+			return
+		}
+
+		if attempt >= a.options.MaxAttempts {
+			slog.Error("audit: dropping events after failed attempts", "sink", sink.Name(), "events", len(batch), "attempts", attempt, "error", err)
+			return
+		}
+		slog.Warn("audit: failed to write events, retrying", "sink", sink.Name(), "events", len(batch), "retry_in", backoff.String(), "error", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-a.done:
+			if err := a.write(sink, batch); err != nil {
+				slog.Error("audit: dropping events on shutdown", "sink", sink.Name(), "events", len(batch), "attempts", attempt+1, "error", err)
+			}
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// write makes one attempt to write a batch to sink
+func (a *Auditor) write(sink AuditSink, batch []Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.options.WriteTimeout)
+	defer cancel()
+	return sink.Write(ctx, batch)
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errSinkDown = errors.New("sink is down")
+
+// fakeSink fails its first failures writes, or every write when failures is negative, and
+// records the batches it accepts
+type fakeSink struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	batches  [][]Event
+	// written receives every attempt, when set
+	written chan struct{}
+	// release blocks writes until it is closed, when set
+	release chan struct{}
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Write(ctx context.Context, events []Event) error {
+	if s.written != nil {
+		s.written <- struct{}{}
+	}
+	if s.release != nil {
+		<-s.release
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.failures != 0 {
+		s.failures--
+		return errSinkDown
+	}
+	s.batches = append(s.batches, append([]Event(nil), events...))
+	return nil
+}
+
+// delivered returns the number of write attempts and the IDs of the accepted events
+func (s *fakeSink) delivered() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, batch := range s.batches {
+		for _, event := range batch {
+			ids = append(ids, event.ID)
+		}
+	}
+	return s.attempts, ids
+}
+
+// waitFor fails the test when done isn't true within a few seconds
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func closeAuditor(t *testing.T, a *Auditor) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestEventsAreBufferedWhileTheSinkFails(t *testing.T) {
+	sink := &fakeSink{failures: 2}
+	a := NewAuditor(Options{BatchSize: 5, FlushInterval: time.Hour, MaxAttempts: 3, RetryBackoff: time.Millisecond}, sink)
+
+	for i := 0; i < 5; i++ {
+		a.Emit(Event{ID: fmt.Sprint(i), Action: "export"})
+	}
+	waitFor(t, "the events were not delivered", func() bool {
+		_, ids := sink.delivered()
+		return len(ids) > 0
+	})
+	closeAuditor(t, a)
+
+	attempts, ids := sink.delivered()
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 2 failures and a success", attempts)
+	}
+	if fmt.Sprint(ids) != "[0 1 2 3 4]" {
+		t.Errorf("delivered %v, want every event in order", ids)
+	}
+}
+
+func TestEventsAreDroppedAfterMaxAttempts(t *testing.T) {
+	sink := &fakeSink{failures: -1}
+	a := NewAuditor(Options{MaxAttempts: 3, RetryBackoff: time.Millisecond, FlushInterval: time.Millisecond}, sink)
+
+	a.Emit(Event{Action: "export"})
+	waitFor(t, "the batch was not retried", func() bool {
+		attempts, _ := sink.delivered()
+		return attempts >= 3
+	})
+	closeAuditor(t, a)
+
+	if attempts, ids := sink.delivered(); attempts != 3 || len(ids) != 0 {
+		t.Errorf("attempts = %d, delivered %v, want 3 failed attempts", attempts, ids)
+	}
+}
+
+func TestCloseTriesAFailedBatchOnceMore(t *testing.T) {
+	sink := &fakeSink{failures: -1, written: make(chan struct{}, 10)}
+	a := NewAuditor(Options{MaxAttempts: 5, RetryBackoff: time.Hour, FlushInterval: time.Millisecond}, sink)
+
+	a.Emit(Event{Action: "export"})
+	<-sink.written
+
+	// Close doesn't wait out the backoff, nor retry until MaxAttempts
+	started := time.Now()
+	closeAuditor(t, a)
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Close took %v", elapsed)
+	}
+	if attempts, _ := sink.delivered(); attempts != 2 {
+		t.Errorf("attempts = %d, want the first and a last one on close", attempts)
+	}
+}
+
+func TestEmitDropsEventsWhenTheQueueIsFull(t *testing.T) {
+	sink := &fakeSink{written: make(chan struct{}, 10), release: make(chan struct{})}
+	a := NewAuditor(Options{BufferSize: 2, BatchSize: 1}, sink)
+
+	// The first event is being written, the next two fill the queue
+	a.Emit(Event{ID: "0"})
+	<-sink.written
+	for i := 1; i < 5; i++ {
+		a.Emit(Event{ID: fmt.Sprint(i)})
+	}
+	close(sink.release)
+	closeAuditor(t, a)
+
+	if _, ids := sink.delivered(); fmt.Sprint(ids) != "[0 1 2]" {
+		t.Errorf("delivered %v, want the events that fit in the queue", ids)
+	}
+}
@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"provider-report-api/pkg/sqldialect"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LogstashSink posts batches of events to the Logstash HTTP input as a JSON array, which the
+// json codec splits into one document per event
+type LogstashSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewLogstashSink creates a sink for the Logstash HTTP input at url. verifyCert set to false
+// skips TLS certificate verification.
+func NewLogstashSink(url string, verifyCert bool) *LogstashSink {
+	return &LogstashSink{
+		url: url,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: !verifyCert},
+			},
+		},
+	}
+}
+
+func (s *LogstashSink) Name() string {
+	return "logstash"
+}
+
+func (s *LogstashSink) Write(ctx context.Context, events []Event) error {
+	documents := make([]interface{}, len(events))
+	for i, event := range events {
+		documents[i] = logstashEvent(event)
+	}
+	payload, err := json.Marshal(documents)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit events: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+
+	if res.StatusCode >= 300 || bytes.Contains(body, []byte("invalid")) {
+		return fmt.Errorf("logstash rejected audit events: status %d: %s", res.StatusCode, body)
+	}
+	return nil
+}
+
+// logstashEvent formats the timestamp like SaveAuditLog so both land in the same index mapping
+func logstashEvent(event Event) interface{} {
+	return struct {
+		Event
+		Timestamp string `json:"timestamp"`
+	}{event, event.Timestamp.Format("2006-01-02T15:04")}
+}
+
+// DatabaseSink stores events in the audit_events table
+type DatabaseSink struct {
+	db    *sqlx.DB
+	query string
+}
+
+func NewDatabaseSink(db *sqlx.DB, dialect sqldialect.Dialect) *DatabaseSink {
+	// Events retried after a partial failure are already stored, an existing ID is skipped
+	query := dialect.Upsert(sqldialect.Upsert{
+		Table:   "audit_events",
+		Columns: []string{"id", "action", "username", "data", "level", "service", "sub_module", "created_at", "request_id"},
+		Keys:    []string{"id"},
+	})
+	return &DatabaseSink{db: db, query: query}
+}
+
+func (s *DatabaseSink) Name() string {
+	return "database"
+}
+
+// Write stores the batch in one transaction, one statement per event since an upsert
+// cannot insert several rows on SQL Server
+func (s *DatabaseSink) Write(ctx context.Context, events []Event) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin audit transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, event := range events {
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit event data: %w", err)
+		}
+		row := map[string]interface{}{
+			"id":         event.ID,
+			"action":     event.Action,
+			"username":   event.Username,
+			"data":       string(data),
+			"level":      event.Level,
+			"service":    event.Service,
+			"sub_module": event.SubModule,
+			"created_at": event.Timestamp,
+			"request_id": event.RequestID,
+		}
+		if _, err := tx.NamedExecContext(ctx, s.query, row); err != nil {
+			return fmt.Errorf("failed to store audit events: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit audit events: %w", err)
+	}
+	return nil
+}
+
+// StdoutSink writes events as JSON lines, for local development and log collectors
+type StdoutSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdoutSink(w io.Writer) *StdoutSink {
+	return &StdoutSink{w: w}
+}
+
+func (s *StdoutSink) Name() string {
+	return "stdout"
+}
+
+func (s *StdoutSink) Write(ctx context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encoder := json.NewEncoder(s.w)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSRefreshInterval = 15 * time.Minute
+	// minJWKSRefreshInterval limits refetches triggered by unknown key IDs
+	minJWKSRefreshInterval = 30 * time.Second
+)
+
+// KeySource returns the public key for a key ID
+type KeySource interface {
+	Key(kid string) (interface{}, error)
+}
+
+// JWKSKeySource fetches signing keys from a JWKS endpoint and caches them. Keys are refetched
+// after the refresh interval, and early when a token names an unknown key ID so rotated keys
+// are picked up without a restart.
+type JWKSKeySource struct {
+	url             string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]interface{}
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	now         func() time.Time
+}
+
+// NewJWKSKeySource creates a key source for url. refreshInterval defaults to 15 minutes.
+func NewJWKSKeySource(url string, refreshInterval time.Duration) *JWKSKeySource {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+	return &JWKSKeySource{
+		url:             url,
+		refreshInterval: refreshInterval,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		now:             time.Now,
+	}
+}
+
+// Key returns the key with the given ID. An empty kid is accepted when the set has a single key.
+func (s *JWKSKeySource) Key(kid string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.keys == nil || now.Sub(s.fetchedAt) >= s.refreshInterval {
+		// Stale keys keep working while the endpoint is down
+		if err := s.refresh(now); err != nil && s.keys == nil {
+			return nil, err
+		}
+	}
+
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+
+	// The key may have been rotated in after the last fetch
+	if err := s.refresh(now); err != nil {
+		return nil, err
+	}
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+}
+
+func (s *JWKSKeySource) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// refresh replaces the cached keys. On failure the previous keys are kept. Once keys are
+// cached, the endpoint is fetched at most once per minJWKSRefreshInterval.
+func (s *JWKSKeySource) refresh(now time.Time) error {
+	if s.keys != nil && now.Sub(s.attemptedAt) < minJWKSRefreshInterval {
+		return nil
+	}
+	s.attemptedAt = now
+
+	keys, err := s.fetch()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrKeySourceUnavailable, err)
+	}
+	s.keys = keys
+	s.fetchedAt = now
+	return nil
+}
+
+func (s *JWKSKeySource) fetch() (map[string]interface{}, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip keys we cannot use instead of rejecting the whole set
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+// jsonWebKey is an RSA or EC public key as defined by RFC 7517
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	if value == "" {
+		return nil, errors.New("missing key parameter")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key parameter: %w", err)
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+func isRSAKey(key interface{}) bool {
+	_, ok := key.(*rsa.PublicKey)
+	return ok
+}
+
+func isECDSAKey(key interface{}) bool {
+	_, ok := key.(*ecdsa.PublicKey)
+	return ok
+}
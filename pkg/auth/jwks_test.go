@@ -0,0 +1,379 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksServer serves a JWKS document whose keys can be replaced to simulate a rotation
+type jwksServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []map[string]string
+	status  int
+	fetches int
+}
+
+func newJWKSServer(t *testing.T, keys ...map[string]string) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: keys, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"use": "sig",
+		"alg": "ES256",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signToken signs claims with method and key, setting the kid header when it is not empty
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// testClock is a settable clock for the key source and the verifier
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newJWKSVerifier returns a verifier that fetches its keys from server, with clock as its time
+func newJWKSVerifier(t *testing.T, server *jwksServer, cfg Config, clock *testClock) *JWTVerifier {
+	t.Helper()
+	source := NewJWKSKeySource(server.URL, time.Hour)
+	source.now = clock.Now
+	verifier, err := NewVerifierWithKeySource(cfg, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.now = clock.Now
+	return verifier
+}
+
+func validClaims(clock *testClock) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "user-1",
+		"iat": float64(clock.Now().Unix()),
+		"exp": float64(clock.Now().Add(time.Hour).Unix()),
+	}
+}
+
+func TestJWKSVerifiesRS256AndES256(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ecKey := newECKey(t)
+	server := newJWKSServer(t, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey))
+	clock := &testClock{now: time.Now()}
+	verifier := newJWKSVerifier(t, server, Config{}, clock)
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    string
+		key    interface{}
+	}{
+		{"RS256", jwt.SigningMethodRS256, "rsa-1", rsaKey},
+		{"ES256", jwt.SigningMethodES256, "ec-1", ecKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Verify(signToken(t, tt.method, tt.kid, tt.key, validClaims(clock)))
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if claims["sub"] != "user-1" {
+				t.Errorf("sub = %v, want user-1", claims["sub"])
+			}
+		})
+	}
+
+	if got := server.fetchCount(); got != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", got)
+	}
+}
+
+func TestJWKSRejectsKeyOfOtherType(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ecKey := newECKey(t)
+	server := newJWKSServer(t, rsaJWK("rsa-1", &rsaKey.PublicKey))
+	clock := &testClock{now: time.Now()}
+	verifier := newJWKSVerifier(t, server, Config{}, clock)
+
+	// An ES256 token naming the RSA key must not be checked against it
+	_, err := verifier.Verify(signToken(t, jwt.SigningMethodES256, "rsa-1", ecKey, validClaims(clock)))
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Verify = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestJWKSPicksUpRotatedKey(t *testing.T) {
+	oldKey := newRSAKey(t)
+	newKey := newRSAKey(t)
+	server := newJWKSServer(t, rsaJWK("2024-01", &oldKey.PublicKey))
+	clock := &testClock{now: time.Now()}
+	verifier := newJWKSVerifier(t, server, Config{}, clock)
+
+	if _, err := verifier.Verify(signToken(t, jwt.SigningMethodRS256, "2024-01", oldKey, validClaims(clock))); err != nil {
+		t.Fatalf("Verify with the current key: %v", err)
+	}
+
+	// The issuer rotates: tokens signed with the new key arrive before the cache expires
+	server.setKeys(rsaJWK("2024-01", &oldKey.PublicKey), rsaJWK("2024-02", &newKey.PublicKey))
+	clock.Advance(minJWKSRefreshInterval)
+
+	if _, err := verifier.Verify(signToken(t, jwt.SigningMethodRS256, "2024-02", newKey, validClaims(clock))); err != nil {
+		t.Fatalf("Verify with the rotated key: %v", err)
+	}
+	if got := server.fetchCount(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+
+	// Tokens signed with the old key stay valid while it is published
+	if _, err := verifier.Verify(signToken(t, jwt.SigningMethodRS256, "2024-01", oldKey, validClaims(clock))); err != nil {
+		t.Fatalf("Verify with the previous key: %v", err)
+	}
+}
+
+func TestJWKSUnknownKeyID(t *testing.T) {
+	key := newRSAKey(t)
+	server := newJWKSServer(t, rsaJWK("known", &key.PublicKey))
+	clock := &testClock{now: time.Now()}
+	verifier := newJWKSVerifier(t, server, Config{}, clock)
+
+	if _, err := verifier.Verify(signToken(t, jwt.SigningMethodRS256, "known", key, validClaims(clock))); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := verifier.Verify(signToken(t, jwt.SigningMethodRS256, "unknown", key, validClaims(clock)))
+		if !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("Verify = %v, want ErrUnknownKey", err)
+		}
+		if code := ErrorCode(err); code != CodeUnknownKey {
+			t.Errorf("ErrorCode = %d, want %d", code, CodeUnknownKey)
+		}
+	}
+
+	// Unknown key IDs must not make every request refetch the keys
+	if got := server.fetchCount(); got != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", got)
+	}
+}
+
+func TestJWKSKeepsKeysWhileEndpointIsDown(t *testing.T) {
+	key := newRSAKey(t)
+	server := newJWKSServer(t, rsaJWK("k1", &key.PublicKey))
+	clock := &testClock{now: time.Now()}
+	verifier := newJWKSVerifier(t, server, Config{}, clock)
+
+	if _, err := verifier.Verify(signToken(t, jwt.SigningMethodRS256, "k1", key, validClaims(clock))); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	server.setStatus(http.StatusServiceUnavailable)
+	clock.Advance(2 * time.Hour)
+	if _, err := verifier.Verify(signToken(t, jwt.SigningMethodRS256, "k1", key, validClaims(clock))); err != nil {
+		t.Fatalf("Verify with stale keys: %v", err)
+	}
+}
+
+func TestJWKSUnavailable(t *testing.T) {
+	key := newRSAKey(t)
+	server := newJWKSServer(t)
+	server.setStatus(http.StatusInternalServerError)
+	clock := &testClock{now: time.Now()}
+	verifier := newJWKSVerifier(t, server, Config{}, clock)
+
+	_, err := verifier.Verify(signToken(t, jwt.SigningMethodRS256, "k1", key, validClaims(clock)))
+	if !errors.Is(err, ErrKeySourceUnavailable) {
+		t.Fatalf("Verify = %v, want ErrKeySourceUnavailable", err)
+	}
+}
+
+func TestJWKSValidatesClaims(t *testing.T) {
+	key := newRSAKey(t)
+	server := newJWKSServer(t, rsaJWK("k1", &key.PublicKey))
+	clock := &testClock{now: time.Now()}
+	verifier := newJWKSVerifier(t, server, Config{
+		Issuer:   "https://sso.example.com",
+		Audience: "provider-report-api",
+		Leeway:   30 * time.Second,
+	}, clock)
+
+	claims := func(override jwt.MapClaims) jwt.MapClaims {
+		c := validClaims(clock)
+		c["iss"] = "https://sso.example.com"
+		c["aud"] = []interface{}{"other-api", "provider-report-api"}
+		for name, value := range override {
+			if value == nil {
+				delete(c, name)
+				continue
+			}
+			c[name] = value
+		}
+		return c
+	}
+	now := clock.Now()
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		wantErr  error
+		wantCode int
+	}{
+		{"valid", claims(nil), nil, 0},
+		{"single audience", claims(jwt.MapClaims{"aud": "provider-report-api"}), nil, 0},
+		{"wrong issuer", claims(jwt.MapClaims{"iss": "https://evil.example.com"}), ErrInvalidIssuer, CodeInvalidIssuer},
+		{"missing issuer", claims(jwt.MapClaims{"iss": nil}), ErrInvalidIssuer, CodeInvalidIssuer},
+		{"wrong audience", claims(jwt.MapClaims{"aud": "other-api"}), ErrInvalidAudience, CodeInvalidAudience},
+		{"missing audience", claims(jwt.MapClaims{"aud": nil}), ErrInvalidAudience, CodeInvalidAudience},
+		{"not valid yet", claims(jwt.MapClaims{"nbf": float64(now.Add(time.Minute).Unix())}), ErrTokenNotYetValid, CodeTokenNotYetValid},
+		{"nbf within leeway", claims(jwt.MapClaims{"nbf": float64(now.Add(10 * time.Second).Unix())}), nil, 0},
+		{"expired", claims(jwt.MapClaims{"exp": float64(now.Add(-time.Minute).Unix())}), ErrTokenExpired, CodeTokenExpired},
+		{"exp within leeway", claims(jwt.MapClaims{"exp": float64(now.Add(-10 * time.Second).Unix())}), nil, 0},
+		{"issued in the future", claims(jwt.MapClaims{"iat": float64(now.Add(time.Minute).Unix())}), ErrTokenNotYetValid, CodeTokenNotYetValid},
+		{"exp not a number", claims(jwt.MapClaims{"exp": "tomorrow"}), ErrMalformedToken, CodeInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.Verify(signToken(t, jwt.SigningMethodRS256, "k1", key, tt.claims))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify = %v, want %v", err, tt.wantErr)
+			}
+			if code := ErrorCode(err); code != tt.wantCode {
+				t.Errorf("ErrorCode = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestJWKSRejectsBadSignature(t *testing.T) {
+	key := newRSAKey(t)
+	other := newRSAKey(t)
+	server := newJWKSServer(t, rsaJWK("k1", &key.PublicKey))
+	clock := &testClock{now: time.Now()}
+	verifier := newJWKSVerifier(t, server, Config{}, clock)
+
+	_, err := verifier.Verify(signToken(t, jwt.SigningMethodRS256, "k1", other, validClaims(clock)))
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestJWKSRejectsHS256WithoutSecret(t *testing.T) {
+	key := newRSAKey(t)
+	server := newJWKSServer(t, rsaJWK("k1", &key.PublicKey))
+	clock := &testClock{now: time.Now()}
+	verifier := newJWKSVerifier(t, server, Config{}, clock)
+
+	// The classic confusion attack: an HS256 token "signed" with the public key
+	token := signToken(t, jwt.SigningMethodHS256, "k1", key.PublicKey.N.Bytes(), validClaims(clock))
+	if _, err := verifier.Verify(token); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("Verify = %v, want ErrUnsupportedAlgorithm", err)
+	}
+}
@@ -0,0 +1,251 @@
+// Package auth verifies the JWT access tokens issued by the SSO service. Tokens can be signed
+// with a shared secret (HS256) or with rotating asymmetric keys (RS256/ES256) published as a
+// JWKS document, and their issuer, audience and validity window are checked with a
+// configurable clock-skew tolerance.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Verification failures. Use ErrorCode to turn them into the code returned to clients.
+var (
+	ErrMalformedToken       = errors.New("token is malformed")
+	ErrUnsupportedAlgorithm = errors.New("token signing algorithm is not allowed")
+	ErrUnknownKey           = errors.New("token is signed with an unknown key")
+	ErrInvalidSignature     = errors.New("token signature is invalid")
+	ErrTokenExpired         = errors.New("token is expired")
+	ErrTokenNotYetValid     = errors.New("token is not valid yet")
+	ErrInvalidIssuer        = errors.New("token issuer is not accepted")
+	ErrInvalidAudience      = errors.New("token audience is not accepted")
+	ErrKeySourceUnavailable = errors.New("token signing keys are unavailable")
+)
+
+// Error codes returned in the "code" field of 401 responses. 20001 and 20002 are the codes
+// clients already handle for invalid and expired tokens.
+const (
+	CodeInvalidToken         = 20001
+	CodeTokenExpired         = 20002
+	CodeTokenNotYetValid     = 20003
+	CodeInvalidIssuer        = 20004
+	CodeInvalidAudience      = 20005
+	CodeUnknownKey           = 20006
+	CodeUnsupportedAlgorithm = 20007
+	CodeKeySourceUnavailable = 20008
+)
+
+// ErrorCode maps a verification error to its client error code
+func ErrorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrTokenExpired):
+		return CodeTokenExpired
+	case errors.Is(err, ErrTokenNotYetValid):
+		return CodeTokenNotYetValid
+	case errors.Is(err, ErrInvalidIssuer):
+		return CodeInvalidIssuer
+	case errors.Is(err, ErrInvalidAudience):
+		return CodeInvalidAudience
+	case errors.Is(err, ErrUnknownKey):
+		return CodeUnknownKey
+	case errors.Is(err, ErrUnsupportedAlgorithm):
+		return CodeUnsupportedAlgorithm
+	case errors.Is(err, ErrKeySourceUnavailable):
+		return CodeKeySourceUnavailable
+	case errors.Is(err, ErrInvalidAPIKey):
+		return CodeInvalidAPIKey
+	case errors.Is(err, ErrAPIKeyExpired):
+		return CodeAPIKeyExpired
+	case errors.Is(err, ErrAPIKeyRevoked):
+		return CodeAPIKeyRevoked
+	default:
+		return CodeInvalidToken
+	}
+}
+
+// Verifier checks an access token and returns its claims
+type Verifier interface {
+	Verify(token string) (jwt.MapClaims, error)
+}
+
+// Config selects how tokens are verified. At least one of Secret and JWKSURL is required.
+type Config struct {
+	// Secret verifies HS256 tokens
+	Secret string
+	// JWKSURL is fetched for the RS256/ES256 public keys, selected by the token "kid"
+	JWKSURL string
+	// JWKSRefreshInterval is how long fetched keys are cached
+	JWKSRefreshInterval time.Duration
+	// Algorithms limits the accepted "alg" values. Empty allows HS256 when Secret is set and
+	// RS256/ES256 when JWKSURL is set.
+	Algorithms []string
+	// Issuer, when set, must equal the "iss" claim
+	Issuer string
+	// Audience, when set, must be one of the "aud" claim values
+	Audience string
+	// Leeway is the clock skew tolerated on exp, nbf and iat
+	Leeway time.Duration
+}
+
+// JWTVerifier is the Verifier for HS256, RS256 and ES256 tokens
+type JWTVerifier struct {
+	secret     []byte
+	keys       KeySource
+	algorithms map[string]bool
+	issuer     string
+	audience   string
+	leeway     time.Duration
+	now        func() time.Time
+}
+
+// NewVerifier builds a verifier from cfg
+func NewVerifier(cfg Config) (*JWTVerifier, error) {
+	if cfg.Secret == "" && cfg.JWKSURL == "" {
+		return nil, errors.New("either a JWT secret or a JWKS URL is required")
+	}
+
+	var keys KeySource
+	if cfg.JWKSURL != "" {
+		keys = NewJWKSKeySource(cfg.JWKSURL, cfg.JWKSRefreshInterval)
+	}
+	return newVerifier(cfg, keys)
+}
+
+// NewVerifierWithKeySource builds a verifier that looks up asymmetric keys in keys instead of
+// fetching cfg.JWKSURL
+func NewVerifierWithKeySource(cfg Config, keys KeySource) (*JWTVerifier, error) {
+	return newVerifier(cfg, keys)
+}
+
+func newVerifier(cfg Config, keys KeySource) (*JWTVerifier, error) {
+	algorithms := cfg.Algorithms
+	if len(algorithms) == 0 {
+		if cfg.Secret != "" {
+			algorithms = append(algorithms, "HS256")
+		}
+		if keys != nil {
+			algorithms = append(algorithms, "RS256", "ES256")
+		}
+	}
+
+	allowed := make(map[string]bool, len(algorithms))
+	for _, alg := range algorithms {
+		alg = strings.ToUpper(strings.TrimSpace(alg))
+		switch alg {
+		case "HS256":
+			if cfg.Secret == "" {
+				return nil, errors.New("HS256 requires a JWT secret")
+			}
+		case "RS256", "ES256":
+			if keys == nil {
+				return nil, fmt.Errorf("%s requires a JWKS URL", alg)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported JWT algorithm %q", alg)
+		}
+		allowed[alg] = true
+	}
+
+	return &JWTVerifier{
+		secret:     []byte(cfg.Secret),
+		keys:       keys,
+		algorithms: allowed,
+		issuer:     cfg.Issuer,
+		audience:   cfg.Audience,
+		leeway:     cfg.Leeway,
+		now:        time.Now,
+	}, nil
+}
+
+// Verify checks the signature and the registered claims of token
+func (v *JWTVerifier) Verify(token string) (jwt.MapClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithLeeway(v.leeway),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(v.now),
+	}
+	if v.issuer != "" {
+		options = append(options, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		options = append(options, jwt.WithAudience(v.audience))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.NewParser(options...).ParseWithClaims(token, claims, v.keyFunc); err != nil {
+		return nil, v.parseError(err, claims)
+	}
+	return claims, nil
+}
+
+// keyFunc returns the verification key for the token algorithm. The key type always
+// matches the algorithm, so an RS256 public key can never be used as an HS256 secret.
+func (v *JWTVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if !v.algorithms[alg] {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+
+	if alg == "HS256" {
+		return v.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, err := v.keys.Key(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA:
+		if isRSAKey(key) {
+			return key, nil
+		}
+	case *jwt.SigningMethodECDSA:
+		if isECDSAKey(key) {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: key %q does not match %s", ErrUnknownKey, kid, alg)
+}
+
+// parseError turns a jwt error into one of the package errors. claims holds what was decoded
+// from the token, if anything.
+func (v *JWTVerifier) parseError(err error, claims jwt.MapClaims) error {
+	// Errors of keyFunc are already package errors
+	for _, known := range []error{ErrUnsupportedAlgorithm, ErrUnknownKey, ErrKeySourceUnavailable} {
+		if errors.Is(err, known) {
+			return err
+		}
+	}
+
+	iss, _ := claims["iss"].(string)
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return fmt.Errorf("%w: %v", ErrMalformedToken, err)
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return ErrInvalidSignature
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		return fmt.Errorf("%w: %v", ErrUnknownKey, err)
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return ErrTokenNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return fmt.Errorf("%w: %q", ErrInvalidIssuer, iss)
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return ErrInvalidAudience
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		// iss and aud are the only claims required, when an issuer or audience is configured
+		if v.issuer != "" && iss == "" {
+			return fmt.Errorf("%w: %q", ErrInvalidIssuer, iss)
+		}
+		return ErrInvalidAudience
+	default:
+		return fmt.Errorf("%w: %v", ErrMalformedToken, err)
+	}
+}
@@ -0,0 +1,145 @@
+// Package health answers the liveness and readiness probes of the server. Liveness only tells
+// that the process serves requests; readiness checks the dependencies and turns false while
+// the server drains for a shutdown, so load balancers stop sending new requests.
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses of a check and of the whole report
+const (
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+	StatusDraining = "draining"
+)
+
+// CheckFunc checks one dependency. It returns an error when the dependency is unusable.
+type CheckFunc func(ctx context.Context) error
+
+// DetailsFunc is a check that also reports details, e.g. the number of running jobs
+type DetailsFunc func(ctx context.Context) (map[string]interface{}, error)
+
+// CheckResult is the state of one dependency
+type CheckResult struct {
+	Status    string                 `json:"status"`
+	Critical  bool                   `json:"critical"`
+	LatencyMs int64                  `json:"latency_ms"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// Report is the answer of a readiness probe
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type check struct {
+	name     string
+	critical bool
+	run      DetailsFunc
+}
+
+// Checker runs the readiness checks. Only critical checks make the server not ready; the others
+// are reported so operators see a degraded dependency the server can work without.
+type Checker struct {
+	timeout  time.Duration
+	checks   []check
+	draining atomic.Bool
+}
+
+// NewChecker creates a checker that gives every check timeout to answer, 0 means 2 seconds
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a check. Checks must be added before the server starts.
+func (c *Checker) Add(name string, critical bool, fn CheckFunc) {
+	c.AddWithDetails(name, critical, func(ctx context.Context) (map[string]interface{}, error) {
+		return nil, fn(ctx)
+	})
+}
+
+// AddWithDetails registers a check that reports details
+func (c *Checker) AddWithDetails(name string, critical bool, fn DetailsFunc) {
+	c.checks = append(c.checks, check{name: name, critical: critical, run: fn})
+}
+
+// SetDraining makes every following readiness probe fail, it is called when shutdown starts
+func (c *Checker) SetDraining() {
+	c.draining.Store(true)
+}
+
+// Draining reports whether the server is shutting down
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Ready runs all checks concurrently and reports whether the server can take requests
+func (c *Checker) Ready(ctx context.Context) (bool, Report) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make([]CheckResult, len(c.checks))
+	var wg sync.WaitGroup
+	for i, chk := range c.checks {
+		wg.Add(1)
+		go func(i int, chk check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, chk)
+		}(i, chk)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, Checks: make(map[string]CheckResult, len(c.checks))}
+	for i, chk := range c.checks {
+		report.Checks[chk.name] = results[i]
+		if chk.critical && results[i].Status != StatusUp {
+			report.Status = StatusNotReady
+		}
+	}
+	if c.Draining() {
+		report.Status = StatusDraining
+	}
+	return report.Status == StatusReady, report
+}
+
+func runCheck(ctx context.Context, chk check) CheckResult {
+	result := CheckResult{Status: StatusUp, Critical: chk.critical}
+	started := time.Now()
+
+	// A check that ignores ctx must not hold up the probe
+	type outcome struct {
+		details map[string]interface{}
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		details, err := chk.run(ctx)
+		done <- outcome{details, err}
+	}()
+
+	var out outcome
+	select {
+	case out = <-done:
+	case <-ctx.Done():
+		out.err = ctx.Err()
+	}
+
+	result.LatencyMs = time.Since(started).Milliseconds()
+	result.Details = out.details
+	if out.err != nil {
+		result.Status = StatusDown
+		result.Error = out.err.Error()
+	}
+	return result
+}
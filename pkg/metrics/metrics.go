@@ -0,0 +1,223 @@
+// Package metrics exposes the Prometheus metrics of the service: HTTP requests by route,
+// exports, schedule runs, emails, the Redis caches and the database pool. Services receive a
+// *Metrics and report through its Observe methods; a nil *Metrics records nothing, so
+// services built without metrics keep working.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric of the service
+const namespace = "provider_report"
+
+// Outcomes of an export, a schedule run or an email
+const (
+	OutcomeSuccess   = "success"
+	OutcomeError     = "error"
+	OutcomeTimeout   = "timeout"
+	OutcomeCancelled = "cancelled"
+	OutcomeRejected  = "rejected"
+)
+
+// Results of a cache lookup
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheError = "error"
+)
+
+// Metrics holds the collectors of the service in its own registry
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests        *prometheus.CounterVec
+	httpDuration        *prometheus.HistogramVec
+	exports             *prometheus.CounterVec
+	exportDuration      *prometheus.HistogramVec
+	exportRows          *prometheus.HistogramVec
+	exportBytes         *prometheus.HistogramVec
+	scheduleRuns        *prometheus.CounterVec
+	scheduleRunDuration prometheus.Histogram
+	emails              *prometheus.CounterVec
+	emailDuration       prometheus.Histogram
+	cacheLookups        *prometheus.CounterVec
+}
+
+// New creates the metrics with the Go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		exports: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exports_total",
+			Help:      "Report exports by format and outcome.",
+		}, []string{"format", "outcome"}),
+		exportDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "export_duration_seconds",
+			Help:      "Time to query and render a report export, by format.",
+			Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, []string{"format"}),
+		exportRows: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "export_rows",
+			Help:      "Providers in a successful export, by format.",
+			Buckets:   prometheus.ExponentialBuckets(10, 10, 6),
+		}, []string{"format"}),
+		exportBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "export_bytes",
+			Help:      "Size of the file of a successful export, by format.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 8),
+		}, []string{"format"}),
+		scheduleRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "schedule_runs_total",
+			Help:      "Schedule runs by outcome; rejected runs arrived while the server was shutting down.",
+		}, []string{"outcome"}),
+		scheduleRunDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "schedule_run_duration_seconds",
+			Help:      "Time to export and email the report of a schedule.",
+			Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+		}),
+		emails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "emails_total",
+			Help:      "Emails sent by outcome.",
+		}, []string{"outcome"}),
+		emailDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "email_send_duration_seconds",
+			Help:      "Time to hand an email to the SMTP server.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_lookups_total",
+			Help:      "Redis cache lookups by cache and result (hit, miss or error).",
+		}, []string{"cache", "result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration,
+		m.exports, m.exportDuration, m.exportRows, m.exportBytes,
+		m.scheduleRuns, m.scheduleRunDuration,
+		m.emails, m.emailDuration,
+		m.cacheLookups,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// RegisterDB reports the connection pool statistics of db, labelled with name
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterGauge reports the value of fn on every scrape
+func (m *Metrics) RegisterGauge(name, help string, fn func() float64) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+// ObserveRequest records a handled HTTP request. route is the route template, not the path,
+// so IDs don't multiply the series.
+func (m *Metrics) ObserveRequest(method, route string, status int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.httpDuration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+// ObserveExport records an export; rows and bytes are only recorded when it succeeded
+func (m *Metrics) ObserveExport(format string, elapsed time.Duration, rows, bytes int64, err error) {
+	if m == nil {
+		return
+	}
+	m.exports.WithLabelValues(format, Outcome(err)).Inc()
+	m.exportDuration.WithLabelValues(format).Observe(elapsed.Seconds())
+	if err == nil {
+		m.exportRows.WithLabelValues(format).Observe(float64(rows))
+		m.exportBytes.WithLabelValues(format).Observe(float64(bytes))
+	}
+}
+
+// ObserveScheduleRun records a schedule run that was started
+func (m *Metrics) ObserveScheduleRun(elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.scheduleRuns.WithLabelValues(Outcome(err)).Inc()
+	m.scheduleRunDuration.Observe(elapsed.Seconds())
+}
+
+// ScheduleRunRejected records a schedule run refused because the server is shutting down
+func (m *Metrics) ScheduleRunRejected() {
+	if m == nil {
+		return
+	}
+	m.scheduleRuns.WithLabelValues(OutcomeRejected).Inc()
+}
+
+// ObserveEmail records an email send
+func (m *Metrics) ObserveEmail(elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.emails.WithLabelValues(Outcome(err)).Inc()
+	m.emailDuration.Observe(elapsed.Seconds())
+}
+
+// ObserveCacheLookup records a lookup in cache with result CacheHit, CacheMiss or CacheError
+func (m *Metrics) ObserveCacheLookup(cache, result string) {
+	if m == nil {
+		return
+	}
+	m.cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// Outcome classifies err as an outcome label
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, context.DeadlineExceeded):
+		return OutcomeTimeout
+	case errors.Is(err, context.Canceled):
+		return OutcomeCancelled
+	default:
+		return OutcomeError
+	}
+}
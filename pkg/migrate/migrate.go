@@ -0,0 +1,263 @@
+// Package migrate applies versioned SQL migrations and records them in the schema_migrations
+// table.
+//
+// A migration is a pair of files NNN_name.up.sql and NNN_name.down.sql. The checksum of the up
+// script is stored when it is applied, so an applied migration that was edited afterwards is
+// reported instead of silently diverging from the database. Scripts may be split into batches
+// with GO lines, which SQL Server needs when a statement uses a column added earlier in the
+// same script.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"provider-report-api/pkg/sqldialect"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrChecksumMismatch is returned when an applied migration differs from its file
+var ErrChecksumMismatch = errors.New("applied migration was modified")
+
+// ErrUnknownMigration is returned when the database has a migration this build does not know,
+// it was migrated by a newer version
+var ErrUnknownMigration = errors.New("database has a migration unknown to this build")
+
+var (
+	fileName     = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+	batchDivider = regexp.MustCompile(`(?im)^\s*GO\s*$`)
+)
+
+// Migration is one version of the schema
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// AppliedMigration is a row of schema_migrations
+type AppliedMigration struct {
+	Version   int       `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// Status of a migration known to this build, Applied is nil while it is pending
+type Status struct {
+	Migration
+	Applied  *AppliedMigration
+	Modified bool
+}
+
+// Load reads the migrations of fsys, ordered by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected file %s in migrations, expected NNN_name.up.sql or NNN_name.down.sql", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, match[2])
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+			sum := sha256.Sum256(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %03d_%s needs both an up and a down script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies migrations to one database
+type Migrator struct {
+	db         *sqlx.DB
+	dialect    sqldialect.Dialect
+	migrations []Migration
+}
+
+func New(db *sqlx.DB, dialect sqldialect.Dialect, migrations []Migration) *Migrator {
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}
+}
+
+// Up applies the pending migrations in order, each in its own transaction. It refuses to run
+// when an applied migration was modified or is unknown to this build.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, status := range statuses {
+		if status.Modified {
+			return nil, fmt.Errorf("%w: %03d_%s", ErrChecksumMismatch, status.Version, status.Name)
+		}
+		if status.Applied == nil {
+			pending = append(pending, status.Migration)
+		}
+	}
+
+	var applied []Migration
+	for _, migration := range pending {
+		if err := m.apply(ctx, migration, true); err != nil {
+			return applied, fmt.Errorf("failed to apply migration %03d_%s: %w", migration.Version, migration.Name, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// Down rolls back the last steps applied migrations, newest first
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack []Migration
+	for i := len(statuses) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		migration := statuses[i].Migration
+		if statuses[i].Applied == nil {
+			continue
+		}
+		if err := m.apply(ctx, migration, false); err != nil {
+			return rolledBack, fmt.Errorf("failed to roll back migration %03d_%s: %w", migration.Version, migration.Name, err)
+		}
+		rolledBack = append(rolledBack, migration)
+	}
+	return rolledBack, nil
+}
+
+// Status lists every migration of this build with the row recording its application
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if _, err := m.db.ExecContext(ctx, m.createTableQuery()); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var rows []AppliedMigration
+	if err := m.db.SelectContext(ctx, &rows, `SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version`); err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	applied := make(map[int]AppliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Migration: migration}
+		if row, ok := applied[migration.Version]; ok {
+			status.Applied = &row
+			status.Modified = row.Checksum != migration.Checksum
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, row := range applied {
+		return nil, fmt.Errorf("%w: %03d_%s", ErrUnknownMigration, version, row.Name)
+	}
+	return statuses, nil
+}
+
+// apply runs the up or down script of migration and records the result in the same transaction
+func (m *Migrator) apply(ctx context.Context, migration Migration, up bool) error {
+	script := migration.Down
+	if up {
+		script = migration.Up
+	}
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, batch := range batchDivider.Split(script, -1) {
+		if strings.TrimSpace(batch) == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, batch); err != nil {
+			return err
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES ($1, $2, $3, $4)`,
+			migration.Version, migration.Name, migration.Checksum, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) createTableQuery() string {
+	if m.dialect.Name() == sqldialect.SQLServer {
+		return `
+			IF OBJECT_ID(N'schema_migrations', N'U') IS NULL
+			CREATE TABLE schema_migrations (
+				version INT PRIMARY KEY,
+				name NVARCHAR(255) NOT NULL,
+				checksum CHAR(64) NOT NULL,
+				applied_at DATETIME2 NOT NULL
+			)
+		`
+	}
+	return `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`
+}
@@ -0,0 +1,183 @@
+// Package sqldialect generates the SQL that differs between SQL Server and PostgreSQL.
+//
+// Queries are written with $N placeholders and sqlx :name parameters for both databases:
+// lib/pq understands them natively, and the "mssql" driver rewrites them to @pN. Only the
+// constructs below need a dialect.
+package sqldialect
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the supported dialects, as accepted in DB_DRIVER
+const (
+	SQLServer = "mssql"
+	Postgres  = "postgres"
+)
+
+// DefaultSQLServerCollation is the case-insensitive collation used for LIKE on SQL Server
+const DefaultSQLServerCollation = "Thai_CI_AS"
+
+// Dialect renders the database specific parts of a query
+type Dialect interface {
+	// Name is the dialect name, SQLServer or Postgres
+	Name() string
+	// DriverName is the database/sql driver used to connect
+	DriverName() string
+	// Bool returns the literal of a boolean value
+	Bool(value bool) string
+	// CurrentDate returns today's date without a time
+	CurrentDate() string
+	// ILike matches expr against the pattern placeholder ignoring case
+	ILike(expr, pattern string) string
+	// Paginate returns the clause following ORDER BY that skips offset rows and returns limit rows
+	Paginate(limit, offset string) string
+	// Output returns the clause between the column list and VALUES of an INSERT that returns
+	// columns of the inserted row, empty when the dialect uses Returning
+	Output(columns ...string) string
+	// Returning returns the clause after VALUES of an INSERT that returns columns of the
+	// inserted row, empty when the dialect uses Output
+	Returning(columns ...string) string
+	// Upsert returns a statement inserting one row from named parameters, see Upsert
+	Upsert(upsert Upsert) string
+}
+
+// Upsert inserts a row unless a row with the same Keys exists. The values are read from the
+// named parameters of the same names as Columns.
+type Upsert struct {
+	Table   string
+	Columns []string
+	Keys    []string
+	// Increment lists the columns added to an existing row. Without them an existing row is
+	// left as is.
+	Increment []string
+}
+
+// New returns the dialect of a DB_DRIVER value
+func New(driver string) (Dialect, error) {
+	switch strings.ToLower(strings.TrimSpace(driver)) {
+	case "mssql", "sqlserver":
+		return NewSQLServerDialect(DefaultSQLServerCollation), nil
+	case "postgres", "postgresql":
+		return PostgresDialect{}, nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q, use %q or %q", driver, SQLServer, Postgres)
+}
+
+// PostgresDialect renders PostgreSQL
+type PostgresDialect struct{}
+
+func (PostgresDialect) Name() string       { return Postgres }
+func (PostgresDialect) DriverName() string { return "postgres" }
+
+func (PostgresDialect) Bool(value bool) string {
+	if value {
+		return "true"
+	}
+	return "false"
+}
+
+func (PostgresDialect) CurrentDate() string { return "CURRENT_DATE" }
+
+func (PostgresDialect) ILike(expr, pattern string) string {
+	return expr + " ILIKE " + pattern
+}
+
+func (PostgresDialect) Paginate(limit, offset string) string {
+	return fmt.Sprintf(" LIMIT %s OFFSET %s", limit, offset)
+}
+
+func (PostgresDialect) Output(columns ...string) string { return "" }
+
+func (PostgresDialect) Returning(columns ...string) string {
+	return "RETURNING " + strings.Join(columns, ", ")
+}
+
+func (PostgresDialect) Upsert(upsert Upsert) string {
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) ",
+		upsert.Table, strings.Join(upsert.Columns, ", "), namedParams(upsert.Columns), strings.Join(upsert.Keys, ", "))
+	if len(upsert.Increment) == 0 {
+		return query + "DO NOTHING"
+	}
+
+	assignments := make([]string, len(upsert.Increment))
+	for i, column := range upsert.Increment {
+		assignments[i] = fmt.Sprintf("%[1]s = %[2]s.%[1]s + EXCLUDED.%[1]s", column, upsert.Table)
+	}
+	return query + "DO UPDATE SET " + strings.Join(assignments, ", ")
+}
+
+// SQLServerDialect renders SQL Server (2012 or later, for OFFSET ... FETCH)
+type SQLServerDialect struct {
+	collation string
+}
+
+// NewSQLServerDialect creates a SQL Server dialect that matches LIKE patterns with collation
+func NewSQLServerDialect(collation string) SQLServerDialect {
+	return SQLServerDialect{collation: collation}
+}
+
+func (SQLServerDialect) Name() string       { return SQLServer }
+func (SQLServerDialect) DriverName() string { return "mssql" }
+
+func (SQLServerDialect) Bool(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func (SQLServerDialect) CurrentDate() string { return "CAST(GETDATE() AS DATE)" }
+
+func (d SQLServerDialect) ILike(expr, pattern string) string {
+	return fmt.Sprintf("%s COLLATE %s LIKE %s", expr, d.collation, pattern)
+}
+
+func (SQLServerDialect) Paginate(limit, offset string) string {
+	return fmt.Sprintf(" OFFSET %s ROWS FETCH NEXT %s ROWS ONLY", offset, limit)
+}
+
+func (SQLServerDialect) Output(columns ...string) string {
+	inserted := make([]string, len(columns))
+	for i, column := range columns {
+		inserted[i] = "INSERTED." + column
+	}
+	return "OUTPUT " + strings.Join(inserted, ", ")
+}
+
+func (SQLServerDialect) Returning(columns ...string) string { return "" }
+
+// Upsert uses MERGE with HOLDLOCK so concurrent upserts of the same key do not both insert
+func (SQLServerDialect) Upsert(upsert Upsert) string {
+	sources := make([]string, len(upsert.Columns))
+	values := make([]string, len(upsert.Columns))
+	for i, column := range upsert.Columns {
+		sources[i] = fmt.Sprintf(":%[1]s AS %[1]s", column)
+		values[i] = "source." + column
+	}
+	matches := make([]string, len(upsert.Keys))
+	for i, key := range upsert.Keys {
+		matches[i] = fmt.Sprintf("target.%[1]s = source.%[1]s", key)
+	}
+
+	query := fmt.Sprintf("MERGE INTO %s WITH (HOLDLOCK) AS target USING (SELECT %s) AS source ON %s ",
+		upsert.Table, strings.Join(sources, ", "), strings.Join(matches, " AND "))
+	if len(upsert.Increment) > 0 {
+		assignments := make([]string, len(upsert.Increment))
+		for i, column := range upsert.Increment {
+			assignments[i] = fmt.Sprintf("%[1]s = target.%[1]s + source.%[1]s", column)
+		}
+		query += "WHEN MATCHED THEN UPDATE SET " + strings.Join(assignments, ", ") + " "
+	}
+	return query + fmt.Sprintf("WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s);",
+		strings.Join(upsert.Columns, ", "), strings.Join(values, ", "))
+}
+
+func namedParams(columns []string) string {
+	params := make([]string, len(columns))
+	for i, column := range columns {
+		params[i] = ":" + column
+	}
+	return strings.Join(params, ", ")
+}
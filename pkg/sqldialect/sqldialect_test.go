@@ -0,0 +1,167 @@
+package sqldialect
+
+import "testing"
+
+func dialects(t *testing.T) (Dialect, Dialect) {
+	t.Helper()
+	postgres, err := New("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlServer, err := New("mssql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return postgres, sqlServer
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		driver string
+		name   string
+	}{
+		{"postgres", Postgres},
+		{"PostgreSQL", Postgres},
+		{"mssql", SQLServer},
+		{" sqlserver ", SQLServer},
+	}
+	for _, tt := range tests {
+		d, err := New(tt.driver)
+		if err != nil {
+			t.Fatalf("New(%q): %v", tt.driver, err)
+		}
+		if d.Name() != tt.name {
+			t.Errorf("New(%q).Name() = %q, want %q", tt.driver, d.Name(), tt.name)
+		}
+	}
+
+	if _, err := New("mysql"); err == nil {
+		t.Error("New(mysql) succeeded, want an unsupported driver error")
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	postgres, sqlServer := dialects(t)
+
+	if got, want := postgres.Paginate("$3", "$4"), " LIMIT $3 OFFSET $4"; got != want {
+		t.Errorf("postgres Paginate = %q, want %q", got, want)
+	}
+	if got, want := sqlServer.Paginate("$3", "$4"), " OFFSET $4 ROWS FETCH NEXT $3 ROWS ONLY"; got != want {
+		t.Errorf("sql server Paginate = %q, want %q", got, want)
+	}
+}
+
+func TestILike(t *testing.T) {
+	postgres, sqlServer := dialects(t)
+
+	if got, want := postgres.ILike("p.name_thai", "$1"), "p.name_thai ILIKE $1"; got != want {
+		t.Errorf("postgres ILike = %q, want %q", got, want)
+	}
+	if got, want := sqlServer.ILike("p.name_thai", "$1"), "p.name_thai COLLATE Thai_CI_AS LIKE $1"; got != want {
+		t.Errorf("sql server ILike = %q, want %q", got, want)
+	}
+	custom := NewSQLServerDialect("Latin1_General_CI_AI")
+	if got, want := custom.ILike("name", "$2"), "name COLLATE Latin1_General_CI_AI LIKE $2"; got != want {
+		t.Errorf("custom collation ILike = %q, want %q", got, want)
+	}
+}
+
+func TestBool(t *testing.T) {
+	postgres, sqlServer := dialects(t)
+
+	tests := []struct {
+		dialect Dialect
+		value   bool
+		want    string
+	}{
+		{postgres, true, "true"},
+		{postgres, false, "false"},
+		{sqlServer, true, "1"},
+		{sqlServer, false, "0"},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.Bool(tt.value); got != tt.want {
+			t.Errorf("%s Bool(%v) = %q, want %q", tt.dialect.Name(), tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestReturningAndOutput(t *testing.T) {
+	postgres, sqlServer := dialects(t)
+
+	if got, want := postgres.Returning("id", "created_at"), "RETURNING id, created_at"; got != want {
+		t.Errorf("postgres Returning = %q, want %q", got, want)
+	}
+	if got := postgres.Output("id", "created_at"); got != "" {
+		t.Errorf("postgres Output = %q, want empty", got)
+	}
+	if got, want := sqlServer.Output("id", "created_at"), "OUTPUT INSERTED.id, INSERTED.created_at"; got != want {
+		t.Errorf("sql server Output = %q, want %q", got, want)
+	}
+	if got := sqlServer.Returning("id", "created_at"); got != "" {
+		t.Errorf("sql server Returning = %q, want empty", got)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	postgres, sqlServer := dialects(t)
+
+	usage := Upsert{
+		Table:     "export_usage",
+		Columns:   []string{"username", "usage_date", "row_count"},
+		Keys:      []string{"username", "usage_date"},
+		Increment: []string{"row_count"},
+	}
+	insertOnly := Upsert{
+		Table:   "user_favorites",
+		Columns: []string{"username", "provider_id"},
+		Keys:    []string{"username", "provider_id"},
+	}
+
+	tests := []struct {
+		name    string
+		dialect Dialect
+		upsert  Upsert
+		want    string
+	}{
+		{
+			name:    "postgres increment",
+			dialect: postgres,
+			upsert:  usage,
+			want: "INSERT INTO export_usage (username, usage_date, row_count) VALUES (:username, :usage_date, :row_count) " +
+				"ON CONFLICT (username, usage_date) DO UPDATE SET row_count = export_usage.row_count + EXCLUDED.row_count",
+		},
+		{
+			name:    "postgres insert only",
+			dialect: postgres,
+			upsert:  insertOnly,
+			want:    "INSERT INTO user_favorites (username, provider_id) VALUES (:username, :provider_id) ON CONFLICT (username, provider_id) DO NOTHING",
+		},
+		{
+			name:    "sql server increment",
+			dialect: sqlServer,
+			upsert:  usage,
+			want: "MERGE INTO export_usage WITH (HOLDLOCK) AS target " +
+				"USING (SELECT :username AS username, :usage_date AS usage_date, :row_count AS row_count) AS source " +
+				"ON target.username = source.username AND target.usage_date = source.usage_date " +
+				"WHEN MATCHED THEN UPDATE SET row_count = target.row_count + source.row_count " +
+				"WHEN NOT MATCHED THEN INSERT (username, usage_date, row_count) VALUES (source.username, source.usage_date, source.row_count);",
+		},
+		{
+			name:    "sql server insert only",
+			dialect: sqlServer,
+			upsert:  insertOnly,
+			want: "MERGE INTO user_favorites WITH (HOLDLOCK) AS target " +
+				"USING (SELECT :username AS username, :provider_id AS provider_id) AS source " +
+				"ON target.username = source.username AND target.provider_id = source.provider_id " +
+				"WHEN NOT MATCHED THEN INSERT (username, provider_id) VALUES (source.username, source.provider_id);",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dialect.Upsert(tt.upsert); got != tt.want {
+				t.Errorf("Upsert =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+// Package tracing sets up OpenTelemetry tracing. Requests carry the W3C trace context of the
+// caller; spans are exported over OTLP/HTTP when TRACING_EXPORTER=otlp and dropped otherwise,
+// so instrumented code needs no check of its own. Database queries are traced by wrapping the
+// SQL driver, with statements reduced to their shape by SanitizeSQL.
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"provider-report-api/pkg/sqldialect"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters of Config.Exporter
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+)
+
+// instrumentationName names the tracer of the spans created by the service itself
+const instrumentationName = "provider-report-api"
+
+// maxStatementLength bounds the statements recorded on query spans
+const maxStatementLength = 2000
+
+// Config selects where spans go
+type Config struct {
+	// Exporter is none (the default) or otlp
+	Exporter string
+	// Endpoint is the full OTLP/HTTP traces URL, e.g. http://otel-collector:4318/v1/traces.
+	// Empty uses the OTEL_EXPORTER_OTLP_* environment variables of the SDK.
+	Endpoint    string
+	ServiceName string
+	Environment string
+	// SampleRatio is the share of new traces that are recorded; traces started by a caller
+	// follow the caller's decision
+	SampleRatio float64
+}
+
+// Setup installs the W3C trace context propagator and, for the otlp exporter, a tracer
+// provider. The returned function flushes the spans that are still buffered.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		// The global provider is a no-op until one is set
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+
+	var opts []otlptracehttp.Option
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.DeploymentEnvironment(cfg.Environment),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span of the service
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// SQLOptions configures the traced SQL driver. Statements are recorded sanitized, since
+// queries built with literals could otherwise leak provider data into the traces.
+func SQLOptions(dialect sqldialect.Dialect) []otelsql.Option {
+	dbSystem := semconv.DBSystemMSSQL
+	if dialect.Name() == sqldialect.Postgres {
+		dbSystem = semconv.DBSystemPostgreSQL
+	}
+
+	return []otelsql.Option{
+		otelsql.WithAttributes(dbSystem),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableQuery:         true,
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			// A canceled query is already reported by the span of its request
+			RecordError: func(err error) bool {
+				return !errors.Is(err, context.Canceled)
+			},
+		}),
+		otelsql.WithAttributesGetter(func(_ context.Context, _ otelsql.Method, query string, _ []driver.NamedValue) []attribute.KeyValue {
+			if query == "" {
+				return nil
+			}
+			return []attribute.KeyValue{semconv.DBQueryText(SanitizeSQL(query))}
+		}),
+	}
+}
+
+var (
+	stringLiteral  = regexp.MustCompile(`N?'(?:[^']|'')*'`)
+	numericLiteral = regexp.MustCompile(`(^|[^\w$@.])-?\d+(?:\.\d+)?`)
+	whitespace     = regexp.MustCompile(`\s+`)
+)
+
+// SanitizeSQL replaces the string and number literals of query with ? and collapses its
+// whitespace. Placeholders such as $1 and @p1 are kept.
+func SanitizeSQL(query string) string {
+	query = stringLiteral.ReplaceAllString(query, "?")
+	query = numericLiteral.ReplaceAllString(query, "$1?")
+	query = strings.TrimSpace(whitespace.ReplaceAllString(query, " "))
+	if len(query) > maxStatementLength {
+		query = query[:maxStatementLength] + "..."
+	}
+	return query
+}
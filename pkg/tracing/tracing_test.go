@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"string", "SELECT * FROM providers WHERE province = 'Bangkok'", "SELECT * FROM providers WHERE province = ?"},
+		{"unicode string", "SELECT * FROM providers WHERE name_thai = N'โรงพยาบาลกรุงเทพ'", "SELECT * FROM providers WHERE name_thai = ?"},
+		{"escaped quote", "SELECT * FROM providers WHERE name_eng = 'St. Mary''s' AND code = 'P''1'", "SELECT * FROM providers WHERE name_eng = ? AND code = ?"},
+		{"empty string", "UPDATE providers SET email = '' WHERE id = 7", "UPDATE providers SET email = ? WHERE id = ?"},
+		{"integer", "SELECT TOP 100 * FROM providers", "SELECT TOP ? * FROM providers"},
+		{"negative number", "SELECT * FROM t WHERE balance < -250", "SELECT * FROM t WHERE balance < ?"},
+		{"decimal", "SELECT * FROM t WHERE discount IN (12.5, -0.75)", "SELECT * FROM t WHERE discount IN (?, ?)"},
+		{"postgres placeholders", "SELECT * FROM t WHERE id = $1 AND code = $12", "SELECT * FROM t WHERE id = $1 AND code = $12"},
+		{"sql server placeholders", "SELECT * FROM t WHERE id = @p1 AND code = @p2", "SELECT * FROM t WHERE id = @p1 AND code = @p2"},
+		{"question mark placeholders", "SELECT * FROM t WHERE id = ? LIMIT ?", "SELECT * FROM t WHERE id = ? LIMIT ?"},
+		{"identifiers with digits", "SELECT col_2, t1.field3 FROM table1 t1", "SELECT col_2, t1.field3 FROM table1 t1"},
+		{"pagination", "SELECT * FROM t ORDER BY id OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY", "SELECT * FROM t ORDER BY id OFFSET ? ROWS FETCH NEXT ? ROWS ONLY"},
+		{"whitespace", "\n\tSELECT *\n\t\tFROM t\n\tWHERE id = 1\n", "SELECT * FROM t WHERE id = ?"},
+		{"number in string", "SELECT * FROM t WHERE code = 'P-001' AND id = $1", "SELECT * FROM t WHERE code = ? AND id = $1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeSQL(tt.query); got != tt.want {
+				t.Errorf("SanitizeSQL(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeSQLTruncatesLongStatements(t *testing.T) {
+	query := "SELECT " + strings.Repeat("provider_code, ", 200) + "id FROM providers"
+	got := SanitizeSQL(query)
+	if len(got) != maxStatementLength+len("...") || !strings.HasSuffix(got, "...") {
+		t.Errorf("SanitizeSQL returned %d bytes, want %d and a ... suffix", len(got), maxStatementLength+len("..."))
+	}
+}
@@ -0,0 +1,106 @@
+package utility
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Mask types of sensitive fields, see MaskValue
+const (
+	MaskTypeAccount = "account"
+	MaskTypeLast4   = "last4"
+	MaskTypeEmail   = "email"
+	MaskTypeName    = "name"
+	MaskTypeFull    = "full"
+)
+
+const maskRune = 'x'
+
+// MaskValue hides a sensitive value for users without access to sensitive data:
+//
+//	account: 123-4-56789-0       -> xxx-x-x6789-x
+//	last4:   1234567890123       -> xxxxxxxxx0123
+//	email:   somchai@example.com -> sxxxxxx@example.com
+//	name:    Somchai Jaidee      -> Sxxxxxx Jxxxxx
+//	full:    anything            -> xxxxxxxx
+//
+// Separators are kept so the masked value still looks like the original. Unknown mask types
+// mask fully.
+func MaskValue(value, maskType string) string {
+	if value == "" {
+		return value
+	}
+
+	switch maskType {
+	case MaskTypeAccount:
+		// Reveal the four digits before the check digit
+		n := countAlphanumeric(value)
+		return maskAlphanumeric(value, func(i int) bool { return i >= n-5 && i < n-1 && n >= 6 })
+	case MaskTypeLast4:
+		n := countAlphanumeric(value)
+		return maskAlphanumeric(value, func(i int) bool { return i >= n-4 && n > 4 })
+	case MaskTypeEmail:
+		addresses := strings.Split(value, ",")
+		for i, address := range addresses {
+			addresses[i] = maskEmail(address)
+		}
+		return strings.Join(addresses, ",")
+	case MaskTypeName:
+		words := strings.Split(value, " ")
+		for i, word := range words {
+			words[i] = maskAlphanumeric(word, func(i int) bool { return i == 0 })
+		}
+		return strings.Join(words, " ")
+	default:
+		return maskAlphanumeric(value, func(int) bool { return false })
+	}
+}
+
+func maskEmail(address string) string {
+	// Keep the space after the comma of an address list
+	trimmed := strings.TrimLeft(address, " ")
+	leading := address[:len(address)-len(trimmed)]
+
+	at := strings.LastIndex(trimmed, "@")
+	if at < 0 {
+		return leading + maskAlphanumeric(trimmed, func(int) bool { return false })
+	}
+	return leading + maskAlphanumeric(trimmed[:at], func(i int) bool { return i == 0 }) + trimmed[at:]
+}
+
+// maskAlphanumeric replaces letters and digits with maskRune unless reveal reports true for
+// their index among the letters and digits of value
+func maskAlphanumeric(value string, reveal func(i int) bool) string {
+	var b strings.Builder
+	i := 0
+	for _, r := range value {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r) {
+			b.WriteRune(r)
+			continue
+		}
+		if unicode.IsMark(r) {
+			// Thai vowel and tone marks follow the revealed or masked letter
+			if reveal(i - 1) {
+				b.WriteRune(r)
+			}
+			continue
+		}
+		if reveal(i) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(maskRune)
+		}
+		i++
+	}
+	return b.String()
+}
+
+func countAlphanumeric(value string) int {
+	n := 0
+	for _, r := range value {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			n++
+		}
+	}
+	return n
+}
@@ -2,26 +2,48 @@ package utility
 
 import (
 	shared "provider-report-api/internal/modules/shared/dtos"
-	"provider-report-api/pkg/vault"
+	"provider-report-api/pkg/metrics"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
 // Remove duplicate struct - use shared.UserActionAccessRights instead
 
+// Caches reported by RedisService to metrics
+const (
+	cacheSearchPreference = "search_preference"
+	cachePermissions      = "permissions"
+)
+
 type RedisService struct {
-	client *redis.Client
+	client  *redis.Client
+	metrics *metrics.Metrics
+}
+
+// observeLookup reports the result of a cache lookup that returned err
+func (s *RedisService) observeLookup(cache string, err error) {
+	switch {
+	case err == nil:
+		s.metrics.ObserveCacheLookup(cache, metrics.CacheHit)
+	case errors.Is(err, redis.Nil):
+		s.metrics.ObserveCacheLookup(cache, metrics.CacheMiss)
+	default:
+		s.metrics.ObserveCacheLookup(cache, metrics.CacheError)
+	}
 }
 
-func (s *RedisService) GetSearchPreference(subModule, username string) (interface{}, error) {
+func (s *RedisService) GetSearchPreference(ctx context.Context, subModule, username string) (interface{}, error) {
 	key := fmt.Sprintf("save:search:%s:%s", subModule, username)
-	fmt.Println("key", key)
-	value, err := s.client.Get(context.Background(), key).Result()
+	slog.Debug("redis get search preference", "key", key)
+	value, err := s.client.Get(ctx, key).Result()
+	s.observeLookup(cacheSearchPreference, err)
 	if err != nil {
 	 return nil, err
 	}
@@ -35,41 +57,57 @@ func (s *RedisService) GetSearchPreference(subModule, username string) (interfac
 	return reqSearch, nil
 }
 
-func (s *RedisService) SetSearchPreference(subModule, username string, reqSearch interface{}) error {
+func (s *RedisService) SetSearchPreference(ctx context.Context, subModule, username string, reqSearch interface{}) error {
 	key := fmt.Sprintf("save:search:%s:%s", subModule, username)
 	value, err := json.Marshal(reqSearch)
 	if err != nil {
 	 return err
 	}
    
-	err = s.client.Set(context.Background(), key, value, 0).Err()
+	err = s.client.Set(ctx, key, value, 0).Err()
    
 	return err
 }
 	
 
-func NewRedisService() *RedisService {
-	creds := vault.GetRedisSecret()
-	if creds == nil {
-		log.Fatalf("Secret data type is not expected.")
+// ConnectRedisService connects to the Redis server at addr. It returns an error instead of
+// exiting, so features with a database fallback can run without Redis. Cache lookups are
+// reported to m, which may be nil, and every command is traced without its arguments.
+func ConnectRedisService(addr string, m *metrics.Metrics) (*RedisService, error) {
+	if addr == "" {
+		return nil, errors.New("REDIS_URL is not set")
 	}
 
 	rdb := redis.NewClient(&redis.Options{
-		Addr:     creds.RedisUrl,
+		Addr:     addr,
 		Password: "", // no password set
 		DB:       0,
 	})
 
-	// Ping the Redis server to check if the connection is established
-	pong, err := rdb.Ping(context.Background()).Result()
-	if err != nil {
-		log.Fatal("Failed to connect to Redis:", err)
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		rdb.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	// Keys and values hold usernames and permissions, so statements are left out of the spans
+	if err := redisotel.InstrumentTracing(rdb, redisotel.WithDBStatement(false)); err != nil {
+		rdb.Close()
+		return nil, fmt.Errorf("failed to trace Redis: %w", err)
 	}
-	fmt.Println("Connected to Redis:", pong)
 
 	return &RedisService{
-		client: rdb,
-	}
+		client:  rdb,
+		metrics: m,
+	}, nil
+}
+
+// Ping checks that Redis answers
+func (s *RedisService) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+// Close closes the connections to Redis
+func (s *RedisService) Close() error {
+	return s.client.Close()
 }
 
 func (s *RedisService) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
@@ -85,22 +123,24 @@ func (s *RedisService) Get(ctx context.Context, key string) (string, error) {
 	return value, nil
 }
 
-func (s *RedisService) SetPermissions(userId string, userRoleId string, moduleName string, permissions interface{}) error {
+// SetPermissions caches the permissions of a user role for ttl, 0 keeps them until invalidated
+func (s *RedisService) SetPermissions(ctx context.Context, userId string, userRoleId string, moduleName string, permissions interface{}, ttl time.Duration) error {
 	key := fmt.Sprintf("permissions:%s:%s:%s", userId, userRoleId, moduleName)
 	value, err := json.Marshal(permissions)
 	if err != nil {
 		return err
 	}
 
-	err = s.client.Set(context.Background(), key, value, 0).Err()
+	err = s.client.Set(ctx, key, value, ttl).Err()
 	return err
 }
 
-func (s *RedisService) GetPermissions(userId string, userRoleId string, moduleName string) (*[]shared.UserActionAccessRights, error) {
+func (s *RedisService) GetPermissions(ctx context.Context, userId string, userRoleId string, moduleName string) (*[]shared.UserActionAccessRights, error) {
 	key := fmt.Sprintf("permissions:%s:%s:%s", userId, userRoleId, moduleName)
-	fmt.Println(key)
+	slog.Debug("redis get permissions", "key", key)
 
-	value, err := s.client.Get(context.Background(), key).Result()
+	value, err := s.client.Get(ctx, key).Result()
+	s.observeLookup(cachePermissions, err)
 	if err != nil {
 		if err == redis.Nil {
 			return nil, err // Key not found
@@ -116,14 +156,14 @@ func (s *RedisService) GetPermissions(userId string, userRoleId string, moduleNa
 	return permissions, nil
 }
 
-func (s *RedisService) FetchKeysByPattern(pattern string) ([]string, error) {
+func (s *RedisService) FetchKeysByPattern(ctx context.Context, pattern string) ([]string, error) {
 	var keys []string
 	var cursor uint64
 	var err error
 
 	for {
 		var scanResult []string
-		scanResult, cursor, err = s.client.Scan(context.Background(), cursor, pattern, 100).Result()
+		scanResult, cursor, err = s.client.Scan(ctx, cursor, pattern, 100).Result()
 		if err != nil {
 			return nil, err
 		}
@@ -138,19 +178,65 @@ func (s *RedisService) FetchKeysByPattern(pattern string) ([]string, error) {
 	return keys, nil
 }
 
-func (s *RedisService) InvalidateUserPermissions(userId string) error {
-	pattern := fmt.Sprintf("permissions:%s:*", userId)
-	keys, err := s.FetchKeysByPattern(pattern)
+// InvalidateUserPermissions deletes the cached permissions of every role of the user in moduleName
+func (s *RedisService) InvalidateUserPermissions(ctx context.Context, userId string, moduleName string) error {
+	pattern := fmt.Sprintf("permissions:%s:*:%s", userId, moduleName)
+	keys, err := s.FetchKeysByPattern(ctx, pattern)
 	if err != nil {
 		return err
 	}
 
 	if len(keys) > 0 {
-		err := s.client.Del(context.Background(), keys...).Err()
+		err := s.client.Del(ctx, keys...).Err()
 		if err != nil {
 			return err
 		}
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// takeTokenScript refills the token bucket stored at KEYS[1] by ARGV[1] tokens per second up
+// to ARGV[2] and takes one token. It returns whether a token was taken, the tokens left and
+// the milliseconds until the next token. Redis time is used so every instance shares a clock.
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1])
+local updated = tonumber(bucket[2])
+if tokens == nil then
+	tokens = burst
+	updated = now
+end
+
+tokens = math.min(burst, tokens + (now - updated) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+local wait = 0
+if tokens < 1 then
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, math.floor(tokens), wait}
+`)
+
+// TakeToken takes a token from the rate limit bucket of key, refilled at rate tokens per
+// second up to burst. It returns whether the request is allowed, the tokens left and how long
+// until the next token.
+func (s *RedisService) TakeToken(ctx context.Context, key string, rate float64, burst int) (bool, int, time.Duration, error) {
+	result, err := takeTokenScript.Run(ctx, s.client, []string{"ratelimit:" + key}, rate, burst).Int64Slice()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	return result[0] == 1, int(result[1]), time.Duration(result[2]) * time.Millisecond, nil
+}
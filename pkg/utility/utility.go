@@ -4,6 +4,7 @@ import (
 	config "provider-report-api/configs"
 	"provider-report-api/constant"
 	sharedRequest "provider-report-api/internal/modules/shared/dtos/requests"
+	"context"
 	"crypto/tls"
 	"database/sql"
 	"encoding/csv"
@@ -11,7 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -22,7 +23,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -97,7 +98,7 @@ func ProcessQueryConfigs(queryConfigs []QueryConfig, whereClauses *[]string, que
 			if v == "" {
 				continue
 			}
-			fmt.Println("Processing string:", v)
+			slog.Debug("query filter", "condition", config.Condition)
 			if strings.Contains(config.Condition, "LIKE") {
 				*whereClauses = append(*whereClauses, config.Condition)
 				count := strings.Count(config.Condition, "?")
@@ -112,7 +113,7 @@ func ProcessQueryConfigs(queryConfigs []QueryConfig, whereClauses *[]string, que
 			if v == nil || *v == "" {
 				continue
 			}
-			fmt.Println("Processing string:", *v)
+			slog.Debug("query filter", "condition", config.Condition)
 			if strings.Contains(config.Condition, "LIKE") {
 				*whereClauses = append(*whereClauses, config.Condition)
 				count := strings.Count(config.Condition, "?")
@@ -124,7 +125,7 @@ func ProcessQueryConfigs(queryConfigs []QueryConfig, whereClauses *[]string, que
 				*queryParams = append(*queryParams, *v)
 			}
 		case *int:
-			fmt.Println("Processing integer:", *v)
+			slog.Debug("query filter", "condition", config.Condition)
 			if strings.Contains(config.Condition, "LIKE") {
 				strValue := strconv.Itoa(*v)
 				*whereClauses = append(*whereClauses, config.Condition)
@@ -137,7 +138,7 @@ func ProcessQueryConfigs(queryConfigs []QueryConfig, whereClauses *[]string, que
 				*queryParams = append(*queryParams, *v)
 			}
 		case int:
-			fmt.Println("Processing integer:", v)
+			slog.Debug("query filter", "condition", config.Condition)
 			if strings.Contains(config.Condition, "LIKE") {
 				strValue := strconv.Itoa(v)
 				*whereClauses = append(*whereClauses, config.Condition)
@@ -150,11 +151,11 @@ func ProcessQueryConfigs(queryConfigs []QueryConfig, whereClauses *[]string, que
 				*queryParams = append(*queryParams, v)
 			}
 		case *bool:
-			fmt.Println("Processing boolean:", *v)
+			slog.Debug("query filter", "condition", config.Condition)
 			*whereClauses = append(*whereClauses, config.Condition)
 			*queryParams = append(*queryParams, *v)
 		default:
-			log.Printf("Unsupported type for value: %#v", config.Value)
+			slog.Warn("unsupported query filter value", "condition", config.Condition, "type", fmt.Sprintf("%T", config.Value))
 		}
 	}
 }
@@ -181,12 +182,22 @@ func ValidateIDParam(c *gin.Context, paramName string, minValue int) (int, bool)
 	return id, true
 }
 
+// DecodeToken verifies an HS256 token signed with JWT_SECRET.
+//
+// Deprecated: use auth.JWTVerifier, which also supports RS256/ES256 and checks iss/aud.
 func DecodeToken(tok string) (jwt.MapClaims, error) {
 	// Parse the access token
 
 	token, err := jwt.Parse(tok, func(token *jwt.Token) (interface{}, error) {
-		// You need to provide the secret key used to sign the token for validation
-		return []byte("YOUR_SECRET_KEY_HERE"), nil
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		// Secret key used to sign the token, shared with the SSO service
+		cfg := config.Current()
+		if cfg == nil {
+			return nil, errors.New("configuration is not loaded")
+		}
+		return []byte(cfg.JWTSecret), nil
 	})
 
 	// Check for errors
@@ -225,6 +236,60 @@ func GetUsername(c *gin.Context) (string, error) {
 	return username, nil
 }
 
+type usernameContextKey struct{}
+
+// ContextWithUsername returns a copy of ctx carrying the authenticated username
+func ContextWithUsername(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, usernameContextKey{}, username)
+}
+
+// UsernameFromContext returns the authenticated username set by ContextWithUsername
+func UsernameFromContext(ctx context.Context) (string, bool) {
+	username, ok := ctx.Value(usernameContextKey{}).(string)
+	return username, ok && username != ""
+}
+
+type userRoleIDContextKey struct{}
+
+// ContextWithUserRoleID returns a copy of ctx carrying the user role of the authenticated user
+func ContextWithUserRoleID(ctx context.Context, userRoleID int) context.Context {
+	return context.WithValue(ctx, userRoleIDContextKey{}, userRoleID)
+}
+
+// UserRoleIDFromContext returns the user role set by ContextWithUserRoleID
+func UserRoleIDFromContext(ctx context.Context) (int, bool) {
+	userRoleID, ok := ctx.Value(userRoleIDContextKey{}).(int)
+	return userRoleID, ok
+}
+
+type sensitiveDataAccessContextKey struct{}
+
+// ContextWithSensitiveDataAccess returns a copy of ctx that allows or denies unmasked sensitive fields
+func ContextWithSensitiveDataAccess(ctx context.Context, allowed bool) context.Context {
+	return context.WithValue(ctx, sensitiveDataAccessContextKey{}, allowed)
+}
+
+// CanViewSensitiveData reports whether ctx allows unmasked sensitive fields. Sensitive fields
+// are masked unless access was granted with ContextWithSensitiveDataAccess.
+func CanViewSensitiveData(ctx context.Context) bool {
+	allowed, _ := ctx.Value(sensitiveDataAccessContextKey{}).(bool)
+	return allowed
+}
+
+type deletedDataAccessContextKey struct{}
+
+// ContextWithDeletedDataAccess returns a copy of ctx that allows or denies soft-deleted rows
+func ContextWithDeletedDataAccess(ctx context.Context, allowed bool) context.Context {
+	return context.WithValue(ctx, deletedDataAccessContextKey{}, allowed)
+}
+
+// CanViewDeletedData reports whether ctx allows soft-deleted rows. They are hidden unless access
+// was granted with ContextWithDeletedDataAccess.
+func CanViewDeletedData(ctx context.Context) bool {
+	allowed, _ := ctx.Value(deletedDataAccessContextKey{}).(bool)
+	return allowed
+}
+
 func ConvertISOToCustomFormat(isoDate string) (string, error) {
 	// Parse the ISO string into a time.Time object
 	parsedTime, err := time.Parse(time.RFC3339, isoDate)
@@ -251,7 +316,7 @@ func ExportExcelFromSlice(data []map[string]interface{}, headCell []string) (*ex
 
 	defer func() {
 		if err := f.Close(); err != nil {
-			fmt.Println(err)
+			slog.Warn("failed to close excel file", "error", err)
 		}
 	}()
 
@@ -318,7 +383,7 @@ func DownloadLocalFile(filePath string, c *gin.Context) error {
 		return fmt.Errorf("invalid file extension: %s", filepath.Ext(fileStat.Name()))
 	}
 
-	fmt.Println("filename => ", fileStat.Name())
+	slog.Debug("downloading local file", "file_name", fileStat.Name())
 
 	// Set headers for file download
 	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
@@ -411,7 +476,7 @@ func SaveImportMonitorLog(data any) (string, error) {
 	req, err := http.NewRequest(http.MethodPost, os.Getenv("LOGSTASH_URL"), payload)
 
 	if err != nil {
-		log.Print("error: ", err)
+		slog.Error("failed to build import monitor log request", "error", err)
 
 		return "", err
 	}
@@ -420,7 +485,7 @@ func SaveImportMonitorLog(data any) (string, error) {
 
 	res, err := client.Do(req)
 	if err != nil {
-		log.Print("error: ", err)
+		slog.Error("failed to send import monitor log", "error", err)
 
 		return "", err
 	}
@@ -429,10 +494,8 @@ func SaveImportMonitorLog(data any) (string, error) {
 
 	body, err := io.ReadAll(res.Body)
 
-	fmt.Println("body => ", string(body))
-
 	if err != nil || strings.Contains(string(body), "invalid") {
-		log.Println("error: ", err)
+		slog.Error("import monitor log was not accepted", "status", res.StatusCode, "body", string(body), "error", err)
 
 		return "", err
 	}
@@ -440,6 +503,9 @@ func SaveImportMonitorLog(data any) (string, error) {
 	return currentTime.Format("2006-01-02T15:04"), nil
 }
 
+// SaveAuditLog posts a single audit entry to Logstash and blocks until it answers.
+//
+// Deprecated: report actions emit events through audit.Auditor, which buffers and retries.
 func SaveAuditLog(action string, currentUser string, data any, method, level, subModule string) error {
 	auditLog, err := json.Marshal(sharedRequest.AuditLog{
 		Id:        uuid.NewString(),
@@ -449,7 +515,7 @@ func SaveAuditLog(action string, currentUser string, data any, method, level, su
 		Timestamp: time.Now().Format("2006-01-02T15:04"),
 		Level:     level,
 		SubModule: subModule,
-		Service:   constant.CLIENT_MGMT_INDEX,
+		Service:   constant.PROVIDER_REPORT_INDEX,
 	})
 
 	if err != nil {
@@ -458,7 +524,7 @@ func SaveAuditLog(action string, currentUser string, data any, method, level, su
 
 	payload := strings.NewReader(string(auditLog))
 
-	log.Printf("payload  %+v : ", payload)
+	slog.Debug("sending audit log", "action", action, "user", currentUser, "sub_module", subModule)
 
 	client := &http.Client{}
 	req, err := http.NewRequest(method, os.Getenv("LOGSTASH_URL"), payload)
@@ -478,14 +544,54 @@ func SaveAuditLog(action string, currentUser string, data any, method, level, su
 
 	body, err := io.ReadAll(res.Body)
 
-	fmt.Println("body => ", string(body))
-
 	if err != nil || strings.Contains(string(body), "invalid") {
+		slog.Error("audit log was not accepted", "action", action, "status", res.StatusCode, "body", string(body), "error", err)
 		return err
 	}
 
 	return nil
 }
+// MergePatch applies an RFC 7396 JSON merge patch to the target document. Members set to
+// null in the patch are removed, objects are merged recursively and anything else replaces
+// the target value.
+func MergePatch(target, patch []byte) ([]byte, error) {
+	var patchValue interface{}
+	if err := json.Unmarshal(patch, &patchValue); err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+
+	var targetValue interface{}
+	if len(target) > 0 {
+		if err := json.Unmarshal(target, &targetValue); err != nil {
+			return nil, fmt.Errorf("invalid merge patch target: %w", err)
+		}
+	}
+
+	return json.Marshal(mergePatchValue(targetValue, patchValue))
+}
+
+func mergePatchValue(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatchValue(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
 func CompareStructs(a, b interface{}) map[string][2]interface{} {
 	differences := make(map[string][2]interface{})
 
@@ -494,7 +600,7 @@ func CompareStructs(a, b interface{}) map[string][2]interface{} {
 	typeA := reflect.TypeOf(a)
 
 	if typeA != reflect.TypeOf(b) {
-		fmt.Println("Structs are of different types")
+		slog.Warn("cannot compare structs of different types", "a", typeA, "b", reflect.TypeOf(b))
 		return differences
 	}
 
@@ -591,6 +697,34 @@ func FormatAuditLogEdit(differences map[string][2]interface{}, isAdd bool) strin
 	return sb.String()
 }
 
+// DiffStructs compares two values of the same struct type with CompareStructs and keeps
+// only the fields whose value changed, as text. Slices and maps are formatted as JSON.
+func DiffStructs(a, b interface{}) map[string][2]string {
+	changes := make(map[string][2]string)
+	for label, values := range CompareStructs(a, b) {
+		from := diffValue(values[0])
+		to := diffValue(values[1])
+		if from != to {
+			changes[label] = [2]string{from, to}
+		}
+	}
+	return changes
+}
+
+func diffValue(v interface{}) string {
+	v = deref(v)
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map {
+		if rv.Len() == 0 {
+			return ""
+		}
+		if encoded, err := json.Marshal(v); err == nil {
+			return string(encoded)
+		}
+	}
+	return stringify(v)
+}
+
 func GetEsClient() (*elasticsearch.Client, error) {
 	cfg := elasticsearch.Config{
 		Addresses: []string{
@@ -616,7 +750,7 @@ func GetEsClient() (*elasticsearch.Client, error) {
 	}
 	defer res.Body.Close()
 
-	fmt.Println("Connected to Elasticsearch successfully")
+	slog.Info("connected to Elasticsearch")
 	return es, nil
 }
 
@@ -768,7 +902,6 @@ func FindDiscount(providerCode string, categoryId int) (*int, error) {
 func ParseIDs(ids []string) ([]int, error) {
 	var parsedNumbers []int
 	for _, WantedStr := range ids {
-		fmt.Println(WantedStr)
 		// Split the string by commas
 		numbers := strings.Split(WantedStr, ",")
 
@@ -777,7 +910,7 @@ func ParseIDs(ids []string) ([]int, error) {
 		for _, numStr := range numbers {
 			num, err := strconv.Atoi(numStr)
 			if err != nil {
-				fmt.Printf("Error parsing %s: %v\n", numStr, err)
+				slog.Warn("invalid id", "id", numStr, "error", err)
 				// Handle parsing error
 			}
 			parsedNumbers = append(parsedNumbers, num)
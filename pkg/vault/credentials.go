@@ -0,0 +1,232 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Credentials is a username and password issued by a Vault database secrets engine
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// DynamicCredentials holds the database credentials leased from a path such as
+// database/creds/report-api. Run renews the lease in the background and, once the lease gets
+// close to its max TTL, leases new credentials and hands them to the OnRotate callbacks.
+type DynamicCredentials struct {
+	client       *Client
+	path         string
+	rotateBefore time.Duration
+
+	mu            sync.Mutex
+	current       Credentials
+	leaseID       string
+	leaseDuration time.Duration
+	renewable     bool
+	expiresAt     time.Time
+	rotations     int
+	lastRenewal   time.Time
+	lastErr       error
+	onRotate      []func(Credentials)
+}
+
+// NewDynamicCredentials leases the first credentials from path. The credentials are rotated
+// rotateBefore ahead of the end of their lease, or a quarter of the lease duration ahead when
+// that is shorter, so a role TTL below rotateBefore doesn't rotate them as soon as they are
+// leased.
+func NewDynamicCredentials(ctx context.Context, client *Client, path string, rotateBefore time.Duration) (*DynamicCredentials, error) {
+	d := &DynamicCredentials{client: client, path: path, rotateBefore: rotateBefore}
+	if err := d.lease(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// RotateBefore returns how long ahead of the end of their lease the credentials are rotated
+func (d *DynamicCredentials) RotateBefore() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rotateThreshold()
+}
+
+// rotateThreshold is RotateBefore for callers holding mu
+func (d *DynamicCredentials) rotateThreshold() time.Duration {
+	if d.leaseDuration > 0 {
+		return min(d.rotateBefore, d.leaseDuration/4)
+	}
+	return d.rotateBefore
+}
+
+// Current returns the credentials to open new connections with
+func (d *DynamicCredentials) Current() Credentials {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.current
+}
+
+// OnRotate registers fn to be called with the new credentials after every rotation
+func (d *DynamicCredentials) OnRotate(fn func(Credentials)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onRotate = append(d.onRotate, fn)
+}
+
+// lease reads new credentials from Vault and makes them current
+func (d *DynamicCredentials) lease(ctx context.Context) error {
+	secret, err := d.client.client.Logical().ReadWithContext(ctx, d.path)
+	if err != nil {
+		return fmt.Errorf("failed to lease database credentials from %s: %w", d.path, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return fmt.Errorf("no database credentials returned by %s", d.path)
+	}
+
+	username, _ := secret.Data["username"].(string)
+	password, _ := secret.Data["password"].(string)
+	if username == "" || password == "" {
+		return fmt.Errorf("database credentials from %s have no username or password", d.path)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.current = Credentials{Username: username, Password: password}
+	d.leaseID = secret.LeaseID
+	d.leaseDuration = time.Duration(secret.LeaseDuration) * time.Second
+	d.renewable = secret.Renewable
+	d.expiresAt = time.Time{}
+	if d.leaseDuration > 0 {
+		d.expiresAt = time.Now().Add(d.leaseDuration)
+	}
+	d.lastRenewal = time.Now()
+	d.lastErr = nil
+	return nil
+}
+
+// Run renews and rotates the credentials until ctx is done
+func (d *DynamicCredentials) Run(ctx context.Context) {
+	for {
+		wait, ok := d.nextCheck()
+		if !ok {
+			// The lease never expires
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if err := d.maintain(ctx); err != nil {
+			slog.Warn("vault: failed to maintain database credentials", "path", d.path, "error", err)
+			d.mu.Lock()
+			d.lastErr = err
+			d.mu.Unlock()
+		}
+	}
+}
+
+// nextCheck returns how long to wait before the lease needs renewing or rotating
+func (d *DynamicCredentials) nextCheck() (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.expiresAt.IsZero() {
+		return 0, false
+	}
+
+	remaining := time.Until(d.expiresAt)
+	untilRotate := remaining - d.rotateThreshold()
+	wait := untilRotate
+	if d.renewable && remaining*2/3 < wait {
+		wait = remaining * 2 / 3
+	}
+	// A failed renewal or rotation is due again right away; back off instead of spinning
+	if d.lastErr != nil {
+		return max(wait, retryInterval), true
+	}
+	return max(wait, 0), true
+}
+
+// maintain rotates the credentials when their lease is about to end and renews it otherwise
+func (d *DynamicCredentials) maintain(ctx context.Context) error {
+	d.mu.Lock()
+	remaining := time.Until(d.expiresAt)
+	rotateBefore := d.rotateThreshold()
+	leaseID, leaseDuration, renewable := d.leaseID, d.leaseDuration, d.renewable
+	d.mu.Unlock()
+
+	if remaining > rotateBefore && renewable {
+		secret, err := d.client.client.Sys().RenewWithContext(ctx, leaseID, int(leaseDuration.Seconds()))
+		if err != nil {
+			return fmt.Errorf("failed to renew lease %s: %w", leaseID, err)
+		}
+		if secret == nil {
+			return fmt.Errorf("no lease returned when renewing %s", leaseID)
+		}
+
+		d.mu.Lock()
+		// Vault caps renewals at the max TTL of the lease, which is when rotation takes over
+		d.expiresAt = time.Now().Add(time.Duration(secret.LeaseDuration) * time.Second)
+		d.lastRenewal = time.Now()
+		d.lastErr = nil
+		d.mu.Unlock()
+		return nil
+	}
+	if remaining > rotateBefore {
+		return nil
+	}
+
+	if err := d.lease(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.rotations++
+	creds, callbacks := d.current, d.onRotate
+	d.mu.Unlock()
+
+	slog.Info("vault: rotated database credentials", "path", d.path, "username", creds.Username)
+	for _, fn := range callbacks {
+		fn(creds)
+	}
+
+	// Connections opened with the old credentials keep working until their lease ends, so it is
+	// left to expire rather than revoked here
+	return nil
+}
+
+// Health returns an error when the lease expired or the credentials are due for rotation and
+// the last attempt failed
+func (d *DynamicCredentials) Health() (map[string]interface{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	details := map[string]interface{}{
+		"path":         d.path,
+		"rotations":    d.rotations,
+		"last_renewal": d.lastRenewal,
+	}
+	if d.expiresAt.IsZero() {
+		return details, nil
+	}
+
+	details["lease_expires_at"] = d.expiresAt
+	remaining := time.Until(d.expiresAt)
+	if remaining <= 0 {
+		return details, errors.New("database credentials lease expired")
+	}
+	if d.lastErr != nil && remaining <= d.rotateThreshold() {
+		return details, d.lastErr
+	}
+	if d.lastErr != nil {
+		details["last_error"] = d.lastErr.Error()
+	}
+	return details, nil
+}
@@ -0,0 +1,316 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testCredsPath = "database/creds/report-api"
+
+// fakeVault stands in for the Vault endpoints used by DynamicCredentials: token lookup,
+// database credentials and lease renewal
+type fakeVault struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// leaseSeconds is the lease duration of new credentials and renewals
+	leaseSeconds int
+	renewable    bool
+	// failing answers every request with a 503
+	failing bool
+	// emptyRenewal answers renewals with no content
+	emptyRenewal bool
+	leases       int
+	renewals     []string
+}
+
+func newFakeVault(t *testing.T, leaseSeconds int) *fakeVault {
+	t.Helper()
+	v := &fakeVault{leaseSeconds: leaseSeconds, renewable: true}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serve))
+	t.Cleanup(v.Close)
+	return v
+}
+
+func (v *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != "test-token" {
+		writeVaultJSON(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	if v.failing {
+		writeVaultJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"errors": []string{"Vault is sealed"}})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/auth/token/lookup-self":
+		writeVaultJSON(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"ttl": 0, "renewable": false},
+		})
+
+	case "/v1/" + testCredsPath:
+		v.leases++
+		writeVaultJSON(w, http.StatusOK, map[string]interface{}{
+			"lease_id":       fmt.Sprintf("%s/lease-%d", testCredsPath, v.leases),
+			"lease_duration": v.leaseSeconds,
+			"renewable":      v.renewable,
+			"data": map[string]interface{}{
+				"username": fmt.Sprintf("v-report-%d", v.leases),
+				"password": fmt.Sprintf("secret-%d", v.leases),
+			},
+		})
+
+	case "/v1/sys/leases/renew":
+		var body struct {
+			LeaseID string `json:"lease_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		v.renewals = append(v.renewals, body.LeaseID)
+		if v.emptyRenewal {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeVaultJSON(w, http.StatusOK, map[string]interface{}{
+			"lease_id":       body.LeaseID,
+			"lease_duration": v.leaseSeconds,
+			"renewable":      true,
+		})
+
+	default:
+		writeVaultJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func writeVaultJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (v *fakeVault) set(fn func(v *fakeVault)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fn(v)
+}
+
+func (v *fakeVault) renewed() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]string(nil), v.renewals...)
+}
+
+func newTestCredentials(t *testing.T, v *fakeVault, rotateBefore time.Duration) *DynamicCredentials {
+	t.Helper()
+	ctx := context.Background()
+	client, err := NewClient(ctx, Config{Address: v.URL, Token: "test-token"})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	creds, err := NewDynamicCredentials(ctx, client, testCredsPath, rotateBefore)
+	if err != nil {
+		t.Fatalf("NewDynamicCredentials: %v", err)
+	}
+	return creds
+}
+
+// expireIn moves the end of the current lease to d from now
+func expireIn(creds *DynamicCredentials, d time.Duration) {
+	creds.mu.Lock()
+	defer creds.mu.Unlock()
+	creds.expiresAt = time.Now().Add(d)
+}
+
+func TestDynamicCredentialsLease(t *testing.T) {
+	v := newFakeVault(t, 3600)
+	creds := newTestCredentials(t, v, 5*time.Minute)
+
+	if got, want := creds.Current(), (Credentials{Username: "v-report-1", Password: "secret-1"}); got != want {
+		t.Errorf("Current = %+v, want %+v", got, want)
+	}
+	if got := creds.RotateBefore(); got != 5*time.Minute {
+		t.Errorf("RotateBefore = %v, want 5m", got)
+	}
+	if _, err := creds.Health(); err != nil {
+		t.Errorf("Health: %v", err)
+	}
+}
+
+func TestDynamicCredentialsClampRotateBeforeToLease(t *testing.T) {
+	v := newFakeVault(t, 60)
+	creds := newTestCredentials(t, v, time.Hour)
+
+	// A role TTL below rotateBefore must not rotate the credentials as soon as they are leased
+	if got := creds.RotateBefore(); got != 15*time.Second {
+		t.Errorf("RotateBefore = %v, want 15s", got)
+	}
+	wait, ok := creds.nextCheck()
+	if !ok || wait < 30*time.Second {
+		t.Errorf("nextCheck = %v, %v, want a wait of about 40s", wait, ok)
+	}
+}
+
+func TestDynamicCredentialsRenew(t *testing.T) {
+	v := newFakeVault(t, 3600)
+	creds := newTestCredentials(t, v, 5*time.Minute)
+	expireIn(creds, 30*time.Minute)
+
+	if err := creds.maintain(context.Background()); err != nil {
+		t.Fatalf("maintain: %v", err)
+	}
+
+	if got := v.renewed(); len(got) != 1 || got[0] != testCredsPath+"/lease-1" {
+		t.Errorf("renewed leases = %v, want the first lease", got)
+	}
+	if got := creds.Current().Username; got != "v-report-1" {
+		t.Errorf("renewal changed the credentials to %s", got)
+	}
+	creds.mu.Lock()
+	remaining := time.Until(creds.expiresAt)
+	creds.mu.Unlock()
+	if remaining < 59*time.Minute {
+		t.Errorf("lease ends in %v after renewal, want about 1h", remaining)
+	}
+}
+
+func TestDynamicCredentialsRenewWithoutLease(t *testing.T) {
+	v := newFakeVault(t, 3600)
+	creds := newTestCredentials(t, v, 5*time.Minute)
+	expireIn(creds, 30*time.Minute)
+	v.set(func(v *fakeVault) { v.emptyRenewal = true })
+
+	err := creds.maintain(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "no lease returned") {
+		t.Fatalf("maintain = %v, want a missing lease error", err)
+	}
+}
+
+func TestDynamicCredentialsRotate(t *testing.T) {
+	v := newFakeVault(t, 3600)
+	creds := newTestCredentials(t, v, 5*time.Minute)
+
+	var rotated []Credentials
+	creds.OnRotate(func(c Credentials) { rotated = append(rotated, c) })
+
+	// Close to the max TTL renewing no longer helps, new credentials are leased
+	expireIn(creds, time.Minute)
+	if err := creds.maintain(context.Background()); err != nil {
+		t.Fatalf("maintain: %v", err)
+	}
+
+	want := Credentials{Username: "v-report-2", Password: "secret-2"}
+	if got := creds.Current(); got != want {
+		t.Errorf("Current = %+v, want %+v", got, want)
+	}
+	if len(rotated) != 1 || rotated[0] != want {
+		t.Errorf("OnRotate called with %+v, want %+v", rotated, want)
+	}
+	if got := v.renewed(); len(got) != 0 {
+		t.Errorf("renewed %v while rotating", got)
+	}
+	details, err := creds.Health()
+	if err != nil {
+		t.Fatalf("Health: %v", err)
+	}
+	if details["rotations"] != 1 {
+		t.Errorf("rotations = %v, want 1", details["rotations"])
+	}
+}
+
+func TestDynamicCredentialsRunRotatesShortLease(t *testing.T) {
+	v := newFakeVault(t, 2)
+	v.set(func(v *fakeVault) { v.renewable = false })
+	creds := newTestCredentials(t, v, time.Hour)
+
+	rotated := make(chan Credentials, 1)
+	creds.OnRotate(func(c Credentials) {
+		select {
+		case rotated <- c:
+		default:
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go creds.Run(ctx)
+
+	select {
+	case c := <-rotated:
+		if c.Username == "v-report-1" {
+			t.Errorf("rotated to the same credentials %+v", c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("credentials were not rotated before the lease ended")
+	}
+}
+
+func TestDynamicCredentialsVaultFailure(t *testing.T) {
+	v := newFakeVault(t, 3600)
+	creds := newTestCredentials(t, v, 5*time.Minute)
+	v.set(func(v *fakeVault) { v.failing = true })
+
+	// A failed renewal keeps the credentials and backs off instead of retrying right away
+	expireIn(creds, 30*time.Minute)
+	err := creds.maintain(context.Background())
+	if err == nil {
+		t.Fatal("maintain succeeded against a failing Vault")
+	}
+	creds.mu.Lock()
+	creds.lastErr = err
+	creds.mu.Unlock()
+
+	if got := creds.Current().Username; got != "v-report-1" {
+		t.Errorf("credentials changed to %s after a failed renewal", got)
+	}
+	if _, err := creds.Health(); err != nil {
+		t.Errorf("Health = %v, want healthy while the lease has time left", err)
+	}
+
+	// A failed rotation is retried after retryInterval, not in a hot loop
+	expireIn(creds, time.Minute)
+	err = creds.maintain(context.Background())
+	if err == nil {
+		t.Fatal("rotation succeeded against a failing Vault")
+	}
+	creds.mu.Lock()
+	creds.lastErr = err
+	creds.mu.Unlock()
+
+	if wait, ok := creds.nextCheck(); !ok || wait < retryInterval {
+		t.Errorf("nextCheck = %v, %v, want at least %v", wait, ok, retryInterval)
+	}
+	if _, err := creds.Health(); err == nil {
+		t.Error("Health is ok although the credentials are due for rotation and Vault fails")
+	}
+
+	// Vault recovers: the next attempt rotates and clears the error
+	v.set(func(v *fakeVault) { v.failing = false })
+	if err := creds.maintain(context.Background()); err != nil {
+		t.Fatalf("maintain after recovery: %v", err)
+	}
+	if _, err := creds.Health(); err != nil {
+		t.Errorf("Health after recovery: %v", err)
+	}
+}
+
+func TestNewDynamicCredentialsVaultDown(t *testing.T) {
+	v := newFakeVault(t, 3600)
+	client, err := NewClient(context.Background(), Config{Address: v.URL, Token: "test-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.set(func(v *fakeVault) { v.failing = true })
+
+	if _, err := NewDynamicCredentials(context.Background(), client, testCredsPath, time.Minute); err == nil {
+		t.Fatal("NewDynamicCredentials succeeded against a failing Vault")
+	}
+}
@@ -1,18 +1,34 @@
 // Package vault provides utility functions to interact with HashiCorp Vault to securely retrieve
-// stored secrets, specifically database credentials. This package encapsulates the complexity of
-// Vault operations including client initialization and secret retrieval.
+// stored secrets, specifically database and Redis credentials. This package encapsulates the
+// complexity of Vault operations including client authentication, token renewal, secret
+// retrieval and the leases of dynamic database credentials. The settings of the client are
+// passed in by the configs package, which merges the static secrets into the configuration.
 package vault
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/vault/api"
-	"github.com/joho/godotenv"
 	"github.com/mitchellh/mapstructure"
 )
 
+// Auth methods of Config.AuthMethod
+const (
+	AuthToken      = "token"
+	AuthAppRole    = "approle"
+	AuthKubernetes = "kubernetes"
+)
+
+// retryInterval is the wait before a failed renewal or login is tried again
+const retryInterval = 10 * time.Second
+
 // DatabaseCredentials represents the structure of the database credentials as stored in Vault.
 // It maps the Vault secrets to a Go struct, facilitating the use of these credentials in the application.
 type DatabaseCredentials struct {
@@ -27,109 +43,238 @@ type RedisCredentials struct {
 	RedisUrl string `mapstructure:"redisUrl" json:"redisUrl"`
 }
 
-// createClient initializes and returns a new Vault client using the VAULT_ADDR environment variable.
-// It returns an error if the VAULT_ADDR is not set or the client cannot be created.
-func createClient() (*api.Client, error) {
-	vaultAddress := os.Getenv("VAULT_ADDR")
-	if vaultAddress == "" {
-		return nil, fmt.Errorf("VAULT_ADDR environment variable is not set")
-	}
+// Config selects the Vault server and how the service logs in to it
+type Config struct {
+	Address string
+	// AuthMethod is token (the default), approle or kubernetes
+	AuthMethod string
+	// Token is used by the token method
+	Token string
+	// AppRoleMount, RoleID and SecretID are used by the approle method
+	AppRoleMount string
+	RoleID       string
+	SecretID     string
+	// KubernetesMount, KubernetesRole and the service account token at KubernetesTokenPath are
+	// used by the kubernetes method
+	KubernetesMount     string
+	KubernetesRole      string
+	KubernetesTokenPath string
+}
 
-	config := &api.Config{
-		Address: vaultAddress,
-	}
-	client, err := api.NewClient(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Vault client: %v", err)
-	}
+// Client reads secrets from one Vault server and keeps its login token valid, see KeepAlive
+type Client struct {
+	client *api.Client
+	cfg    Config
 
-	return client, nil
+	mu sync.Mutex
+	// tokenExpiry is zero for tokens that never expire
+	tokenExpiry    time.Time
+	tokenRenewable bool
+	lastErr        error
 }
 
-// getSecret retrieves a secret from Vault at a specified path and sets the client token using
-// the VAULT_TOKEN environment variable. It returns the secret or an error if the secret cannot be read.
-func getSecret(client *api.Client, path string) (*api.Secret, error) {
-	client.SetToken(os.Getenv("VAULT_TOKEN"))
-	secret, err := client.Logical().Read(path)
+// NewClient creates a client for the Vault server of cfg and logs in
+func NewClient(ctx context.Context, cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("vault address is not set")
+	}
+	if cfg.AuthMethod == "" {
+		cfg.AuthMethod = AuthToken
+	}
+
+	client, err := api.NewClient(&api.Config{Address: cfg.Address})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read secret: %v", err)
+		return nil, fmt.Errorf("failed to create Vault client: %w", err)
 	}
 
-	return secret, nil
+	c := &Client{client: client, cfg: cfg}
+	if err := c.login(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// AuthMethod returns the auth method the client logs in with
+func (c *Client) AuthMethod() string {
+	return c.cfg.AuthMethod
 }
 
-// GetDatabaseSecret initializes the Vault client, retrieves the secret containing database credentials,
-// and decodes the secret into the DatabaseCredentials struct. This function loads environment variables from
-// a .env file at runtime. It logs fatal errors and exits the application if it cannot complete any step,
-// including environment loading, client creation, or secret retrieval.
-//
-// Returns:
-//   - A pointer to a DatabaseCredentials struct populated with database credentials from Vault.
-//   - Nil if there are any failures during the process, after logging the error.
-func GetDatabaseSecret() *DatabaseCredentials {
-	if os.Getenv("ENV") != "production" {
-		if err := godotenv.Load(); err != nil {
-			log.Fatalf("Error loading .env file: %v", err)
+// login authenticates with the configured method and records the lifetime of the token
+func (c *Client) login(ctx context.Context) error {
+	var path string
+	var data map[string]interface{}
+
+	switch c.cfg.AuthMethod {
+	case AuthToken:
+		c.client.SetToken(c.cfg.Token)
+		secret, err := c.client.Auth().Token().LookupSelfWithContext(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to look up Vault token: %w", err)
+		}
+		ttl, err := secret.TokenTTL()
+		if err != nil {
+			return fmt.Errorf("failed to read Vault token TTL: %w", err)
 		}
+		renewable, _ := secret.TokenIsRenewable()
+		c.setToken(ttl, renewable)
+		return nil
+	case AuthAppRole:
+		path = "auth/" + mountOrDefault(c.cfg.AppRoleMount, "approle") + "/login"
+		data = map[string]interface{}{"role_id": c.cfg.RoleID, "secret_id": c.cfg.SecretID}
+	case AuthKubernetes:
+		jwt, err := os.ReadFile(c.cfg.KubernetesTokenPath)
+		if err != nil {
+			return fmt.Errorf("failed to read Kubernetes service account token: %w", err)
+		}
+		path = "auth/" + mountOrDefault(c.cfg.KubernetesMount, "kubernetes") + "/login"
+		data = map[string]interface{}{"role": c.cfg.KubernetesRole, "jwt": strings.TrimSpace(string(jwt))}
+	default:
+		return fmt.Errorf("unknown Vault auth method %q", c.cfg.AuthMethod)
 	}
 
-	client, err := createClient()
+	secret, err := c.client.Logical().WriteWithContext(ctx, path, data)
 	if err != nil {
-		log.Fatalf("Error creating client: %v", err)
+		return fmt.Errorf("failed to log in to Vault with %s: %w", c.cfg.AuthMethod, err)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return fmt.Errorf("Vault %s login returned no token", c.cfg.AuthMethod)
 	}
 
-	secret, err := getSecret(client, "kv/data/tpa-newcore")
-	if err != nil {
-		log.Fatalf("Error getting secret: %v", err)
+	c.client.SetToken(secret.Auth.ClientToken)
+	c.setToken(time.Duration(secret.Auth.LeaseDuration)*time.Second, secret.Auth.Renewable)
+	return nil
+}
+
+func (c *Client) setToken(ttl time.Duration, renewable bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tokenExpiry = time.Time{}
+	if ttl > 0 {
+		c.tokenExpiry = time.Now().Add(ttl)
+	}
+	c.tokenRenewable = renewable
+	c.lastErr = nil
+}
+
+// KeepAlive renews the login token at two thirds of its TTL until ctx is done. Tokens that can
+// no longer be renewed are replaced by logging in again, except for the token method where
+// the token is all the client has.
+func (c *Client) KeepAlive(ctx context.Context) {
+	for {
+		c.mu.Lock()
+		expiry, lastErr := c.tokenExpiry, c.lastErr
+		c.mu.Unlock()
+
+		if expiry.IsZero() && lastErr == nil {
+			// The token never expires
+			return
+		}
+
+		wait := time.Until(expiry) * 2 / 3
+		if lastErr != nil || wait < time.Second {
+			wait = min(retryInterval, max(time.Until(expiry)/3, time.Second))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if err := c.renewToken(ctx); err != nil {
+			slog.Warn("vault: failed to renew token", "auth_method", c.cfg.AuthMethod, "error", err)
+			c.mu.Lock()
+			c.lastErr = err
+			c.mu.Unlock()
+		}
 	}
+}
+
+func (c *Client) renewToken(ctx context.Context) error {
+	c.mu.Lock()
+	renewable := c.tokenRenewable
+	c.mu.Unlock()
 
-	if secret != nil && secret.Data != nil {
-		data, ok := secret.Data["data"].(map[string]interface{})
-		if ok {
-			var creds DatabaseCredentials
-			if err := mapstructure.Decode(data, &creds); err != nil {
-				log.Fatalf("Failed to decode data into struct: %v", err)
+	if renewable {
+		secret, err := c.client.Auth().Token().RenewSelfWithContext(ctx, 0)
+		if err == nil && secret != nil && secret.Auth != nil {
+			ttl := time.Duration(secret.Auth.LeaseDuration) * time.Second
+			// A token at its max TTL is renewed for less each time; log in again before it runs out
+			if ttl > retryInterval || c.cfg.AuthMethod == AuthToken {
+				c.setToken(ttl, secret.Auth.Renewable)
+				return nil
 			}
-			return &creds
-		} else {
-			fmt.Println("Secret data type is not expected.")
+		} else if c.cfg.AuthMethod == AuthToken {
+			return fmt.Errorf("failed to renew Vault token: %w", err)
 		}
-	} else {
-		fmt.Println("No data returned.")
 	}
-	return nil
+
+	if c.cfg.AuthMethod == AuthToken {
+		return errors.New("Vault token is not renewable and will expire")
+	}
+	return c.login(ctx)
 }
 
-func GetRedisSecret() *RedisCredentials {
-	if os.Getenv("ENV") != "production" {
-		if err := godotenv.Load(); err != nil {
-			log.Fatalf("Error loading .env file: %v", err)
+// Health returns an error when the token expired or its last renewal failed
+func (c *Client) Health() (map[string]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	details := map[string]interface{}{"auth_method": c.cfg.AuthMethod}
+	if !c.tokenExpiry.IsZero() {
+		details["token_expires_at"] = c.tokenExpiry
+		if time.Now().After(c.tokenExpiry) {
+			return details, errors.New("Vault token expired")
 		}
 	}
+	if c.lastErr != nil {
+		return details, c.lastErr
+	}
+	return details, nil
+}
 
-	client, err := createClient()
-	if err != nil {
-		log.Fatalf("Error creating client: %v", err)
+// DatabaseCredentials reads the database credentials stored at path, e.g. kv/data/tpa-newcore
+func (c *Client) DatabaseCredentials(ctx context.Context, path string) (*DatabaseCredentials, error) {
+	var creds DatabaseCredentials
+	if err := c.readKV(ctx, path, &creds); err != nil {
+		return nil, err
 	}
+	return &creds, nil
+}
 
-	secret, err := getSecret(client, "kv/data/redis")
+// RedisCredentials reads the Redis credentials stored at path, e.g. kv/data/redis
+func (c *Client) RedisCredentials(ctx context.Context, path string) (*RedisCredentials, error) {
+	var creds RedisCredentials
+	if err := c.readKV(ctx, path, &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// readKV decodes the data of the KV v2 secret at path into out
+func (c *Client) readKV(ctx context.Context, path string, out interface{}) error {
+	secret, err := c.client.Logical().ReadWithContext(ctx, path)
 	if err != nil {
-		log.Fatalf("Error getting secret: %v", err)
+		return fmt.Errorf("failed to read secret %s: %w", path, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return fmt.Errorf("no data returned for secret %s", path)
 	}
 
-	if secret != nil && secret.Data != nil {
-		data, ok := secret.Data["data"].(map[string]interface{})
-		if ok {
-			var redisData RedisCredentials
-			if err := mapstructure.Decode(data, &redisData); err != nil {
-				log.Fatalf("Failed to decode data into struct: %v", err)
-			}
-			return &redisData
-		} else {
-			fmt.Println("Secret data type is not expected.")
-		}
-	} else {
-		fmt.Println("No data returned.")
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("secret %s has an unexpected data type", path)
+	}
+	if err := mapstructure.Decode(data, out); err != nil {
+		return fmt.Errorf("failed to decode secret %s: %w", path, err)
 	}
 	return nil
 }
+
+func mountOrDefault(mount, def string) string {
+	if mount = strings.Trim(mount, "/"); mount != "" {
+		return mount
+	}
+	return def
+}